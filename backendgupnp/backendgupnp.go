@@ -3,23 +3,21 @@
 package backendgupnp
 
 import (
-	"github.com/clbanning/mxj"
-
 	"github.com/gotk3/gotk3/glib"
 
-	"github.com/sqp/godock/libs/log"
 	"github.com/sqp/godock/libs/ternary"
 
 	"github.com/sqp/gupnp/gupnp"
+	"github.com/sqp/gupnp/upnpmetrics"
+	"github.com/sqp/gupnp/upnprecord"
 	"github.com/sqp/gupnp/upnptype"
 
-	"encoding/xml"
-	// "fmt"
-
+	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -29,10 +27,16 @@ const (
 	SchemaAVTransport      = "urn:schemas-upnp-org:service:AVTransport"
 	SchemaRenderingControl = "urn:schemas-upnp-org:service:RenderingControl"
 	SchemaContentDirectory = "urn:schemas-upnp-org:service:ContentDirectory"
+	SchemaConnectionMgr    = "urn:schemas-upnp-org:service:ConnectionManager"
 	SchemaMediaRenderer    = "urn:schemas-upnp-org:device:MediaRenderer:1"
 	SchemaMediaServer      = "urn:schemas-upnp-org:device:MediaServer:1"
+	SchemaRootDevice       = "upnp:rootdevice"
 )
 
+// ResubscribeDelay is the delay before renewing a lost event subscription.
+//
+var ResubscribeDelay = 10 * time.Second
+
 // controlPointEvents defines discovery events connected to the C backend.
 type controlPointEvents struct {
 	onRendererFound func(*Renderer)
@@ -44,20 +48,91 @@ type controlPointEvents struct {
 // ControlPoint handles UPnP devices on the network.
 //
 type ControlPoint struct {
-	events controlPointEvents
+	events   controlPointEvents
+	opts     Options
+	metrics  *upnpmetrics.Metrics
+	log      upnptype.Logger
+	recorder *upnprecord.Recorder
 
-	dmrCP *gupnp.ControlPoint
-	dmsCP *gupnp.ControlPoint
+	contexts []*netContext
+
+	static     map[string]*staticDevice // static devices indexed by location.
+	staticFile string                   // path to the static devices list.
+
+	found    map[string]device        // forwarded renderers and servers indexed by UDN.
+	parents  map[string]string        // parent UDN indexed by embedded device UDN.
+	embedded map[string]*embeddedRoot // devices found by traversal indexed by root UDN.
 }
 
-// NewControlPoint creates an UPnP devices manager.
+// device defines a forwarded renderer or server.
+type device interface {
+	DeviceInfo() *upnptype.DeviceInfo
+}
+
+// netContext defines an allowed gupnp context with its network and control points.
+type netContext struct {
+	*gupnp.Context
+	nw  *network
+	cps []*gupnp.ControlPoint
+}
+
+// Options defines the network and monitoring settings of the control point.
+//
+type Options struct {
+	Port        uint     // Port used by contexts. 0 for a random port.
+	Interfaces  []string // Allowed network interfaces names (eth0). Empty allows all.
+	Subnets     []string // Allowed networks in CIDR notation (192.168.1.0/24). Empty allows all.
+	DisableIPv6 bool     // Ignore contexts with an IPv6 host address.
+
+	Metrics *upnpmetrics.Metrics // Collector of actions and subscriptions metrics. Defaults to upnpmetrics.Default.
+	Logger  upnptype.Logger      // Logger of the backend and the C binding. Defaults to no logs.
+
+	Recorder *upnprecord.Recorder // Capture of the devices traffic: actions, responses, faults and events. Nil records nothing.
+}
+
+// NewControlPoint creates an UPnP devices manager listening on all interfaces.
 //
 func NewControlPoint() *ControlPoint {
-	cp := &ControlPoint{}
+	return NewControlPointWithOptions(Options{})
+}
+
+// NewControlPointWithOptions creates an UPnP devices manager restricted to
+// the networks allowed by the options.
+//
+func NewControlPointWithOptions(opts Options) *ControlPoint {
+	cp := &ControlPoint{
+		opts:     opts,
+		metrics:  opts.Metrics,
+		log:      opts.Logger,
+		recorder: opts.Recorder,
+		static:   make(map[string]*staticDevice),
+		found:    make(map[string]device),
+		parents:  make(map[string]string),
+		embedded: make(map[string]*embeddedRoot),
+	}
+	if cp.metrics == nil {
+		cp.metrics = upnpmetrics.Default
+	}
+	if cp.log == nil {
+		cp.log = upnptype.NopLogger{}
+	}
+	gupnp.SetLogger(cp.log)
+
+	for _, subnet := range opts.Subnets {
+		if _, _, e := net.ParseCIDR(subnet); e != nil {
+			cp.log.Warn("subnet ignored", "subnet", subnet, upnptype.FieldError, e)
+		}
+	}
 
-	context := gupnp.ContextManagerCreate(0)
+	context := gupnp.ContextManagerCreate(opts.Port)
 	_, e := context.Connect("context-available", cp.onContextAvailable)
-	log.Err(e, "connect context")
+	if e != nil {
+		cp.log.Error("connect context", upnptype.FieldError, e)
+	}
+	_, e = context.Connect("context-unavailable", cp.onContextUnavailable)
+	if e != nil {
+		cp.log.Error("connect context", upnptype.FieldError, e)
+	}
 
 	return cp
 }
@@ -83,41 +158,125 @@ func (cp *ControlPoint) onContextAvailable(one *glib.Object, two *glib.Object) {
 	cm := gupnp.WrapContextManager(one)
 	context := gupnp.WrapContext(two)
 
-	cp.dmrCP = gupnp.ControlPointNew(context, SchemaMediaRenderer)
-	cp.dmsCP = gupnp.ControlPointNew(context, SchemaMediaServer)
+	nw := &network{
+		iface:  context.GetInterface(),
+		hostIP: context.GetHostIP(),
+	}
+	if !cp.opts.Accept(nw.iface, nw.hostIP) {
+		cp.log.Info("context ignored", "interface", nw.iface, "host", nw.hostIP)
+		return
+	}
+
+	dmrCP := gupnp.ControlPointNew(context, SchemaMediaRenderer)
+	dmsCP := gupnp.ControlPointNew(context, SchemaMediaServer)
+	rootCP := gupnp.ControlPointNew(context, SchemaRootDevice)
 
-	_, er := cp.dmrCP.Connect("device-proxy-available", cp.onDmrProxyAvailable, cp.events.onRendererFound)
-	_, es := cp.dmsCP.Connect("device-proxy-available", cp.onDmsProxyAvailable, cp.events.onServerFound)
-	_, erl := cp.dmrCP.Connect("device-proxy-unavailable", cp.onDmrProxyLost, cp.events.onRendererLost)
-	_, esl := cp.dmsCP.Connect("device-proxy-unavailable", cp.onDmsProxyLost, cp.events.onServerLost)
+	_, er := dmrCP.Connect("device-proxy-available", cp.onDmrProxyAvailable, nw)
+	_, es := dmsCP.Connect("device-proxy-available", cp.onDmsProxyAvailable, nw)
+	_, eroot := rootCP.Connect("device-proxy-available", cp.onRootProxyAvailable, nw)
+	_, erl := dmrCP.Connect("device-proxy-unavailable", cp.onDmrProxyLost)
+	_, esl := dmsCP.Connect("device-proxy-unavailable", cp.onDmsProxyLost)
+	_, erootl := rootCP.Connect("device-proxy-unavailable", cp.onRootProxyLost)
 
-	log.Err(er, "connect device-proxy")
-	log.Err(es, "connect device-proxy")
-	log.Err(erl, "connect device-proxy")
-	log.Err(esl, "connect device-proxy")
+	for _, e := range []error{er, es, eroot, erl, esl, erootl} {
+		if e != nil {
+			cp.log.Error("connect device-proxy", upnptype.FieldError, e)
+		}
+	}
 
-	cp.dmrCP.SSDPResourceBrowser.SetActive(true)
-	cp.dmsCP.SSDPResourceBrowser.SetActive(true)
+	dmrCP.SSDPResourceBrowser.SetActive(true)
+	dmsCP.SSDPResourceBrowser.SetActive(true)
+	rootCP.SSDPResourceBrowser.SetActive(true)
 
 	// Let context manager take care of the control point life cycle
-	cm.ManageControlPoint(cp.dmrCP)
-	cm.ManageControlPoint(cp.dmsCP)
+	cm.ManageControlPoint(dmrCP)
+	cm.ManageControlPoint(dmsCP)
+	cm.ManageControlPoint(rootCP)
+
+	cp.contexts = append(cp.contexts, &netContext{context, nw, []*gupnp.ControlPoint{dmrCP, dmsCP, rootCP}})
+
+	cp.startStatic() // Static devices were waiting for a context.
+}
+
+// onContextUnavailable drops the context of a network going down, with its
+// control points, so it's no longer used to rescan or reach static devices.
+func (cp *ControlPoint) onContextUnavailable(one *glib.Object, two *glib.Object) {
+	context := gupnp.WrapContext(two)
+	for i, ctx := range cp.contexts {
+		if ctx.Native() != context.Native() {
+			continue
+		}
+		for _, c := range ctx.cps {
+			c.SSDPResourceBrowser.SetActive(false)
+		}
+		cp.contexts = append(cp.contexts[:i], cp.contexts[i+1:]...)
+		for rootUDN, root := range cp.embedded {
+			if root.nw == ctx.nw {
+				cp.lostRoot(rootUDN)
+			}
+		}
+		cp.log.Info("context lost", "interface", ctx.nw.iface, "host", ctx.nw.hostIP)
+		return
+	}
 }
 
+// Call runs fn in the glib main loop handling the backend events.
+//
+func (cp *ControlPoint) Call(fn func()) {
+	glib.IdleAdd(fn)
+}
+
+var _ upnptype.EventLoop = (*ControlPoint)(nil)
+
 // Rescan network for servers and renderers.
 //
 func (cp *ControlPoint) Rescan() {
-	cp.dmrCP.SSDPResourceBrowser.Rescan()
-	cp.dmsCP.SSDPResourceBrowser.Rescan()
+	for _, ctx := range cp.contexts {
+		for _, c := range ctx.cps {
+			c.SSDPResourceBrowser.Rescan()
+		}
+	}
 }
 
-func (cp *ControlPoint) onDmrProxyAvailable(one *glib.Object, two *glib.Object, onMediaRendererFound func(*Renderer)) {
-	proxy := gupnp.WrapDeviceProxy(two)
+func (cp *ControlPoint) onDmrProxyAvailable(one *glib.Object, two *glib.Object, nw *network) {
+	cp.addRenderer(gupnp.WrapDeviceProxy(two), nw)
+}
 
-	avTransport := &gupnp.ServiceProxy{*proxy.DeviceInfo.GetService(SchemaAVTransport)}
-	renderControl := &gupnp.ServiceProxy{*proxy.DeviceInfo.GetService(SchemaRenderingControl)}
+func (cp *ControlPoint) onDmsProxyAvailable(one *glib.Object, two *glib.Object, nw *network) {
+	cp.addServer(gupnp.WrapDeviceProxy(two), nw)
+}
 
+// onDmrProxyLost forwards the renderer lost, whichever proxy created it
+// (announce, embedded traversal or static location).
+func (cp *ControlPoint) onDmrProxyLost(one *glib.Object, two *glib.Object) {
+	if r, ok := cp.found[gupnp.WrapDeviceProxy(two).GetUdn()].(*Renderer); ok {
+		cp.lostRenderer(r)
+	}
+}
+
+// onDmsProxyLost forwards the server lost, whichever proxy created it.
+func (cp *ControlPoint) onDmsProxyLost(one *glib.Object, two *glib.Object) {
+	if s, ok := cp.found[gupnp.WrapDeviceProxy(two).GetUdn()].(*Server); ok {
+		cp.lostServer(s)
+	}
+}
+
+// addRenderer creates the renderer for the device proxy and forwards it.
+// Returns nil if the device was already forwarded or lacks a service.
+func (cp *ControlPoint) addRenderer(proxy *gupnp.DeviceProxy, nw *network) *Renderer {
 	udn := proxy.GetUdn()
+	if _, ok := cp.found[udn]; ok {
+		return nil
+	}
+
+	avTransportInfo := proxy.DeviceInfo.GetService(SchemaAVTransport)
+	renderControlInfo := proxy.DeviceInfo.GetService(SchemaRenderingControl)
+	if avTransportInfo == nil || renderControlInfo == nil {
+		cp.log.Info("renderer ignored, missing service", upnptype.FieldUDN, udn)
+		return nil
+	}
+	avTransport := &gupnp.ServiceProxy{*avTransportInfo}
+	renderControl := &gupnp.ServiceProxy{*renderControlInfo}
 
 	// if (udn != NULL)
 	// if (G_UNLIKELY (cm != NULL))
@@ -125,19 +284,32 @@ func (cp *ControlPoint) onDmrProxyAvailable(one *glib.Object, two *glib.Object,
 	// if (rendering_control != NULL)
 
 	r := &Renderer{
+		network:       *nw,
 		udn:           udn,
 		name:          proxy.GetFriendlyName(),
 		proxy:         proxy,
 		avTransport:   avTransport,
-		renderControl: renderControl}
+		renderControl: renderControl,
+		lastChange:    gupnp.LastChangeParserNew(),
+		metrics:       cp.metrics,
+		log:           cp.log,
+		recorder:      cp.recorder}
+	r.SetDeviceInfo(cp.deviceInfo(proxy))
+	cp.found[udn] = r
+	cp.recorder.Device(r.DeviceInfo())
 
 	cp.events.onRendererFound(r)
 
 	avTransport.AddNotify("LastChange", glib.TYPE_STRING, r.onMsgAVT)
 	renderControl.AddNotify("LastChange", glib.TYPE_STRING, r.onMsgRCS)
 
-	avTransport.SetSubscribed(true)
-	renderControl.SetSubscribed(true)
+	cp.subscribe(avTransport, udn, r.name)
+	cp.subscribe(renderControl, udn, r.name)
+
+	if connMgr := getService(proxy, SchemaConnectionMgr); connMgr != nil {
+		connMgr.AddNotifyString("CurrentConnectionIDs", r.onConnectionIDs)
+		cp.subscribe(connMgr, udn, r.name)
+	}
 
 	// state_name := ""
 	// avTransport.SendAction("GetTransportInfo", nil, "CurrentTransportState", &state_name)
@@ -153,64 +325,141 @@ func (cp *ControlPoint) onDmrProxyAvailable(one *glib.Object, two *glib.Object,
 	//gupnp_service_proxy_begin_action (g_object_ref (cm), "GetProtocolInfo", get_protocol_info_cb, NULL, NULL);
 
 	// g_object_unref (cm);
+
+	return r
 }
 
-func (cp *ControlPoint) onDmsProxyAvailable(one *glib.Object, two *glib.Object, onMediaServerFound func(*Server)) {
-	proxy := gupnp.WrapDeviceProxy(two)
+// addServer creates the server for the device proxy and forwards it.
+// Returns nil if the device was already forwarded or lacks a service.
+func (cp *ControlPoint) addServer(proxy *gupnp.DeviceProxy, nw *network) *Server {
+	udn := proxy.GetUdn()
+	if _, ok := cp.found[udn]; ok {
+		return nil
+	}
 
-	contentDir := &gupnp.ServiceProxy{*proxy.DeviceInfo.GetService(SchemaContentDirectory)}
+	contentDirInfo := proxy.DeviceInfo.GetService(SchemaContentDirectory)
+	if contentDirInfo == nil {
+		cp.log.Info("server ignored, missing service", upnptype.FieldUDN, udn)
+		return nil
+	}
+	contentDir := &gupnp.ServiceProxy{*contentDirInfo}
 
 	s := &Server{
-		udn:         proxy.GetUdn(),
+		network:     *nw,
+		udn:         udn,
 		name:        proxy.GetFriendlyName(),
 		proxy:       proxy,
 		contentDir:  contentDir,
 		id:          "0",
-		isContainer: true}
+		isContainer: true,
+		metrics:     cp.metrics,
+		log:         cp.log,
+		recorder:    cp.recorder}
+	s.SetDeviceInfo(cp.deviceInfo(proxy))
+	cp.found[udn] = s
+	cp.recorder.Device(s.DeviceInfo())
 
 	// Forward event.
 	cp.events.onServerFound(s)
 
-	// if (G_LIKELY (friendly_name != NULL && content_dir != NULL)) {
-
-	//         /* Append the embedded devices */
-	//         child = gupnp_device_info_list_devices (info);
-	//         while (child) {
-	//                 append_media_server (GUPNP_DEVICE_PROXY (child->data),
-	//                                      model,
-	//                                      &device_iter);
-	//                 g_object_unref (child->data);
-	//                 child = g_list_delete_link (child, child);
-	//         }
-
-	//         gupnp_service_proxy_add_notify (content_dir,
-	//                                         "ContainerUpdateIDs",
-	//                                         G_TYPE_STRING,
-	//                                         on_container_update_ids,
-	//                                         NULL);
-	//         gupnp_service_proxy_set_subscribed (content_dir, TRUE);
-	//         g_hash_table_insert (initial_notify, content_dir, content_dir);
-
-	// }
+	contentDir.AddNotifyUint("SystemUpdateID", s.onSystemUpdateID)
+	contentDir.AddNotifyString("ContainerUpdateIDs", s.onContainerUpdateIDs)
+	cp.subscribe(contentDir, udn, s.name)
 
 	// if (!expanded) {
 	//         gtk_tree_view_expand_all (GTK_TREE_VIEW (treeview));
 	//         expanded = TRUE;
 	// }
+
+	return s
 }
 
-func (cp *ControlPoint) onDmrProxyLost(one *glib.Object, two *glib.Object) {
-	cp.events.onRendererLost(&Renderer{proxy: gupnp.WrapDeviceProxy(two)})
+//
+//-----------------------------------------------------------------[ NETWORK ]--
+
+// Accept returns whether a context on the interface and host IP is allowed.
+// Invalid subnets never match.
+//
+func (opts Options) Accept(iface, hostIP string) bool {
+	if opts.DisableIPv6 && strings.Contains(hostIP, ":") {
+		return false
+	}
+
+	if len(opts.Interfaces) > 0 {
+		found := false
+		for _, name := range opts.Interfaces {
+			found = found || name == iface
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(opts.Subnets) > 0 {
+		ip := net.ParseIP(hostIP)
+		found := false
+		for _, subnet := range opts.Subnets {
+			_, ipnet, e := net.ParseCIDR(subnet)
+			if e == nil && ip != nil && ipnet.Contains(ip) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
 }
 
-func (cp *ControlPoint) onDmsProxyLost(one *glib.Object, two *glib.Object) {
-	cp.events.onServerLost(&Server{proxy: gupnp.WrapDeviceProxy(two)})
+// deviceInfo converts the description of the device and its embedded devices.
+func deviceInfo(dev *gupnp.DeviceInfo) *upnptype.DeviceInfo {
+	info := &upnptype.DeviceInfo{
+		DeviceType:       dev.GetDeviceType(),
+		FriendlyName:     dev.GetFriendlyName(),
+		Manufacturer:     dev.GetManufacturer(),
+		ManufacturerURL:  dev.GetManufacturerURL(),
+		ModelDescription: dev.GetModelDescription(),
+		ModelName:        dev.GetModelName(),
+		ModelNumber:      dev.GetModelNumber(),
+		ModelURL:         dev.GetModelURL(),
+		SerialNumber:     dev.GetSerialNumber(),
+		UDN:              dev.GetUdn(),
+		PresentationURL:  dev.GetPresentationURL(),
+		Location:         dev.GetLocation(),
+	}
+	for _, srv := range dev.ListServices() {
+		info.Services = append(info.Services, upnptype.ServiceInfo{
+			ServiceType: srv.GetServiceType(),
+			ServiceID:   srv.GetID(),
+			SCPDURL:     srv.GetSCPDURL(),
+			ControlURL:  srv.GetControlURL(),
+			EventSubURL: srv.GetEventSubscriptionURL(),
+		})
+	}
+	for _, child := range dev.ListDevices() {
+		childInfo := deviceInfo(child)
+		childInfo.ParentUDN = info.UDN
+		info.Devices = append(info.Devices, *childInfo)
+	}
+	return info
+}
+
+// network defines the context a device was found on.
+type network struct {
+	iface  string // network interface name.
+	hostIP string // local IP address on the interface.
 }
 
+func (n *network) Interface() string { return n.iface }
+func (n *network) HostIP() string    { return n.hostIP }
+
 //
 //------------------------------------------------------------------[ SERVER ]--
 
 type Server struct {
+	network
+	upnptype.Description
+
 	udn   string
 	name  string
 	proxy *gupnp.DeviceProxy
@@ -219,9 +468,15 @@ type Server struct {
 
 	icon string // path to icon file on disk.
 
+	events upnptype.ServerEvents
+
 	id          string
 	isContainer bool
 	childCount  int
+
+	metrics  *upnpmetrics.Metrics
+	log      upnptype.Logger
+	recorder *upnprecord.Recorder
 }
 
 func (srv *Server) UDN() string         { return srv.udn }
@@ -229,10 +484,18 @@ func (srv *Server) Name() string        { return srv.name }
 func (srv *Server) Icon() string        { return srv.icon }
 func (srv *Server) SetIcon(icon string) { srv.icon = icon }
 
+func (srv *Server) Events() *upnptype.ServerEvents { return &srv.events }
+
+// Watch registers a callback for an evented state variable of one of the
+// server services.
+func (srv *Server) Watch(schema, variable string, typ glib.Type, callback gupnp.NotifyFunc) bool {
+	return watch(srv.proxy, schema, variable, typ, callback)
+}
+
 func (srv *Server) CompareProxy(utest upnptype.UDNer) bool {
 	stest, ok := interface{}(utest).(*Server)
 	if !ok {
-		println("Renderer.CompareProxy: cast failed")
+		srv.log.Error("compare proxy: not a gupnp server", upnptype.FieldUDN, utest.UDN())
 		return false
 	}
 	// return false
@@ -241,7 +504,7 @@ func (srv *Server) CompareProxy(utest upnptype.UDNer) bool {
 
 func (srv *Server) GetIconFile(filename string) string {
 	url, _, _, _, _ := srv.proxy.GetIconUrl("", -1, 24, 24, true)
-	return getIconFile(url, filename)
+	return getIconFile(srv.log, url, filename)
 }
 
 func (s *Server) Browse(req *upnptype.BrowseRequest) (browseResult *upnptype.BrowseResult, err error) {
@@ -249,7 +512,7 @@ func (s *Server) Browse(req *upnptype.BrowseRequest) (browseResult *upnptype.Bro
 	var numberReturned uint
 	var totalMatches uint
 
-	s.contentDir.SendAction("Browse",
+	s.send(s.contentDir, "Browse",
 		"ObjectID", req.ObjectID,
 		"BrowseFlag", "BrowseDirectChildren",
 		"Filter", "@childCount",
@@ -261,15 +524,18 @@ func (s *Server) Browse(req *upnptype.BrowseRequest) (browseResult *upnptype.Bro
 		"NumberReturned", &numberReturned,
 		"TotalMatches", &totalMatches)
 
-	item := didlContainer{}
-	xml.Unmarshal([]byte(didlXml), &item)
+	containers, items, e := parseDidl(didlXml)
+	if e != nil {
+		s.log.Warn("browse parse DIDL", upnptype.FieldUDN, s.udn, "object", req.ObjectID, upnptype.FieldError, e)
+	}
 
-	if uint(len(item.Container)+len(item.Item)) != numberReturned {
-		log.DEV("browse count problem. Said", numberReturned, "found, but parsed", len(item.Container), "and", len(item.Item))
+	if uint(len(containers)+len(items)) != numberReturned {
+		s.log.Debug("browse count mismatch", upnptype.FieldUDN, s.udn, "object", req.ObjectID,
+			"returned", numberReturned, "containers", len(containers), "items", len(items))
 	}
 
-	listObj := make([]upnptype.Object, len(item.Item))
-	for k, v := range item.Item {
+	listObj := make([]upnptype.Object, len(items))
+	for k, v := range items {
 		listObj[k] = v.Object
 	}
 
@@ -277,7 +543,7 @@ func (s *Server) Browse(req *upnptype.BrowseRequest) (browseResult *upnptype.Bro
 		NumberReturned: int32(numberReturned),
 		TotalMatches:   int32(totalMatches),
 		// UpdateID:       out.UpdateID,
-		Container: item.Container,
+		Container: containers,
 		Item:      listObj,
 	}, nil
 }
@@ -286,7 +552,7 @@ func (s *Server) BrowseMetadata(container string, startingIndex, requestedCount
 
 	var result string
 
-	s.contentDir.SendAction("Browse",
+	s.send(s.contentDir, "Browse",
 		"ObjectID", container, //req.ObjectID,
 		"BrowseFlag", "BrowseMetadata",
 		"Filter", "*",
@@ -299,21 +565,27 @@ func (s *Server) BrowseMetadata(container string, startingIndex, requestedCount
 	// log.DEV("BrowseMetadata", result)
 	// log.DEV("browsenew", numberReturned, totalMatches)
 
-	item := didlContainer{}
-	xml.Unmarshal([]byte(result), &item)
+	containers, items, e := parseDidl(result)
+	if e != nil {
+		s.log.Warn("browse metadata parse DIDL", upnptype.FieldUDN, s.udn, "object", container, upnptype.FieldError, e)
+	}
 
-	return item.Container, item.Item, result
+	return containers, items, result
 }
 
 //
 //---------------------------------------------------------------[ RENDERERS ]--
 
 type Renderer struct {
+	network
+	upnptype.Description
+
 	udn           string
 	name          string
 	proxy         *gupnp.DeviceProxy
 	avTransport   *gupnp.ServiceProxy
 	renderControl *gupnp.ServiceProxy
+	lastChange    *gupnp.LastChangeParser
 	icon          string // path to icon file on disk.
 
 	events upnptype.RendererEvents
@@ -323,12 +595,16 @@ type Renderer struct {
 	state    upnptype.PlaybackState
 
 	tick *time.Ticker // update timer loop when media is playing.
+
+	metrics  *upnpmetrics.Metrics
+	log      upnptype.Logger
+	recorder *upnprecord.Recorder
 }
 
 func (rend *Renderer) CompareProxy(utest upnptype.UDNer) bool {
 	rtest, ok := interface{}(utest).(*Renderer)
 	if !ok {
-		println("Renderer.CompareProxy: cast failed")
+		rend.log.Error("compare proxy: not a gupnp renderer", upnptype.FieldUDN, utest.UDN())
 		return false
 	}
 	return rtest.proxy.Native() == rend.proxy.Native()
@@ -336,7 +612,7 @@ func (rend *Renderer) CompareProxy(utest upnptype.UDNer) bool {
 
 func (rend *Renderer) GetIconFile(filename string) string {
 	url, _, _, _, _ := rend.proxy.DeviceInfo.GetIconUrl("", -1, 24, 24, true)
-	return getIconFile(url, filename)
+	return getIconFile(rend.log, url, filename)
 }
 
 func (rend *Renderer) UDN() string                      { return rend.udn }
@@ -346,22 +622,28 @@ func (rend *Renderer) SetIcon(icon string)              { rend.icon = icon }
 func (rend *Renderer) Events() *upnptype.RendererEvents { return &rend.events }
 func (rend *Renderer) Duration() int                    { return rend.duration }
 
+// Watch registers a callback for an evented state variable of one of the
+// renderer services.
+func (rend *Renderer) Watch(schema, variable string, typ glib.Type, callback gupnp.NotifyFunc) bool {
+	return watch(rend.proxy, schema, variable, typ, callback)
+}
+
 //--------------------------------------------------------[ RENDERINGCONTROL ]--
 
 func (rend *Renderer) GetMute(instanceId uint32, channel string) (bool, error) {
 	var current bool
-	e := rend.renderControl.SendAction("GetMute", "Channel", channel, nil, "CurrentMute", &current)
+	e := rend.send(rend.renderControl, "GetMute", "Channel", channel, nil, "CurrentMute", &current)
 	return current, e
 }
 
 func (rend *Renderer) GetVolume(instanceId uint32, channel string) (uint16, error) {
 	var current uint
-	e := rend.renderControl.SendAction("GetVolume", "Channel", channel, nil, "CurrentVolume", &current)
+	e := rend.send(rend.renderControl, "GetVolume", "Channel", channel, nil, "CurrentVolume", &current)
 	return uint16(current), e
 }
 
 func (rend *Renderer) SetVolume(instanceId uint32, channel string, vol uint16) error {
-	return rend.renderControl.SendAction("SetVolume", "Channel", "Master", "DesiredVolume", uint(vol))
+	return rend.send(rend.renderControl, "SetVolume", "Channel", "Master", "DesiredVolume", uint(vol))
 }
 
 func (rend *Renderer) SetRelativeVolume(instanceId uint32, channel string, adjustment int32) (newVolume uint16, e error) {
@@ -369,8 +651,11 @@ func (rend *Renderer) SetRelativeVolume(instanceId uint32, channel string, adjus
 	if e != nil {
 		return 0, e
 	}
-	vol := ternary.Min(int(int32(uintvol)+adjustment), 100)
-	vol = ternary.Max(vol, 0) // can't be negative.
+	quirks := rend.Quirks()
+	vol := ternary.Max(int(int32(uintvol)+adjustment), int(quirks.VolumeMin)) // can't be negative.
+	if quirks.VolumeMax > 0 {
+		vol = ternary.Min(vol, int(quirks.VolumeMax))
+	}
 
 	e = rend.SetVolume(instanceId, channel, uint16(vol))
 	if e != nil {
@@ -380,17 +665,17 @@ func (rend *Renderer) SetRelativeVolume(instanceId uint32, channel string, adjus
 }
 
 func (rend *Renderer) SetMute(instanceId uint32, channel string, desiredMute bool) error {
-	return rend.renderControl.SendAction("SetMute", "Channel", "Master", "DesiredMute", desiredMute)
+	return rend.send(rend.renderControl, "SetMute", "Channel", "Master", "DesiredMute", desiredMute)
 }
 
 //-------------------------------------------------------------[ AVTRANSPORT ]--
 
 func (rend *Renderer) Play(instanceId uint32, speed string) error {
-	return rend.avTransport.SendAction("Play", "Speed", speed)
+	return rend.send(rend.avTransport, "Play", "Speed", speed)
 }
 
 func (rend *Renderer) Pause(instanceId uint32) error {
-	return rend.avTransport.SendAction("Pause")
+	return rend.send(rend.avTransport, "Pause")
 }
 
 func (rend *Renderer) PlayPause(instanceId uint32, speed string) error {
@@ -405,12 +690,14 @@ func (rend *Renderer) PlayPause(instanceId uint32, speed string) error {
 }
 
 func (rend *Renderer) Stop(instanceId uint32) error {
-	return rend.avTransport.SendAction("Stop")
+	return rend.send(rend.avTransport, "Stop")
 }
 
 func (rend *Renderer) SetAVTransportURI(instanceId uint32, currentURI, currentURIMetaData string) error {
-	rend.Stop(0)
-	e := rend.avTransport.SendAction("SetAVTransportURI", "CurrentURI", currentURI, "CurrentURIMetaData", currentURIMetaData)
+	if rend.Quirks().StopBeforeSetURI {
+		rend.Stop(0)
+	}
+	e := rend.send(rend.avTransport, "SetAVTransportURI", "CurrentURI", currentURI, "CurrentURIMetaData", currentURIMetaData)
 	if e != nil {
 		return e
 	}
@@ -422,9 +709,9 @@ func (rend *Renderer) AddURIToQueue(instanceId uint32, req *upnptype.AddURIToQue
 	return nil, nil
 }
 
-// unit: ABS_TIME   (REL_TIME don't work on my TV)
+// unit: ABS_TIME or REL_TIME, depending on the renderer (see upnptype.CapableRenderer).
 func (rend *Renderer) Seek(instanceId uint32, unit, target string) error {
-	e := rend.avTransport.SendAction("Seek", "Unit", unit, "Target", target)
+	e := rend.send(rend.avTransport, "Seek", "Unit", unit, "Target", target)
 	if e != nil {
 		return e
 	}
@@ -435,7 +722,7 @@ func (rend *Renderer) Seek(instanceId uint32, unit, target string) error {
 
 func (rend *Renderer) GetCurrentTime() int {
 	current := ""
-	if !log.Err(rend.avTransport.SendAction("GetPositionInfo", nil, "AbsTime", &current), "AbsTime") {
+	if rend.send(rend.avTransport, "GetPositionInfo", nil, "AbsTime", &current) == nil { // errors are logged by send.
 		// log.Info("AbsTime", current)
 		rend.Current = upnptype.TimeToSecond(current)
 	}
@@ -465,63 +752,67 @@ func (rend *Renderer) GetTransportInfo(instanceID uint32) (*upnptype.TransportIn
 	return &upnptype.TransportInfo{}, nil
 }
 
-// TODO: need to complete it.
 func (rend *Renderer) GetPositionInfo(instanceID uint32) (*upnptype.PositionInfo, error) {
 	pos := &upnptype.PositionInfo{}
-	rend.avTransport.SendAction("GetPositionInfo", nil, "TrackDuration", &pos.TrackDuration)
-	// rend.avTransport.SendAction("GetMediaInfo", nil, "MediaDuration", &pos.TrackDuration) // works too, but may not point exactly to the same thing...
-
+	var track uint
+	e := rend.send(rend.avTransport, "GetPositionInfo", nil,
+		"Track", &track,
+		"TrackDuration", &pos.TrackDuration,
+		"TrackMetaData", &pos.TrackMetaData,
+		"TrackURI", &pos.TrackURI,
+		"RelTime", &pos.RelTime,
+		"AbsTime", &pos.AbsTime)
+	if e != nil {
+		return nil, e
+	}
+	pos.Track = uint32(track)
 	return pos, nil
 }
 
-func (rend *Renderer) Next(instanceID uint32) error     { return nil }
-func (rend *Renderer) Previous(instanceID uint32) error { return nil }
+func (rend *Renderer) Next(instanceID uint32) error {
+	return rend.send(rend.avTransport, "Next")
+}
+
+func (rend *Renderer) Previous(instanceID uint32) error {
+	return rend.send(rend.avTransport, "Previous")
+}
+
 func (rend *Renderer) SetNextAVTransportURI(instanceID uint32, nextURI, nextURIMetaData string) error {
-	return nil
+	return rend.send(rend.avTransport, "SetNextAVTransportURI", "NextURI", nextURI, "NextURIMetaData", nextURIMetaData)
 }
 
 //
 //-------------------------------------------------------[ RENDERER MESSAGES ]--
 
-// Parse received message from device.
-//
-func unmarshalMessage(x string) map[string]interface{} {
-	m, err := mxj.NewMapXml([]byte(x))
-	log.Err(err, "parse xml message")
-
-	gg, e := m.ValuesForPath("Event.InstanceID")
-	if e == nil && len(gg) > 0 {
-
-		values := make(map[string]interface{})
-
-		for k, v := range gg[0].(map[string]interface{}) {
-			if k != "-val" {
-				values[k] = v.(map[string]interface{})["-val"]
-			}
-		}
-		return values
-	}
-	return nil
-}
+// Variables parsed from received LastChange messages.
+var (
+	lastChangeAVT = []string{"TransportState", "CurrentTrackDuration", "CurrentTrackMetaData"}
+	lastChangeRCS = []string{"Mute", "Volume"}
+)
 
 func (rend *Renderer) onMsgAVT(str string) {
 	// log.Info("onMsgAVT", str)
 	// log.DEV("AVT")
+	rend.recorder.Event(rend.udn, rend.avTransport.GetServiceType(), "LastChange", str)
+
+	values, e := rend.lastChange.ParseString(0, str, lastChangeAVT...)
+	if e != nil {
+		rend.log.Warn("parse AVTransport LastChange", upnptype.FieldUDN, rend.udn, upnptype.FieldError, e)
+	}
 
-	for k, v := range unmarshalMessage(str) {
-		_ = v
+	for k, v := range values {
 		switch k {
 		case "TransportState":
-			rend.state = upnptype.PlaybackStateFromName(v.(string))
+			rend.state = upnptype.PlaybackStateFromName(v)
 			rend.events.OnTransportState(rend, rend.state)
 			rend.tick = upnptype.Clock(rend, rend.tick, rend.state)
 
 		case "CurrentTrackDuration":
-			rend.duration = upnptype.TimeToSecond(v.(string))
-			rend.events.OnCurrentTrackDuration(rend, upnptype.TimeToSecond(v.(string)))
+			rend.duration = upnptype.TimeToSecond(v)
+			rend.events.OnCurrentTrackDuration(rend, upnptype.TimeToSecond(v))
 
 		case "CurrentTrackMetaData":
-			rend.events.OnCurrentTrackMetaData(rend, unmarshalDidl(v.(string)))
+			rend.events.OnCurrentTrackMetaData(rend, rend.unmarshalDidl(v))
 
 			// log.DETAIL(item)
 			// log.Info(k, v)
@@ -537,17 +828,22 @@ func (rend *Renderer) onMsgAVT(str string) {
 func (rend *Renderer) onMsgRCS(str string) {
 	// log.Info("onMsgRCS", str)
 	// log.DEV("RCS")
+	rend.recorder.Event(rend.udn, rend.renderControl.GetServiceType(), "LastChange", str)
+
+	values, e := rend.lastChange.ParseString(0, str, lastChangeRCS...)
+	if e != nil {
+		rend.log.Warn("parse RenderingControl LastChange", upnptype.FieldUDN, rend.udn, upnptype.FieldError, e)
+	}
 
-	for k, v := range unmarshalMessage(str) {
-		_ = v
+	for k, v := range values {
 		switch k {
 		case "Mute":
-			if i, e := strconv.Atoi(v.(string)); e == nil {
+			if i, e := strconv.Atoi(v); e == nil {
 				rend.events.OnMute(rend, i == 1)
 			}
 
 		case "Volume":
-			if i, e := strconv.Atoi(v.(string)); e == nil {
+			if i, e := strconv.Atoi(v); e == nil {
 				rend.events.OnVolume(rend, uint(i))
 			}
 
@@ -559,49 +855,250 @@ func (rend *Renderer) onMsgRCS(str string) {
 	}
 }
 
+func (rend *Renderer) onConnectionIDs(service *gupnp.ServiceProxy, variable string, value string) {
+	rend.recorder.Event(rend.udn, service.GetServiceType(), variable, value)
+	rend.events.OnCurrentConnectionIDs(rend, upnptype.ParseConnectionIDs(value))
+}
+
+func (rend *Renderer) unsubscribe() {
+	rend.avTransport.SetSubscribed(false)
+	rend.renderControl.SetSubscribed(false)
+}
+
+//
+//---------------------------------------------------------[ SERVER MESSAGES ]--
+
+func (srv *Server) onSystemUpdateID(service *gupnp.ServiceProxy, variable string, value uint) {
+	srv.recorder.Event(srv.udn, service.GetServiceType(), variable, strconv.FormatUint(uint64(value), 10))
+	srv.events.OnSystemUpdateID(srv, value)
+}
+
+func (srv *Server) onContainerUpdateIDs(service *gupnp.ServiceProxy, variable string, value string) {
+	srv.recorder.Event(srv.udn, service.GetServiceType(), variable, value)
+	srv.events.OnContainerUpdateIDs(srv, upnptype.ParseContainerUpdateIDs(value))
+}
+
+func (srv *Server) unsubscribe() {
+	srv.contentDir.SetSubscribed(false)
+}
+
+//
+//----------------------------------------------------------------[ SERVICES ]--
+
+// getService returns the service proxy matching the schema, or nil if the
+// device doesn't provide it.
+func getService(proxy *gupnp.DeviceProxy, schema string) *gupnp.ServiceProxy {
+	info := proxy.DeviceInfo.GetService(schema)
+	if info == nil {
+		return nil
+	}
+	return &gupnp.ServiceProxy{*info}
+}
+
+// watch subscribes to an evented state variable of a device service.
+func watch(proxy *gupnp.DeviceProxy, schema, variable string, typ glib.Type, callback gupnp.NotifyFunc) bool {
+	service := getService(proxy, schema)
+	if service == nil {
+		return false
+	}
+	ok := service.AddNotifyValue(variable, typ, callback)
+	service.SetSubscribed(true)
+	return ok
+}
+
+// send sends a SOAP action to a renderer service, and records its metrics, logs and traffic.
+func (rend *Renderer) send(service *gupnp.ServiceProxy, action string, args ...interface{}) error {
+	return sendAction(rend.metrics, rend.log, rend.recorder, rend.udn, rend.name, service, action, args...)
+}
+
+// send sends a SOAP action to a server service, and records its metrics, logs and traffic.
+func (srv *Server) send(service *gupnp.ServiceProxy, action string, args ...interface{}) error {
+	return sendAction(srv.metrics, srv.log, srv.recorder, srv.udn, srv.name, service, action, args...)
+}
+
+func sendAction(metrics *upnpmetrics.Metrics, log upnptype.Logger, rec *upnprecord.Recorder, udn, name string, service *gupnp.ServiceProxy, action string, args ...interface{}) error {
+	start := time.Now()
+	e := service.SendAction(action, args...)
+	duration := time.Since(start)
+	metrics.Action(udn, name, action, duration, e)
+	if rec != nil {
+		in, out := actionArgs(args)
+		rec.Action(udn, service.GetServiceType(), action, in, out, duration, e)
+	}
+	if e != nil {
+		log.Warn("action failed", upnptype.FieldUDN, udn, upnptype.FieldAction, action, upnptype.FieldDuration, duration, upnptype.FieldError, e)
+	} else {
+		log.Debug("action", upnptype.FieldUDN, udn, upnptype.FieldAction, action, upnptype.FieldDuration, duration)
+	}
+	return e
+}
+
+// actionArgs returns the input and output arguments of an action sent with
+// SendAction, as sent on the wire. Output pointers are read after the call.
+func actionArgs(args []interface{}) (in, out map[string]string) {
+	in = map[string]string{"InstanceID": "0"}
+	out = make(map[string]string)
+	list := in
+	for i := 0; i < len(args); i++ {
+		if args[i] == nil { // separator between in and out args.
+			list = out
+			continue
+		}
+		if i+1 < len(args) {
+			list[fmt.Sprint(args[i])] = formatArg(args[i+1])
+			i++
+		}
+	}
+	return in, out
+}
+
+func formatArg(value interface{}) string {
+	switch v := value.(type) {
+	case *bool:
+		return formatArg(*v)
+	case *string:
+		return *v
+	case *uint:
+		return strconv.FormatUint(uint64(*v), 10)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	}
+	return fmt.Sprint(value)
+}
+
+// subscribe subscribes to the events of a device service. A lost subscription
+// is renewed after ResubscribeDelay, while the device is still found.
+func (cp *ControlPoint) subscribe(service *gupnp.ServiceProxy, udn, name string) {
+	serviceType := service.GetServiceType()
+	_, e := service.Connect("subscription-lost", func(_ *glib.Object) {
+		cp.metrics.SubscriptionFailed(udn, name, serviceType)
+		cp.log.Info("subscription lost", upnptype.FieldUDN, udn, upnptype.FieldDevice, name, "service", serviceType)
+
+		glib.TimeoutAdd(uint(ResubscribeDelay/time.Millisecond), func() bool {
+			if _, ok := cp.found[udn]; ok {
+				service.SetSubscribed(false)
+				service.SetSubscribed(true)
+				cp.metrics.SubscriptionRenewed(udn, name, serviceType)
+			}
+			return false // run once.
+		})
+	})
+	if e != nil {
+		cp.log.Error("connect subscription-lost", upnptype.FieldUDN, udn, upnptype.FieldError, e)
+	}
+
+	service.SetSubscribed(true)
+	cp.metrics.SubscriptionRenewed(udn, name, serviceType)
+}
+
 //
 //-------------------------------------------------------------------[ ICONS ]--
 
-func getIconFile(url, filename string) string {
-	file, e := os.Create(filename)
-	if log.Err(e, "Create icon file") {
+func getIconFile(log upnptype.Logger, url, filename string) string {
+	data, e := download(url)
+	if e != nil {
+		log.Warn("download icon", "url", url, upnptype.FieldError, e)
 		return ""
 	}
-	defer file.Close()
-	_, e = file.Write(download(url))
-	if log.Err(e, "Write icon file") {
+	e = ioutil.WriteFile(filename, data, 0644)
+	if e != nil {
+		log.Warn("write icon file", "file", filename, upnptype.FieldError, e)
 		return ""
 	}
 	return filename
 }
 
-func download(addr string) []byte {
-	resp, err := http.Get(addr)
-	if err != nil {
-		log.Err(err, "http.Get")
-		// handle error
-		return []byte{}
+func download(addr string) ([]byte, error) {
+	resp, e := http.Get(addr)
+	if e != nil {
+		return nil, e
 	}
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	log.Err(err, "ioutil.ReadAll")
-	return body
+	return ioutil.ReadAll(resp.Body)
 }
 
 //
-//-------------------------------------------------------------[ XML PARSING ]--
+//------------------------------------------------------------[ DIDL PARSING ]--
+
+// parseDidl parses a DIDL-Lite document with the gupnp-av parser.
+// Objects found before a parsing error are returned with the error.
+// Results must match upnptype.ParseDIDL (see didl_test.go).
+func parseDidl(str string) ([]upnptype.Container, []upnptype.Item, error) {
+	var containers []upnptype.Container
+	var items []upnptype.Item
+	if str == "" {
+		return containers, items, nil
+	}
+
+	objects, e := gupnp.ParseDIDL(str)
+	for _, obj := range objects {
+		if obj.IsContainer() {
+			containers = append(containers, upnptype.Container{
+				Object:     didlObject(obj),
+				ChildCount: ternary.Max(obj.GetChildCount(), 0),
+			})
+		} else {
+			items = append(items, upnptype.Item{
+				Object: didlObject(obj),
+				Res:    didlResources(obj),
+			})
+		}
+	}
+	return containers, items, e
+}
 
-func unmarshalDidl(str string) *upnptype.Item {
-	item := didl{}
-	xml.Unmarshal([]byte(str), &item)
-	return &item.Item
+func (rend *Renderer) unmarshalDidl(str string) *upnptype.Item {
+	_, items, e := parseDidl(str)
+	if e != nil {
+		rend.log.Warn("parse track DIDL", upnptype.FieldUDN, rend.udn, upnptype.FieldError, e)
+	}
+	if e != nil || len(items) == 0 {
+		return &upnptype.Item{}
+	}
+	return &items[0]
 }
 
-type didlContainer struct {
-	Container []upnptype.Container `xml:"container"`
-	Item      []upnptype.Item      `xml:"item"`
+func didlObject(obj *gupnp.DIDLLiteObject) upnptype.Object {
+	restricted := 0
+	if obj.GetRestricted() {
+		restricted = 1
+	}
+	return upnptype.Object{
+		ID:         obj.GetID(),
+		ParentID:   obj.GetParentID(),
+		Restricted: restricted,
+		Class:      obj.GetUpnpClass(),
+		Title:      obj.GetTitle(),
+		Artist:     obj.GetArtist(),
+		Album:      obj.GetAlbum(),
+		Genre:      obj.GetGenre(),
+		AlbumArt:   obj.GetAlbumArt(),
+	}
 }
 
-type didl struct {
-	Item upnptype.Item
+func didlResources(obj *gupnp.DIDLLiteObject) []upnptype.Resource {
+	var list []upnptype.Resource
+	for _, res := range obj.GetResources() {
+		ur := upnptype.Resource{
+			ProtocolInfo: res.GetProtocolInfo(),
+			URL:          res.GetURI(),
+		}
+		if size := res.GetSize(); size > 0 {
+			ur.Size = uint64(size)
+		}
+		if bitrate := res.GetBitrate(); bitrate > 0 {
+			ur.Bitrate = uint(bitrate)
+		}
+		if duration := res.GetDuration(); duration >= 0 {
+			ur.Duration = upnptype.TimeToString(duration)
+		}
+		if width, height := res.GetResolution(); width > 0 && height > 0 {
+			ur.Resolution = strconv.Itoa(width) + "x" + strconv.Itoa(height)
+		}
+		list = append(list, ur)
+	}
+	return list
 }
@@ -0,0 +1,45 @@
+package backendgupnp
+
+import (
+	"github.com/sqp/gupnp/upnptype"
+
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// TestParseDidl checks the gupnp-av parser against the documents used to test
+// upnptype.ParseDIDL, so both parsers give the same objects.
+func TestParseDidl(t *testing.T) {
+	data, e := ioutil.ReadFile("../upnptype/testdata/didl.json")
+	if e != nil {
+		t.Fatal(e)
+	}
+	var tests []struct {
+		Name       string
+		DIDL       string
+		Containers []upnptype.Container
+		Items      []upnptype.Item
+		Error      bool
+	}
+	if e := json.Unmarshal(data, &tests); e != nil {
+		t.Fatal("parse fixtures:", e)
+	}
+
+	for _, test := range tests {
+		containers, items, e := parseDidl(test.DIDL)
+		if (e != nil) != test.Error {
+			t.Errorf("%s: error %v", test.Name, e)
+		}
+		if test.Error {
+			continue
+		}
+		if !reflect.DeepEqual(containers, test.Containers) {
+			t.Errorf("%s: containers\n%+v\nwant\n%+v", test.Name, containers, test.Containers)
+		}
+		if !reflect.DeepEqual(items, test.Items) {
+			t.Errorf("%s: items\n%+v\nwant\n%+v", test.Name, items, test.Items)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package backendgupnp
+
+import (
+	"github.com/gotk3/gotk3/glib"
+
+	"github.com/sqp/gupnp/gupnp"
+	"github.com/sqp/gupnp/upnptype"
+)
+
+// embeddedDevice defines a media device found inside a root device.
+type embeddedDevice struct {
+	renderer *Renderer
+	server   *Server
+}
+
+// embeddedRoot defines a root device traversed, with its media devices.
+type embeddedRoot struct {
+	nw      *network
+	devices []*embeddedDevice
+}
+
+//
+//-----------------------------------------------------------[ ROOT DEVICES ]--
+
+// onRootProxyAvailable looks for media devices embedded in the root device
+// (NAS boxes, AV receivers), as they may not be announced on their own.
+func (cp *ControlPoint) onRootProxyAvailable(one *glib.Object, two *glib.Object, nw *network) {
+	root := gupnp.WrapDeviceProxy(two)
+	rootUDN := root.GetUdn()
+	if _, ok := cp.embedded[rootUDN]; ok {
+		return
+	}
+	cp.embedded[rootUDN] = &embeddedRoot{nw: nw, devices: cp.walkEmbedded(&root.DeviceInfo, nw)}
+}
+
+// onRootProxyLost forwards lost events for devices found by traversal.
+func (cp *ControlPoint) onRootProxyLost(one *glib.Object, two *glib.Object) {
+	cp.lostRoot(gupnp.WrapDeviceProxy(two).GetUdn())
+}
+
+// lostRoot forwards lost events for devices found by traversal of the root
+// device, and forgets its embedded devices parents.
+func (cp *ControlPoint) lostRoot(rootUDN string) {
+	root, ok := cp.embedded[rootUDN]
+	if !ok {
+		return
+	}
+	for _, dev := range root.devices {
+		if dev.renderer != nil {
+			dev.renderer.unsubscribe()
+			cp.lostRenderer(dev.renderer)
+		}
+		if dev.server != nil {
+			dev.server.unsubscribe()
+			cp.lostServer(dev.server)
+		}
+	}
+	delete(cp.embedded, rootUDN)
+	cp.forgetParents(rootUDN)
+}
+
+// forgetParents removes the parent entries of the devices embedded in the
+// device, recursively.
+func (cp *ControlPoint) forgetParents(parentUDN string) {
+	for udn, parent := range cp.parents {
+		if parent == parentUDN {
+			delete(cp.parents, udn)
+			cp.forgetParents(udn)
+		}
+	}
+}
+
+// walkEmbedded registers the parent of embedded devices, and creates the
+// media devices not already found.
+func (cp *ControlPoint) walkEmbedded(parent *gupnp.DeviceInfo, nw *network) (list []*embeddedDevice) {
+	parentUDN := parent.GetUdn()
+	for _, child := range parent.ListDevices() {
+		udn := child.GetUdn()
+		cp.parents[udn] = parentUDN
+
+		if dev, ok := cp.found[udn]; ok { // Already found by its own announce.
+			dev.DeviceInfo().ParentUDN = parentUDN
+
+		} else {
+			proxy := gupnp.WrapDeviceProxy(child.Object)
+			switch typ := child.GetDeviceType(); {
+			case isDeviceType(typ, SchemaMediaRenderer):
+				if r := cp.addRenderer(proxy, nw); r != nil {
+					cp.log.Info("embedded renderer found", upnptype.FieldUDN, udn, upnptype.FieldDevice, r.Name(), "parent", parentUDN)
+					list = append(list, &embeddedDevice{renderer: r})
+				}
+
+			case isDeviceType(typ, SchemaMediaServer):
+				if s := cp.addServer(proxy, nw); s != nil {
+					cp.log.Info("embedded server found", upnptype.FieldUDN, udn, upnptype.FieldDevice, s.Name(), "parent", parentUDN)
+					list = append(list, &embeddedDevice{server: s})
+				}
+			}
+		}
+
+		list = append(list, cp.walkEmbedded(child, nw)...)
+	}
+	return list
+}
+
+//
+//-----------------------------------------------------------------[ HELPERS ]--
+
+// deviceInfo returns the description of the device, with its parent if known.
+func (cp *ControlPoint) deviceInfo(proxy *gupnp.DeviceProxy) *upnptype.DeviceInfo {
+	info := deviceInfo(&proxy.DeviceInfo)
+	info.ParentUDN = cp.parents[info.UDN]
+	return info
+}
+
+// lostRenderer forgets the renderer and forwards the lost event once.
+func (cp *ControlPoint) lostRenderer(r *Renderer) {
+	if cp.found[r.udn] != device(r) { // Already lost, or found again as another instance.
+		return
+	}
+	delete(cp.found, r.udn)
+	cp.recorder.Lost(r.udn)
+	cp.events.onRendererLost(r)
+}
+
+// lostServer forgets the server and forwards the lost event once.
+func (cp *ControlPoint) lostServer(s *Server) {
+	if cp.found[s.udn] != device(s) { // Already lost, or found again as another instance.
+		return
+	}
+	delete(cp.found, s.udn)
+	cp.recorder.Lost(s.udn)
+	cp.events.onServerLost(s)
+}
@@ -0,0 +1,274 @@
+package backendgupnp
+
+import (
+	"github.com/gotk3/gotk3/glib"
+
+	"github.com/sqp/gupnp/gupnp"
+	"github.com/sqp/gupnp/upnptype"
+
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StaticPollInterval defines the delay between checks of static devices.
+//
+var StaticPollInterval = 30 * time.Second
+
+// staticDevice defines a device added by its description URL.
+type staticDevice struct {
+	location string
+	udn      string    // read from the description. Empty until downloaded.
+	renderer *Renderer // created from the description. nil when found by SSDP.
+	server   *Server
+	stop     chan struct{} // closed to stop polling. nil when not started.
+}
+
+// AddDevice registers a device by its description URL (the SSDP LOCATION),
+// for devices unreachable by multicast discovery.
+//
+// The renderer or server is created once the description can be downloaded
+// and forwarded with the usual found event. The location is then polled
+// to forward lost and found events.
+//
+func (cp *ControlPoint) AddDevice(location string) error {
+	if _, ok := cp.static[location]; ok {
+		return nil
+	}
+	u, e := url.Parse(location)
+	if e != nil {
+		return e
+	}
+	if u.Scheme != "http" || u.Host == "" {
+		return errors.New("invalid device location: " + location)
+	}
+
+	dev := &staticDevice{location: location}
+	cp.static[location] = dev
+	cp.saveStatic()
+
+	if len(cp.contexts) > 0 { // Or wait for a network context.
+		cp.watchStatic(dev)
+	}
+	return nil
+}
+
+// RemoveDevice unregisters a device added by its description URL.
+//
+func (cp *ControlPoint) RemoveDevice(location string) {
+	dev, ok := cp.static[location]
+	if !ok {
+		return
+	}
+	if dev.stop != nil {
+		close(dev.stop)
+	}
+	cp.dropStatic(dev)
+	delete(cp.static, location)
+	cp.saveStatic()
+}
+
+// StaticDevices returns the description URL of devices added manually.
+//
+func (cp *ControlPoint) StaticDevices() []string {
+	list := make([]string, 0, len(cp.static))
+	for location := range cp.static {
+		list = append(list, location)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// LoadStaticDevices adds the devices listed in a JSON file, and keeps the
+// file updated when devices are added or removed.
+//
+// A missing file is not an error, it will be created on the first change.
+//
+func (cp *ControlPoint) LoadStaticDevices(filename string) error {
+	data, e := ioutil.ReadFile(filename)
+	if e != nil && !os.IsNotExist(e) {
+		return e
+	}
+
+	var list []string
+	if len(data) > 0 {
+		if e := json.Unmarshal(data, &list); e != nil {
+			return e
+		}
+	}
+
+	cp.staticFile = "" // Don't save while loading.
+	for _, location := range list {
+		if e := cp.AddDevice(location); e != nil {
+			cp.log.Warn("add static device", "location", location, upnptype.FieldError, e)
+		}
+	}
+	cp.staticFile = filename
+	return nil
+}
+
+func (cp *ControlPoint) saveStatic() {
+	if cp.staticFile == "" {
+		return
+	}
+	data, e := json.MarshalIndent(cp.StaticDevices(), "", "\t")
+	if e == nil {
+		e = ioutil.WriteFile(cp.staticFile, data, 0644)
+	}
+	if e != nil {
+		cp.log.Warn("save static devices", "file", cp.staticFile, upnptype.FieldError, e)
+	}
+}
+
+//
+//-----------------------------------------------------------------[ POLLING ]--
+
+// startStatic starts polling static devices waiting for a network context.
+func (cp *ControlPoint) startStatic() {
+	for _, dev := range cp.static {
+		if dev.stop == nil {
+			cp.watchStatic(dev)
+		}
+	}
+}
+
+// watchStatic polls the device description. Results are handled in the main loop.
+func (cp *ControlPoint) watchStatic(dev *staticDevice) {
+	dev.stop = make(chan struct{})
+	go func(location string, stop chan struct{}) {
+		tick := time.NewTicker(StaticPollInterval)
+		defer tick.Stop()
+		for {
+			data, e := fetchDescription(location)
+			glib.IdleAdd(func() { cp.updateStatic(dev, data, e) })
+
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+			}
+		}
+	}(dev.location, dev.stop)
+}
+
+func (cp *ControlPoint) updateStatic(dev *staticDevice, description []byte, e error) {
+	if cp.static[dev.location] != dev { // Removed meanwhile.
+		return
+	}
+	if e == nil && dev.udn == "" {
+		var info *upnptype.DeviceInfo
+		if info, e = upnptype.ParseDeviceDescription(description, dev.location); e == nil {
+			dev.udn = info.UDN
+		}
+	}
+
+	// Alive when created from the description, or already found by SSDP.
+	_, alive := cp.found[dev.udn]
+	switch {
+	case e != nil && alive:
+		cp.log.Info("static device lost", "location", dev.location, upnptype.FieldError, e)
+		cp.dropStatic(dev)
+
+	case e == nil && !alive:
+		if e := cp.createStatic(dev, description); e != nil {
+			cp.log.Warn("create static device", "location", dev.location, upnptype.FieldError, e)
+		}
+	}
+}
+
+func (cp *ControlPoint) createStatic(dev *staticDevice, description []byte) error {
+	ctx := cp.contextFor(dev.location)
+	if ctx == nil {
+		return errors.New("no network context")
+	}
+	proxy, e := gupnp.DeviceProxyNewFromDescription(ctx.Context, description, dev.location)
+	if e != nil {
+		return e
+	}
+
+	switch typ := proxy.GetDeviceType(); {
+	case isDeviceType(typ, SchemaMediaRenderer):
+		dev.renderer = cp.addRenderer(proxy, ctx.nw)
+
+	case isDeviceType(typ, SchemaMediaServer):
+		dev.server = cp.addServer(proxy, ctx.nw)
+
+	default:
+		return errors.New("unsupported device type " + typ + ": " + dev.location)
+	}
+	if dev.renderer == nil && dev.server == nil {
+		return errors.New("missing media service: " + dev.location)
+	}
+	return nil
+}
+
+func (cp *ControlPoint) dropStatic(dev *staticDevice) {
+	if dev.renderer != nil {
+		dev.renderer.unsubscribe()
+		cp.lostRenderer(dev.renderer)
+		dev.renderer = nil
+	}
+	if dev.server != nil {
+		dev.server.unsubscribe()
+		cp.lostServer(dev.server)
+		dev.server = nil
+	}
+}
+
+// contextFor returns the network context used to reach the location.
+func (cp *ControlPoint) contextFor(location string) *netContext {
+	if len(cp.contexts) == 0 {
+		return nil
+	}
+	if u, e := url.Parse(location); e == nil {
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+		if conn, e := net.Dial("udp", host); e == nil { // No packet sent, only resolves the route.
+			localIP := conn.LocalAddr().(*net.UDPAddr).IP.String()
+			conn.Close()
+			for _, ctx := range cp.contexts {
+				if ctx.nw.hostIP == localIP {
+					return ctx
+				}
+			}
+		}
+	}
+	return cp.contexts[0]
+}
+
+//
+//-----------------------------------------------------------------[ HELPERS ]--
+
+var descriptionClient = &http.Client{Timeout: 10 * time.Second}
+
+func fetchDescription(location string) ([]byte, error) {
+	resp, e := descriptionClient.Get(location)
+	if e != nil {
+		return nil, e
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("get device description: " + resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// isDeviceType compares device types, ignoring the version.
+func isDeviceType(typ, schema string) bool {
+	trim := func(s string) string {
+		if i := strings.LastIndex(s, ":"); i > 0 {
+			return s[:i]
+		}
+		return s
+	}
+	return trim(typ) == trim(schema)
+}
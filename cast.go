@@ -0,0 +1,263 @@
+package gupnp
+
+import (
+	"github.com/sqp/gupnp/upnptype"
+
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Errors returned when an action requires a selected device.
+//
+var (
+	ErrNoRenderer = errors.New("no renderer selected")
+	ErrNoServer   = errors.New("no server selected")
+)
+
+//
+//--------------------------------------------------------------------[ CAST ]--
+
+// PlayURL starts the playback of a media URL on the selected renderer.
+//
+// mimeType describes the media to the renderer. When empty, or not a media
+// type, it's guessed from the URL extension. upnptype.ProbeURL asks the
+// server, out of the backend main loop.
+//
+func (cp *MediaControl) PlayURL(uri, mimeType string) error {
+	if cp.curRend == nil {
+		return ErrNoRenderer
+	}
+	cp.slideshow.halt()
+	parsed, e := neturl.Parse(uri)
+	if e != nil {
+		return e
+	}
+	if upnptype.ItemClass(mimeType) == "" {
+		if guess := upnptype.MimeType(parsed.Path); guess != "" {
+			mimeType = guess
+		}
+	}
+	if mimeType == "" {
+		mimeType = "*"
+	}
+	title := path.Base(parsed.Path)
+	if title == "/" || title == "." {
+		title = parsed.Host
+	}
+	return cp.curCaps.SetAVTransportURI(0, uri, upnptype.Metadata(uri, title, mimeType, 0))
+}
+
+// PlayFile starts the playback of a local file on the selected renderer.
+//
+// The file is served by an internal HTTP server listening on the address
+// facing the renderer, until Close is called. Subtitles found next to a video
+// (see upnptype.FindSubtitles) are also served, unless disabled for the
+// renderer.
+//
+func (cp *MediaControl) PlayFile(filename string) error {
+	if cp.curRend == nil {
+		return ErrNoRenderer
+	}
+	filename, e := filepath.Abs(filename)
+	if e != nil {
+		return e
+	}
+	info, e := os.Stat(filename)
+	if e != nil {
+		return e
+	}
+	if !info.Mode().IsRegular() {
+		return errors.New("not a file: " + filename)
+	}
+	mimeType := upnptype.MimeType(filename)
+	if mimeType == "" {
+		return errors.New("unknown media type: " + filename)
+	}
+	cp.slideshow.halt()
+
+	hostIP, e := localAddress(cp.curRend)
+	if e != nil {
+		return e
+	}
+	uri, e := cp.cast.share(hostIP, filename)
+	if e != nil {
+		return e
+	}
+	title := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+	metadata := upnptype.Metadata(uri, title, mimeType, info.Size())
+
+	cp.cast.setCaption(filename, "")
+	if upnptype.ItemClass(mimeType) == upnptype.ClassVideo && cp.Subtitles(cp.curRend.UDN()) {
+		if subs := upnptype.FindSubtitles(filename); len(subs) > 0 {
+			subURI, e := cp.cast.share(hostIP, subs[0])
+			if e != nil {
+				return e
+			}
+			cp.cast.setCaption(filename, subURI)
+			metadata = upnptype.AddSubtitle(metadata, subURI, upnptype.SubtitleMimeType(subs[0]))
+		}
+	}
+	return cp.curCaps.SetAVTransportURI(0, uri, metadata)
+}
+
+// Close stops serving the files played with PlayFile.
+//
+func (cp *MediaControl) Close() error {
+	return cp.cast.close()
+}
+
+// localAddress returns the local IP address used to reach the renderer.
+func localAddress(rend upnptype.Renderer) (string, error) {
+	if ip := rend.HostIP(); ip != "" {
+		return ip, nil
+	}
+	info := rend.DeviceInfo()
+	if info == nil || info.Location == "" {
+		return "", errors.New("unknown renderer address: " + rend.Name())
+	}
+	loc, e := neturl.Parse(info.Location)
+	if e != nil {
+		return "", e
+	}
+	port := loc.Port()
+	if port == "" {
+		port = "80"
+	}
+	conn, e := net.Dial("udp", net.JoinHostPort(loc.Hostname(), port)) // No packet sent, only routing.
+	if e != nil {
+		return "", e
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+//
+//-------------------------------------------------------------[ CAST SERVER ]--
+
+// castServer serves local files to renderers, with one HTTP server for each
+// local address.
+type castServer struct {
+	mu       sync.Mutex
+	servers  map[string]*http.Server // Indexed by local IP.
+	bases    map[string]string       // Base URL indexed by local IP.
+	files    map[string]string       // File path indexed by token.
+	tokens   map[string]string       // Token indexed by file path.
+	captions map[string]string       // Subtitles URL indexed by video file path.
+}
+
+// share returns the URL of the file on the server for the local address.
+func (cs *castServer) share(hostIP, filename string) (string, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	base, ok := cs.bases[hostIP]
+	if !ok {
+		listener, e := net.Listen("tcp", net.JoinHostPort(hostIP, "0"))
+		if e != nil {
+			return "", e
+		}
+		if cs.servers == nil {
+			cs.servers = make(map[string]*http.Server)
+			cs.bases = make(map[string]string)
+			cs.files = make(map[string]string)
+			cs.tokens = make(map[string]string)
+			cs.captions = make(map[string]string)
+		}
+		srv := &http.Server{Handler: http.HandlerFunc(cs.serve)}
+		go srv.Serve(listener)
+		base = "http://" + listener.Addr().String() + "/"
+		cs.servers[hostIP] = srv
+		cs.bases[hostIP] = base
+	}
+
+	token, ok := cs.tokens[filename]
+	if !ok {
+		b := make([]byte, 8)
+		if _, e := rand.Read(b); e != nil {
+			return "", e
+		}
+		token = hex.EncodeToString(b)
+		cs.tokens[filename] = token
+		cs.files[token] = filename
+	}
+	return base + token + "/" + neturl.PathEscape(filepath.Base(filename)), nil
+}
+
+// setCaption sets the subtitles URL sent with a shared video.
+func (cs *castServer) setCaption(filename, subURL string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.captions == nil {
+		return
+	}
+	if subURL == "" {
+		delete(cs.captions, filename)
+	} else {
+		cs.captions[filename] = subURL
+	}
+}
+
+// serve sends a shared file, with range requests support.
+// The subtitles URL of a video is sent in the CaptionInfo.sec header.
+func (cs *castServer) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+	cs.mu.Lock()
+	filename, ok := cs.files[token]
+	caption := cs.captions[filename]
+	cs.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	file, e := os.Open(filename)
+	if e != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	info, e := file.Stat()
+	if e != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	mimeType := upnptype.MimeType(filename)
+	if mimeType == "" {
+		mimeType = upnptype.SubtitleMimeType(filename)
+	}
+	if caption != "" {
+		w.Header().Set("CaptionInfo.sec", caption)
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("transferMode.dlna.org", "Streaming")
+	if r.Header.Get("getcontentFeatures.dlna.org") == "1" {
+		w.Header().Set("contentFeatures.dlna.org", upnptype.ContentFeatures())
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
+
+func (cs *castServer) close() error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	var first error
+	for _, srv := range cs.servers {
+		if e := srv.Close(); e != nil && first == nil {
+			first = e
+		}
+	}
+	cs.servers, cs.bases, cs.files, cs.tokens, cs.captions = nil, nil, nil, nil, nil
+	return first
+}
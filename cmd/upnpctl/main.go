@@ -0,0 +1,104 @@
+// Command upnpctl controls UPnP media renderers and servers from the command
+// line, using the gupnp backend.
+//
+// Run upnpctl -h for the list of commands.
+package main
+
+import (
+	"github.com/sqp/gupnp"              // UPnP control point.
+	"github.com/sqp/gupnp/backendgupnp" // gupnp backend.
+	cgupnp "github.com/sqp/gupnp/gupnp" // glib main context.
+	"github.com/sqp/gupnp/upnpctl"      // commands.
+	"github.com/sqp/gupnp/upnplog"      // slog logger.
+
+	"github.com/peterh/liner" // shell line edition.
+
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+func init() {
+	runtime.LockOSThread() // The C backend is only used from the main thread.
+}
+
+func main() {
+	log := upnplog.Text(os.Stderr, slog.LevelWarn) // stdout is kept for results.
+	media, e := gupnp.New(log)
+	if e != nil {
+		fmt.Fprintln(os.Stderr, "upnpctl: temp dir:", e)
+	}
+	defer media.Close()
+	media.SetControlPoint(backendgupnp.NewControlPointWithOptions(backendgupnp.Options{Logger: log}))
+
+	ctl := upnpctl.New(media, os.Stdout)
+	ctl.Loop = iterate
+	input := &lineReader{ctl: ctl}
+	ctl.Input = input
+	e = ctl.Run(os.Args[1:])
+	input.Close()
+	if e != nil {
+		fmt.Fprintln(os.Stderr, "upnpctl:", e)
+		os.Exit(1)
+	}
+}
+
+// iterate handles the backend events for the duration.
+func iterate(duration time.Duration) {
+	for deadline := time.Now().Add(duration); time.Now().Before(deadline); {
+		if !cgupnp.Iterate(false) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+//
+//-------------------------------------------------------------------[ SHELL ]--
+
+// lineReader reads the shell lines with edition, completion and history.
+// The terminal is only set up when the shell prompts.
+type lineReader struct {
+	ctl   *upnpctl.Ctl
+	state *liner.State
+}
+
+func (r *lineReader) Prompt(prompt string) (string, error) {
+	if r.state == nil {
+		r.state = liner.NewLiner()
+		r.state.SetCtrlCAborts(true)
+		r.state.SetCompleter(r.ctl.Complete)
+		if f, e := os.Open(historyFile()); e == nil {
+			r.state.ReadHistory(f)
+			f.Close()
+		}
+	}
+	line, e := r.state.Prompt(prompt)
+	if e == liner.ErrPromptAborted { // Ctrl-C clears the line.
+		return "", nil
+	}
+	return line, e
+}
+
+func (r *lineReader) AppendHistory(line string) {
+	r.state.AppendHistory(line)
+}
+
+// Close saves the history and restores the terminal.
+func (r *lineReader) Close() {
+	if r.state == nil {
+		return
+	}
+	if f, e := os.Create(historyFile()); e == nil {
+		r.state.WriteHistory(f)
+		f.Close()
+	}
+	r.state.Close()
+}
+
+func historyFile() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".upnpctl_history")
+}
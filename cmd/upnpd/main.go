@@ -0,0 +1,157 @@
+// Command upnpd serves a REST API to control UPnP media renderers and browse
+// media servers, using the gupnp backend.
+//
+// The API is described on /openapi.json, and the Prometheus metrics of the
+// control point are served on /metrics.
+//
+// With -mpris, the selected renderer is also exported on the D-Bus session bus
+// as a MPRIS2 player, to be controlled by desktop media keys.
+//
+// With -mqtt tcp://host:1883, the renderers state is published to the MQTT
+// broker, with Home Assistant discovery, and commands are accepted on the set
+// topics (see package upnpmqtt).
+//
+// Warnings are logged on stderr, and actions with their duration with -v.
+//
+// With -record file, the SOAP actions, responses, faults and events of all
+// devices are written to the file as JSON lines, to be replayed in tests (see
+// package upnprecord). The file is closed on SIGINT or SIGTERM.
+package main
+
+import (
+	"github.com/sqp/gupnp"              // UPnP control point.
+	"github.com/sqp/gupnp/backendgupnp" // gupnp backend.
+	cgupnp "github.com/sqp/gupnp/gupnp" // glib main context.
+	"github.com/sqp/gupnp/mpris"        // D-Bus media player.
+	"github.com/sqp/gupnp/upnpd"        // REST API.
+	"github.com/sqp/gupnp/upnplog"      // slog logger.
+	"github.com/sqp/gupnp/upnpmetrics"  // Prometheus metrics.
+	"github.com/sqp/gupnp/upnprecord"   // traffic capture.
+
+	"github.com/godbus/dbus/v5"
+	"github.com/gotk3/gotk3/glib"
+
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+)
+
+func init() {
+	runtime.LockOSThread() // The C backend is only used from the main thread.
+}
+
+func main() {
+	os.Exit(run())
+}
+
+// run serves the API until an error or an interrupt signal, and returns the
+// exit code once the media control is closed.
+func run() int {
+	addr := flag.String("listen", upnpd.DefaultAddress, "listen `address`")
+	withMPRIS := flag.Bool("mpris", false, "export the selected renderer as a MPRIS2 player on the session bus")
+	broker := flag.String("mqtt", "", "publish the renderers to the MQTT broker `url`")
+	verbose := flag.Bool("v", false, "log debug messages")
+	record := flag.String("record", "", "record the devices traffic to `file`")
+	flag.Parse()
+
+	level := slog.LevelWarn
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	log := upnplog.Text(os.Stderr, level)
+	media, e := gupnp.New(log)
+	if e != nil {
+		fmt.Fprintln(os.Stderr, "upnpd: temp dir:", e)
+		return 1
+	}
+	defer media.Close()
+
+	var rec *upnprecord.Recorder
+	if *record != "" {
+		rec, e = upnprecord.Create(*record)
+		if e != nil {
+			fmt.Fprintln(os.Stderr, "upnpd: record:", e)
+			return 1
+		}
+		defer rec.Close()
+	}
+	media.SetControlPoint(backendgupnp.NewControlPointWithOptions(backendgupnp.Options{Logger: log, Recorder: rec}))
+
+	// API requests are run in the main loop, with the backend events.
+	call := func(fn func()) {
+		done := make(chan struct{})
+		glib.IdleAdd(func() {
+			defer close(done)
+			fn()
+		})
+		<-done
+	}
+	api := upnpd.New(media)
+	api.Call = call
+	api.Handle("/metrics", upnpmetrics.Default.Handler())
+
+	if *withMPRIS {
+		go func() {
+			if e := exportMPRIS(media, call); e != nil {
+				fmt.Fprintln(os.Stderr, "upnpd: mpris:", e)
+			}
+		}()
+	}
+	if *broker != "" {
+		go func() {
+			if e := startMQTT(media, *broker, call, log); e != nil {
+				fmt.Fprintln(os.Stderr, "upnpd: mqtt:", e)
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	exit := make(chan int, 1)
+	go func() {
+		select {
+		case e := <-serve(*addr, api):
+			fmt.Fprintln(os.Stderr, "upnpd:", e)
+			exit <- 1
+
+		case <-signals:
+			exit <- 0
+		}
+		glib.IdleAdd(func() {}) // wake the main loop.
+	}()
+
+	for {
+		select {
+		case code := <-exit:
+			return code
+
+		default:
+			cgupnp.Iterate(true)
+		}
+	}
+}
+
+// serve serves the API in the background, and returns the channel receiving
+// its error.
+func serve(addr string, api http.Handler) <-chan error {
+	errs := make(chan error, 1)
+	go func() { errs <- http.ListenAndServe(addr, api) }()
+	return errs
+}
+
+// exportMPRIS exports the selected renderer on the session bus. The player is
+// exported from its own goroutine, as it waits for the main loop.
+func exportMPRIS(media *gupnp.MediaControl, call func(func())) error {
+	conn, e := dbus.ConnectSessionBus()
+	if e != nil {
+		return e
+	}
+	player := mpris.New(media)
+	player.Call = call
+	return player.Export(conn, mpris.BusName)
+}
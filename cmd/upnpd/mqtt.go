@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/sqp/gupnp"          // UPnP control point.
+	"github.com/sqp/gupnp/upnpmqtt" // MQTT bridge.
+	"github.com/sqp/gupnp/upnptype"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"time"
+)
+
+// mqttRefresh is the delay between polls of the renderers not selected.
+const mqttRefresh = 10 * time.Second
+
+// mqttClient adapts a paho client to the bridge.
+type mqttClient struct{ mqtt.Client }
+
+// Publish doesn't wait for the broker, as it is called from the main loop.
+func (c mqttClient) Publish(topic string, retained bool, payload []byte) error {
+	c.Client.Publish(topic, 1, retained, payload)
+	return nil
+}
+
+func (c mqttClient) Subscribe(filter string, handler func(topic string, payload []byte)) error {
+	token := c.Client.Subscribe(filter, 1, func(_ mqtt.Client, msg mqtt.Message) {
+		handler(msg.Topic(), msg.Payload())
+	})
+	token.Wait()
+	return token.Error()
+}
+
+// startMQTT connects the bridge to the broker, and polls the renderers.
+// The bridge is started from its own goroutine, as it waits for the main loop.
+func startMQTT(media *gupnp.MediaControl, broker string, call func(func()), log upnptype.Logger) error {
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID("upnpd").
+		SetAutoReconnect(true).
+		SetOrderMatters(false). // commands wait for the main loop.
+		SetWill(upnpmqtt.DefaultPrefix+"/upnpd", "offline", 1, true)
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if token.Wait(); token.Error() != nil {
+		return token.Error()
+	}
+	client.Publish(upnpmqtt.DefaultPrefix+"/upnpd", 1, true, "online")
+
+	bridge := upnpmqtt.New(media, mqttClient{client}, log)
+	bridge.Call = call
+	if e := bridge.Start(); e != nil {
+		return e
+	}
+	for range time.Tick(mqttRefresh) {
+		bridge.Refresh()
+	}
+	return nil
+}
@@ -9,24 +9,29 @@ import (
 
 	"github.com/sqp/gupnp"          // UPnP control point.
 	"github.com/sqp/gupnp/guigtk"   // UPnP gui.
+	"github.com/sqp/gupnp/upnplog"  // slog logger.
 	"github.com/sqp/gupnp/upnptype" // UPnP common types.
 
-	"fmt"
+	"log/slog"
+	"os"
 )
 
 func main() {
 	gtk.Init(nil)
 
 	// Create the UPnP device manager.
-	handler := NewHandler(&logger{})
+	log := upnplog.Text(os.Stderr, slog.LevelInfo)
+	handler := NewHandler(log)
 
 	// Connect an UPnP backend to the manager.
 	// mgr := backendsonos.NewManager(&logger{app.Log()})
 	// mgr.SetEvents(app.cp.DefineEvents())
 	// go mgr.Start(true)
 
-	backend := backendgupnp.NewControlPoint()
-	backend.SetEvents(handler.cp.DefineEvents())
+	// The backend runs in the gtk main loop, used with backend.Call for the
+	// slideshow timers.
+	backend := backendgupnp.NewControlPointWithOptions(backendgupnp.Options{Logger: log})
+	handler.cp.SetControlPoint(backend)
 
 	// Create the control window.
 	guigtk.WindowTitle = "gupnp example"
@@ -46,14 +51,14 @@ func main() {
 // Handler defines a gupnp client with some connected callbacks.
 type Handler struct {
 	cp  upnptype.MediaControl
-	log *logger
+	log upnptype.Logger
 }
 
 // NewHandler creates a handler to show how to use gupnp callbacks.
-func NewHandler(log *logger) *Handler {
-	cp, e := gupnp.New(&logger{})
+func NewHandler(log upnptype.Logger) *Handler {
+	cp, e := gupnp.New(log)
 	if e != nil {
-		log.Warningf("temp dir: %s\n", e)
+		log.Warn("temp dir", upnptype.FieldError, e)
 	}
 
 	handler := &Handler{
@@ -72,26 +77,17 @@ func NewHandler(log *logger) *Handler {
 }
 
 func (o *Handler) onMediaRendererFound(r upnptype.Renderer) {
-	o.log.Infof("Renderer Found: %s  -  %s\n", r.Name(), r.UDN())
+	o.log.Info("renderer found", upnptype.FieldDevice, r.Name(), upnptype.FieldUDN, r.UDN())
 }
 
 func (o *Handler) onMediaServerFound(srv upnptype.Server) {
-	o.log.Infof("Server Found: %s  -  %s\n", srv.Name(), srv.UDN())
+	o.log.Info("server found", upnptype.FieldDevice, srv.Name(), upnptype.FieldUDN, srv.UDN())
 }
 
 func (o *Handler) onMediaRendererLost(r upnptype.Renderer) {
-	o.log.Infof("Renderer Lost: %s  -  %s\n", r.Name(), r.UDN())
+	o.log.Info("renderer lost", upnptype.FieldDevice, r.Name(), upnptype.FieldUDN, r.UDN())
 }
 
 func (o *Handler) onMediaServerLost(srv upnptype.Server) {
-	o.log.Infof("Server Lost: %s  -  %s\n", srv.Name(), srv.UDN())
+	o.log.Info("server lost", upnptype.FieldDevice, srv.Name(), upnptype.FieldUDN, srv.UDN())
 }
-
-//
-//------------------------------------------------------------------[ LOGGER ]--
-
-type logger struct{}
-
-func (l *logger) Infof(pattern string, args ...interface{}) { fmt.Printf(pattern, args...) }
-
-func (l *logger) Warningf(pattern string, args ...interface{}) { fmt.Printf(pattern, args...) }
@@ -0,0 +1,542 @@
+package gupnp
+
+/*
+#include <libgupnp-av/gupnp-av.h>
+#include <libxml/parser.h>
+#include <libxml/tree.h>
+#include <glib-2.0/glib.h>
+#include <stdlib.h>
+#include <string.h>
+
+static GUPnPDIDLLiteObject*    toGUPnPDIDLLiteObject(void *p)    { return (GUPNP_DIDL_LITE_OBJECT(p)); }
+static GUPnPDIDLLiteContainer* toGUPnPDIDLLiteContainer(void *p) { return (GUPNP_DIDL_LITE_CONTAINER(p)); }
+static GUPnPDIDLLiteResource*  toGUPnPDIDLLiteResource(void *p)  { return (GUPNP_DIDL_LITE_RESOURCE(p)); }
+static GUPnPDIDLLiteParser*    toGUPnPDIDLLiteParser(void *p)    { return (GUPNP_DIDL_LITE_PARSER(p)); }
+static GUPnPDIDLLiteWriter*    toGUPnPDIDLLiteWriter(void *p)    { return (GUPNP_DIDL_LITE_WRITER(p)); }
+static GUPnPLastChangeParser*  toGUPnPLastChangeParser(void *p)  { return (GUPNP_LAST_CHANGE_PARSER(p)); }
+
+static gboolean is_didl_lite_container(void *p) { return GUPNP_IS_DIDL_LITE_CONTAINER(p); }
+
+static gchar* didl_error_get_message(GError *error) { return error->message; }
+
+static gboolean is_element (xmlNode *node, const char *name) {
+	return node->type == XML_ELEMENT_NODE && strcmp ((const char*) node->name, name) == 0;
+}
+
+// gupnp_last_change_parser_parse_last_change is variadic and reparses the
+// document for each call. Reads it once the same way: values[i] is set to the
+// val attribute of the first names[i] element of the instance, or NULL.
+// Returns FALSE if the document isn't a LastChange event.
+static gboolean last_change_parse_strings (const char *xml, guint instance_id, char **names, char **values, int count) {
+	xmlDoc *doc = xmlRecoverMemory (xml, strlen (xml));
+	if (doc == NULL)
+		return FALSE;
+
+	xmlNode *event = xmlDocGetRootElement (doc);
+	gboolean ok = event != NULL && is_element (event, "Event");
+	xmlNode *instance = NULL;
+	xmlNode *node;
+	for (node = ok ? event->children : NULL; node != NULL && instance == NULL; node = node->next) {
+		if (!is_element (node, "InstanceID"))
+			continue;
+		xmlChar *val = xmlGetProp (node, (const xmlChar*) "val");
+		if (val != NULL && strtoul ((const char*) val, NULL, 10) == instance_id)
+			instance = node;
+		xmlFree (val);
+	}
+
+	int i;
+	for (i = 0; i < count; i++) {
+		values[i] = NULL;
+		for (node = instance ? instance->children : NULL; node != NULL; node = node->next) {
+			if (is_element (node, names[i])) {
+				xmlChar *val = xmlGetProp (node, (const xmlChar*) "val");
+				values[i] = g_strdup ((const char*) val);
+				xmlFree (val);
+				break;
+			}
+		}
+	}
+
+	xmlFreeDoc (doc);
+	return ok;
+}
+
+*/
+// #cgo pkg-config: glib-2.0 gupnp-av-1.0 libxml-2.0
+import "C"
+
+import (
+	"github.com/gotk3/gotk3/glib"
+
+	"errors"
+	"runtime"
+	"unsafe"
+)
+
+/*
+ * GUPnPDIDLLiteObject
+ */
+
+// DIDLLiteObject is a representation of GUPnP's GUPnPDIDLLiteObject, an item
+// or a container.
+type DIDLLiteObject struct {
+	*glib.Object
+}
+
+// Native() returns a pointer to the underlying GUPnPDIDLLiteObject.
+func (v *DIDLLiteObject) Native() *C.GUPnPDIDLLiteObject {
+	if v == nil || v.GObject == nil {
+		return nil
+	}
+	p := unsafe.Pointer(v.GObject)
+	return C.toGUPnPDIDLLiteObject(p)
+}
+
+func WrapDIDLLiteObject(obj *glib.Object) *DIDLLiteObject {
+	return &DIDLLiteObject{obj}
+}
+
+// IsContainer returns true if the object is a container.
+func (v *DIDLLiteObject) IsContainer() bool {
+	return gobool(C.is_didl_lite_container(unsafe.Pointer(v.GObject)))
+}
+
+func (v *DIDLLiteObject) GetID() string {
+	return C.GoString(C.gupnp_didl_lite_object_get_id(v.Native()))
+}
+
+func (v *DIDLLiteObject) GetParentID() string {
+	return C.GoString(C.gupnp_didl_lite_object_get_parent_id(v.Native()))
+}
+
+func (v *DIDLLiteObject) GetTitle() string {
+	return C.GoString(C.gupnp_didl_lite_object_get_title(v.Native()))
+}
+
+func (v *DIDLLiteObject) GetUpnpClass() string {
+	return C.GoString(C.gupnp_didl_lite_object_get_upnp_class(v.Native()))
+}
+
+func (v *DIDLLiteObject) GetCreator() string {
+	return C.GoString(C.gupnp_didl_lite_object_get_creator(v.Native()))
+}
+
+func (v *DIDLLiteObject) GetArtist() string {
+	return C.GoString(C.gupnp_didl_lite_object_get_artist(v.Native()))
+}
+
+func (v *DIDLLiteObject) GetAlbum() string {
+	return C.GoString(C.gupnp_didl_lite_object_get_album(v.Native()))
+}
+
+func (v *DIDLLiteObject) GetGenre() string {
+	return C.GoString(C.gupnp_didl_lite_object_get_genre(v.Native()))
+}
+
+func (v *DIDLLiteObject) GetAlbumArt() string {
+	return C.GoString(C.gupnp_didl_lite_object_get_album_art(v.Native()))
+}
+
+func (v *DIDLLiteObject) GetRestricted() bool {
+	return gobool(C.gupnp_didl_lite_object_get_restricted(v.Native()))
+}
+
+// GetChildCount returns the number of children of a container, -1 if unknown.
+func (v *DIDLLiteObject) GetChildCount() int {
+	if !v.IsContainer() {
+		return -1
+	}
+	return int(C.gupnp_didl_lite_container_get_child_count(C.toGUPnPDIDLLiteContainer(unsafe.Pointer(v.GObject))))
+}
+
+// GetResources returns the resources of the object.
+func (v *DIDLLiteObject) GetResources() []*DIDLLiteResource {
+	c := C.gupnp_didl_lite_object_get_resources(v.Native())
+	list := ListFromNative(unsafe.Pointer(c))
+	defer list.Free()
+
+	// The returned list should be g_list_free()'d and the elements should be g_object_unref()'d.
+	res := make([]*DIDLLiteResource, list.Length())
+	for i := range res {
+		ptr := unsafe.Pointer(list.NthData(uint(i)).(C.gpointer))
+		res[i] = &DIDLLiteResource{wrapObjectFull(ptr)}
+	}
+	return res
+}
+
+func (v *DIDLLiteObject) SetID(id string) {
+	cstr := C.CString(id)
+	defer C.free(unsafe.Pointer(cstr))
+	C.gupnp_didl_lite_object_set_id(v.Native(), cstr)
+}
+
+func (v *DIDLLiteObject) SetParentID(id string) {
+	cstr := C.CString(id)
+	defer C.free(unsafe.Pointer(cstr))
+	C.gupnp_didl_lite_object_set_parent_id(v.Native(), cstr)
+}
+
+func (v *DIDLLiteObject) SetTitle(title string) {
+	cstr := C.CString(title)
+	defer C.free(unsafe.Pointer(cstr))
+	C.gupnp_didl_lite_object_set_title(v.Native(), cstr)
+}
+
+func (v *DIDLLiteObject) SetUpnpClass(class string) {
+	cstr := C.CString(class)
+	defer C.free(unsafe.Pointer(cstr))
+	C.gupnp_didl_lite_object_set_upnp_class(v.Native(), cstr)
+}
+
+func (v *DIDLLiteObject) SetCreator(creator string) {
+	cstr := C.CString(creator)
+	defer C.free(unsafe.Pointer(cstr))
+	C.gupnp_didl_lite_object_set_creator(v.Native(), cstr)
+}
+
+func (v *DIDLLiteObject) SetArtist(artist string) {
+	cstr := C.CString(artist)
+	defer C.free(unsafe.Pointer(cstr))
+	C.gupnp_didl_lite_object_set_artist(v.Native(), cstr)
+}
+
+func (v *DIDLLiteObject) SetAlbum(album string) {
+	cstr := C.CString(album)
+	defer C.free(unsafe.Pointer(cstr))
+	C.gupnp_didl_lite_object_set_album(v.Native(), cstr)
+}
+
+func (v *DIDLLiteObject) SetGenre(genre string) {
+	cstr := C.CString(genre)
+	defer C.free(unsafe.Pointer(cstr))
+	C.gupnp_didl_lite_object_set_genre(v.Native(), cstr)
+}
+
+func (v *DIDLLiteObject) SetAlbumArt(albumArt string) {
+	cstr := C.CString(albumArt)
+	defer C.free(unsafe.Pointer(cstr))
+	C.gupnp_didl_lite_object_set_album_art(v.Native(), cstr)
+}
+
+func (v *DIDLLiteObject) SetRestricted(restricted bool) {
+	C.gupnp_didl_lite_object_set_restricted(v.Native(), gbool(restricted))
+}
+
+// SetChildCount sets the number of children of a container.
+func (v *DIDLLiteObject) SetChildCount(count int) {
+	if v.IsContainer() {
+		C.gupnp_didl_lite_container_set_child_count(C.toGUPnPDIDLLiteContainer(unsafe.Pointer(v.GObject)), C.gint(count))
+	}
+}
+
+// AddResource creates a new resource attached to the object.
+func (v *DIDLLiteObject) AddResource() *DIDLLiteResource {
+	c := C.gupnp_didl_lite_object_add_resource(v.Native())
+	if c == nil {
+		return nil
+	}
+	return &DIDLLiteResource{wrapObjectFull(unsafe.Pointer(c))}
+}
+
+/*
+ * GUPnPDIDLLiteResource
+ */
+
+// DIDLLiteResource is a representation of GUPnP's GUPnPDIDLLiteResource.
+type DIDLLiteResource struct {
+	*glib.Object
+}
+
+// Native() returns a pointer to the underlying GUPnPDIDLLiteResource.
+func (v *DIDLLiteResource) Native() *C.GUPnPDIDLLiteResource {
+	if v == nil || v.GObject == nil {
+		return nil
+	}
+	p := unsafe.Pointer(v.GObject)
+	return C.toGUPnPDIDLLiteResource(p)
+}
+
+func (v *DIDLLiteResource) GetURI() string {
+	return C.GoString(C.gupnp_didl_lite_resource_get_uri(v.Native()))
+}
+
+func (v *DIDLLiteResource) GetSize() int64 {
+	return int64(C.gupnp_didl_lite_resource_get_size64(v.Native()))
+}
+
+func (v *DIDLLiteResource) GetBitrate() int {
+	return int(C.gupnp_didl_lite_resource_get_bitrate(v.Native()))
+}
+
+// GetDuration returns the duration in seconds, -1 if unknown.
+func (v *DIDLLiteResource) GetDuration() int {
+	return int(C.gupnp_didl_lite_resource_get_duration(v.Native()))
+}
+
+// GetResolution returns the width and height, -1 if unknown.
+func (v *DIDLLiteResource) GetResolution() (int, int) {
+	return int(C.gupnp_didl_lite_resource_get_width(v.Native())), int(C.gupnp_didl_lite_resource_get_height(v.Native()))
+}
+
+// GetProtocolInfo returns the protocolInfo string (http-get:*:audio/mpeg:*).
+func (v *DIDLLiteResource) GetProtocolInfo() string {
+	info := C.gupnp_didl_lite_resource_get_protocol_info(v.Native())
+	if info == nil {
+		return ""
+	}
+	cstr := C.gupnp_protocol_info_to_string(info)
+	defer C.g_free(C.gpointer(cstr))
+	return C.GoString(cstr)
+}
+
+func (v *DIDLLiteResource) SetURI(uri string) {
+	cstr := C.CString(uri)
+	defer C.free(unsafe.Pointer(cstr))
+	C.gupnp_didl_lite_resource_set_uri(v.Native(), cstr)
+}
+
+func (v *DIDLLiteResource) SetSize(size int64) {
+	C.gupnp_didl_lite_resource_set_size64(v.Native(), C.gint64(size))
+}
+
+func (v *DIDLLiteResource) SetBitrate(bitrate int) {
+	C.gupnp_didl_lite_resource_set_bitrate(v.Native(), C.int(bitrate))
+}
+
+// SetDuration sets the duration in seconds.
+func (v *DIDLLiteResource) SetDuration(duration int) {
+	C.gupnp_didl_lite_resource_set_duration(v.Native(), C.long(duration))
+}
+
+func (v *DIDLLiteResource) SetResolution(width, height int) {
+	C.gupnp_didl_lite_resource_set_width(v.Native(), C.int(width))
+	C.gupnp_didl_lite_resource_set_height(v.Native(), C.int(height))
+}
+
+// SetProtocolInfo parses and sets the protocolInfo string.
+func (v *DIDLLiteResource) SetProtocolInfo(protocolInfo string) error {
+	cstr := C.CString(protocolInfo)
+	defer C.free(unsafe.Pointer(cstr))
+
+	var err *C.GError = nil
+	info := C.gupnp_protocol_info_new_from_string(cstr, &err)
+	if info == nil {
+		return takeError(err)
+	}
+	defer C.g_object_unref(C.gpointer(info))
+	C.gupnp_didl_lite_resource_set_protocol_info(v.Native(), info)
+	return nil
+}
+
+/*
+ * GUPnPDIDLLiteParser
+ */
+
+// DIDLLiteParser is a representation of GUPnP's GUPnPDIDLLiteParser.
+//
+// Signals "object-available", "item-available" and "container-available"
+// are emitted during ParseDIDL. Use ConnectObject or Connect.
+type DIDLLiteParser struct {
+	*glib.Object
+}
+
+// Native() returns a pointer to the underlying GUPnPDIDLLiteParser.
+func (v *DIDLLiteParser) Native() *C.GUPnPDIDLLiteParser {
+	if v == nil || v.GObject == nil {
+		return nil
+	}
+	p := unsafe.Pointer(v.GObject)
+	return C.toGUPnPDIDLLiteParser(p)
+}
+
+func DIDLLiteParserNew() *DIDLLiteParser {
+	c := C.gupnp_didl_lite_parser_new()
+	if c == nil {
+		return nil
+	}
+	return &DIDLLiteParser{wrapObjectFull(unsafe.Pointer(c))}
+}
+
+// ConnectObject connects a callback to the "object-available" signal.
+func (v *DIDLLiteParser) ConnectObject(call func(*DIDLLiteObject)) error {
+	_, e := v.Connect("object-available", func(_ *glib.Object, obj *glib.Object) {
+		call(WrapDIDLLiteObject(obj))
+	})
+	return e
+}
+
+// ConnectContainer connects a callback to the "container-available" signal.
+func (v *DIDLLiteParser) ConnectContainer(call func(*DIDLLiteObject)) error {
+	_, e := v.Connect("container-available", func(_ *glib.Object, obj *glib.Object) {
+		call(WrapDIDLLiteObject(obj))
+	})
+	return e
+}
+
+// ConnectItem connects a callback to the "item-available" signal.
+func (v *DIDLLiteParser) ConnectItem(call func(*DIDLLiteObject)) error {
+	_, e := v.Connect("item-available", func(_ *glib.Object, obj *glib.Object) {
+		call(WrapDIDLLiteObject(obj))
+	})
+	return e
+}
+
+// ParseDIDL parses the DIDL-Lite document, emitting signals for each object found.
+func (v *DIDLLiteParser) ParseDIDL(didl string) error {
+	cstr := C.CString(didl)
+	defer C.free(unsafe.Pointer(cstr))
+
+	var err *C.GError = nil
+	if C.gupnp_didl_lite_parser_parse_didl(v.Native(), cstr, &err) == 0 {
+		return takeError(err)
+	}
+	return nil
+}
+
+// ParseDIDL parses a DIDL-Lite document and returns all its objects.
+func ParseDIDL(didl string) ([]*DIDLLiteObject, error) {
+	parser := DIDLLiteParserNew()
+	if parser == nil {
+		return nil, errors.New("create DIDL-Lite parser")
+	}
+	var list []*DIDLLiteObject
+	e := parser.ConnectObject(func(obj *DIDLLiteObject) { list = append(list, obj) })
+	if e != nil {
+		return nil, e
+	}
+	e = parser.ParseDIDL(didl)
+	return list, e
+}
+
+/*
+ * GUPnPDIDLLiteWriter
+ */
+
+// DIDLLiteWriter is a representation of GUPnP's GUPnPDIDLLiteWriter.
+type DIDLLiteWriter struct {
+	*glib.Object
+}
+
+// Native() returns a pointer to the underlying GUPnPDIDLLiteWriter.
+func (v *DIDLLiteWriter) Native() *C.GUPnPDIDLLiteWriter {
+	if v == nil || v.GObject == nil {
+		return nil
+	}
+	p := unsafe.Pointer(v.GObject)
+	return C.toGUPnPDIDLLiteWriter(p)
+}
+
+// DIDLLiteWriterNew creates a writer. language can be empty.
+func DIDLLiteWriterNew(language string) *DIDLLiteWriter {
+	var cLang *C.char
+	if language != "" {
+		cLang = C.CString(language)
+		defer C.free(unsafe.Pointer(cLang))
+	}
+	c := C.gupnp_didl_lite_writer_new(cLang)
+	if c == nil {
+		return nil
+	}
+	return &DIDLLiteWriter{wrapObjectFull(unsafe.Pointer(c))}
+}
+
+// AddItem creates a new item in the document.
+func (v *DIDLLiteWriter) AddItem() *DIDLLiteObject {
+	c := C.gupnp_didl_lite_writer_add_item(v.Native())
+	if c == nil {
+		return nil
+	}
+	return WrapDIDLLiteObject(wrapObjectFull(unsafe.Pointer(c)))
+}
+
+// AddContainer creates a new container in the document.
+func (v *DIDLLiteWriter) AddContainer() *DIDLLiteObject {
+	c := C.gupnp_didl_lite_writer_add_container(v.Native())
+	if c == nil {
+		return nil
+	}
+	return WrapDIDLLiteObject(wrapObjectFull(unsafe.Pointer(c)))
+}
+
+// GetString returns the DIDL-Lite document.
+func (v *DIDLLiteWriter) GetString() string {
+	cstr := C.gupnp_didl_lite_writer_get_string(v.Native())
+	defer C.g_free(C.gpointer(cstr))
+	return C.GoString(cstr)
+}
+
+/*
+ * GUPnPLastChangeParser
+ */
+
+// LastChangeParser is a representation of GUPnP's GUPnPLastChangeParser.
+type LastChangeParser struct {
+	*glib.Object
+}
+
+// Native() returns a pointer to the underlying GUPnPLastChangeParser.
+func (v *LastChangeParser) Native() *C.GUPnPLastChangeParser {
+	if v == nil || v.GObject == nil {
+		return nil
+	}
+	p := unsafe.Pointer(v.GObject)
+	return C.toGUPnPLastChangeParser(p)
+}
+
+func LastChangeParserNew() *LastChangeParser {
+	c := C.gupnp_last_change_parser_new()
+	if c == nil {
+		return nil
+	}
+	return &LastChangeParser{wrapObjectFull(unsafe.Pointer(c))}
+}
+
+// ParseString parses a LastChange event for the instance and returns the
+// values of the requested variables. Missing variables are not in the map.
+func (v *LastChangeParser) ParseString(instanceID uint, lastChange string, names ...string) (map[string]string, error) {
+	values := make(map[string]string)
+	if len(names) == 0 {
+		return values, nil
+	}
+
+	cXML := C.CString(lastChange)
+	defer C.free(unsafe.Pointer(cXML))
+
+	cNames := make([]*C.char, len(names))
+	for i, name := range names {
+		cNames[i] = C.CString(name)
+		defer C.free(unsafe.Pointer(cNames[i]))
+	}
+	cValues := make([]*C.char, len(names))
+
+	ok := C.last_change_parse_strings(cXML, C.guint(instanceID), &cNames[0], &cValues[0], C.int(len(names)))
+	for i, cValue := range cValues {
+		if cValue != nil {
+			values[names[i]] = takeString(cValue)
+		}
+	}
+	if ok == 0 {
+		return values, errors.New("invalid LastChange event")
+	}
+	return values, nil
+}
+
+//
+//-----------------------------------------------------------------[ HELPERS ]--
+
+// wrapObjectFull wraps an object already owned by the caller (transfer full).
+func wrapObjectFull(ptr unsafe.Pointer) *glib.Object {
+	obj := &glib.Object{glib.ToGObject(ptr)}
+	runtime.SetFinalizer(obj, (*glib.Object).Unref)
+	return obj
+}
+
+// takeError converts and frees a GError.
+func takeError(err *C.GError) error {
+	if err == nil {
+		return errors.New("unknown error")
+	}
+	defer C.g_error_free(err)
+	return errors.New(C.GoString((*C.char)(C.didl_error_get_message(err))))
+}
@@ -5,27 +5,31 @@ package gupnp
 #include <libgupnp/gupnp-control-point.h>
 #include <libgupnp-av/gupnp-av.h>
 #include <libgssdp/gssdp-resource-browser.h>
+#include <libgssdp/gssdp-client.h>
 #include <glib-2.0/glib.h>
 
 static GUPnPContext*         toGUPnPContext(void *p)         { return (GUPNP_CONTEXT(p)); }
+static GSSDPClient*          toGSSDPClient(void *p)          { return (GSSDP_CLIENT(p)); }
 static GUPnPContextManager*  toGUPnPContextManager(void *p)  { return (GUPNP_CONTEXT_MANAGER(p)); }
 static GUPnPControlPoint*    toGUPnPControlPoint(void *p)    { return (GUPNP_CONTROL_POINT(p)); }
 static GUPnPDeviceInfo*      toGUPnPDeviceInfo(void *p)      { return (GUPNP_DEVICE_INFO(p)); }
 static GUPnPDeviceProxy*     toGUPnPDeviceProxy(void *p)     { return (GUPNP_DEVICE_PROXY(p)); }
+static GUPnPServiceInfo*     toGUPnPServiceInfo(void *p)     { return (GUPNP_SERVICE_INFO(p)); }
 static GUPnPServiceProxy*    toGUPnPServiceProxy(void *p)    { return (GUPNP_SERVICE_PROXY(p)); }
 static GSSDPResourceBrowser* toGSSDPResourceBrowser(void *p) { return (GSSDP_RESOURCE_BROWSER(p)); }
 static GList*                toGlist(void* l)                { return (GList*)l; }
 static gpointer              intToPointer(int i)             { return GINT_TO_POINTER(i); }
 
 static gchar* error_get_message(GError *error) { return error->message; }
+static int    error_control_code(GError *error) { return error->domain == GUPNP_CONTROL_ERROR ? error->code : 0; }
 
 
 
 // golang functions declaration, prevents warning.
-void onNotifyCallback(GValue*, int);
+void onNotifyCallback(char*, GValue*, int);
 
 static void on_notify_callback (GUPnPServiceProxy *rendering_control, const char *variable_name, GValue *value, gpointer user_data) {
-	onNotifyCallback(value, GPOINTER_TO_INT(user_data));
+	onNotifyCallback((char*)variable_name, value, GPOINTER_TO_INT(user_data));
 }
 
 static gboolean service_proxy_add_notify (GUPnPServiceProxy *rendering_control, const char *variable_name, GType type, int callback_id) {
@@ -39,15 +43,25 @@ import "C"
 import (
 	"github.com/gotk3/gotk3/glib"
 
-	"github.com/sqp/godock/libs/log"
+	"github.com/sqp/gupnp/upnptype"
 
 	"errors"
+	"fmt"
 	"runtime"
 	"unsafe"
 
 	"reflect"
 )
 
+// logger receives the errors of the binding.
+var logger upnptype.Logger = upnptype.NopLogger{}
+
+// SetLogger sets the logger receiving the errors of the binding.
+//
+func SetLogger(log upnptype.Logger) {
+	logger = log
+}
+
 /*
  * GUPnPContext
  */
@@ -70,6 +84,40 @@ func WrapContext(obj *glib.Object) *Context {
 	return &Context{obj}
 }
 
+// ContextNew creates a context on the network interface (lo, eth0), listening
+// on the port (0 for any). Contexts are usually provided by the ContextManager.
+func ContextNew(iface string, port uint) (*Context, error) {
+	cstr := C.CString(iface)
+	defer C.free(unsafe.Pointer(cstr))
+
+	var err *C.GError
+	c := C.gupnp_context_new(nil, cstr, C.guint(port), &err)
+	if c == nil {
+		return nil, takeError(err)
+	}
+	return WrapContext(wrapObjectFull(unsafe.Pointer(c))), nil
+}
+
+// GetInterface returns the name of the network interface used (eth0).
+func (v *Context) GetInterface() string {
+	return C.GoString(C.gssdp_client_get_interface(C.toGSSDPClient(unsafe.Pointer(v.GObject))))
+}
+
+// GetHostIP returns the IP address of the host on the interface.
+func (v *Context) GetHostIP() string {
+	return C.GoString(C.gssdp_client_get_host_ip(C.toGSSDPClient(unsafe.Pointer(v.GObject))))
+}
+
+// GetNetwork returns the network identifier of the context.
+func (v *Context) GetNetwork() string {
+	return C.GoString(C.gssdp_client_get_network(C.toGSSDPClient(unsafe.Pointer(v.GObject))))
+}
+
+// GetPort returns the port the context is listening on.
+func (v *Context) GetPort() uint {
+	return uint(C.gupnp_context_get_port(v.Native()))
+}
+
 /*
  * GUPnPContextManager
  */
@@ -240,19 +288,78 @@ func (v *DeviceInfo) GetFriendlyName() string {
 	return C.GoString(cstr)
 }
 
+// GetDeviceType returns the device type (urn:...:device:MediaRenderer:1).
+func (v *DeviceInfo) GetDeviceType() string {
+	return C.GoString(C.gupnp_device_info_get_device_type(v.Native()))
+}
+
+// GetLocation returns the URL of the device description.
+func (v *DeviceInfo) GetLocation() string {
+	return C.GoString(C.gupnp_device_info_get_location(v.Native()))
+}
+
+// GetManufacturer returns the manufacturer name.
+func (v *DeviceInfo) GetManufacturer() string {
+	return takeString(C.gupnp_device_info_get_manufacturer(v.Native()))
+}
+
+// GetManufacturerURL returns the manufacturer web site URL.
+func (v *DeviceInfo) GetManufacturerURL() string {
+	return takeString(C.gupnp_device_info_get_manufacturer_url(v.Native()))
+}
+
+// GetModelDescription returns the model description.
+func (v *DeviceInfo) GetModelDescription() string {
+	return takeString(C.gupnp_device_info_get_model_description(v.Native()))
+}
+
+// GetModelName returns the model name.
+func (v *DeviceInfo) GetModelName() string {
+	return takeString(C.gupnp_device_info_get_model_name(v.Native()))
+}
+
+// GetModelNumber returns the model number.
+func (v *DeviceInfo) GetModelNumber() string {
+	return takeString(C.gupnp_device_info_get_model_number(v.Native()))
+}
+
+// GetModelURL returns the model web site URL.
+func (v *DeviceInfo) GetModelURL() string {
+	return takeString(C.gupnp_device_info_get_model_url(v.Native()))
+}
+
+// GetSerialNumber returns the serial number.
+func (v *DeviceInfo) GetSerialNumber() string {
+	return takeString(C.gupnp_device_info_get_serial_number(v.Native()))
+}
+
+// GetPresentationURL returns the absolute URL of the device web page.
+func (v *DeviceInfo) GetPresentationURL() string {
+	return takeString(C.gupnp_device_info_get_presentation_url(v.Native()))
+}
+
+// ListDevices returns the embedded devices.
 func (v *DeviceInfo) ListDevices() []*DeviceInfo {
+	var devices []*DeviceInfo
 	c := C.gupnp_device_info_list_devices(v.Native())
-	glist := (*glib.List)(unsafe.Pointer(c))
-	// glist := glib.ListFromNative(unsafe.Pointer(c))
-	// log.Info("len", C.g_list_length(c))
-	devices := make([]*DeviceInfo, glist.Length())
-	// The returned list should be g_list_free()'d and the elements should be g_object_unref()'d.
-	for i := uint(0); i < uint(len(devices)); i++ {
-		devices[i] = toDeviceInfo(glist.NthData(i).(*C.GUPnPDeviceInfo))
+	for l := c; l != nil; l = l.next {
+		devices = append(devices, WrapDeviceInfo(wrapObjectFull(unsafe.Pointer(l.data))))
 	}
+	C.g_list_free(c)
 	return devices
 }
 
+// ListServices returns the services provided by the device.
+func (v *DeviceInfo) ListServices() []*ServiceInfo {
+	var services []*ServiceInfo
+	c := C.gupnp_device_info_list_services(v.Native())
+	for l := c; l != nil; l = l.next {
+		services = append(services, WrapServiceInfo(wrapObjectFull(unsafe.Pointer(l.data))))
+	}
+	C.g_list_free(c)
+	return services
+}
+
 func (v *DeviceInfo) GetIconUrl(requestedMimeType string, requestedDepth, requestedWidth, requestedHeight int, preferBigger bool) (string, string, int, int, int) {
 	var cMT *C.char = nil
 	if requestedMimeType != "" {
@@ -330,6 +437,45 @@ func toServiceInfo(c *C.GUPnPServiceInfo) *ServiceInfo {
 	return WrapServiceInfo(wrapObject(unsafe.Pointer(c)))
 }
 
+// Native() returns a pointer to the underlying GUPnPServiceInfo.
+func (v *ServiceInfo) Native() *C.GUPnPServiceInfo {
+	if v == nil || v.GObject == nil {
+		return nil
+	}
+	p := unsafe.Pointer(v.GObject)
+	return C.toGUPnPServiceInfo(p)
+}
+
+// GetServiceType returns the full service type (urn:...:service:Name:1).
+func (v *ServiceInfo) GetServiceType() string {
+	return C.GoString(C.gupnp_service_info_get_service_type(v.Native()))
+}
+
+// GetID returns the service ID (urn:upnp-org:serviceId:Name).
+func (v *ServiceInfo) GetID() string {
+	return takeString(C.gupnp_service_info_get_id(v.Native()))
+}
+
+// GetUdn returns the UDN of the device providing the service.
+func (v *ServiceInfo) GetUdn() string {
+	return C.GoString(C.gupnp_service_info_get_udn(v.Native()))
+}
+
+// GetSCPDURL returns the absolute URL of the service description.
+func (v *ServiceInfo) GetSCPDURL() string {
+	return takeString(C.gupnp_service_info_get_scpd_url(v.Native()))
+}
+
+// GetControlURL returns the absolute URL used to send actions.
+func (v *ServiceInfo) GetControlURL() string {
+	return takeString(C.gupnp_service_info_get_control_url(v.Native()))
+}
+
+// GetEventSubscriptionURL returns the absolute URL used to subscribe to events.
+func (v *ServiceInfo) GetEventSubscriptionURL() string {
+	return takeString(C.gupnp_service_info_get_event_subscription_url(v.Native()))
+}
+
 /*
  * GUPnPServiceProxy
  */
@@ -351,25 +497,102 @@ func (v *ServiceProxy) Native() *C.GUPnPServiceProxy {
 //
 //-----------------------------------------------------------[ NOTIFICATIONS ]--
 
-var callbacksFunc = []func(string){}
+// NotifyFunc is called when an evented state variable changes.
+// The emitting service and the variable name are provided with the value,
+// converted to its Go type (bool, uint, int, string...).
+type NotifyFunc func(service *ServiceProxy, variable string, value interface{})
 
-func (v *ServiceProxy) AddNotify(variable string, typ glib.Type, callback func(string)) bool {
+type notifyCallback struct {
+	service *ServiceProxy
+	call    NotifyFunc
+}
+
+var callbacksFunc = []notifyCallback{}
+
+// AddNotifyValue registers a callback for an evented state variable of the
+// given glib type. Returns true if the notification was added.
+func (v *ServiceProxy) AddNotifyValue(variable string, typ glib.Type, callback NotifyFunc) bool {
 	callbackID := len(callbacksFunc)
-	callbacksFunc = append(callbacksFunc, callback)
+	callbacksFunc = append(callbacksFunc, notifyCallback{service: v, call: callback})
 
 	cstr := C.CString(variable)
 	defer C.free(unsafe.Pointer(cstr))
-	C.service_proxy_add_notify(v.Native(), cstr, C.GType(typ), C.int(callbackID))
-	return false
+	return gobool(C.service_proxy_add_notify(v.Native(), cstr, C.GType(typ), C.int(callbackID)))
+}
+
+// AddNotify registers a callback for an evented state variable, receiving
+// the value formatted as a string.
+func (v *ServiceProxy) AddNotify(variable string, typ glib.Type, callback func(string)) bool {
+	return v.AddNotifyValue(variable, typ, func(_ *ServiceProxy, _ string, value interface{}) {
+		if str, ok := value.(string); ok {
+			callback(str)
+			return
+		}
+		callback(fmt.Sprint(value))
+	})
+}
+
+// AddNotifyBool registers a callback for an evented boolean state variable.
+func (v *ServiceProxy) AddNotifyBool(variable string, callback func(*ServiceProxy, string, bool)) bool {
+	return v.AddNotifyValue(variable, glib.TYPE_BOOLEAN, func(service *ServiceProxy, name string, value interface{}) {
+		if v, ok := value.(bool); ok {
+			callback(service, name, v)
+		} else {
+			notifyMismatch(service, name, "bool", value)
+		}
+	})
+}
+
+// AddNotifyUint registers a callback for an evented unsigned integer state variable.
+func (v *ServiceProxy) AddNotifyUint(variable string, callback func(*ServiceProxy, string, uint)) bool {
+	return v.AddNotifyValue(variable, glib.TYPE_UINT, func(service *ServiceProxy, name string, value interface{}) {
+		if v, ok := value.(uint); ok {
+			callback(service, name, v)
+		} else {
+			notifyMismatch(service, name, "uint", value)
+		}
+	})
+}
+
+// AddNotifyInt registers a callback for an evented integer state variable.
+func (v *ServiceProxy) AddNotifyInt(variable string, callback func(*ServiceProxy, string, int)) bool {
+	return v.AddNotifyValue(variable, glib.TYPE_INT, func(service *ServiceProxy, name string, value interface{}) {
+		if v, ok := value.(int); ok {
+			callback(service, name, v)
+		} else {
+			notifyMismatch(service, name, "int", value)
+		}
+	})
+}
+
+// AddNotifyString registers a callback for an evented string state variable.
+func (v *ServiceProxy) AddNotifyString(variable string, callback func(*ServiceProxy, string, string)) bool {
+	return v.AddNotifyValue(variable, glib.TYPE_STRING, func(service *ServiceProxy, name string, value interface{}) {
+		if v, ok := value.(string); ok {
+			callback(service, name, v)
+		} else {
+			notifyMismatch(service, name, "string", value)
+		}
+	})
+}
+
+// notifyMismatch logs a value of an unexpected type, dropped to keep the
+// callback typed.
+func notifyMismatch(service *ServiceProxy, variable, want string, value interface{}) {
+	logger.Warn("notify: unexpected value type", "service", service.GetServiceType(), "variable", variable,
+		"want", want, "type", reflect.TypeOf(value))
 }
 
 //export onNotifyCallback
-func onNotifyCallback(cGValue *C.GValue, callbackID C.int) {
+func onNotifyCallback(cVariable *C.char, cGValue *C.GValue, callbackID C.int) {
+	cb := callbacksFunc[int(callbackID)]
 	gv := glib.ValueFromNative(unsafe.Pointer(cGValue))
-	str, e := gv.GetString()
-	if !log.Err(e, "onNotifyCallback get GValue") {
-		callbacksFunc[int(callbackID)](str)
+	value, e := gv.GoValue()
+	if e != nil {
+		logger.Warn("notify: get GValue", "variable", C.GoString(cVariable), upnptype.FieldError, e)
+		return
 	}
+	cb.call(cb.service, C.GoString(cVariable), value)
 }
 
 //
@@ -380,6 +603,8 @@ func (v *ServiceProxy) SetSubscribed(subscribed bool) {
 // Note that outvalues will be changed. Default GValues will be freed and new ones seem to get allocated.
 // It's up to you to free everything.
 //
+// Faults returned by the device are *upnptype.Fault with the UPnP error code.
+//
 func (v *ServiceProxy) SendActionList(action string, innames, invalues, outnames, outtypes, outvalues *List) error {
 	cAction := C.CString(action)
 	defer C.free(unsafe.Pointer(cAction))
@@ -388,7 +613,11 @@ func (v *ServiceProxy) SendActionList(action string, innames, invalues, outnames
 	res := C.gupnp_service_proxy_send_action_list(v.Native(), cAction, &err, innames.GList, invalues.GList, outnames.GList, outtypes.GList, &outvalues.GList)
 	if res == 0 {
 		defer C.g_error_free(err)
-		return errors.New(C.GoString((*C.char)(C.error_get_message(err))))
+		msg := C.GoString((*C.char)(C.error_get_message(err)))
+		if code := int(C.error_control_code(err)); code > 0 { // Fault returned by the device.
+			return &upnptype.Fault{Code: code, Description: msg}
+		}
+		return errors.New(msg)
 	}
 
 	return nil
@@ -467,7 +696,7 @@ func (v *ServiceProxy) addArgumentsIn(args ...interface{}) (names, values *List,
 				gval.SetUInt(args[i+1].(uint))
 
 			default:
-				log.Info("addArguments unknown type", reflect.TypeOf(args[i+1]))
+				logger.Error("add arguments: unknown type", "name", args[i], "type", reflect.TypeOf(args[i+1]))
 			}
 
 			values = values.Append(unsafe.Pointer(gval.Native()))
@@ -508,7 +737,7 @@ func (v *ServiceProxy) addArgumentsOut(args []interface{}) (names, types, values
 				gval.SetUInt(*args[i+1].(*uint))
 
 			default:
-				log.Info("addArguments unknown type", reflect.TypeOf(args[i+1]))
+				logger.Error("add arguments: unknown type", "name", args[i], "type", reflect.TypeOf(args[i+1]))
 			}
 
 			_, gtype, _ := gval.Type()
@@ -524,6 +753,16 @@ func (v *ServiceProxy) addArgumentsOut(args []interface{}) (names, types, values
 
 //
 
+//
+//---------------------------------------------------------------[ MAIN LOOP ]--
+
+// Iterate runs a single iteration of the default glib main context, to handle
+// the network events without a GTK main loop. Returns true if events were
+// dispatched.
+func Iterate(mayBlock bool) bool {
+	return gobool(C.g_main_context_iteration(nil, gbool(mayBlock)))
+}
+
 //
 //-----------------------------------------------------------------[ HELPERS ]--
 
@@ -534,6 +773,19 @@ func gbool(b bool) C.gboolean {
 	return C.gboolean(0)
 }
 
+func gobool(b C.gboolean) bool {
+	return b != 0
+}
+
+// takeString converts and frees a string allocated by the library.
+func takeString(cstr *C.char) string {
+	if cstr == nil {
+		return ""
+	}
+	defer C.g_free(C.gpointer(unsafe.Pointer(cstr)))
+	return C.GoString(cstr)
+}
+
 func wrapObject(ptr unsafe.Pointer) *glib.Object {
 	obj := &glib.Object{glib.ToGObject(ptr)}
 	obj.RefSink()
@@ -0,0 +1,83 @@
+package gupnp
+
+/*
+#include <libgupnp/gupnp-resource-factory.h>
+#include <libgupnp/gupnp-xml-doc.h>
+#include <libsoup/soup.h>
+#include <libxml/parser.h>
+#include <libxml/tree.h>
+#include <glib-2.0/glib.h>
+#include <stdlib.h>
+#include <string.h>
+
+static xmlNode* xml_find_child (xmlNode *node, const char *name) {
+	xmlNode *child;
+	for (child = node ? node->children : NULL; child; child = child->next)
+		if (child->type == XML_ELEMENT_NODE && strcmp ((const char*) child->name, name) == 0)
+			return child;
+	return NULL;
+}
+
+// Creates a device proxy from a description document, as the control point
+// does for SSDP announced devices. Returns NULL if the document is invalid.
+static GUPnPDeviceProxy* device_proxy_new_from_description (GUPnPContext *context, const char *data, int size, const char *location) {
+	GUPnPDeviceProxy *proxy = NULL;
+	xmlDoc *xml = xmlRecoverMemory (data, size);
+	if (xml == NULL)
+		return NULL;
+
+	GUPnPXMLDoc *doc = gupnp_xml_doc_new (xml); // takes ownership of xml.
+	xmlNode *root = xmlDocGetRootElement (xml);
+	xmlNode *element = xml_find_child (root, "device");
+	xmlNode *udn_node = xml_find_child (element, "UDN");
+	xmlNode *base_node = xml_find_child (root, "URLBase");
+
+	if (element != NULL && udn_node != NULL) {
+		xmlChar *udn = xmlNodeGetContent (udn_node);
+		xmlChar *base = base_node ? xmlNodeGetContent (base_node) : NULL;
+		SoupURI *url_base = soup_uri_new (base ? (const char*) base : location);
+
+		if (url_base != NULL) {
+			proxy = gupnp_resource_factory_create_device_proxy (gupnp_resource_factory_get_default (),
+			                                                    context, doc, element,
+			                                                    (const char*) udn, location, url_base);
+			soup_uri_free (url_base);
+		}
+		xmlFree (udn);
+		if (base)
+			xmlFree (base);
+	}
+
+	g_object_unref (doc);
+	return proxy;
+}
+
+*/
+// #cgo pkg-config: glib-2.0 gupnp-1.0 libxml-2.0 libsoup-2.4
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// DeviceProxyNewFromDescription creates a device proxy from its description
+// document, without SSDP discovery.
+//
+// location is the URL the description was downloaded from, used to resolve
+// the services URLs when the document has no URLBase.
+func DeviceProxyNewFromDescription(context *Context, description []byte, location string) (*DeviceProxy, error) {
+	if len(description) == 0 {
+		return nil, errors.New("empty device description")
+	}
+	cData := C.CString(string(description))
+	defer C.free(unsafe.Pointer(cData))
+	cLocation := C.CString(location)
+	defer C.free(unsafe.Pointer(cLocation))
+
+	c := C.device_proxy_new_from_description(context.Native(), cData, C.int(len(description)), cLocation)
+	if c == nil {
+		return nil, errors.New("invalid device description: " + location)
+	}
+	return WrapDeviceProxy(wrapObjectFull(unsafe.Pointer(c))), nil
+}
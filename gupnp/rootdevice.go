@@ -0,0 +1,383 @@
+package gupnp
+
+/*
+#include <libgupnp/gupnp-root-device.h>
+#include <libgupnp/gupnp-service.h>
+#include <libgupnp/gupnp-context-manager.h>
+#include <glib-2.0/glib.h>
+#include <stdlib.h>
+
+static GUPnPRootDevice* toGUPnPRootDevice(void *p) { return (GUPNP_ROOT_DEVICE(p)); }
+static GUPnPService*    toGUPnPService(void *p)    { return (GUPNP_SERVICE(p)); }
+
+
+
+// golang functions declaration, prevents warning.
+void onActionInvoked(GUPnPServiceAction*, int);
+void onQueryVariable(char*, GValue*, int);
+
+static void on_action_invoked (GUPnPService *service, GUPnPServiceAction *action, gpointer user_data) {
+	onActionInvoked(action, GPOINTER_TO_INT(user_data));
+}
+
+static void on_query_variable (GUPnPService *service, const char *variable, GValue *value, gpointer user_data) {
+	onQueryVariable((char*)variable, value, GPOINTER_TO_INT(user_data));
+}
+
+// Connects to "action-invoked", restricted to the given action when name is not empty.
+static gulong service_connect_action (GUPnPService *service, const char *name, int callback_id) {
+	gchar *signal = (name[0] != '\0') ? g_strconcat ("action-invoked::", name, NULL) : g_strdup ("action-invoked");
+	gulong id = g_signal_connect (service, signal, G_CALLBACK (on_action_invoked), GINT_TO_POINTER (callback_id));
+	g_free (signal);
+	return id;
+}
+
+static gulong service_connect_query_variable (GUPnPService *service, int callback_id) {
+	return g_signal_connect (service, "query-variable", G_CALLBACK (on_query_variable), GINT_TO_POINTER (callback_id));
+}
+
+
+// Action arguments, gupnp_service_action_get and set are variadic.
+
+static gchar* service_action_get_string (GUPnPServiceAction *action, const char *argument) {
+	gchar *value = NULL;
+	gupnp_service_action_get (action, argument, G_TYPE_STRING, &value, NULL);
+	return value;
+}
+
+static gboolean service_action_get_bool (GUPnPServiceAction *action, const char *argument) {
+	gboolean value = FALSE;
+	gupnp_service_action_get (action, argument, G_TYPE_BOOLEAN, &value, NULL);
+	return value;
+}
+
+static guint service_action_get_uint (GUPnPServiceAction *action, const char *argument) {
+	guint value = 0;
+	gupnp_service_action_get (action, argument, G_TYPE_UINT, &value, NULL);
+	return value;
+}
+
+static gint service_action_get_int (GUPnPServiceAction *action, const char *argument) {
+	gint value = 0;
+	gupnp_service_action_get (action, argument, G_TYPE_INT, &value, NULL);
+	return value;
+}
+
+static void service_action_set_string (GUPnPServiceAction *action, const char *argument, const char *value) {
+	gupnp_service_action_set (action, argument, G_TYPE_STRING, value, NULL);
+}
+
+static void service_action_set_bool (GUPnPServiceAction *action, const char *argument, gboolean value) {
+	gupnp_service_action_set (action, argument, G_TYPE_BOOLEAN, value, NULL);
+}
+
+static void service_action_set_uint (GUPnPServiceAction *action, const char *argument, guint value) {
+	gupnp_service_action_set (action, argument, G_TYPE_UINT, value, NULL);
+}
+
+static void service_action_set_int (GUPnPServiceAction *action, const char *argument, gint value) {
+	gupnp_service_action_set (action, argument, G_TYPE_INT, value, NULL);
+}
+
+
+// Evented state variables, gupnp_service_notify is variadic.
+
+static void service_notify_string (GUPnPService *service, const char *variable, const char *value) {
+	gupnp_service_notify (service, variable, G_TYPE_STRING, value, NULL);
+}
+
+static void service_notify_bool (GUPnPService *service, const char *variable, gboolean value) {
+	gupnp_service_notify (service, variable, G_TYPE_BOOLEAN, value, NULL);
+}
+
+static void service_notify_uint (GUPnPService *service, const char *variable, guint value) {
+	gupnp_service_notify (service, variable, G_TYPE_UINT, value, NULL);
+}
+
+static void service_notify_int (GUPnPService *service, const char *variable, gint value) {
+	gupnp_service_notify (service, variable, G_TYPE_INT, value, NULL);
+}
+
+
+// Query variable answers, the GValue is provided uninitialized.
+
+static void value_set_string (GValue *value, const char *v) { g_value_init (value, G_TYPE_STRING);  g_value_set_string (value, v); }
+static void value_set_bool (GValue *value, gboolean v)      { g_value_init (value, G_TYPE_BOOLEAN); g_value_set_boolean (value, v); }
+static void value_set_uint (GValue *value, guint v)         { g_value_init (value, G_TYPE_UINT);    g_value_set_uint (value, v); }
+static void value_set_int (GValue *value, gint v)           { g_value_init (value, G_TYPE_INT);     g_value_set_int (value, v); }
+
+*/
+// #cgo pkg-config: glib-2.0 gupnp-1.0 gssdp-1.0
+import "C"
+
+import (
+	"github.com/gotk3/gotk3/glib"
+
+	"errors"
+	"reflect"
+	"unsafe"
+)
+
+/*
+ * GUPnPRootDevice
+ */
+
+// RootDevice is a representation of GUPnP's GUPnPRootDevice.
+type RootDevice struct {
+	DeviceInfo
+}
+
+// Native() returns a pointer to the underlying GUPnPRootDevice.
+func (v *RootDevice) Native() *C.GUPnPRootDevice {
+	if v == nil || v.GObject == nil {
+		return nil
+	}
+	p := unsafe.Pointer(v.GObject)
+	return C.toGUPnPRootDevice(p)
+}
+
+func WrapRootDevice(obj *glib.Object) *RootDevice {
+	return &RootDevice{DeviceInfo{obj}}
+}
+
+// RootDeviceNew creates a root device published on the context.
+//
+// descriptionPath is the path of the device description file, relative to
+// descriptionDir, the directory holding the description and SCPD files.
+func RootDeviceNew(context *Context, descriptionPath, descriptionDir string) *RootDevice {
+	cPath := C.CString(descriptionPath)
+	defer C.free(unsafe.Pointer(cPath))
+	cDir := C.CString(descriptionDir)
+	defer C.free(unsafe.Pointer(cDir))
+
+	c := C.gupnp_root_device_new(context.Native(), cPath, cDir)
+	if c == nil {
+		return nil
+	}
+	return WrapRootDevice(wrapObjectFull(unsafe.Pointer(c)))
+}
+
+// SetAvailable starts or stops the device advertisement.
+func (v *RootDevice) SetAvailable(available bool) {
+	C.gupnp_root_device_set_available(v.Native(), gbool(available))
+}
+
+// GetAvailable returns whether the device is advertised.
+func (v *RootDevice) GetAvailable() bool {
+	return gobool(C.gupnp_root_device_get_available(v.Native()))
+}
+
+// GetRelativeLocation returns the description location relative to the HTTP root.
+func (v *RootDevice) GetRelativeLocation() string {
+	return C.GoString(C.gupnp_root_device_get_relative_location(v.Native()))
+}
+
+// GetDescriptionDir returns the directory holding the description files.
+func (v *RootDevice) GetDescriptionDir() string {
+	return C.GoString(C.gupnp_root_device_get_description_dir(v.Native()))
+}
+
+// GetService returns the hosted service matching the type, or nil.
+func (v *RootDevice) GetService(typ string) *Service {
+	info := v.DeviceInfo.GetService(typ)
+	if info == nil {
+		return nil
+	}
+	return &Service{*info}
+}
+
+// ManageRootDevice lets the context manager take care of the device life cycle.
+func (v *ContextManager) ManageRootDevice(root *RootDevice) {
+	C.gupnp_context_manager_manage_root_device(v.Native(), root.Native())
+}
+
+/*
+ * GUPnPService
+ */
+
+// Service is a representation of GUPnP's GUPnPService.
+type Service struct {
+	ServiceInfo
+}
+
+// Native() returns a pointer to the underlying GUPnPService.
+func (v *Service) Native() *C.GUPnPService {
+	if v == nil || v.GObject == nil {
+		return nil
+	}
+	p := unsafe.Pointer(v.GObject)
+	return C.toGUPnPService(p)
+}
+
+var (
+	actionCallbacks = []func(*ServiceAction){}
+	queryCallbacks  = []func(string) interface{}{}
+)
+
+// ConnectAction registers a handler for the action. An empty name receives
+// all actions.
+//
+// The handler must answer with Return or ReturnError.
+func (v *Service) ConnectAction(name string, callback func(*ServiceAction)) {
+	callbackID := len(actionCallbacks)
+	actionCallbacks = append(actionCallbacks, callback)
+
+	cstr := C.CString(name)
+	defer C.free(unsafe.Pointer(cstr))
+	C.service_connect_action(v.Native(), cstr, C.int(callbackID))
+}
+
+// ConnectQueryVariable registers a handler returning state variables values.
+// Supported return types are bool, uint, int and string.
+func (v *Service) ConnectQueryVariable(callback func(variable string) interface{}) {
+	callbackID := len(queryCallbacks)
+	queryCallbacks = append(queryCallbacks, callback)
+	C.service_connect_query_variable(v.Native(), C.int(callbackID))
+}
+
+//export onActionInvoked
+func onActionInvoked(cAction *C.GUPnPServiceAction, callbackID C.int) {
+	actionCallbacks[int(callbackID)](&ServiceAction{cAction})
+}
+
+//export onQueryVariable
+func onQueryVariable(cVariable *C.char, cGValue *C.GValue, callbackID C.int) {
+	value := queryCallbacks[int(callbackID)](C.GoString(cVariable))
+	switch value.(type) {
+	case bool:
+		C.value_set_bool(cGValue, gbool(value.(bool)))
+
+	case uint:
+		C.value_set_uint(cGValue, C.guint(value.(uint)))
+
+	case int:
+		C.value_set_int(cGValue, C.gint(value.(int)))
+
+	case string:
+		cstr := C.CString(value.(string))
+		defer C.free(unsafe.Pointer(cstr))
+		C.value_set_string(cGValue, cstr)
+
+	default:
+		logger.Warn("query variable: unknown type", "variable", C.GoString(cVariable), "type", reflect.TypeOf(value))
+	}
+}
+
+// NotifyValue emits a change of an evented state variable.
+// Supported value types are bool, uint, int and string.
+func (v *Service) NotifyValue(variable string, value interface{}) error {
+	cVar := C.CString(variable)
+	defer C.free(unsafe.Pointer(cVar))
+
+	switch value.(type) {
+	case bool:
+		C.service_notify_bool(v.Native(), cVar, gbool(value.(bool)))
+
+	case uint:
+		C.service_notify_uint(v.Native(), cVar, C.guint(value.(uint)))
+
+	case int:
+		C.service_notify_int(v.Native(), cVar, C.gint(value.(int)))
+
+	case string:
+		cstr := C.CString(value.(string))
+		defer C.free(unsafe.Pointer(cstr))
+		C.service_notify_string(v.Native(), cVar, cstr)
+
+	default:
+		return errors.New("notify unknown type " + reflect.TypeOf(value).String())
+	}
+	return nil
+}
+
+// FreezeNotify queues notifications until ThawNotify is called.
+func (v *Service) FreezeNotify() {
+	C.gupnp_service_freeze_notify(v.Native())
+}
+
+// ThawNotify sends all queued notifications.
+func (v *Service) ThawNotify() {
+	C.gupnp_service_thaw_notify(v.Native())
+}
+
+/*
+ * GUPnPServiceAction
+ */
+
+// ServiceAction is a representation of GUPnP's GUPnPServiceAction, an
+// invoked action waiting for an answer.
+type ServiceAction struct {
+	native *C.GUPnPServiceAction
+}
+
+// Name returns the invoked action name.
+func (a *ServiceAction) Name() string {
+	return C.GoString(C.gupnp_service_action_get_name(a.native))
+}
+
+// GetString returns the value of a string input argument.
+func (a *ServiceAction) GetString(argument string) string {
+	cArg := C.CString(argument)
+	defer C.free(unsafe.Pointer(cArg))
+	return takeString((*C.char)(C.service_action_get_string(a.native, cArg)))
+}
+
+// GetBool returns the value of a boolean input argument.
+func (a *ServiceAction) GetBool(argument string) bool {
+	cArg := C.CString(argument)
+	defer C.free(unsafe.Pointer(cArg))
+	return gobool(C.service_action_get_bool(a.native, cArg))
+}
+
+// GetUint returns the value of an unsigned integer input argument.
+func (a *ServiceAction) GetUint(argument string) uint {
+	cArg := C.CString(argument)
+	defer C.free(unsafe.Pointer(cArg))
+	return uint(C.service_action_get_uint(a.native, cArg))
+}
+
+// GetInt returns the value of an integer input argument.
+func (a *ServiceAction) GetInt(argument string) int {
+	cArg := C.CString(argument)
+	defer C.free(unsafe.Pointer(cArg))
+	return int(C.service_action_get_int(a.native, cArg))
+}
+
+// Set sets the value of an output argument.
+// Supported value types are bool, uint, int and string.
+func (a *ServiceAction) Set(argument string, value interface{}) error {
+	cArg := C.CString(argument)
+	defer C.free(unsafe.Pointer(cArg))
+
+	switch value.(type) {
+	case bool:
+		C.service_action_set_bool(a.native, cArg, gbool(value.(bool)))
+
+	case uint:
+		C.service_action_set_uint(a.native, cArg, C.guint(value.(uint)))
+
+	case int:
+		C.service_action_set_int(a.native, cArg, C.gint(value.(int)))
+
+	case string:
+		cstr := C.CString(value.(string))
+		defer C.free(unsafe.Pointer(cstr))
+		C.service_action_set_string(a.native, cArg, cstr)
+
+	default:
+		return errors.New("set argument unknown type " + reflect.TypeOf(value).String())
+	}
+	return nil
+}
+
+// Return sends the action answer with the output arguments set.
+func (a *ServiceAction) Return() {
+	C.gupnp_service_action_return(a.native)
+}
+
+// ReturnError sends an UPnP error answer.
+func (a *ServiceAction) ReturnError(code uint, description string) {
+	cstr := C.CString(description)
+	defer C.free(unsafe.Pointer(cstr))
+	C.gupnp_service_action_return_error(a.native, C.guint(code), cstr)
+}
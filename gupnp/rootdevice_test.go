@@ -0,0 +1,114 @@
+package gupnp
+
+import (
+	"github.com/gotk3/gotk3/glib"
+
+	"github.com/sqp/gupnp/upnptype"
+
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	echoDevice  = "urn:schemas-gupnp-test:device:Echo:1"
+	echoService = "urn:schemas-gupnp-test:service:Echo:1"
+)
+
+// iterateUntil runs the main context until done returns true.
+func iterateUntil(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for", what)
+		}
+		if !Iterate(false) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// TestRootDevice publishes a device on the loopback context, discovers it
+// with a control point, and calls its action and events.
+func TestRootDevice(t *testing.T) {
+	context, e := ContextNew("lo", 0)
+	if e != nil {
+		t.Skip("no loopback context:", e)
+	}
+	dir, e := filepath.Abs("testdata")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	root := RootDeviceNew(context, "echo.xml", dir)
+	if root == nil {
+		t.Fatal("create root device")
+	}
+	service := root.GetService(echoService)
+	if service == nil {
+		t.Fatal("service not found:", echoService)
+	}
+	service.ConnectAction("Echo", func(action *ServiceAction) {
+		text := action.GetString("Text")
+		if text == "" {
+			action.ReturnError(402, "Invalid Args")
+			return
+		}
+		action.Set("Result", "echo "+text)
+		action.Return()
+	})
+	service.ConnectQueryVariable(func(variable string) interface{} {
+		if variable == "Count" {
+			return uint(3)
+		}
+		return nil
+	})
+	root.SetAvailable(true)
+
+	var proxy *DeviceProxy
+	cp := ControlPointNew(context, echoDevice)
+	cp.Connect("device-proxy-available", func(_ *glib.Object, obj *glib.Object) {
+		proxy = WrapDeviceProxy(obj)
+	})
+	cp.SetActive(true)
+	iterateUntil(t, "device", func() bool { return proxy != nil })
+
+	if udn := proxy.GetUdn(); udn != root.GetUdn() {
+		t.Errorf("udn %q, want %q", udn, root.GetUdn())
+	}
+	info := proxy.GetService(echoService)
+	if info == nil {
+		t.Fatal("proxy service not found:", echoService)
+	}
+	echo := &ServiceProxy{*info}
+
+	var result string
+	if e := echo.SendAction("Echo", "Text", "hello", nil, "Result", &result); e != nil {
+		t.Fatal("send action:", e)
+	}
+	if result != "echo hello" {
+		t.Errorf("result %q, want %q", result, "echo hello")
+	}
+
+	e = echo.SendAction("Echo", "Text", "", nil, "Result", &result)
+	var fault *upnptype.Fault
+	if !errors.As(e, &fault) || fault.Code != 402 {
+		t.Errorf("send action error %v, want fault 402", e)
+	}
+
+	count := uint(0)
+	echo.AddNotifyUint("Count", func(_ *ServiceProxy, _ string, value uint) { count = value })
+	echo.SetSubscribed(true)
+	iterateUntil(t, "initial event", func() bool { return count == 3 })
+
+	if e := service.NotifyValue("Count", uint(4)); e != nil {
+		t.Fatal("notify:", e)
+	}
+	iterateUntil(t, "event", func() bool { return count == 4 })
+
+	if e := service.NotifyValue("Count", 1.5); e == nil {
+		t.Error("notify of a float: no error")
+	}
+}
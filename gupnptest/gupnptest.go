@@ -0,0 +1,35 @@
+// Package gupnptest provides a media control with fake devices, for the tests
+// of the packages using it.
+//
+//   media, cp, tv := gupnptest.NewMedia(t)
+//   cp.AddRenderer(mocktype.NewRenderer("uuid:kitchen", "Kitchen"))
+//
+package gupnptest
+
+import (
+	"github.com/sqp/gupnp"
+	"github.com/sqp/gupnp/mocktype"
+	"github.com/sqp/gupnp/upnplog"
+
+	"testing"
+)
+
+// NewMedia creates a media control logging to the test, connected to a fake
+// control point with a renderer (TV, uuid:tv) and the server of
+// mocktype.NewTestNAS. The media control is closed with the test.
+//
+func NewMedia(t testing.TB) (*gupnp.MediaControl, *mocktype.ControlPoint, *mocktype.Renderer) {
+	t.Helper()
+	media, e := gupnp.New(upnplog.Test(t))
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() { media.Close() })
+	cp := mocktype.NewControlPoint()
+	media.SetControlPoint(cp)
+
+	tv := mocktype.NewRenderer("uuid:tv", "TV")
+	cp.AddRenderer(tv)
+	cp.AddServer(mocktype.NewTestNAS())
+	return media, cp, tv
+}
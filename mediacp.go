@@ -24,10 +24,14 @@
 package gupnp
 
 import (
+	"github.com/sqp/gupnp/upnpmetrics"
 	"github.com/sqp/gupnp/upnptype"
 
+	"errors"
 	"io/ioutil"
+	neturl "net/url"
 	"path"
+	"strings"
 )
 
 //
@@ -36,7 +40,10 @@ import (
 // MediaControl manages media renderers and servers on the UPnP network.
 //
 type MediaControl struct {
+	backend upnptype.ControlPoint
+
 	curRend   upnptype.Renderer
+	curCaps   *upnptype.CapableRenderer // capability checks on the current renderer.
 	renderers upnptype.Renderers
 
 	curSrv  upnptype.Server
@@ -49,24 +56,36 @@ type MediaControl struct {
 	preferredServer   string
 	seekDelta         int
 	volumeDelta       int
+	noSubtitles       map[string]bool // renderers UDN with subtitles disabled.
 
-	tmpDir string
+	tmpDir    string
+	cast      castServer // serves files played with PlayFile.
+	slideshow *Slideshow
 
-	log upnptype.Logger
+	log     upnptype.Logger
+	metrics *upnpmetrics.Metrics
 }
 
-// New creates a new MediaControl manager.
+// New creates a new MediaControl manager. The logger can be nil.
 //
 func New(log upnptype.Logger) (*MediaControl, error) {
+	if log == nil {
+		log = upnptype.NopLogger{}
+	}
 	tmpDir, e := ioutil.TempDir("", "tvplay")
-	return &MediaControl{
+	cp := &MediaControl{
 		renderers: make(upnptype.Renderers),
 		servers:   make(map[string]upnptype.Server),
 		hooks:     make(map[string]*upnptype.MediaHook),
 
-		tmpDir: tmpDir,
-		log:    log,
-	}, e
+		noSubtitles: make(map[string]bool),
+
+		tmpDir:  tmpDir,
+		log:     log,
+		metrics: upnpmetrics.Default,
+	}
+	cp.slideshow = newSlideshow(cp)
+	return cp, e
 }
 
 // DefineEvents returns pointers to control events callbacks.
@@ -80,6 +99,42 @@ func (cp *MediaControl) DefineEvents() upnptype.ControlPointEvents {
 	}
 }
 
+// SetMetrics sets the collector of the device metrics. Defaults to
+// upnpmetrics.Default.
+//
+func (cp *MediaControl) SetMetrics(metrics *upnpmetrics.Metrics) {
+	cp.metrics = metrics
+}
+
+// SetControlPoint connects the discovery backend. The timers of backends with
+// an event loop (upnptype.EventLoop) run in it.
+//
+func (cp *MediaControl) SetControlPoint(backend upnptype.ControlPoint) {
+	cp.backend = backend
+	if loop, ok := backend.(upnptype.EventLoop); ok {
+		cp.slideshow.Call = loop.Call
+	}
+	backend.SetEvents(cp.DefineEvents())
+}
+
+// AddDevice registers a device by its description URL, without SSDP discovery.
+// The device will be forwarded with the usual found events when reachable.
+//
+func (cp *MediaControl) AddDevice(location string) error {
+	if cp.backend == nil {
+		return errors.New("add device: no control point")
+	}
+	return cp.backend.AddDevice(location)
+}
+
+// RemoveDevice unregisters a device added by its description URL.
+//
+func (cp *MediaControl) RemoveDevice(location string) {
+	if cp.backend != nil {
+		cp.backend.RemoveDevice(location)
+	}
+}
+
 //
 //-----------------------------------------------------------------[ ACTIONS ]--
 
@@ -91,41 +146,47 @@ func (cp *MediaControl) Action(action upnptype.Action) error {
 	}
 
 	var e error
+	var muted bool
+	var vol uint16
 	switch action {
 
 	case upnptype.ActionToggleMute:
-		muted, e := cp.curRend.GetMute(0, upnptype.ChannelMaster)
+		muted, e = cp.curRend.GetMute(0, upnptype.ChannelMaster)
 		if e == nil {
-			e = cp.curRend.SetMute(0, upnptype.ChannelMaster, !muted)
+			e = cp.curCaps.SetMute(0, upnptype.ChannelMaster, !muted)
 		}
 
 	case upnptype.ActionVolumeDown:
-		vol, e := cp.curRend.GetVolume(0, upnptype.ChannelMaster)
+		vol, e = cp.curRend.GetVolume(0, upnptype.ChannelMaster)
 		if e == nil {
-			e = cp.curRend.SetVolume(0, upnptype.ChannelMaster, vol-uint16(cp.volumeDelta))
+			newvol := int(vol) - cp.volumeDelta
+			if newvol < 0 {
+				newvol = 0
+			}
+			e = cp.curCaps.SetVolume(0, upnptype.ChannelMaster, uint16(newvol))
 		}
 
 	case upnptype.ActionVolumeUp:
-		vol, e := cp.curRend.GetVolume(0, upnptype.ChannelMaster)
+		vol, e = cp.curRend.GetVolume(0, upnptype.ChannelMaster)
 		if e == nil {
-			e = cp.curRend.SetVolume(0, upnptype.ChannelMaster, vol+uint16(cp.volumeDelta))
+			e = cp.curCaps.SetVolume(0, upnptype.ChannelMaster, vol+uint16(cp.volumeDelta)) // Max set by quirks.
 		}
 
 	case upnptype.ActionPlayPause:
-		e = cp.curRend.PlayPause(0, upnptype.PlaySpeedNormal)
+		e = cp.curCaps.PlayPause(0, upnptype.PlaySpeedNormal)
 
 	case upnptype.ActionStop:
-		e = cp.curRend.Stop(0)
+		e = cp.curCaps.Stop(0)
 
 	case upnptype.ActionSeekBackward:
-		e = cp.curRend.Seek(0, upnptype.SeekModeAbsTime, upnptype.TimeToString(cp.GetCurrentTime()-cp.seekDelta))
+		e = cp.curCaps.SeekTime(0, cp.GetCurrentTime()-cp.seekDelta)
 
 	case upnptype.ActionSeekForward:
-		e = cp.curRend.Seek(0, upnptype.SeekModeAbsTime, upnptype.TimeToString(cp.GetCurrentTime()+cp.seekDelta))
+		e = cp.curCaps.SeekTime(0, cp.GetCurrentTime()+cp.seekDelta)
 	}
 
 	if e != nil {
-		cp.log.Warningf("action failed #%d: %s", action, e)
+		cp.log.Warn("action failed", upnptype.FieldUDN, cp.curRend.UDN(), upnptype.FieldAction, action.String(), upnptype.FieldError, e)
 	}
 	return e
 }
@@ -139,6 +200,13 @@ func (cp *MediaControl) Renderer() upnptype.Renderer {
 	return cp.curRend
 }
 
+// Capabilities returns the current renderer wrapped with capability checks,
+// or nil if no renderer is selected.
+//
+func (cp *MediaControl) Capabilities() *upnptype.CapableRenderer {
+	return cp.curCaps
+}
+
 // RendererExists return true if a renderer is selected.
 //
 func (cp *MediaControl) RendererExists() bool {
@@ -161,6 +229,10 @@ func (cp *MediaControl) GetRenderer(udn string) upnptype.Renderer {
 //
 func (cp *MediaControl) SetRenderer(udn string) {
 	cp.curRend = cp.GetRenderer(udn)
+	cp.curCaps = nil
+	if cp.curRend != nil {
+		cp.curCaps = upnptype.NewCapableRenderer(cp.curRend)
+	}
 	cp.onRendererSelected(cp.curRend)
 
 	if cp.curRend == nil {
@@ -170,9 +242,6 @@ func (cp *MediaControl) SetRenderer(udn string) {
 	// Get current settings and forward to connected clients.
 	vol, e := cp.curRend.GetVolume(0, upnptype.ChannelMaster)
 	if e == nil {
-
-		println("cp.curRend.Events().OnVolume", cp.curRend.Events().OnVolume != nil)
-
 		cp.curRend.Events().OnVolume(cp.curRend, uint(vol))
 	}
 
@@ -354,6 +423,23 @@ func (cp *MediaControl) SetPreferredServer(name string) {
 	cp.setServerDefault()
 }
 
+// SetSubtitles enables or disables the delivery of subtitles to a renderer.
+// Subtitles are enabled by default.
+//
+func (cp *MediaControl) SetSubtitles(udn string, enable bool) {
+	if enable {
+		delete(cp.noSubtitles, udn)
+	} else {
+		cp.noSubtitles[udn] = true
+	}
+}
+
+// Subtitles returns whether subtitles are delivered to the renderer.
+//
+func (cp *MediaControl) Subtitles(udn string) bool {
+	return !cp.noSubtitles[udn]
+}
+
 //
 //------------------------------------------------------------------[ BROWSE ]--
 
@@ -388,26 +474,55 @@ func (cp *MediaControl) BrowseMetadata(container string, startingIndex uint) err
 	}
 	_, items, didlxml := cp.curSrv.BrowseMetadata(container, startingIndex, uint(upnptype.MaxBrowse))
 	for _, item := range items {
-
-		// log.Info("RES", len(item.Res))
-		if len(item.Res) > 0 {
-			// log.Info("RES", didlxml)
-
-			// if cp.RendererExists() {
-			return cp.curRend.SetAVTransportURI(0, item.Res[0].URL, didlxml)
-			// }
+		cp.slideshow.halt()
+		media, sub := splitResources(item.Res)
+		if media == nil {
+			continue
+		}
+		if sub != nil && cp.Subtitles(cp.curRend.UDN()) && !strings.Contains(didlxml, "CaptionInfoEx") {
+			didlxml = upnptype.AddSubtitle(didlxml, sub.URL, resourceType(sub))
 		}
+		return cp.curCaps.SetAVTransportURI(0, media.URL, didlxml)
 	}
 	return nil
 }
 
+// splitResources returns the first media resource and the first subtitles
+// resource of an item.
+func splitResources(list []upnptype.Resource) (media, sub *upnptype.Resource) {
+	for i := range list {
+		switch {
+		case upnptype.IsSubtitleType(resourceType(&list[i])):
+			if sub == nil {
+				sub = &list[i]
+			}
+		case media == nil:
+			media = &list[i]
+		}
+	}
+	return media, sub
+}
+
+// resourceType returns the MIME type of a resource, from its protocolInfo or
+// its URL extension for subtitles.
+func resourceType(res *upnptype.Resource) string {
+	fields := strings.Split(res.ProtocolInfo, ":")
+	if len(fields) >= 3 && fields[2] != "*" {
+		return fields[2]
+	}
+	if parsed, e := neturl.Parse(res.URL); e == nil {
+		return upnptype.SubtitleMimeType(parsed.Path)
+	}
+	return ""
+}
+
 // SetNextAVTransportURI sets the next playback URI,
 //
 func (cp *MediaControl) SetNextAVTransportURI(nextURI, nextURIMetaData string) error {
 	if !cp.RendererExists() {
 		return nil
 	}
-	return cp.Renderer().SetNextAVTransportURI(0, nextURI, nextURIMetaData)
+	return cp.curCaps.SetNextAVTransportURI(0, nextURI, nextURIMetaData)
 }
 
 // AddURIToQueue is TODO.
@@ -416,7 +531,7 @@ func (cp *MediaControl) AddURIToQueue(req *upnptype.AddURIToQueueIn) (*upnptype.
 		return nil, nil
 	}
 
-	println("EnqueuedURI", req.EnqueuedURI)
+	cp.log.Debug("add uri to queue", upnptype.FieldUDN, cp.curRend.UDN(), "uri", req.EnqueuedURI)
 
 	_, items, didlxml := cp.curSrv.BrowseMetadata(req.EnqueuedURI, 0, uint(upnptype.MaxBrowse))
 	for _, item := range items {
@@ -442,11 +557,18 @@ func (cp *MediaControl) AddURIToQueue(req *upnptype.AddURIToQueueIn) (*upnptype.
 
 // Seek seeks to new time in track. Input in seconds.
 //
+// A time seek unsupported by the renderer falls back to the other time mode
+// (ABS_TIME or REL_TIME).
+//
 func (cp *MediaControl) Seek(unit, target string) error {
 	if cp.curRend == nil {
 		return nil
 	}
-	return cp.curRend.Seek(0, unit, target)
+	e := cp.curCaps.Seek(0, unit, target)
+	if upnptype.IsNotSupported(e) && (unit == upnptype.SeekModeAbsTime || unit == upnptype.SeekModeRelTime) {
+		return cp.curCaps.SeekTime(0, upnptype.TimeToSecond(target))
+	}
+	return e
 }
 
 // SeekPercent seeks to new time in track. Input is the percent position in track. Range 0 to 100.
@@ -464,9 +586,8 @@ func (cp *MediaControl) SeekPercent(value float64) error {
 
 	// println("seek", positionInfo.TrackDuration, percent, upnptype.TimeToString(int(percent)))
 
-	cp.Seek(upnptype.SeekModeAbsTime, upnptype.TimeToString(int(percent)))
 	// cp.Renderer().Duration()  // was used
-	return nil
+	return cp.curCaps.SeekTime(0, int(percent))
 }
 
 // target = "%d:%02d:%02d" % (hours,minutes,seconds)
@@ -512,6 +633,8 @@ func (cp *MediaControl) onSetSeekDelta(delta int) {
 
 func (cp *MediaControl) onRendererFound(r upnptype.Renderer) {
 	cp.renderers[r.UDN()] = r
+	cp.metrics.Discovery(upnpmetrics.TypeRenderer, upnpmetrics.EventFound)
+	cp.metrics.SetDevices(upnpmetrics.TypeRenderer, len(cp.renderers))
 
 	if cp.tmpDir != "" {
 		r.SetIcon(r.GetIconFile(path.Join(cp.tmpDir, r.UDN()))) // Get device icon.
@@ -523,6 +646,7 @@ func (cp *MediaControl) onRendererFound(r upnptype.Renderer) {
 
 	// Connect renderer events to renderer hooks.
 	r.Events().OnTransportState = func(rcb upnptype.Renderer, value upnptype.PlaybackState) {
+		cp.metrics.RendererState(rcb.UDN(), rcb.Name(), value) // all renderers, not only the active one.
 		for _, instance := range cp.hookTestRenderer(rcb, testTransportState) {
 			instance.OnTransportState(rcb, value)
 		}
@@ -558,11 +682,19 @@ func (cp *MediaControl) onRendererFound(r upnptype.Renderer) {
 		}
 	}
 
+	r.Events().OnCurrentConnectionIDs = func(rcb upnptype.Renderer, value []string) {
+		for _, instance := range cp.hookTestRenderer(rcb, testCurrentConnectionIDs) {
+			instance.OnCurrentConnectionIDs(rcb, value)
+		}
+	}
+
 	cp.setRendererDefault() // Now we can test if we need to select it.
 }
 
 func (cp *MediaControl) onMediaServerFound(srv upnptype.Server) {
 	cp.servers[srv.UDN()] = srv
+	cp.metrics.Discovery(upnpmetrics.TypeServer, upnpmetrics.EventFound)
+	cp.metrics.SetDevices(upnpmetrics.TypeServer, len(cp.servers))
 
 	if cp.tmpDir != "" {
 		srv.SetIcon(srv.GetIconFile(path.Join(cp.tmpDir, srv.UDN()))) // Get device icon.
@@ -572,6 +704,19 @@ func (cp *MediaControl) onMediaServerFound(srv upnptype.Server) {
 		instance.OnServerFound(srv)
 	}
 
+	// Connect server events to server hooks.
+	srv.Events().OnSystemUpdateID = func(scb upnptype.Server, value uint) {
+		for _, instance := range cp.hookTestServer(scb, testSystemUpdateID) {
+			instance.OnSystemUpdateID(scb, value)
+		}
+	}
+
+	srv.Events().OnContainerUpdateIDs = func(scb upnptype.Server, value map[string]uint) {
+		for _, instance := range cp.hookTestServer(scb, testContainerUpdateIDs) {
+			instance.OnContainerUpdateIDs(scb, value)
+		}
+	}
+
 	// cp.setServerDefault() // Now we can test if we need to select it.
 }
 
@@ -583,6 +728,9 @@ func (cp *MediaControl) onMediaRendererLost(rtest upnptype.Renderer) {
 			}
 
 			delete(cp.renderers, rend.UDN()) // delete from our index.
+			cp.metrics.Discovery(upnpmetrics.TypeRenderer, upnpmetrics.EventLost)
+			cp.metrics.SetDevices(upnpmetrics.TypeRenderer, len(cp.renderers))
+			cp.metrics.RemoveRenderer(rend.UDN())
 
 			for _, instance := range cp.hookTest(testRendererLost) { // forward device lost event.
 				instance.OnRendererLost(rend)
@@ -599,6 +747,8 @@ func (cp *MediaControl) onMediaServerLost(stest upnptype.Server) {
 			}
 
 			delete(cp.servers, srv.UDN()) // delete from our index.
+			cp.metrics.Discovery(upnpmetrics.TypeServer, upnpmetrics.EventLost)
+			cp.metrics.SetDevices(upnpmetrics.TypeServer, len(cp.servers))
 
 			for _, instance := range cp.hookTest(testServerLost) { // forward device lost event.
 				instance.OnServerLost(srv)
@@ -631,6 +781,13 @@ func (cp *MediaControl) hookTestRenderer(rend upnptype.Renderer, test func(insta
 	return nil
 }
 
+func (cp *MediaControl) hookTestServer(srv upnptype.Server, test func(instance *upnptype.MediaHook) bool) (ret []*upnptype.MediaHook) {
+	if cp.ServerIsActive(srv) {
+		return cp.hookTest(test)
+	}
+	return nil
+}
+
 // hookTest builds the list of registered clients implementing test.
 //
 func (cp *MediaControl) hookTest(test func(instance *upnptype.MediaHook) bool) (ret []*upnptype.MediaHook) {
@@ -648,6 +805,9 @@ func testCurrentTrackMetaData(h *upnptype.MediaHook) bool { return h.OnCurrentTr
 func testMute(h *upnptype.MediaHook) bool                 { return h.OnMute != nil }
 func testVolume(h *upnptype.MediaHook) bool               { return h.OnVolume != nil }
 func testCurrentTime(h *upnptype.MediaHook) bool          { return h.OnCurrentTime != nil }
+func testCurrentConnectionIDs(h *upnptype.MediaHook) bool { return h.OnCurrentConnectionIDs != nil }
+func testSystemUpdateID(h *upnptype.MediaHook) bool       { return h.OnSystemUpdateID != nil }
+func testContainerUpdateIDs(h *upnptype.MediaHook) bool   { return h.OnContainerUpdateIDs != nil }
 
 func testSetVolumeDelta(h *upnptype.MediaHook) bool   { return h.OnSetVolumeDelta != nil }
 func testSetSeekDelta(h *upnptype.MediaHook) bool     { return h.OnSetSeekDelta != nil }
@@ -657,6 +817,8 @@ func testRendererLost(h *upnptype.MediaHook) bool     { return h.OnRendererLost
 func testServerLost(h *upnptype.MediaHook) bool       { return h.OnServerLost != nil }
 func testRendererSelected(h *upnptype.MediaHook) bool { return h.OnRendererSelected != nil }
 func testServerSelected(h *upnptype.MediaHook) bool   { return h.OnServerSelected != nil }
+func testSlideshowState(h *upnptype.MediaHook) bool   { return h.OnSlideshowState != nil }
+func testSlideshowImage(h *upnptype.MediaHook) bool   { return h.OnSlideshowImage != nil }
 
 //
 //---------------------------------------------------[ RenderControl PARSING ]--
@@ -0,0 +1,872 @@
+package gupnp
+
+import (
+	"github.com/sqp/gupnp/mocktype"
+	"github.com/sqp/gupnp/upnplog"
+	"github.com/sqp/gupnp/upnpmetrics"
+	"github.com/sqp/gupnp/upnptype"
+
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestControl creates a media control connected to a fake control point.
+func newTestControl(t *testing.T) (*MediaControl, *mocktype.ControlPoint) {
+	media, e := New(upnplog.Test(t))
+	if e != nil {
+		t.Fatal("new media control:", e)
+	}
+	t.Cleanup(func() { os.RemoveAll(media.tmpDir) })
+
+	cp := mocktype.NewControlPoint()
+	media.SetControlPoint(cp)
+	return media, cp
+}
+
+// newTestRenderer creates a media control with a selected fake renderer.
+func newTestRenderer(t *testing.T) (*MediaControl, *mocktype.Renderer) {
+	media, cp := newTestControl(t)
+	tv := mocktype.NewRenderer("uuid:tv", "TV")
+	cp.AddRenderer(tv)
+	media.SetRenderer(tv.UDN())
+	tv.ResetCalls()
+	return media, tv
+}
+
+//
+//---------------------------------------------------------------[ SELECTION ]--
+
+func TestRendererFound(t *testing.T) {
+	media, cp := newTestControl(t)
+
+	var found upnptype.Renderer
+	hook := media.SubscribeHook("test")
+	hook.OnRendererFound = func(r upnptype.Renderer) { found = r }
+
+	tv := mocktype.NewRenderer("uuid:tv", "TV")
+	cp.AddRenderer(tv)
+
+	if found != tv {
+		t.Fatal("OnRendererFound not forwarded")
+	}
+	if media.GetRenderer("uuid:tv") != tv || len(media.Renderers()) != 1 {
+		t.Fatal("renderer not indexed")
+	}
+	if media.RendererExists() {
+		t.Fatal("renderer selected without preference")
+	}
+}
+
+func TestSetRenderer(t *testing.T) {
+	media, cp := newTestControl(t)
+	tv := mocktype.NewRenderer("uuid:tv", "TV")
+	tv.SetState(mocktype.RendererState{Volume: 42, Transport: upnptype.PlaybackStatePlaying})
+	cp.AddRenderer(tv)
+
+	var selected upnptype.Renderer
+	var volume uint
+	var state upnptype.PlaybackState
+	hook := media.SubscribeHook("test")
+	hook.OnRendererSelected = func(r upnptype.Renderer) { selected = r }
+	hook.OnVolume = func(_ upnptype.Renderer, v uint) { volume = v }
+	hook.OnTransportState = func(_ upnptype.Renderer, s upnptype.PlaybackState) { state = s }
+
+	media.SetRenderer("uuid:tv")
+
+	if media.Renderer() != tv || selected != tv {
+		t.Fatal("renderer not selected")
+	}
+	if volume != 42 || state != upnptype.PlaybackStatePlaying {
+		t.Errorf("initial state not forwarded: volume=%d state=%d", volume, state)
+	}
+	if media.Capabilities() == nil {
+		t.Error("no capabilities for the selected renderer")
+	}
+
+	media.SetRenderer("")
+	if media.RendererExists() || media.Capabilities() != nil || selected != nil {
+		t.Error("renderer not unselected")
+	}
+}
+
+func TestPreferredRenderer(t *testing.T) {
+	media, cp := newTestControl(t)
+	media.SetPreferredRenderer("TV")
+
+	cp.AddRenderer(mocktype.NewRenderer("uuid:radio", "Radio"))
+	if media.RendererExists() {
+		t.Fatal("wrong renderer selected")
+	}
+
+	tv := mocktype.NewRenderer("uuid:tv", "TV")
+	cp.AddRenderer(tv)
+	if media.Renderer() != tv {
+		t.Fatal("preferred renderer not selected when found")
+	}
+}
+
+func TestPreferredServer(t *testing.T) {
+	media, cp := newTestControl(t)
+	nas := mocktype.NewServer("uuid:nas", "NAS")
+	cp.AddServer(nas)
+
+	media.SetPreferredServer("NAS")
+	if media.Server() != nas {
+		t.Fatal("preferred server not selected")
+	}
+}
+
+func TestRendererLost(t *testing.T) {
+	media, cp := newTestControl(t)
+	tv := mocktype.NewRenderer("uuid:tv", "TV")
+	cp.AddRenderer(tv)
+	media.SetRenderer(tv.UDN())
+
+	var lost upnptype.Renderer
+	hook := media.SubscribeHook("test")
+	hook.OnRendererLost = func(r upnptype.Renderer) { lost = r }
+
+	cp.RemoveRenderer(tv)
+
+	if lost != tv {
+		t.Error("OnRendererLost not forwarded")
+	}
+	if media.RendererExists() || media.GetRenderer(tv.UDN()) != nil {
+		t.Error("lost renderer still known")
+	}
+}
+
+func TestServerLost(t *testing.T) {
+	media, cp := newTestControl(t)
+	nas := mocktype.NewServer("uuid:nas", "NAS")
+	cp.AddServer(nas)
+	media.SetServer(nas.UDN())
+
+	cp.RemoveServer(nas)
+
+	if media.ServerExists() || len(media.Servers()) != 0 {
+		t.Error("lost server still known")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	media, cp := newTestControl(t)
+	metrics := upnpmetrics.New()
+	media.SetMetrics(metrics)
+
+	tv := mocktype.NewRenderer("uuid:tv", "TV")
+	cp.AddRenderer(tv)
+	cp.AddRenderer(mocktype.NewRenderer("uuid:kitchen", "Kitchen"))
+	cp.AddServer(mocktype.NewServer("uuid:nas", "NAS"))
+	tv.Play(0, upnptype.PlaySpeedNormal) // state of renderers not selected.
+	cp.RemoveRenderer(tv)
+
+	var b strings.Builder
+	metrics.WriteTo(&b)
+	for _, want := range []string{
+		`upnp_devices{type="renderer"} 1`,
+		`upnp_devices{type="server"} 1`,
+		`upnp_discovery_events_total{type="renderer",event="found"} 2`,
+		`upnp_discovery_events_total{type="renderer",event="lost"} 1`,
+	} {
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Errorf("missing %s in:\n%s", want, b.String())
+		}
+	}
+	if strings.Contains(b.String(), "upnp_renderer_state") {
+		t.Error("state of lost renderer still exported")
+	}
+
+	cp.AddRenderer(tv)
+	tv.Pause(0)
+	b.Reset()
+	metrics.WriteTo(&b)
+	if !strings.Contains(b.String(), `upnp_renderer_state{udn="uuid:tv",device="TV",state="PAUSED_PLAYBACK"} 1`) {
+		t.Errorf("missing renderer state in:\n%s", b.String())
+	}
+}
+
+//
+//-------------------------------------------------------------------[ HOOKS ]--
+
+func TestHooksActiveRenderer(t *testing.T) {
+	media, cp := newTestControl(t)
+	tv := mocktype.NewRenderer("uuid:tv", "TV")
+	radio := mocktype.NewRenderer("uuid:radio", "Radio")
+	cp.AddRenderer(tv)
+	cp.AddRenderer(radio)
+	media.SetRenderer(tv.UDN())
+
+	var mutes []bool
+	hook := media.SubscribeHook("test")
+	hook.OnMute = func(_ upnptype.Renderer, mute bool) { mutes = append(mutes, mute) }
+
+	radio.SetState(mocktype.RendererState{Mute: true})
+	if len(mutes) != 0 {
+		t.Fatal("event of an inactive renderer forwarded")
+	}
+
+	tv.SetState(mocktype.RendererState{Mute: true})
+	if len(mutes) != 1 || !mutes[0] {
+		t.Fatal("event of the active renderer not forwarded")
+	}
+
+	media.UnsubscribeHook("test")
+	tv.SetState(mocktype.RendererState{})
+	if len(mutes) != 1 {
+		t.Error("event forwarded to an unsubscribed hook")
+	}
+}
+
+func TestHooksServer(t *testing.T) {
+	media, cp := newTestControl(t)
+	nas := mocktype.NewServer("uuid:nas", "NAS")
+	cp.AddServer(nas)
+	media.SetServer(nas.UDN())
+
+	var updateID uint
+	var containers map[string]uint
+	hook := media.SubscribeHook("test")
+	hook.OnSystemUpdateID = func(_ upnptype.Server, id uint) { updateID = id }
+	hook.OnContainerUpdateIDs = func(_ upnptype.Server, ids map[string]uint) { containers = ids }
+
+	nas.Update("music")
+
+	if updateID != 1 || containers["music"] != 1 {
+		t.Errorf("server events not forwarded: %d %v", updateID, containers)
+	}
+}
+
+//
+//-----------------------------------------------------------------[ ACTIONS ]--
+
+func TestActionNoRenderer(t *testing.T) {
+	media, _ := newTestControl(t)
+	if e := media.Action(upnptype.ActionPlayPause); e != nil {
+		t.Error("action without renderer:", e)
+	}
+}
+
+func TestActionVolume(t *testing.T) {
+	media, tv := newTestRenderer(t)
+	media.SetVolumeDelta(5)
+
+	tv.SetState(mocktype.RendererState{Volume: 50})
+	if e := media.Action(upnptype.ActionVolumeUp); e != nil || tv.State().Volume != 55 {
+		t.Errorf("volume up: %d %v", tv.State().Volume, e)
+	}
+
+	tv.SetState(mocktype.RendererState{Volume: 3})
+	if e := media.Action(upnptype.ActionVolumeDown); e != nil || tv.State().Volume != 0 {
+		t.Errorf("volume down below 0: %d %v", tv.State().Volume, e)
+	}
+
+	tv.SetState(mocktype.RendererState{Volume: 98})
+	if e := media.Action(upnptype.ActionVolumeUp); e != nil || tv.State().Volume != 100 {
+		t.Errorf("volume up above max: %d %v", tv.State().Volume, e)
+	}
+}
+
+func TestActionToggleMute(t *testing.T) {
+	media, tv := newTestRenderer(t)
+
+	media.Action(upnptype.ActionToggleMute)
+	if !tv.State().Mute {
+		t.Error("not muted")
+	}
+	media.Action(upnptype.ActionToggleMute)
+	if tv.State().Mute {
+		t.Error("not unmuted")
+	}
+}
+
+func TestActionPlayPauseStop(t *testing.T) {
+	media, tv := newTestRenderer(t)
+
+	for _, test := range []struct {
+		action upnptype.Action
+		state  upnptype.PlaybackState
+	}{
+		{upnptype.ActionPlayPause, upnptype.PlaybackStatePlaying},
+		{upnptype.ActionPlayPause, upnptype.PlaybackStatePaused},
+		{upnptype.ActionPlayPause, upnptype.PlaybackStatePlaying},
+		{upnptype.ActionStop, upnptype.PlaybackStateStopped},
+	} {
+		if e := media.Action(test.action); e != nil {
+			t.Fatal("action", test.action, e)
+		}
+		if got := tv.State().Transport; got != test.state {
+			t.Fatalf("action %d: state %d, want %d", test.action, got, test.state)
+		}
+	}
+}
+
+func TestActionSeek(t *testing.T) {
+	media, tv := newTestRenderer(t)
+	media.SetSeekDelta(10)
+	tv.SetState(mocktype.RendererState{Position: 60, Duration: 300})
+
+	media.Action(upnptype.ActionSeekForward)
+	if tv.State().Position != 70 {
+		t.Errorf("seek forward: position %d", tv.State().Position)
+	}
+
+	media.Action(upnptype.ActionSeekBackward)
+	if tv.State().Position != 60 {
+		t.Errorf("seek backward: position %d", tv.State().Position)
+	}
+}
+
+func TestActionError(t *testing.T) {
+	media, tv := newTestRenderer(t)
+	fail := errors.New("501 action failed")
+	tv.FailOn("Stop", fail)
+
+	if e := media.Action(upnptype.ActionStop); e != fail {
+		t.Errorf("error not returned: %v", e)
+	}
+
+	tv.FailOn("Stop", nil)
+	if e := media.Action(upnptype.ActionStop); e != nil {
+		t.Errorf("error not removed: %v", e)
+	}
+}
+
+//
+//------------------------------------------------------------[ CAPABILITIES ]--
+
+// relTimeSCPD describes an AVTransport only seeking with REL_TIME, without
+// SetNextAVTransportURI.
+var relTimeSCPD = &upnptype.SCPD{
+	Actions: []upnptype.ActionInfo{
+		{Name: "Play"}, {Name: "Stop"}, {Name: "Pause"},
+		{Name: "Seek", Arguments: []upnptype.ArgumentInfo{
+			{Name: "InstanceID", Direction: "in", RelatedStateVariable: "A_ARG_TYPE_InstanceID"},
+			{Name: "Unit", Direction: "in", RelatedStateVariable: "A_ARG_TYPE_SeekMode"},
+			{Name: "Target", Direction: "in", RelatedStateVariable: "A_ARG_TYPE_SeekTarget"},
+		}},
+	},
+	StateVariables: []upnptype.StateVariable{
+		{Name: "A_ARG_TYPE_SeekMode", DataType: "string", AllowedValues: []string{"TRACK_NR", "REL_TIME"}},
+	},
+}
+
+func TestSeekModeFallback(t *testing.T) {
+	media, cp := newTestControl(t)
+	tv := mocktype.NewRenderer("uuid:tv", "TV")
+	if e := tv.SetSCPD(upnptype.ServiceTypeAVTransport, relTimeSCPD); e != nil {
+		t.Fatal("set SCPD:", e)
+	}
+	cp.AddRenderer(tv)
+	media.SetRenderer(tv.UDN())
+
+	caps := media.Capabilities()
+	if caps.TimeSeekMode() != upnptype.SeekModeRelTime {
+		t.Fatalf("time seek mode %q", caps.TimeSeekMode())
+	}
+	if len(caps.SeekModes()) != 2 {
+		t.Errorf("seek modes %v", caps.SeekModes())
+	}
+
+	if e := media.Seek(upnptype.SeekModeAbsTime, "00:01:40"); e != nil {
+		t.Fatal("seek with fallback:", e)
+	}
+	if tv.State().Position != 100 {
+		t.Errorf("seek position %d", tv.State().Position)
+	}
+}
+
+func TestNotSupported(t *testing.T) {
+	media, cp := newTestControl(t)
+	tv := mocktype.NewRenderer("uuid:tv", "TV")
+	tv.SetSCPD(upnptype.ServiceTypeAVTransport, relTimeSCPD)
+	cp.AddRenderer(tv)
+	media.SetRenderer(tv.UDN())
+	tv.ResetCalls()
+
+	e := media.SetNextAVTransportURI("http://host/next.mp3", "")
+	if !upnptype.IsNotSupported(e) {
+		t.Fatalf("want ErrNotSupported, got %v", e)
+	}
+	if len(tv.Calls()) != 0 {
+		t.Errorf("unsupported action sent: %v", tv.Calls())
+	}
+}
+
+func TestActionNotSupported(t *testing.T) {
+	media, cp := newTestControl(t)
+	tv := mocktype.NewRenderer("uuid:tv", "TV")
+	tv.SetSCPD(upnptype.ServiceTypeRenderingControl, &upnptype.SCPD{
+		Actions: []upnptype.ActionInfo{{Name: "GetMute"}, {Name: "GetVolume"}, {Name: "SetVolume"}},
+	})
+	cp.AddRenderer(tv)
+	media.SetRenderer(tv.UDN())
+
+	if e := media.Action(upnptype.ActionToggleMute); !upnptype.IsNotSupported(e) {
+		t.Errorf("toggle mute: want ErrNotSupported, got %v", e)
+	}
+	if tv.State().Mute {
+		t.Error("muted without SetMute")
+	}
+
+	tv.SetSCPD(upnptype.ServiceTypeAVTransport, &upnptype.SCPD{
+		Actions: []upnptype.ActionInfo{{Name: "Play"}, {Name: "GetTransportInfo"}},
+	})
+	tv.Play(0, upnptype.PlaySpeedNormal)
+	if e := media.Action(upnptype.ActionPlayPause); !upnptype.IsNotSupported(e) {
+		t.Errorf("play pause: want ErrNotSupported, got %v", e)
+	}
+	if e := media.Action(upnptype.ActionStop); !upnptype.IsNotSupported(e) {
+		t.Errorf("stop: want ErrNotSupported, got %v", e)
+	}
+	if tv.State().Transport != upnptype.PlaybackStatePlaying {
+		t.Errorf("transport changed to %v", tv.State().Transport)
+	}
+}
+
+func TestQuirks(t *testing.T) {
+	saved := upnptype.KnownQuirks
+	defer func() { upnptype.KnownQuirks = saved }()
+
+	seekMode, requireMeta, volumeMax := upnptype.SeekModeRelTime, true, uint16(30)
+	upnptype.KnownQuirks = upnptype.NewQuirksDB(upnptype.QuirkRule{
+		Manufacturer:    "MOCK",
+		SeekMode:        &seekMode,
+		RequireMetadata: &requireMeta,
+		VolumeMax:       &volumeMax,
+	})
+
+	media, tv := newTestRenderer(t)
+	caps := media.Capabilities()
+
+	if caps.TimeSeekMode() != upnptype.SeekModeRelTime {
+		t.Errorf("seek mode quirk not applied: %q", caps.TimeSeekMode())
+	}
+
+	caps.SetVolume(0, upnptype.ChannelMaster, 80)
+	if tv.State().Volume != 30 {
+		t.Errorf("volume max quirk not applied: %d", tv.State().Volume)
+	}
+
+	caps.SetAVTransportURI(0, "http://host/a.mp3", "")
+	if tv.State().Metadata == "" {
+		t.Error("metadata quirk not applied")
+	}
+}
+
+//
+//------------------------------------------------------------------[ BROWSE ]--
+
+func TestBrowse(t *testing.T) {
+	media, cp := newTestControl(t)
+
+	if containers, items, _, _ := media.Browse("0", 0); containers != nil || items != nil {
+		t.Fatal("browse without server")
+	}
+
+	nas := mocktype.NewServer("uuid:nas", "NAS")
+	nas.AddContainer("0", "music", "Music")
+	nas.AddContainer("0", "video", "Video")
+	nas.AddItem("music", "song1", "Song 1", "object.item.audioItem.musicTrack", "http://nas/song1.mp3")
+	nas.AddItem("music", "song2", "Song 2", "object.item.audioItem.musicTrack", "http://nas/song2.mp3")
+	cp.AddServer(nas)
+	media.SetServer(nas.UDN())
+
+	containers, items, _, _ := media.Browse("0", 0)
+	if len(containers) != 2 || len(items) != 0 {
+		t.Fatalf("browse root: %d containers, %d items", len(containers), len(items))
+	}
+	if containers[0].ChildCount != 2 {
+		t.Errorf("child count %d", containers[0].ChildCount)
+	}
+
+	containers, items, _, _ = media.Browse("music", 1)
+	if len(containers) != 0 || len(items) != 1 || items[0].ID != "song2" {
+		t.Fatalf("browse page: %v %v", containers, items)
+	}
+
+	nas.FailOn("Browse", errors.New("browse failed"))
+	if containers, items, _, _ := media.Browse("0", 0); containers != nil || items != nil {
+		t.Error("browse error not handled")
+	}
+}
+
+func TestBrowseMetadataPlays(t *testing.T) {
+	media, tv := newTestRenderer(t)
+	cp := mocktype.NewControlPoint()
+	media.SetControlPoint(cp)
+
+	nas := mocktype.NewServer("uuid:nas", "NAS")
+	nas.AddItem("0", "song1", "Song 1", "object.item.audioItem.musicTrack", "http://nas/song1.mp3")
+	cp.AddServer(nas)
+	media.SetServer(nas.UDN())
+
+	if e := media.BrowseMetadata("song1", 0); e != nil {
+		t.Fatal("browse metadata:", e)
+	}
+	st := tv.State()
+	if st.URI != "http://nas/song1.mp3" || st.Metadata == "" || st.Transport != upnptype.PlaybackStatePlaying {
+		t.Errorf("item not played: %+v", st)
+	}
+}
+
+//
+//--------------------------------------------------------------------[ CAST ]--
+
+func TestPlayURL(t *testing.T) {
+	media, tv := newTestRenderer(t)
+
+	if e := media.PlayURL("http://host/stream", "audio/mpeg"); e != nil {
+		t.Fatal("play url:", e)
+	}
+	st := tv.State()
+	if st.URI != "http://host/stream" || !strings.Contains(st.Metadata, "http-get:*:audio/mpeg:") ||
+		!strings.Contains(st.Metadata, "<dc:title>stream</dc:title>") || st.Transport != upnptype.PlaybackStatePlaying {
+		t.Errorf("url not played: %+v", st)
+	}
+
+	// Unknown type: guessed from the extension.
+	if e := media.PlayURL("http://host/movie.mkv", ""); e != nil {
+		t.Fatal("play url:", e)
+	}
+	if st := tv.State(); !strings.Contains(st.Metadata, "video/x-matroska") || !strings.Contains(st.Metadata, "object.item.videoItem") {
+		t.Errorf("type not guessed: %s", st.Metadata)
+	}
+}
+
+func TestPlayFile(t *testing.T) {
+	media, tv := newTestRenderer(t)
+	defer media.Close()
+	tv.SetNetwork("lo", "127.0.0.1")
+
+	filename := filepath.Join(t.TempDir(), "my song.mp3")
+	ioutil.WriteFile(filename, []byte("0123456789"), 0644)
+
+	if e := media.PlayFile(filename); e != nil {
+		t.Fatal("play file:", e)
+	}
+	st := tv.State()
+	if !strings.HasPrefix(st.URI, "http://127.0.0.1:") || !strings.HasSuffix(st.URI, "/my%20song.mp3") ||
+		!strings.Contains(st.Metadata, `size="10"`) || !strings.Contains(st.Metadata, "<dc:title>my song</dc:title>") {
+		t.Fatalf("file not played: %+v", st)
+	}
+
+	req, _ := http.NewRequest("GET", st.URI, nil)
+	req.Header.Set("Range", "bytes=5-")
+	resp, e := http.DefaultClient.Do(req)
+	if e != nil {
+		t.Fatal("get file:", e)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(data) != "56789" || resp.Header.Get("Content-Type") != "audio/mpeg" {
+		t.Errorf("bad file response: %s %q", resp.Status, data)
+	}
+
+	if e := media.PlayFile(filepath.Join(t.TempDir(), "notes.txt")); e == nil {
+		t.Error("missing file played")
+	}
+
+	media.Close()
+	if _, e := http.Get(st.URI); e == nil {
+		t.Error("file still served after close")
+	}
+}
+
+func TestPlayNoRenderer(t *testing.T) {
+	media, _ := newTestControl(t)
+	if e := media.PlayURL("http://host/a.mp3", ""); e != ErrNoRenderer {
+		t.Errorf("want ErrNoRenderer, got %v", e)
+	}
+	if e := media.PlayFile("a.mp3"); e != ErrNoRenderer {
+		t.Errorf("want ErrNoRenderer, got %v", e)
+	}
+}
+
+//
+//---------------------------------------------------------------[ SUBTITLES ]--
+
+func TestBrowseMetadataSubtitles(t *testing.T) {
+	media, tv := newTestRenderer(t)
+	cp := mocktype.NewControlPoint()
+	media.SetControlPoint(cp)
+
+	nas := mocktype.NewServer("uuid:nas", "NAS")
+	nas.AddItem("0", "movie", "Movie", "object.item.videoItem", "http://nas/movie.mkv")
+	nas.AddResource("movie", "http-get:*:text/srt:*", "http://nas/movie.srt")
+	cp.AddServer(nas)
+	media.SetServer(nas.UDN())
+
+	if e := media.BrowseMetadata("movie", 0); e != nil {
+		t.Fatal("browse metadata:", e)
+	}
+	st := tv.State()
+	if st.URI != "http://nas/movie.mkv" {
+		t.Errorf("subtitles played instead of the video: %s", st.URI)
+	}
+	for _, want := range []string{
+		`<sec:CaptionInfoEx sec:type="srt">http://nas/movie.srt</sec:CaptionInfoEx>`,
+		`pv:subtitleFileUri="http://nas/movie.srt"`,
+		`xmlns:sec="` + upnptype.NamespaceSec + `"`,
+	} {
+		if !strings.Contains(st.Metadata, want) {
+			t.Errorf("metadata missing %s: %s", want, st.Metadata)
+		}
+	}
+
+	media.SetSubtitles(tv.UDN(), false)
+	if media.Subtitles(tv.UDN()) {
+		t.Fatal("subtitles not disabled")
+	}
+	media.BrowseMetadata("movie", 0)
+	if st := tv.State(); strings.Contains(st.Metadata, "CaptionInfoEx") {
+		t.Errorf("subtitles sent while disabled: %s", st.Metadata)
+	}
+}
+
+func TestPlayFileSubtitles(t *testing.T) {
+	media, tv := newTestRenderer(t)
+	defer media.Close()
+	tv.SetNetwork("lo", "127.0.0.1")
+
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "movie.mp4"), []byte("video"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "movie.en.srt"), []byte("1\n00:00:01,000 --> 00:00:02,000\nHello\n"), 0644)
+
+	if e := media.PlayFile(filepath.Join(dir, "movie.mp4")); e != nil {
+		t.Fatal("play file:", e)
+	}
+	st := tv.State()
+	if !strings.Contains(st.Metadata, "CaptionInfoEx") {
+		t.Fatalf("subtitles not in metadata: %s", st.Metadata)
+	}
+
+	resp, e := http.Get(st.URI)
+	if e != nil {
+		t.Fatal("get video:", e)
+	}
+	resp.Body.Close()
+	subURI := resp.Header.Get("CaptionInfo.sec")
+	if !strings.HasSuffix(subURI, "/movie.en.srt") {
+		t.Fatalf("bad CaptionInfo.sec header: %q", subURI)
+	}
+
+	resp, e = http.Get(subURI)
+	if e != nil {
+		t.Fatal("get subtitles:", e)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(data), "Hello") || resp.Header.Get("Content-Type") != "text/srt" {
+		t.Errorf("bad subtitles response: %s %q", resp.Header.Get("Content-Type"), data)
+	}
+}
+
+//
+//---------------------------------------------------------------[ SLIDESHOW ]--
+
+// newTestSlideshow creates a media control with a selected renderer and a
+// selected server sharing 3 photos and a song.
+func newTestSlideshow(t *testing.T) (*MediaControl, *mocktype.Renderer, chan int) {
+	media, tv := newTestRenderer(t)
+	cp := mocktype.NewControlPoint()
+	media.SetControlPoint(cp)
+	nas := mocktype.NewServer("uuid:nas", "NAS")
+	nas.AddContainer("0", "photos", "Photos")
+	for _, id := range []string{"a", "b", "c"} {
+		nas.AddItem("photos", id, id, "object.item.imageItem.photo", "http://nas/"+id+".jpg")
+	}
+	nas.AddItem("photos", "song", "song", "object.item.audioItem.musicTrack", "http://nas/song.mp3")
+	cp.AddServer(nas)
+	media.SetServer(nas.UDN())
+
+	images := make(chan int, 10)
+	hook := media.SubscribeHook("test")
+	hook.OnSlideshowImage = func(index, count int, item *upnptype.Item) {
+		if count != 3 {
+			t.Errorf("bad slideshow count: %d", count)
+		}
+		images <- index
+	}
+	t.Cleanup(func() { media.Slideshow().Stop() })
+	return media, tv, images
+}
+
+func waitImage(t *testing.T, images chan int) int {
+	select {
+	case index := <-images:
+		return index
+	case <-time.After(time.Second):
+		t.Fatal("no image displayed")
+	}
+	return -1
+}
+
+func TestSlideshow(t *testing.T) {
+	media, tv, images := newTestSlideshow(t)
+	states := make(chan upnptype.PlaybackState, 10)
+	hook := media.SubscribeHook("states")
+	hook.OnSlideshowState = func(state upnptype.PlaybackState) { states <- state }
+
+	show := media.Slideshow()
+	show.SetInterval(10 * time.Millisecond)
+	if e := show.PlayContainer("photos"); e != nil {
+		t.Fatal("play container:", e)
+	}
+	for want := 0; want < 3; want++ {
+		if index := waitImage(t, images); index != want {
+			t.Fatalf("image %d displayed, want %d", index, want)
+		}
+	}
+	time.Sleep(30 * time.Millisecond)
+	if show.State() != upnptype.PlaybackStateStopped || tv.State().URI != "http://nas/c.jpg" {
+		t.Errorf("slideshow not ended on last image: %d %s", show.State(), tv.State().URI)
+	}
+	if len(states) != 2 || <-states != upnptype.PlaybackStatePlaying || <-states != upnptype.PlaybackStateStopped {
+		t.Error("bad state events")
+	}
+}
+
+// loopControlPoint is a control point with its own event loop: Call queues fn
+// like a main loop idle callback.
+type loopControlPoint struct {
+	*mocktype.ControlPoint
+	calls chan func()
+}
+
+func (cp *loopControlPoint) Call(fn func()) { cp.calls <- fn }
+
+func TestSlideshowCall(t *testing.T) {
+	media, tv, images := newTestSlideshow(t)
+	loop := &loopControlPoint{mocktype.NewControlPoint(), make(chan func(), 10)}
+	media.SetControlPoint(loop)
+
+	// The test goroutine is the event loop owning the media control.
+	var inLoop int32
+	run := func(fn func()) {
+		atomic.StoreInt32(&inLoop, 1)
+		defer atomic.StoreInt32(&inLoop, 0)
+		fn()
+	}
+	hook := media.SubscribeHook("loop")
+	hook.OnSlideshowImage = func(index, count int, item *upnptype.Item) {
+		if atomic.LoadInt32(&inLoop) == 0 {
+			t.Errorf("image %d displayed out of the event loop", index)
+		}
+	}
+
+	show := media.Slideshow()
+	show.SetInterval(time.Millisecond)
+	run(func() {
+		if e := show.PlayContainer("photos"); e != nil {
+			t.Fatal("play container:", e)
+		}
+	})
+	waitImage(t, images)
+
+	tick := func() {
+		select {
+		case fn := <-loop.calls:
+			run(fn)
+		case <-time.After(time.Second):
+			t.Fatal("no tick scheduled in the event loop")
+		}
+	}
+	for want := 1; want < 3; want++ {
+		tick()
+		select {
+		case index := <-images:
+			if index != want {
+				t.Fatalf("image %d displayed, want %d", index, want)
+			}
+		default:
+			t.Fatal("image not displayed by the tick")
+		}
+		if uri := tv.State().URI; !strings.HasSuffix(uri, []string{"a", "b", "c"}[want]+".jpg") {
+			t.Errorf("image %d: renderer URI %s", want, uri)
+		}
+	}
+	tick()
+	if show.State() != upnptype.PlaybackStateStopped {
+		t.Error("slideshow not ended by the last tick")
+	}
+}
+
+func TestSlideshowControls(t *testing.T) {
+	media, tv, images := newTestSlideshow(t)
+	show := media.Slideshow()
+	show.SetInterval(time.Hour)
+	show.PlayContainer("photos")
+	waitImage(t, images)
+
+	show.Pause()
+	if show.State() != upnptype.PlaybackStatePaused {
+		t.Fatal("slideshow not paused")
+	}
+	show.Previous()
+	if index := waitImage(t, images); index != 2 || tv.State().URI != "http://nas/c.jpg" {
+		t.Errorf("previous from first: got image %d %s", index, tv.State().URI)
+	}
+	show.Next()
+	if index := waitImage(t, images); index != 0 {
+		t.Errorf("next from last: got image %d", index)
+	}
+
+	show.SetShuffle(true)
+	if index, _ := show.Position(); index != 0 || tv.State().URI != "http://nas/a.jpg" {
+		t.Errorf("shuffle moved the current image: %d", index)
+	}
+	seen := map[string]bool{tv.State().URI: true}
+	for i := 0; i < 2; i++ {
+		show.Next()
+		waitImage(t, images)
+		seen[tv.State().URI] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("shuffled images repeated: %v", seen)
+	}
+
+	show.Stop()
+	if show.State() != upnptype.PlaybackStateStopped || tv.State().Transport != upnptype.PlaybackStateStopped {
+		t.Error("slideshow not stopped")
+	}
+}
+
+func TestSlideshowResolution(t *testing.T) {
+	media, tv, _ := newTestSlideshow(t)
+	media.UnsubscribeHook("test") // counts 3 images.
+	item := upnptype.Item{
+		Object: upnptype.Object{ID: "big", Title: "big", Class: "object.item.imageItem.photo"},
+		Res: []upnptype.Resource{
+			{ProtocolInfo: "http-get:*:image/jpeg:*", URL: "http://nas/4k.jpg", Resolution: "3840x2160"},
+			{ProtocolInfo: "http-get:*:image/jpeg:*", URL: "http://nas/hd.jpg", Resolution: "1920x1080"},
+			{ProtocolInfo: "http-get:*:image/jpeg:*", URL: "http://nas/thumb.jpg", Resolution: "160x90"},
+		},
+	}
+	show := media.Slideshow()
+	show.SetInterval(time.Hour)
+	show.SetMaxResolution(1920, 1080)
+	if e := show.PlayItems([]upnptype.Item{item}); e != nil {
+		t.Fatal("play items:", e)
+	}
+	if st := tv.State(); st.URI != "http://nas/hd.jpg" || !strings.Contains(st.Metadata, "object.item.imageItem") {
+		t.Errorf("bad resource for 1080p: %s %s", st.URI, st.Metadata)
+	}
+
+	show.SetMaxResolution(100, 100)
+	show.Next()
+	if uri := tv.State().URI; uri != "http://nas/thumb.jpg" {
+		t.Errorf("smallest resource not used: %s", uri)
+	}
+
+	if e := show.PlayItems(nil); e != ErrNoImage {
+		t.Errorf("empty slideshow: want ErrNoImage, got %v", e)
+	}
+}
@@ -0,0 +1,220 @@
+package mediaserver
+
+import (
+	"github.com/sqp/gupnp/upnptype"
+
+	"encoding/xml"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+//-----------------------------------------------------------------[ OBJECTS ]--
+
+// object defines a file or directory of the shared tree.
+type object struct {
+	id       string // Slash separated path relative to the root, or "0" for the root.
+	parentID string
+	title    string
+	class    string
+	mime     string
+	size     int64
+	modTime  time.Time
+	children int    // Containers only.
+	subtitle string // Object ID of the subtitles file, for videos.
+}
+
+func (obj *object) isContainer() bool { return obj.class == upnptype.ClassFolder }
+
+// objectPath returns the slash separated relative path of an object ID.
+// Returns false if the ID doesn't designate an object under the root.
+func objectPath(id string) (string, bool) {
+	if id == "0" {
+		return ".", true
+	}
+	if id == "" || path.Clean("/" + id)[1:] != id {
+		return "", false
+	}
+	return id, true
+}
+
+// childID returns the object ID of a directory entry.
+func childID(parentID, name string) string {
+	if parentID == "0" {
+		return name
+	}
+	return parentID + "/" + name
+}
+
+// parentID returns the object ID of the container of an object.
+func parentID(id string) string {
+	switch dir := path.Dir(id); {
+	case id == "0":
+		return "-1"
+	case dir == ".":
+		return "0"
+	default:
+		return dir
+	}
+}
+
+// newObject returns the object for a file, or nil if it isn't shared.
+func newObject(id string, info os.FileInfo) *object {
+	name := info.Name()
+	if strings.HasPrefix(name, ".") && id != "0" { // Hidden files.
+		return nil
+	}
+	obj := &object{
+		id:       id,
+		parentID: parentID(id),
+		title:    name,
+		modTime:  info.ModTime(),
+	}
+	if info.IsDir() {
+		obj.class = upnptype.ClassFolder
+		return obj
+	}
+	obj.mime = upnptype.MimeType(name)
+	if obj.mime == "" || !info.Mode().IsRegular() {
+		return nil
+	}
+	obj.class = upnptype.ItemClass(obj.mime)
+	obj.title = strings.TrimSuffix(name, filepath.Ext(name))
+	obj.size = info.Size()
+	return obj
+}
+
+// listDir returns the shared entries of a directory, containers first, then
+// sorted by title.
+func listDir(dir, id string) ([]*object, error) {
+	infos, e := ioutil.ReadDir(dir)
+	if e != nil {
+		return nil, e
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	var list []*object
+	for _, info := range infos {
+		obj := newObject(childID(id, info.Name()), info)
+		if obj == nil {
+			continue
+		}
+		if obj.class == upnptype.ClassVideo {
+			if subs := upnptype.MatchSubtitles(names, info.Name()); len(subs) > 0 {
+				obj.subtitle = childID(id, subs[0])
+			}
+		}
+		list = append(list, obj)
+	}
+	sortObjects(list, false)
+	return list, nil
+}
+
+// sortObjects sorts objects by title, containers first.
+func sortObjects(list []*object, descending bool) {
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].isContainer() != list[j].isContainer() {
+			return list[i].isContainer()
+		}
+		if descending {
+			i, j = j, i
+		}
+		return strings.ToLower(list[i].title) < strings.ToLower(list[j].title)
+	})
+}
+
+//
+//---------------------------------------------------------------[ DIDL-LITE ]--
+
+const didlHeader = `<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"` +
+	` xmlns:dc="http://purl.org/dc/elements/1.1/"` +
+	` xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/"` +
+	` xmlns:dlna="urn:schemas-dlna-org:metadata-1-0/"` +
+	` xmlns:sec="` + upnptype.NamespaceSec + `"` +
+	` xmlns:pv="` + upnptype.NamespacePV + `">`
+
+// didlWriter builds a DIDL-Lite document.
+type didlWriter struct {
+	b strings.Builder
+}
+
+func newDIDL() *didlWriter {
+	w := &didlWriter{}
+	w.b.WriteString(didlHeader)
+	return w
+}
+
+func (w *didlWriter) String() string {
+	return w.b.String() + "</DIDL-Lite>"
+}
+
+func (w *didlWriter) escape(str string) {
+	xml.EscapeText(&w.b, []byte(str))
+}
+
+// object writes a container or an item with its resource at the given URL,
+// and its subtitles if any.
+func (w *didlWriter) object(obj *object, resURL, subURL string) {
+	tag := "item"
+	if obj.isContainer() {
+		tag = "container"
+	}
+	w.b.WriteString("<" + tag + ` id="`)
+	w.escape(obj.id)
+	w.b.WriteString(`" parentID="`)
+	w.escape(obj.parentID)
+	w.b.WriteString(`" restricted="1"`)
+	if obj.isContainer() {
+		w.b.WriteString(` childCount="` + strconv.Itoa(obj.children) + `" searchable="0"`)
+	}
+	w.b.WriteString("><dc:title>")
+	w.escape(obj.title)
+	w.b.WriteString("</dc:title><upnp:class>" + obj.class + "</upnp:class>")
+	if !obj.modTime.IsZero() {
+		w.b.WriteString("<dc:date>" + obj.modTime.Format("2006-01-02T15:04:05") + "</dc:date>")
+	}
+	if !obj.isContainer() {
+		subType := upnptype.SubtitleMimeType(subURL)
+		w.b.WriteString(`<res protocolInfo="` + upnptype.ProtocolInfo(obj.mime) + `"`)
+		if obj.size > 0 {
+			w.b.WriteString(` size="` + strconv.FormatInt(obj.size, 10) + `"`)
+		}
+		if subURL != "" {
+			w.b.WriteString(` pv:subtitleFileUri="`)
+			w.escape(subURL)
+			w.b.WriteString(`" pv:subtitleFileType="` + upnptype.SubtitleKind(subType) + `"`)
+		}
+		w.b.WriteString(">")
+		w.escape(resURL)
+		w.b.WriteString("</res>")
+		if subURL != "" {
+			w.b.WriteString(`<res protocolInfo="http-get:*:` + subType + `:*">`)
+			w.escape(subURL)
+			w.b.WriteString("</res>")
+			for _, tag := range []string{"sec:CaptionInfoEx", "sec:CaptionInfo"} {
+				w.b.WriteString("<" + tag + ` sec:type="` + upnptype.SubtitleKind(subType) + `">`)
+				w.escape(subURL)
+				w.b.WriteString("</" + tag + ">")
+			}
+		}
+	}
+	w.b.WriteString("</" + tag + ">")
+}
+
+// escapePath escapes each segment of a slash separated path for use in URLs.
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, seg := range segments {
+		segments[i] = url.PathEscape(seg)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,83 @@
+package mediaserver
+
+import (
+	"github.com/sqp/gupnp/upnpdevice"
+	"github.com/sqp/gupnp/upnptype"
+)
+
+// Services descriptions, limited to the actions implemented.
+
+var (
+	action   = upnpdevice.Action
+	in       = upnpdevice.ArgIn
+	out      = upnpdevice.ArgOut
+	variable = upnpdevice.Variable
+)
+
+func scpdContentDirectory() *upnptype.SCPD {
+	return &upnptype.SCPD{
+		Actions: []upnptype.ActionInfo{
+			action("GetSearchCapabilities", out("SearchCaps", "SearchCapabilities")),
+			action("GetSortCapabilities", out("SortCaps", "SortCapabilities")),
+			action("GetSystemUpdateID", out("Id", "SystemUpdateID")),
+			action("Browse",
+				in("ObjectID", "A_ARG_TYPE_ObjectID"),
+				in("BrowseFlag", "A_ARG_TYPE_BrowseFlag"),
+				in("Filter", "A_ARG_TYPE_Filter"),
+				in("StartingIndex", "A_ARG_TYPE_Index"),
+				in("RequestedCount", "A_ARG_TYPE_Count"),
+				in("SortCriteria", "A_ARG_TYPE_SortCriteria"),
+				out("Result", "A_ARG_TYPE_Result"),
+				out("NumberReturned", "A_ARG_TYPE_Count"),
+				out("TotalMatches", "A_ARG_TYPE_Count"),
+				out("UpdateID", "A_ARG_TYPE_UpdateID")),
+		},
+		StateVariables: []upnptype.StateVariable{
+			variable("SearchCapabilities", "string", false),
+			variable("SortCapabilities", "string", false),
+			variable("SystemUpdateID", "ui4", true),
+			variable("ContainerUpdateIDs", "string", true),
+			variable("A_ARG_TYPE_ObjectID", "string", false),
+			variable("A_ARG_TYPE_BrowseFlag", "string", false, "BrowseMetadata", "BrowseDirectChildren"),
+			variable("A_ARG_TYPE_Filter", "string", false),
+			variable("A_ARG_TYPE_SortCriteria", "string", false),
+			variable("A_ARG_TYPE_Index", "ui4", false),
+			variable("A_ARG_TYPE_Count", "ui4", false),
+			variable("A_ARG_TYPE_UpdateID", "ui4", false),
+			variable("A_ARG_TYPE_Result", "string", false),
+		},
+	}
+}
+
+func scpdConnectionManager() *upnptype.SCPD {
+	return &upnptype.SCPD{
+		Actions: []upnptype.ActionInfo{
+			action("GetProtocolInfo",
+				out("Source", "SourceProtocolInfo"),
+				out("Sink", "SinkProtocolInfo")),
+			action("GetCurrentConnectionIDs",
+				out("ConnectionIDs", "CurrentConnectionIDs")),
+			action("GetCurrentConnectionInfo", in("ConnectionID", "A_ARG_TYPE_ConnectionID"),
+				out("RcsID", "A_ARG_TYPE_RcsID"),
+				out("AVTransportID", "A_ARG_TYPE_AVTransportID"),
+				out("ProtocolInfo", "A_ARG_TYPE_ProtocolInfo"),
+				out("PeerConnectionManager", "A_ARG_TYPE_ConnectionManager"),
+				out("PeerConnectionID", "A_ARG_TYPE_ConnectionID"),
+				out("Direction", "A_ARG_TYPE_Direction"),
+				out("Status", "A_ARG_TYPE_ConnectionStatus")),
+		},
+		StateVariables: []upnptype.StateVariable{
+			variable("SourceProtocolInfo", "string", true),
+			variable("SinkProtocolInfo", "string", true),
+			variable("CurrentConnectionIDs", "string", true),
+			variable("A_ARG_TYPE_ConnectionStatus", "string", false,
+				"OK", "ContentFormatMismatch", "InsufficientBandwidth", "UnreliableChannel", "Unknown"),
+			variable("A_ARG_TYPE_ConnectionManager", "string", false),
+			variable("A_ARG_TYPE_Direction", "string", false, "Input", "Output"),
+			variable("A_ARG_TYPE_ProtocolInfo", "string", false),
+			variable("A_ARG_TYPE_ConnectionID", "i4", false),
+			variable("A_ARG_TYPE_AVTransportID", "i4", false),
+			variable("A_ARG_TYPE_RcsID", "i4", false),
+		},
+	}
+}
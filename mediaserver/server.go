@@ -0,0 +1,480 @@
+// Package mediaserver provides a MediaServer hosted in Go, sharing a local
+// directory tree.
+//
+// Directories are published as containers and media files (audio, video and
+// images) as items, with resources served over HTTP with range requests.
+// The tree is scanned regularly, and changes are evented with SystemUpdateID
+// and ContainerUpdateIDs.
+//
+//   srv, e := mediaserver.New("My files", "/home/me/Music")
+//   e = srv.Start(upnpdevice.Options{Interface: "eth0"})
+//   defer srv.Stop()
+//
+package mediaserver
+
+import (
+	"github.com/sqp/gupnp/upnpdevice"
+	"github.com/sqp/gupnp/upnptype"
+
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeviceType is the UPnP type of the media server.
+//
+const DeviceType = "urn:schemas-upnp-org:device:MediaServer:1"
+
+// DefaultScanInterval is the default delay between checks of the shared tree.
+//
+const DefaultScanInterval = 5 * time.Second
+
+// UPnP ContentDirectory errors codes.
+const (
+	errNoSuchObject    = 701
+	errCannotProcess   = 720
+	errUnsupportedSort = 709
+)
+
+// Server defines a media server sharing a directory.
+//
+type Server struct {
+	ScanInterval time.Duration // Delay between checks of the shared tree. Negative to disable.
+
+	root string
+	dev  *upnpdevice.Device
+	cds  *upnpdevice.Service
+	cm   *upnpdevice.Service
+
+	mu                 sync.Mutex
+	systemUpdateID     uint32
+	containerUpdateIDs map[string]uint32 // Indexed by object ID.
+	signatures         map[string]string // Last content signature of directories.
+	quit               chan struct{}
+	wg                 sync.WaitGroup
+}
+
+// New creates a media server sharing the given directory.
+//
+func New(name, root string) (*Server, error) {
+	root, e := filepath.Abs(root)
+	if e != nil {
+		return nil, e
+	}
+	info, e := os.Stat(root)
+	if e != nil {
+		return nil, e
+	}
+	if !info.IsDir() {
+		return nil, errors.New("not a directory: " + root)
+	}
+
+	srv := &Server{
+		ScanInterval:       DefaultScanInterval,
+		root:               root,
+		containerUpdateIDs: make(map[string]uint32),
+	}
+
+	srv.dev = upnpdevice.NewDevice(upnptype.DeviceInfo{
+		DeviceType:       DeviceType,
+		FriendlyName:     name,
+		Manufacturer:     "gupnp-go",
+		ModelDescription: "Directory media server",
+		ModelName:        "mediaserver",
+		ModelNumber:      "1",
+	})
+
+	srv.cds = upnpdevice.NewService(upnptype.ServiceTypeContentDirectory, "urn:upnp-org:serviceId:ContentDirectory", scpdContentDirectory())
+	srv.cds.Handle("GetSearchCapabilities", srv.getSearchCapabilities)
+	srv.cds.Handle("GetSortCapabilities", srv.getSortCapabilities)
+	srv.cds.Handle("GetSystemUpdateID", srv.getSystemUpdateID)
+	srv.cds.Handle("Browse", srv.browse)
+	srv.cds.SetVariables(map[string]string{
+		"SystemUpdateID":     "0",
+		"ContainerUpdateIDs": "",
+	})
+
+	srv.cm = upnpdevice.NewService(upnptype.ServiceTypeConnectionManager, "urn:upnp-org:serviceId:ConnectionManager", scpdConnectionManager())
+	srv.cm.Handle("GetProtocolInfo", srv.getProtocolInfo)
+	srv.cm.Handle("GetCurrentConnectionIDs", srv.getCurrentConnectionIDs)
+	srv.cm.Handle("GetCurrentConnectionInfo", srv.getCurrentConnectionInfo)
+	srv.cm.SetVariables(map[string]string{
+		"SourceProtocolInfo":   sourceProtocolInfo(),
+		"SinkProtocolInfo":     "",
+		"CurrentConnectionIDs": "0",
+	})
+
+	srv.dev.AddService(srv.cds)
+	srv.dev.AddService(srv.cm)
+	srv.dev.Handle("/content/", http.StripPrefix("/content/", http.HandlerFunc(srv.serveContent)))
+	return srv, nil
+}
+
+// Start serves and advertises the server, and watches the shared tree.
+//
+func (srv *Server) Start(opts upnpdevice.Options) error {
+	srv.Rescan() // Reference signatures, without events.
+	if e := srv.dev.Start(opts); e != nil {
+		return e
+	}
+	if srv.ScanInterval > 0 {
+		srv.quit = make(chan struct{})
+		srv.wg.Add(1)
+		go srv.watch(srv.ScanInterval, srv.quit)
+	}
+	return nil
+}
+
+// Stop stops watching the tree and the device.
+//
+func (srv *Server) Stop() error {
+	if srv.quit != nil {
+		close(srv.quit)
+		srv.wg.Wait()
+		srv.quit = nil
+	}
+	return srv.dev.Stop()
+}
+
+// Device returns the hosted device.
+//
+func (srv *Server) Device() *upnpdevice.Device { return srv.dev }
+
+// UDN returns the unique device name.
+//
+func (srv *Server) UDN() string { return srv.dev.UDN() }
+
+// Location returns the URL of the device description. Empty if not started.
+//
+func (srv *Server) Location() string { return srv.dev.Location() }
+
+// Root returns the shared directory.
+//
+func (srv *Server) Root() string { return srv.root }
+
+// SystemUpdateID returns the current system update ID.
+//
+func (srv *Server) SystemUpdateID() uint32 {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.systemUpdateID
+}
+
+//
+//------------------------------------------------------------------[ WATCH ]--
+
+func (srv *Server) watch(interval time.Duration, quit chan struct{}) {
+	defer srv.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			srv.Rescan()
+		case <-quit:
+			return
+		}
+	}
+}
+
+// Rescan checks the shared tree for changes, and sends the update IDs of
+// modified containers.
+//
+func (srv *Server) Rescan() {
+	signatures := make(map[string]string)
+	filepath.Walk(srv.root, func(file string, info os.FileInfo, e error) error {
+		if e != nil || !info.IsDir() {
+			return nil
+		}
+		id := srv.objectID(file)
+		if id != "0" && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		signatures[id] = signature(file, id)
+		return nil
+	})
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	first := srv.signatures == nil
+	old := srv.signatures
+	srv.signatures = signatures
+	if first {
+		return
+	}
+
+	var changed []string
+	for id, sig := range signatures {
+		if old[id] != sig {
+			changed = append(changed, id)
+		}
+	}
+	for id := range old {
+		if _, ok := signatures[id]; !ok { // Removed, its parent changed too.
+			delete(srv.containerUpdateIDs, id)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	sort.Strings(changed)
+	var ids []string
+	for _, id := range changed {
+		srv.systemUpdateID++
+		srv.containerUpdateIDs[id] = srv.systemUpdateID
+		ids = append(ids, id, strconv.FormatUint(uint64(srv.systemUpdateID), 10))
+	}
+	srv.cds.SetVariables(map[string]string{
+		"SystemUpdateID":     strconv.FormatUint(uint64(srv.systemUpdateID), 10),
+		"ContainerUpdateIDs": strings.Join(ids, ","),
+	})
+}
+
+// signature returns a summary of the shared content of a directory.
+func signature(dir, id string) string {
+	list, _ := listDir(dir, id)
+	var b strings.Builder
+	for _, obj := range list {
+		b.WriteString(obj.id + "|" + strconv.FormatInt(obj.size, 10) + "|" + strconv.FormatInt(obj.modTime.UnixNano(), 10) + "|" + obj.subtitle + "\n")
+	}
+	return b.String()
+}
+
+//
+//--------------------------------------------------------[ CONTENTDIRECTORY ]--
+
+func (srv *Server) getSearchCapabilities(args map[string]string) (map[string]string, error) {
+	return map[string]string{"SearchCaps": ""}, nil
+}
+
+func (srv *Server) getSortCapabilities(args map[string]string) (map[string]string, error) {
+	return map[string]string{"SortCaps": "dc:title"}, nil
+}
+
+func (srv *Server) getSystemUpdateID(args map[string]string) (map[string]string, error) {
+	return map[string]string{"Id": strconv.FormatUint(uint64(srv.SystemUpdateID()), 10)}, nil
+}
+
+func (srv *Server) browse(args map[string]string) (map[string]string, error) {
+	id := args["ObjectID"]
+	obj := srv.object(id)
+	if obj == nil {
+		return nil, &upnpdevice.Error{Code: errNoSuchObject, Description: "No such object"}
+	}
+	start, _ := strconv.Atoi(args["StartingIndex"])
+	count, _ := strconv.Atoi(args["RequestedCount"])
+
+	var list []*object
+	switch args["BrowseFlag"] {
+	case upnptype.BrowseFlagBrowseMetadata:
+		list = []*object{obj}
+
+	case upnptype.BrowseFlagBrowseDirectChildren:
+		if !obj.isContainer() {
+			return nil, &upnpdevice.Error{Code: errNoSuchObject, Description: "No such container"}
+		}
+		var e error
+		list, e = srv.children(id)
+		if e != nil {
+			return nil, &upnpdevice.Error{Code: errCannotProcess, Description: "Cannot process the request"}
+		}
+		switch strings.TrimSpace(args["SortCriteria"]) {
+		case "", "+dc:title":
+		case "-dc:title":
+			sortObjects(list, true)
+		default:
+			return nil, &upnpdevice.Error{Code: errUnsupportedSort, Description: "Unsupported or invalid sort criteria"}
+		}
+
+	default:
+		return nil, &upnpdevice.Error{Code: upnpdevice.ErrInvalidArgs, Description: "Invalid Args"}
+	}
+
+	total := len(list)
+	if start > total {
+		start = total
+	}
+	list = list[start:]
+	if count > 0 && count < len(list) {
+		list = list[:count]
+	}
+
+	didl := newDIDL()
+	for _, child := range list {
+		didl.object(child, srv.resURL(child), srv.subtitleURL(child))
+	}
+
+	srv.mu.Lock()
+	updateID := srv.containerUpdateIDs[id]
+	srv.mu.Unlock()
+
+	return map[string]string{
+		"Result":         didl.String(),
+		"NumberReturned": strconv.Itoa(len(list)),
+		"TotalMatches":   strconv.Itoa(total),
+		"UpdateID":       strconv.FormatUint(uint64(updateID), 10),
+	}, nil
+}
+
+// object returns the object with the given ID, or nil if not found.
+func (srv *Server) object(id string) *object {
+	rel, ok := objectPath(id)
+	if !ok {
+		return nil
+	}
+	file := filepath.Join(srv.root, filepath.FromSlash(rel))
+	info, e := os.Stat(file)
+	if e != nil {
+		return nil
+	}
+	obj := newObject(id, info)
+	if obj == nil {
+		return nil
+	}
+	if id == "0" {
+		obj.title = srv.dev.Info().FriendlyName
+	}
+	if obj.isContainer() {
+		list, _ := listDir(file, id)
+		obj.children = len(list)
+	}
+	if obj.class == upnptype.ClassVideo {
+		if subs := upnptype.FindSubtitles(file); len(subs) > 0 {
+			obj.subtitle = srv.objectID(subs[0])
+		}
+	}
+	return obj
+}
+
+// children returns the shared entries of a container.
+func (srv *Server) children(id string) ([]*object, error) {
+	rel, _ := objectPath(id)
+	dir := filepath.Join(srv.root, filepath.FromSlash(rel))
+	list, e := listDir(dir, id)
+	if e != nil {
+		return nil, e
+	}
+	for _, obj := range list {
+		if obj.isContainer() {
+			sub, _ := listDir(filepath.Join(dir, filepath.Base(obj.id)), obj.id)
+			obj.children = len(sub)
+		}
+	}
+	return list, nil
+}
+
+// objectID returns the object ID of a file in the shared tree.
+func (srv *Server) objectID(file string) string {
+	rel, e := filepath.Rel(srv.root, file)
+	if e != nil || rel == "." {
+		return "0"
+	}
+	return filepath.ToSlash(rel)
+}
+
+// resURL returns the URL of an item resource.
+func (srv *Server) resURL(obj *object) string {
+	if obj.isContainer() {
+		return ""
+	}
+	return srv.dev.BaseURL() + "content/" + escapePath(obj.id)
+}
+
+// subtitleURL returns the URL of an item subtitles, if any.
+func (srv *Server) subtitleURL(obj *object) string {
+	if obj.subtitle == "" {
+		return ""
+	}
+	return srv.dev.BaseURL() + "content/" + escapePath(obj.subtitle)
+}
+
+//
+//--------------------------------------------------------------[ RESOURCES ]--
+
+// serveContent sends a shared file, with range requests support.
+// The subtitles URL of a video is sent in the CaptionInfo.sec header.
+func (srv *Server) serveContent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rel, ok := objectPath(r.URL.Path)
+	if !ok || rel == "." {
+		http.NotFound(w, r)
+		return
+	}
+	file, e := os.Open(filepath.Join(srv.root, filepath.FromSlash(rel)))
+	if e != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer file.Close()
+	info, e := file.Stat()
+	if e != nil {
+		http.NotFound(w, r)
+		return
+	}
+	obj := newObject(rel, info)
+	if subType := upnptype.SubtitleMimeType(rel); obj == nil && subType != "" && info.Mode().IsRegular() && !strings.HasPrefix(info.Name(), ".") {
+		obj = &object{id: rel, mime: subType}
+	}
+	if obj == nil || obj.isContainer() {
+		http.NotFound(w, r)
+		return
+	}
+	if obj.class == upnptype.ClassVideo {
+		if subs := upnptype.FindSubtitles(file.Name()); len(subs) > 0 {
+			w.Header().Set("CaptionInfo.sec", srv.dev.BaseURL()+"content/"+escapePath(srv.objectID(subs[0])))
+		}
+	}
+
+	w.Header().Set("Content-Type", obj.mime)
+	w.Header().Set("Server", upnpdevice.Server)
+	w.Header().Set("transferMode.dlna.org", "Streaming")
+	if r.Header.Get("getcontentFeatures.dlna.org") == "1" {
+		w.Header().Set("contentFeatures.dlna.org", upnptype.ContentFeatures())
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
+
+//
+//-------------------------------------------------------[ CONNECTIONMANAGER ]--
+
+// sourceProtocolInfo lists the formats served.
+func sourceProtocolInfo() string {
+	var list []string
+	for _, typ := range upnptype.MimeTypes() {
+		list = append(list, "http-get:*:"+typ+":*")
+	}
+	return strings.Join(list, ",")
+}
+
+func (srv *Server) getProtocolInfo(args map[string]string) (map[string]string, error) {
+	return map[string]string{"Source": sourceProtocolInfo(), "Sink": ""}, nil
+}
+
+func (srv *Server) getCurrentConnectionIDs(args map[string]string) (map[string]string, error) {
+	return map[string]string{"ConnectionIDs": "0"}, nil
+}
+
+func (srv *Server) getCurrentConnectionInfo(args map[string]string) (map[string]string, error) {
+	if args["ConnectionID"] != "0" {
+		return nil, &upnpdevice.Error{Code: 706, Description: "Invalid connection reference"}
+	}
+	return map[string]string{
+		"RcsID":                 "-1",
+		"AVTransportID":         "-1",
+		"ProtocolInfo":          "",
+		"PeerConnectionManager": "",
+		"PeerConnectionID":      "-1",
+		"Direction":             "Output",
+		"Status":                "OK",
+	}, nil
+}
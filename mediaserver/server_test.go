@@ -0,0 +1,201 @@
+package mediaserver
+
+import (
+	"github.com/sqp/gupnp/upnpdevice"
+	"github.com/sqp/gupnp/upnptype"
+
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// startServer shares a temporary tree:
+//
+//	Music/b.mp3, Music/a.flac, video.mkv, notes.txt, .hidden.mp3
+func startServer(t *testing.T) (*Server, *upnptype.ServiceInfo) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "Music"), 0755)
+	for name, content := range map[string]string{
+		"Music/b.mp3":  "0123456789",
+		"Music/a.flac": "flac",
+		"video.mkv":    "mkv",
+		"notes.txt":    "not shared",
+		".hidden.mp3":  "not shared",
+	} {
+		if e := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	srv, e := New("Test server", root)
+	if e != nil {
+		t.Fatal("new:", e)
+	}
+	srv.ScanInterval = -1
+	if e := srv.Start(upnpdevice.Options{DisableSSDP: true}); e != nil {
+		t.Fatal("start:", e)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	info := srv.Device().Info()
+	return srv, info.Service(upnptype.ServiceTypeContentDirectory)
+}
+
+type didlResult struct {
+	Containers []upnptype.Container `xml:"container"`
+	Items      []upnptype.Item      `xml:"item"`
+}
+
+func browse(t *testing.T, cds *upnptype.ServiceInfo, id, flag string, start, count int) (*didlResult, map[string]string, error) {
+	out, e := upnpdevice.SendAction(cds.ControlURL, cds.ServiceType, "Browse",
+		"ObjectID", id,
+		"BrowseFlag", flag,
+		"Filter", "*",
+		"StartingIndex", strconv.Itoa(start),
+		"RequestedCount", strconv.Itoa(count),
+		"SortCriteria", "")
+	if e != nil {
+		return nil, nil, e
+	}
+	var result didlResult
+	if e := xml.Unmarshal([]byte(out["Result"]), &result); e != nil {
+		t.Fatal("parse didl:", e, out["Result"])
+	}
+	return &result, out, nil
+}
+
+func TestBrowse(t *testing.T) {
+	_, cds := startServer(t)
+
+	result, out, e := browse(t, cds, "0", upnptype.BrowseFlagBrowseDirectChildren, 0, 0)
+	if e != nil {
+		t.Fatal("browse root:", e)
+	}
+	if out["TotalMatches"] != "2" || len(result.Containers) != 1 || len(result.Items) != 1 {
+		t.Fatalf("bad root: %v", out)
+	}
+	if c := result.Containers[0]; c.ID != "Music" || c.ParentID != "0" || c.ChildCount != 2 || c.Class != upnptype.ClassFolder {
+		t.Errorf("bad container: %+v", c)
+	}
+	if item := result.Items[0]; item.Title != "video" || item.Class != upnptype.ClassVideo || len(item.Res) != 1 || item.Res[0].Size != 3 {
+		t.Errorf("bad item: %+v", item)
+	}
+
+	result, out, _ = browse(t, cds, "Music", upnptype.BrowseFlagBrowseDirectChildren, 1, 1)
+	if out["TotalMatches"] != "2" || out["NumberReturned"] != "1" || len(result.Items) != 1 || result.Items[0].Title != "b" {
+		t.Errorf("bad page: %v", out)
+	}
+
+	result, _, e = browse(t, cds, "Music/a.flac", upnptype.BrowseFlagBrowseMetadata, 0, 0)
+	if e != nil || len(result.Items) != 1 || result.Items[0].ParentID != "Music" || result.Items[0].Class != upnptype.ClassMusic {
+		t.Errorf("bad metadata: %v %+v", e, result)
+	}
+
+	result, _, _ = browse(t, cds, "0", upnptype.BrowseFlagBrowseMetadata, 0, 0)
+	if len(result.Containers) != 1 || result.Containers[0].Title != "Test server" {
+		t.Errorf("bad root metadata: %+v", result)
+	}
+
+	for _, id := range []string{"notes.txt", ".hidden.mp3", "../etc", "Music/../video.mkv", "missing"} {
+		_, _, e := browse(t, cds, id, upnptype.BrowseFlagBrowseMetadata, 0, 0)
+		if upnpErr, ok := e.(*upnpdevice.Error); !ok || upnpErr.Code != errNoSuchObject {
+			t.Errorf("object %q: want error 701, got %v", id, e)
+		}
+	}
+}
+
+func TestServeRange(t *testing.T) {
+	_, cds := startServer(t)
+	result, _, _ := browse(t, cds, "Music/b.mp3", upnptype.BrowseFlagBrowseMetadata, 0, 0)
+	url := result.Items[0].Res[0].URL
+
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Range", "bytes=2-5")
+	resp, e := http.DefaultClient.Do(req)
+	if e != nil {
+		t.Fatal(e)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(data) != "2345" || resp.Header.Get("Content-Type") != "audio/mpeg" {
+		t.Errorf("bad range response: %s %q %s", resp.Status, data, resp.Header.Get("Content-Type"))
+	}
+
+	resp, e = http.Get(url[:len(url)-len("Music/b.mp3")] + "notes.txt")
+	if e != nil {
+		t.Fatal(e)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("not shared file served: %s", resp.Status)
+	}
+}
+
+func TestUpdateIDs(t *testing.T) {
+	srv, cds := startServer(t)
+
+	srv.Rescan()
+	if id := srv.SystemUpdateID(); id != 0 {
+		t.Fatalf("update without change: %d", id)
+	}
+
+	ioutil.WriteFile(filepath.Join(srv.Root(), "Music", "c.ogg"), []byte("ogg"), 0644)
+	srv.Rescan()
+	if id := srv.SystemUpdateID(); id == 0 {
+		t.Fatal("no update after change")
+	}
+	if ids := upnptype.ParseContainerUpdateIDs(srv.cds.Variable("ContainerUpdateIDs")); ids["Music"] == 0 {
+		t.Errorf("Music container not updated: %v", ids)
+	}
+
+	_, out, _ := browse(t, cds, "Music", upnptype.BrowseFlagBrowseDirectChildren, 0, 0)
+	if out["TotalMatches"] != "3" || out["UpdateID"] == "0" {
+		t.Errorf("bad browse after change: %v", out)
+	}
+}
+
+func TestServeSubtitles(t *testing.T) {
+	srv, cds := startServer(t)
+	ioutil.WriteFile(filepath.Join(srv.Root(), "video.en.srt"), []byte("subtitles"), 0644)
+
+	result, out, e := browse(t, cds, "video.mkv", upnptype.BrowseFlagBrowseMetadata, 0, 0)
+	if e != nil {
+		t.Fatal("browse:", e)
+	}
+	if len(result.Items) != 1 || len(result.Items[0].Res) != 2 {
+		t.Fatalf("bad item: %+v", result)
+	}
+	subURL := result.Items[0].Res[1].URL
+	if !strings.HasSuffix(subURL, "/video.en.srt") || !strings.Contains(out["Result"], "<sec:CaptionInfoEx") {
+		t.Errorf("bad subtitles metadata: %s", out["Result"])
+	}
+
+	resp, e := http.Get(result.Items[0].Res[0].URL)
+	if e != nil {
+		t.Fatal(e)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("CaptionInfo.sec") != subURL {
+		t.Errorf("bad CaptionInfo.sec header: %q", resp.Header.Get("CaptionInfo.sec"))
+	}
+
+	resp, e = http.Get(subURL)
+	if e != nil {
+		t.Fatal(e)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(data) != "subtitles" || resp.Header.Get("Content-Type") != "text/srt" {
+		t.Errorf("bad subtitles response: %s %q", resp.Header.Get("Content-Type"), data)
+	}
+
+	_, out, _ = browse(t, cds, "0", upnptype.BrowseFlagBrowseDirectChildren, 0, 0)
+	if out["TotalMatches"] != "2" {
+		t.Errorf("subtitles listed as items: %v", out)
+	}
+}
@@ -0,0 +1,785 @@
+// Package mocktype provides fake UPnP devices and control point to use the
+// media control without a network.
+//
+// Devices keep a scriptable state, emit their events like real devices, and
+// can simulate latency and errors on every action.
+//
+//   cp := mocktype.NewControlPoint()
+//   media.SetControlPoint(cp)
+//
+//   tv := mocktype.NewRenderer("uuid:tv", "TV")
+//   tv.FailOn("Seek", errors.New("701 transition not available"))
+//   cp.AddRenderer(tv)
+//
+package mocktype
+
+import (
+	"github.com/sqp/gupnp/upnptype"
+
+	"encoding/xml"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Interfaces implemented.
+var (
+	_ upnptype.ControlPoint = (*ControlPoint)(nil)
+	_ upnptype.Renderer     = (*Renderer)(nil)
+	_ upnptype.Server       = (*Server)(nil)
+)
+
+//
+//-----------------------------------------------------------[ CONTROL POINT ]--
+
+// ControlPoint defines a fake control point, forwarding devices added
+// manually to the control point events.
+//
+type ControlPoint struct {
+	events    upnptype.ControlPointEvents
+	renderers map[string]*Renderer
+	servers   map[string]*Server
+	static    map[string]bool
+}
+
+// NewControlPoint creates a fake control point without devices.
+//
+func NewControlPoint() *ControlPoint {
+	return &ControlPoint{
+		renderers: make(map[string]*Renderer),
+		servers:   make(map[string]*Server),
+		static:    make(map[string]bool),
+	}
+}
+
+// SetEvents sets the discovery callbacks.
+//
+func (cp *ControlPoint) SetEvents(events upnptype.ControlPointEvents) { cp.events = events }
+
+// Rescan forwards found events for all known devices.
+//
+func (cp *ControlPoint) Rescan() {
+	for _, r := range cp.renderers {
+		cp.foundRenderer(r)
+	}
+	for _, s := range cp.servers {
+		cp.foundServer(s)
+	}
+}
+
+// AddDevice registers a location. No device is created, use AddRenderer or
+// AddServer to forward devices.
+//
+func (cp *ControlPoint) AddDevice(location string) error {
+	if location == "" {
+		return errors.New("invalid device location")
+	}
+	cp.static[location] = true
+	return nil
+}
+
+// RemoveDevice unregisters a location.
+//
+func (cp *ControlPoint) RemoveDevice(location string) { delete(cp.static, location) }
+
+// StaticDevices returns the registered locations.
+//
+func (cp *ControlPoint) StaticDevices() []string {
+	var list []string
+	for location := range cp.static {
+		list = append(list, location)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// AddRenderer adds the renderer and forwards the found event.
+//
+func (cp *ControlPoint) AddRenderer(r *Renderer) {
+	cp.renderers[r.UDN()] = r
+	cp.foundRenderer(r)
+}
+
+// RemoveRenderer removes the renderer and forwards the lost event.
+//
+func (cp *ControlPoint) RemoveRenderer(r *Renderer) {
+	delete(cp.renderers, r.UDN())
+	if cp.events.OnRendererLost != nil {
+		cp.events.OnRendererLost(r)
+	}
+}
+
+// AddServer adds the server and forwards the found event.
+//
+func (cp *ControlPoint) AddServer(s *Server) {
+	cp.servers[s.UDN()] = s
+	cp.foundServer(s)
+}
+
+// RemoveServer removes the server and forwards the lost event.
+//
+func (cp *ControlPoint) RemoveServer(s *Server) {
+	delete(cp.servers, s.UDN())
+	if cp.events.OnServerLost != nil {
+		cp.events.OnServerLost(s)
+	}
+}
+
+func (cp *ControlPoint) foundRenderer(r *Renderer) {
+	if cp.events.OnRendererFound != nil {
+		cp.events.OnRendererFound(r)
+	}
+}
+
+func (cp *ControlPoint) foundServer(s *Server) {
+	if cp.events.OnServerFound != nil {
+		cp.events.OnServerFound(s)
+	}
+}
+
+//
+//-------------------------------------------------------------------[ CALLS ]--
+
+// calls provides latency, error injection and recording of device actions.
+type calls struct {
+	mu      sync.Mutex
+	latency time.Duration
+	fail    map[string]error
+	list    []string
+}
+
+// SetLatency sets the delay applied to every action.
+//
+func (c *calls) SetLatency(latency time.Duration) {
+	c.mu.Lock()
+	c.latency = latency
+	c.mu.Unlock()
+}
+
+// FailOn makes the action return the error. A nil error removes the failure.
+//
+func (c *calls) FailOn(action string, e error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fail == nil {
+		c.fail = make(map[string]error)
+	}
+	if e == nil {
+		delete(c.fail, action)
+	} else {
+		c.fail[action] = e
+	}
+}
+
+// Calls returns the names of the actions called, in order.
+//
+func (c *calls) Calls() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.list...)
+}
+
+// ResetCalls clears the list of actions called.
+//
+func (c *calls) ResetCalls() {
+	c.mu.Lock()
+	c.list = nil
+	c.mu.Unlock()
+}
+
+// call records the action, waits for the latency and returns the injected error.
+func (c *calls) call(action string) error {
+	c.mu.Lock()
+	c.list = append(c.list, action)
+	latency, e := c.latency, c.fail[action]
+	c.mu.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	return e
+}
+
+//
+//----------------------------------------------------------------[ RENDERER ]--
+
+// RendererState defines the scriptable state of a fake renderer.
+//
+type RendererState struct {
+	Transport upnptype.PlaybackState
+	Volume    uint16
+	Mute      bool
+
+	URI          string
+	Metadata     string
+	NextURI      string
+	NextMetadata string
+
+	Position int // Current position in seconds.
+	Duration int // Track duration in seconds.
+}
+
+// Renderer defines a fake media renderer.
+//
+type Renderer struct {
+	upnptype.RendererBase
+	calls
+
+	mu    sync.Mutex
+	state RendererState
+}
+
+// NewRenderer creates a fake renderer with the AVTransport, RenderingControl
+// and ConnectionManager services. Services have no description, so all
+// actions are considered supported until one is set with SetSCPD.
+//
+func NewRenderer(udn, name string) *Renderer {
+	r := &Renderer{state: RendererState{
+		Transport: upnptype.PlaybackStateStopped,
+		Volume:    50,
+	}}
+	r.SetUDN(udn)
+	r.SetName(name)
+	r.SetDeviceInfo(&upnptype.DeviceInfo{
+		DeviceType:   "urn:schemas-upnp-org:device:MediaRenderer:1",
+		FriendlyName: name,
+		Manufacturer: "mocktype",
+		ModelName:    "Fake renderer",
+		UDN:          udn,
+		Services: []upnptype.ServiceInfo{
+			{ServiceType: upnptype.ServiceTypeAVTransport},
+			{ServiceType: upnptype.ServiceTypeRenderingControl},
+			{ServiceType: upnptype.ServiceTypeConnectionManager},
+		},
+	})
+	return r
+}
+
+// State returns a copy of the renderer state.
+//
+func (r *Renderer) State() RendererState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+// SetState changes the renderer state and emits events for changed values,
+// as a device changed by another controller.
+//
+func (r *Renderer) SetState(state RendererState) {
+	r.mu.Lock()
+	old := r.state
+	r.state = state
+	r.mu.Unlock()
+
+	if old.Transport != state.Transport {
+		r.emitTransportState(state.Transport)
+	}
+	if old.Volume != state.Volume {
+		r.emitVolume(state.Volume)
+	}
+	if old.Mute != state.Mute {
+		r.emitMute(state.Mute)
+	}
+	if old.Duration != state.Duration && r.Events().OnCurrentTrackDuration != nil {
+		r.Events().OnCurrentTrackDuration(r, state.Duration)
+	}
+	if old.Position != state.Position {
+		r.DisplayCurrentTime()
+	}
+}
+
+func (r *Renderer) update(call func(*RendererState)) RendererState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	call(&r.state)
+	return r.state
+}
+
+func (r *Renderer) emitTransportState(state upnptype.PlaybackState) {
+	if r.Events().OnTransportState != nil {
+		r.Events().OnTransportState(r, state)
+	}
+}
+
+func (r *Renderer) emitVolume(volume uint16) {
+	if r.Events().OnVolume != nil {
+		r.Events().OnVolume(r, uint(volume))
+	}
+}
+
+func (r *Renderer) emitMute(mute bool) {
+	if r.Events().OnMute != nil {
+		r.Events().OnMute(r, mute)
+	}
+}
+
+//-------------------------------------------------------[ RENDERING CONTROL ]--
+
+// GetMute returns the mute state.
+//
+func (r *Renderer) GetMute(instanceID uint32, channel string) (bool, error) {
+	if e := r.call("GetMute"); e != nil {
+		return false, e
+	}
+	return r.State().Mute, nil
+}
+
+// SetMute sets the mute state.
+//
+func (r *Renderer) SetMute(instanceID uint32, channel string, desiredMute bool) error {
+	if e := r.call("SetMute"); e != nil {
+		return e
+	}
+	r.update(func(st *RendererState) { st.Mute = desiredMute })
+	r.emitMute(desiredMute)
+	return nil
+}
+
+// GetVolume returns the volume.
+//
+func (r *Renderer) GetVolume(instanceID uint32, channel string) (uint16, error) {
+	if e := r.call("GetVolume"); e != nil {
+		return 0, e
+	}
+	return r.State().Volume, nil
+}
+
+// SetVolume sets the volume.
+//
+func (r *Renderer) SetVolume(instanceID uint32, channel string, volume uint16) error {
+	if e := r.call("SetVolume"); e != nil {
+		return e
+	}
+	r.update(func(st *RendererState) { st.Volume = volume })
+	r.emitVolume(volume)
+	return nil
+}
+
+// SetRelativeVolume changes the volume by the adjustment.
+//
+func (r *Renderer) SetRelativeVolume(instanceID uint32, channel string, adjustment int32) (uint16, error) {
+	if e := r.call("SetRelativeVolume"); e != nil {
+		return 0, e
+	}
+	st := r.update(func(st *RendererState) {
+		volume := int32(st.Volume) + adjustment
+		if volume < 0 {
+			volume = 0
+		}
+		st.Volume = uint16(volume)
+	})
+	r.emitVolume(st.Volume)
+	return st.Volume, nil
+}
+
+//-------------------------------------------------------------[ AVTRANSPORT ]--
+
+// SetAVTransportURI sets the playback URI and starts playing.
+//
+func (r *Renderer) SetAVTransportURI(instanceID uint32, currentURI, currentURIMetaData string) error {
+	if e := r.call("SetAVTransportURI"); e != nil {
+		return e
+	}
+	r.update(func(st *RendererState) {
+		st.URI = currentURI
+		st.Metadata = currentURIMetaData
+		st.Position = 0
+	})
+	return r.Play(instanceID, upnptype.PlaySpeedNormal)
+}
+
+// SetNextAVTransportURI sets the next playback URI.
+//
+func (r *Renderer) SetNextAVTransportURI(instanceID uint32, nextURI, nextURIMetaData string) error {
+	if e := r.call("SetNextAVTransportURI"); e != nil {
+		return e
+	}
+	r.update(func(st *RendererState) {
+		st.NextURI = nextURI
+		st.NextMetadata = nextURIMetaData
+	})
+	return nil
+}
+
+// AddURIToQueue isn't supported by the fake renderer.
+//
+func (r *Renderer) AddURIToQueue(instanceID uint32, req *upnptype.AddURIToQueueIn) (*upnptype.AddURIToQueueOut, error) {
+	if e := r.call("AddURIToQueue"); e != nil {
+		return nil, e
+	}
+	return nil, &upnptype.ErrNotSupported{Action: "AddURIToQueue"}
+}
+
+// AddMultipleURIsToQueue isn't supported by the fake renderer.
+//
+func (r *Renderer) AddMultipleURIsToQueue(instanceID uint32, req *upnptype.AddMultipleURIsToQueueIn) (*upnptype.AddMultipleURIsToQueueOut, error) {
+	if e := r.call("AddMultipleURIsToQueue"); e != nil {
+		return nil, e
+	}
+	return nil, &upnptype.ErrNotSupported{Action: "AddMultipleURIsToQueue"}
+}
+
+// GetMediaInfo returns the current media.
+//
+func (r *Renderer) GetMediaInfo(instanceID uint32) (*upnptype.MediaInfo, error) {
+	if e := r.call("GetMediaInfo"); e != nil {
+		return nil, e
+	}
+	st := r.State()
+	return &upnptype.MediaInfo{
+		NrTracks:           1,
+		MediaDuration:      upnptype.TimeToString(st.Duration),
+		CurrentURI:         st.URI,
+		CurrentURIMetaData: st.Metadata,
+		NextURI:            st.NextURI,
+		NextURIMetaData:    st.NextMetadata,
+	}, nil
+}
+
+// GetTransportInfo returns the transport state.
+//
+func (r *Renderer) GetTransportInfo(instanceID uint32) (*upnptype.TransportInfo, error) {
+	if e := r.call("GetTransportInfo"); e != nil {
+		return nil, e
+	}
+	return &upnptype.TransportInfo{
+		CurrentTransportState:  transportStateName(r.State().Transport),
+		CurrentTransportStatus: "OK",
+		CurrentSpeed:           upnptype.PlaySpeedNormal,
+	}, nil
+}
+
+// GetPositionInfo returns the current track position.
+//
+func (r *Renderer) GetPositionInfo(instanceID uint32) (*upnptype.PositionInfo, error) {
+	if e := r.call("GetPositionInfo"); e != nil {
+		return nil, e
+	}
+	st := r.State()
+	return &upnptype.PositionInfo{
+		Track:         1,
+		TrackDuration: upnptype.TimeToString(st.Duration),
+		TrackMetaData: st.Metadata,
+		TrackURI:      st.URI,
+		RelTime:       upnptype.TimeToString(st.Position),
+		AbsTime:       upnptype.TimeToString(st.Position),
+	}, nil
+}
+
+// Stop stops the playback.
+//
+func (r *Renderer) Stop(instanceID uint32) error {
+	return r.setTransport("Stop", upnptype.PlaybackStateStopped)
+}
+
+// Play starts the playback.
+//
+func (r *Renderer) Play(instanceID uint32, speed string) error {
+	return r.setTransport("Play", upnptype.PlaybackStatePlaying)
+}
+
+// Pause pauses the playback.
+//
+func (r *Renderer) Pause(instanceID uint32) error {
+	return r.setTransport("Pause", upnptype.PlaybackStatePaused)
+}
+
+// PlayPause toggles the play / pause state.
+//
+func (r *Renderer) PlayPause(instanceID uint32, speed string) error {
+	if r.State().Transport == upnptype.PlaybackStatePlaying {
+		return r.Pause(instanceID)
+	}
+	return r.Play(instanceID, speed)
+}
+
+func (r *Renderer) setTransport(action string, state upnptype.PlaybackState) error {
+	if e := r.call(action); e != nil {
+		return e
+	}
+	r.update(func(st *RendererState) { st.Transport = state })
+	r.emitTransportState(state)
+	return nil
+}
+
+// Seek moves in the current track. Time units only.
+//
+func (r *Renderer) Seek(instanceID uint32, unit, target string) error {
+	if e := r.call("Seek"); e != nil {
+		return e
+	}
+	switch unit {
+	case upnptype.SeekModeAbsTime, upnptype.SeekModeRelTime:
+	default:
+		return &upnptype.ErrNotSupported{Action: "Seek", Value: unit}
+	}
+	r.update(func(st *RendererState) { st.Position = upnptype.TimeToSecond(target) })
+	r.DisplayCurrentTime()
+	return nil
+}
+
+// Next plays the next URI if set.
+//
+func (r *Renderer) Next(instanceID uint32) error {
+	if e := r.call("Next"); e != nil {
+		return e
+	}
+	r.update(func(st *RendererState) {
+		if st.NextURI != "" {
+			st.URI, st.Metadata = st.NextURI, st.NextMetadata
+			st.NextURI, st.NextMetadata = "", ""
+			st.Position = 0
+		}
+	})
+	return nil
+}
+
+// Previous restarts the current track.
+//
+func (r *Renderer) Previous(instanceID uint32) error {
+	if e := r.call("Previous"); e != nil {
+		return e
+	}
+	r.update(func(st *RendererState) { st.Position = 0 })
+	return nil
+}
+
+// GetCurrentTransportActions returns the actions valid in the current state.
+//
+func (r *Renderer) GetCurrentTransportActions(instanceID uint32) ([]string, error) {
+	if e := r.call("GetCurrentTransportActions"); e != nil {
+		return nil, e
+	}
+	switch r.State().Transport {
+	case upnptype.PlaybackStatePlaying:
+		return []string{"Pause", "Stop", "Seek", "Next", "Previous"}, nil
+	case upnptype.PlaybackStatePaused:
+		return []string{"Play", "Stop", "Seek"}, nil
+	}
+	return []string{"Play"}, nil
+}
+
+// GetCurrentTime returns the current position in seconds.
+//
+func (r *Renderer) GetCurrentTime() int { return r.State().Position }
+
+// DisplayCurrentTime forwards the current position with the OnCurrentTime event.
+//
+func (r *Renderer) DisplayCurrentTime() {
+	if r.Events().OnCurrentTime == nil {
+		return
+	}
+	st := r.State()
+	percent := 0.
+	if st.Duration > 0 {
+		percent = float64(st.Position) * 100 / float64(st.Duration)
+	}
+	r.Events().OnCurrentTime(r, st.Position, percent)
+}
+
+func transportStateName(state upnptype.PlaybackState) string {
+	switch state {
+	case upnptype.PlaybackStatePlaying:
+		return upnptype.StatePlaying
+	case upnptype.PlaybackStatePaused:
+		return upnptype.StatePausedPlayback
+	case upnptype.PlaybackStateTransitioning:
+		return upnptype.StateTransitioning
+	}
+	return upnptype.StateStopped
+}
+
+//
+//------------------------------------------------------------------[ SERVER ]--
+
+// Server defines a fake media server with an in-memory content tree.
+//
+type Server struct {
+	upnptype.ServerBase
+	calls
+
+	mu             sync.Mutex
+	objects        map[string]*object   // indexed by ID.
+	children       map[string][]*object // indexed by parent ID.
+	systemUpdateID uint
+}
+
+type object struct {
+	container *upnptype.Container
+	item      *upnptype.Item
+}
+
+func (o *object) base() *upnptype.Object {
+	if o.container != nil {
+		return &o.container.Object
+	}
+	return &o.item.Object
+}
+
+// NewServer creates a fake server with an empty root container.
+//
+func NewServer(udn, name string) *Server {
+	s := &Server{
+		objects:  make(map[string]*object),
+		children: make(map[string][]*object),
+	}
+	s.SetUDN(udn)
+	s.SetName(name)
+	s.SetDeviceInfo(&upnptype.DeviceInfo{
+		DeviceType:   "urn:schemas-upnp-org:device:MediaServer:1",
+		FriendlyName: name,
+		Manufacturer: "mocktype",
+		ModelName:    "Fake server",
+		UDN:          udn,
+		Services: []upnptype.ServiceInfo{
+			{ServiceType: upnptype.ServiceTypeContentDirectory},
+			{ServiceType: upnptype.ServiceTypeConnectionManager},
+		},
+	})
+	s.objects[upnptype.BrowseObjectIDRoot] = &object{container: &upnptype.Container{
+		Object: upnptype.Object{ID: upnptype.BrowseObjectIDRoot, ParentID: "-1", Title: "root", Class: "object.container"},
+	}}
+	return s
+}
+
+// AddContainer adds a container in the parent container.
+//
+func (s *Server) AddContainer(parentID, id, title string) {
+	s.add(parentID, &object{container: &upnptype.Container{
+		Object: upnptype.Object{ID: id, ParentID: parentID, Title: title, Class: "object.container.storageFolder"},
+	}})
+}
+
+// AddItem adds a media item in the parent container.
+//
+func (s *Server) AddItem(parentID, id, title, class, url string) {
+	s.add(parentID, &object{item: &upnptype.Item{
+		Object: upnptype.Object{ID: id, ParentID: parentID, Title: title, Class: class},
+		Res:    []upnptype.Resource{{ProtocolInfo: "http-get:*:*:*", URL: url}},
+	}})
+}
+
+// AddResource adds a resource to an item, like subtitles.
+//
+func (s *Server) AddResource(id, protocolInfo, url string) {
+	s.mu.Lock()
+	if obj, ok := s.objects[id]; ok && obj.item != nil {
+		obj.item.Res = append(obj.item.Res, upnptype.Resource{ProtocolInfo: protocolInfo, URL: url})
+	}
+	s.mu.Unlock()
+}
+
+// NewTestNAS creates the server shared by the tests: a NAS (uuid:nas) with the
+// album Blue Train (album/1) in Music, and its tracks Moment's Notice (track1)
+// and Locomotion (track2).
+//
+func NewTestNAS() *Server {
+	nas := NewServer("uuid:nas", "NAS")
+	nas.AddContainer(upnptype.BrowseObjectIDRoot, "music", "Music")
+	nas.AddContainer("music", "album/1", "Blue Train")
+	nas.AddItem("album/1", "track1", "Moment's Notice", upnptype.ClassMusic, "http://nas/1.mp3")
+	nas.AddItem("album/1", "track2", "Locomotion", upnptype.ClassMusic, "http://nas/2.mp3")
+	return nas
+}
+
+func (s *Server) add(parentID string, obj *object) {
+	s.mu.Lock()
+	s.objects[obj.base().ID] = obj
+	s.children[parentID] = append(s.children[parentID], obj)
+	if parent, ok := s.objects[parentID]; ok && parent.container != nil {
+		parent.container.ChildCount++
+	}
+	s.mu.Unlock()
+}
+
+// Update increases the system update ID and emits the server events, as
+// a content change on the server.
+//
+func (s *Server) Update(containerIDs ...string) {
+	s.mu.Lock()
+	s.systemUpdateID++
+	updateID := s.systemUpdateID
+	s.mu.Unlock()
+
+	if s.Events().OnSystemUpdateID != nil {
+		s.Events().OnSystemUpdateID(s, updateID)
+	}
+	if len(containerIDs) > 0 && s.Events().OnContainerUpdateIDs != nil {
+		ids := make(map[string]uint)
+		for _, id := range containerIDs {
+			ids[id] = updateID
+		}
+		s.Events().OnContainerUpdateIDs(s, ids)
+	}
+}
+
+// Browse lists the children of a container.
+//
+func (s *Server) Browse(req *upnptype.BrowseRequest) (*upnptype.BrowseResult, error) {
+	if e := s.call("Browse"); e != nil {
+		return nil, e
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.objects[req.ObjectID]; !ok {
+		return nil, errors.New("701 no such object: " + req.ObjectID)
+	}
+	children := s.children[req.ObjectID]
+	first, last := page(len(children), int(req.StartingIndex), int(req.RequestCount))
+
+	res := &upnptype.BrowseResult{
+		NumberReturned: int32(last - first),
+		TotalMatches:   int32(len(children)),
+		UpdateID:       int32(s.systemUpdateID),
+	}
+	for _, obj := range children[first:last] {
+		if obj.container != nil {
+			res.Container = append(res.Container, *obj.container)
+		} else {
+			res.Item = append(res.Item, obj.item.Object)
+		}
+	}
+	return res, nil
+}
+
+// BrowseMetadata returns the object with its DIDL-Lite description.
+//
+func (s *Server) BrowseMetadata(id string, startingIndex, requestedCount uint) ([]upnptype.Container, []upnptype.Item, string) {
+	if s.call("BrowseMetadata") != nil {
+		return nil, nil, ""
+	}
+	s.mu.Lock()
+	obj, ok := s.objects[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil, ""
+	}
+
+	didl := struct {
+		XMLName   xml.Name `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ DIDL-Lite"`
+		Container []upnptype.Container
+		Item      []upnptype.Item
+	}{}
+	if obj.container != nil {
+		didl.Container = append(didl.Container, *obj.container)
+	} else {
+		didl.Item = append(didl.Item, *obj.item)
+	}
+	data, _ := xml.Marshal(didl)
+	return didl.Container, didl.Item, string(data)
+}
+
+// page returns the bounds of the requested page. A count of 0 requests all.
+func page(total, start, count int) (int, int) {
+	if start > total {
+		start = total
+	}
+	end := total
+	if count > 0 && start+count < total {
+		end = start + count
+	}
+	return start, end
+}
@@ -0,0 +1,430 @@
+// Package mpris exports the selected renderer of a gupnp.MediaControl as a
+// MPRIS2 media player on D-Bus, so desktop media keys, shell widgets and
+// playerctl can control it.
+//
+//   conn, _ := dbus.ConnectSessionBus()
+//   player := mpris.New(media)
+//   player.Export(conn, mpris.BusName)
+//
+// The player follows the renderer selected in the media control.
+//
+package mpris
+
+import (
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+
+	"github.com/sqp/gupnp"
+	"github.com/sqp/gupnp/upnptype"
+
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// BusName is the default bus name of the player.
+//
+const BusName = "org.mpris.MediaPlayer2.gupnp"
+
+// ObjectPath is the object path of MPRIS2 players.
+//
+const ObjectPath dbus.ObjectPath = "/org/mpris/MediaPlayer2"
+
+// D-Bus interfaces.
+const (
+	ifaceRoot   = "org.mpris.MediaPlayer2"
+	ifacePlayer = "org.mpris.MediaPlayer2.Player"
+)
+
+// hookName is the media hook registered by the player.
+const hookName = "mpris"
+
+// playerMethods maps the player methods named differently in Go, as Seek is
+// reserved for io.Seeker.
+var playerMethods = map[string]string{"SeekBy": "Seek"}
+
+// noTrack is the track ID when no track is loaded.
+const noTrack dbus.ObjectPath = "/org/mpris/MediaPlayer2/TrackList/NoTrack"
+
+// ErrNoRenderer is returned by player actions when no renderer is selected.
+//
+var ErrNoRenderer = errors.New("no renderer selected")
+
+// Player exports the selected renderer as a MPRIS2 player.
+//
+type Player struct {
+	// Call runs fn with exclusive access to the backend. Backends iterating
+	// their own event loop must run fn in it. Defaults to a direct call
+	// guarded by a mutex. Must be set before Export.
+	//
+	Call func(fn func())
+
+	// Identity is the name of the player displayed by clients.
+	//
+	Identity string
+
+	media *gupnp.MediaControl
+	conn  *dbus.Conn
+	props *prop.Properties
+	name  string
+
+	mu       sync.Mutex      // fields updated by the renderer events.
+	callMu   sync.Mutex      // default Call.
+	trackID  dbus.ObjectPath // current track, changed with the metadata.
+	tracks   int             // track counter, to build track IDs.
+	item     *upnptype.Item  // current track metadata.
+	length   int64           // track duration in microseconds.
+	position int64           // track position in microseconds.
+}
+
+// New creates a MPRIS2 player for the media control.
+//
+func New(media *gupnp.MediaControl) *Player {
+	p := &Player{
+		Identity: "UPnP renderer",
+		media:    media,
+		trackID:  noTrack,
+	}
+	p.Call = p.lockedCall
+	return p
+}
+
+func (p *Player) lockedCall(fn func()) {
+	p.callMu.Lock()
+	defer p.callMu.Unlock()
+	fn()
+}
+
+// Export exports the player on the connection and requests the bus name.
+//
+func (p *Player) Export(conn *dbus.Conn, name string) error {
+	p.conn = conn
+	p.name = name
+
+	if e := conn.Export(root{p}, ObjectPath, ifaceRoot); e != nil {
+		return e
+	}
+	if e := conn.ExportWithMap(player{p}, playerMethods, ObjectPath, ifacePlayer); e != nil {
+		return e
+	}
+	var e error
+	p.props, e = prop.Export(conn, ObjectPath, p.propMap())
+	if e != nil {
+		return e
+	}
+	methods := introspect.Methods(player{p})
+	for i := range methods {
+		if name, ok := playerMethods[methods[i].Name]; ok {
+			methods[i].Name = name
+		}
+	}
+	node := &introspect.Node{
+		Name: string(ObjectPath),
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			prop.IntrospectData,
+			{Name: ifaceRoot, Methods: introspect.Methods(root{p}), Properties: p.props.Introspection(ifaceRoot)},
+			{
+				Name:       ifacePlayer,
+				Methods:    methods,
+				Properties: p.props.Introspection(ifacePlayer),
+				Signals:    []introspect.Signal{{Name: "Seeked", Args: []introspect.Arg{{Name: "Position", Type: "x"}}}},
+			},
+		},
+	}
+	if e := conn.Export(introspect.NewIntrospectable(node), ObjectPath, "org.freedesktop.DBus.Introspectable"); e != nil {
+		return e
+	}
+
+	p.Call(func() {
+		p.connectHook()
+		p.setRenderer(p.media.Renderer())
+	})
+
+	reply, e := conn.RequestName(name, dbus.NameFlagDoNotQueue)
+	if e != nil {
+		return e
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf("bus name already taken: %s", name)
+	}
+	return nil
+}
+
+// Close releases the bus name and stops following the media events.
+//
+func (p *Player) Close() error {
+	p.Call(func() { p.media.UnsubscribeHook(hookName) })
+	_, e := p.conn.ReleaseName(p.name)
+	return e
+}
+
+//
+//--------------------------------------------------------------[ PROPERTIES ]--
+
+func (p *Player) propMap() prop.Map {
+	return prop.Map{
+		ifaceRoot: {
+			"CanQuit":             {Value: false, Emit: prop.EmitConst},
+			"CanRaise":            {Value: false, Emit: prop.EmitConst},
+			"HasTrackList":        {Value: false, Emit: prop.EmitConst},
+			"Identity":            {Value: p.Identity, Emit: prop.EmitConst},
+			"SupportedUriSchemes": {Value: []string{"http", "https"}, Emit: prop.EmitConst},
+			"SupportedMimeTypes":  {Value: []string{"audio/mpeg", "audio/flac", "audio/mp4", "video/mp4", "image/jpeg"}, Emit: prop.EmitConst},
+		},
+		ifacePlayer: {
+			"PlaybackStatus": {Value: "Stopped", Emit: prop.EmitTrue},
+			"Rate":           {Value: 1.0, Emit: prop.EmitConst},
+			"MinimumRate":    {Value: 1.0, Emit: prop.EmitConst},
+			"MaximumRate":    {Value: 1.0, Emit: prop.EmitConst},
+			"Metadata":       {Value: p.metadata(), Emit: prop.EmitTrue},
+			"Volume":         {Value: 0.0, Writable: true, Emit: prop.EmitTrue, Callback: p.onSetVolume},
+			"Position":       {Value: int64(0), Emit: prop.EmitFalse}, // polled by clients, Seeked sent on jumps.
+			"CanGoNext":      {Value: false, Emit: prop.EmitTrue},
+			"CanGoPrevious":  {Value: false, Emit: prop.EmitTrue},
+			"CanPlay":        {Value: false, Emit: prop.EmitTrue},
+			"CanPause":       {Value: false, Emit: prop.EmitTrue},
+			"CanSeek":        {Value: false, Emit: prop.EmitTrue},
+			"CanControl":     {Value: true, Emit: prop.EmitConst},
+		},
+	}
+}
+
+// metadata returns the MPRIS metadata of the current track. Lock held.
+func (p *Player) metadata() map[string]dbus.Variant {
+	meta := map[string]dbus.Variant{"mpris:trackid": dbus.MakeVariant(p.trackID)}
+	if p.length > 0 {
+		meta["mpris:length"] = dbus.MakeVariant(p.length)
+	}
+	if p.item == nil {
+		return meta
+	}
+	if p.item.Title != "" {
+		meta["xesam:title"] = dbus.MakeVariant(p.item.Title)
+	}
+	if p.item.Artist != "" {
+		meta["xesam:artist"] = dbus.MakeVariant([]string{p.item.Artist})
+	}
+	if p.item.Album != "" {
+		meta["xesam:album"] = dbus.MakeVariant(p.item.Album)
+	}
+	if p.item.Genre != "" {
+		meta["xesam:genre"] = dbus.MakeVariant([]string{p.item.Genre})
+	}
+	if p.item.AlbumArt != "" {
+		meta["mpris:artUrl"] = dbus.MakeVariant(p.item.AlbumArt)
+	}
+	if len(p.item.Res) > 0 {
+		meta["xesam:url"] = dbus.MakeVariant(p.item.Res[0].URL)
+	}
+	return meta
+}
+
+func (p *Player) updateMetadata() {
+	p.mu.Lock()
+	meta := p.metadata()
+	p.mu.Unlock()
+	p.props.SetMust(ifacePlayer, "Metadata", meta)
+}
+
+// onSetVolume sets the volume written by a client, from 0 to 1.
+//
+// The volume is set asynchronously, as the properties are locked during the
+// callback and the renderer may answer with a volume event.
+func (p *Player) onSetVolume(c *prop.Change) *dbus.Error {
+	vol := c.Value.(float64)
+	switch {
+	case vol < 0:
+		vol = 0
+
+	case vol > 1:
+		vol = 1
+	}
+	go p.run(func(rend upnptype.Renderer, caps *upnptype.CapableRenderer) error {
+		return caps.SetVolume(0, upnptype.ChannelMaster, uint16(vol*100+0.5)) // Max set by quirks.
+	})
+	return nil
+}
+
+//
+//--------------------------------------------------------------------[ HOOK ]--
+
+// connectHook updates the properties with the events of the renderer.
+func (p *Player) connectHook() {
+	hook := p.media.SubscribeHook(hookName)
+	hook.OnRendererSelected = p.setRenderer
+	hook.OnTransportState = func(_ upnptype.Renderer, state upnptype.PlaybackState) {
+		p.props.SetMust(ifacePlayer, "PlaybackStatus", playbackStatus(state))
+	}
+	hook.OnCurrentTrackMetaData = func(_ upnptype.Renderer, item *upnptype.Item) {
+		p.mu.Lock()
+		p.tracks++
+		p.trackID = dbus.ObjectPath(fmt.Sprintf("/org/sqp/gupnp/track/%d", p.tracks))
+		p.item = item
+		p.mu.Unlock()
+		p.updateMetadata()
+	}
+	hook.OnCurrentTrackDuration = func(_ upnptype.Renderer, secs int) {
+		p.mu.Lock()
+		changed := p.length != int64(secs)*1e6
+		p.length = int64(secs) * 1e6
+		p.mu.Unlock()
+		if changed {
+			p.updateMetadata()
+		}
+	}
+	hook.OnCurrentTime = func(_ upnptype.Renderer, secs int, _ float64) {
+		p.mu.Lock()
+		p.position = int64(secs) * 1e6
+		p.mu.Unlock()
+		p.props.SetMust(ifacePlayer, "Position", int64(secs)*1e6)
+	}
+	hook.OnVolume = func(_ upnptype.Renderer, vol uint) {
+		p.props.SetMust(ifacePlayer, "Volume", float64(vol)/100)
+	}
+}
+
+// setRenderer resets the player for a new renderer, or none.
+func (p *Player) setRenderer(rend upnptype.Renderer) {
+	p.mu.Lock()
+	p.trackID, p.item, p.length, p.position = noTrack, nil, 0, 0
+	p.mu.Unlock()
+	p.updateMetadata()
+
+	active := rend != nil
+	p.props.SetMust(ifacePlayer, "PlaybackStatus", "Stopped")
+	p.props.SetMust(ifacePlayer, "Position", int64(0))
+	for _, name := range []string{"CanGoNext", "CanGoPrevious", "CanPlay", "CanPause", "CanSeek"} {
+		p.props.SetMust(ifacePlayer, name, active)
+	}
+}
+
+// playbackStatus returns the MPRIS playback status of the state.
+func playbackStatus(state upnptype.PlaybackState) string {
+	switch state {
+	case upnptype.PlaybackStatePlaying, upnptype.PlaybackStateTransitioning:
+		return "Playing"
+
+	case upnptype.PlaybackStatePaused:
+		return "Paused"
+	}
+	return "Stopped"
+}
+
+//
+//-----------------------------------------------------------------[ METHODS ]--
+
+// run calls the action on the selected renderer in the backend.
+func (p *Player) run(call func(rend upnptype.Renderer, caps *upnptype.CapableRenderer) error) *dbus.Error {
+	var e error
+	p.Call(func() {
+		rend := p.media.Renderer()
+		if rend == nil {
+			e = ErrNoRenderer
+			return
+		}
+		e = call(rend, p.media.Capabilities())
+	})
+	if e != nil {
+		return dbus.MakeFailedError(e)
+	}
+	return nil
+}
+
+// seek moves to the position in microseconds, and signals the jump.
+func (p *Player) seek(caps *upnptype.CapableRenderer, pos int64) error {
+	if e := caps.SeekTime(0, int(pos/1e6)); e != nil {
+		return e
+	}
+	p.mu.Lock()
+	p.position = pos
+	p.mu.Unlock()
+	p.props.SetMust(ifacePlayer, "Position", pos)
+	return p.conn.Emit(ObjectPath, ifacePlayer+".Seeked", pos)
+}
+
+// root implements the org.mpris.MediaPlayer2 methods.
+type root struct{ p *Player }
+
+// Raise is not supported, CanRaise is false.
+func (root) Raise() *dbus.Error { return nil }
+
+// Quit is not supported, CanQuit is false.
+func (root) Quit() *dbus.Error { return nil }
+
+// player implements the org.mpris.MediaPlayer2.Player methods.
+type player struct{ p *Player }
+
+func (pl player) Next() *dbus.Error {
+	return pl.p.run(func(_ upnptype.Renderer, caps *upnptype.CapableRenderer) error { return caps.Next(0) })
+}
+
+func (pl player) Previous() *dbus.Error {
+	return pl.p.run(func(_ upnptype.Renderer, caps *upnptype.CapableRenderer) error { return caps.Previous(0) })
+}
+
+func (pl player) Pause() *dbus.Error {
+	return pl.p.run(func(_ upnptype.Renderer, caps *upnptype.CapableRenderer) error { return caps.Pause(0) })
+}
+
+func (pl player) PlayPause() *dbus.Error {
+	return pl.p.run(func(rend upnptype.Renderer, caps *upnptype.CapableRenderer) error {
+		if pl.p.props.GetMust(ifacePlayer, "PlaybackStatus") == "Playing" {
+			return caps.Pause(0)
+		}
+		return rend.Play(0, upnptype.PlaySpeedNormal)
+	})
+}
+
+func (pl player) Stop() *dbus.Error {
+	return pl.p.run(func(rend upnptype.Renderer, _ *upnptype.CapableRenderer) error { return rend.Stop(0) })
+}
+
+func (pl player) Play() *dbus.Error {
+	return pl.p.run(func(rend upnptype.Renderer, _ *upnptype.CapableRenderer) error {
+		return rend.Play(0, upnptype.PlaySpeedNormal)
+	})
+}
+
+// SeekBy moves by an offset in microseconds. Seeking past the end of the
+// track plays the next one. Exported as Seek.
+func (pl player) SeekBy(offset int64) *dbus.Error {
+	return pl.p.run(func(_ upnptype.Renderer, caps *upnptype.CapableRenderer) error {
+		pl.p.mu.Lock()
+		pos, length := pl.p.position+offset, pl.p.length
+		pl.p.mu.Unlock()
+		switch {
+		case length > 0 && pos > length:
+			return caps.Next(0)
+
+		case pos < 0:
+			pos = 0
+		}
+		return pl.p.seek(caps, pos)
+	})
+}
+
+// SetPosition moves to a position in microseconds, if the track is still the
+// current one.
+func (pl player) SetPosition(trackID dbus.ObjectPath, pos int64) *dbus.Error {
+	return pl.p.run(func(_ upnptype.Renderer, caps *upnptype.CapableRenderer) error {
+		pl.p.mu.Lock()
+		current, length := pl.p.trackID, pl.p.length
+		pl.p.mu.Unlock()
+		if trackID != current || pos < 0 || (length > 0 && pos > length) {
+			return nil // ignored, as required by the spec.
+		}
+		return pl.p.seek(caps, pos)
+	})
+}
+
+func (pl player) OpenUri(uri string) *dbus.Error {
+	mimeType := upnptype.ProbeURL(uri) // Out of the main loop.
+	var e error
+	pl.p.Call(func() { e = pl.p.media.PlayURL(uri, mimeType) })
+	if e != nil {
+		return dbus.MakeFailedError(e)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package mpris
+
+import (
+	"github.com/godbus/dbus/v5"
+
+	"github.com/sqp/gupnp"
+	"github.com/sqp/gupnp/gupnptest"
+	"github.com/sqp/gupnp/mocktype"
+	"github.com/sqp/gupnp/upnptype"
+
+	"bufio"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startBus starts a private session bus and returns its address.
+func startBus(t *testing.T) string {
+	path, e := exec.LookPath("dbus-daemon")
+	if e != nil {
+		t.Skip("dbus-daemon not found")
+	}
+	cmd := exec.Command(path, "--session", "--print-address", "--nofork")
+	out, e := cmd.StdoutPipe()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if e := cmd.Start(); e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	address, e := bufio.NewReader(out).ReadString('\n')
+	if e != nil {
+		t.Fatal("dbus-daemon address:", e)
+	}
+	return strings.TrimSpace(address)
+}
+
+func connect(t *testing.T, address string) *dbus.Conn {
+	conn, e := dbus.Connect(address)
+	if e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// newTestPlayer exports a player for a media control with a TV renderer, and
+// returns the player object seen by a client. Media calls in tests must be
+// sent with the player Call, as D-Bus methods are run in their own goroutine.
+func newTestPlayer(t *testing.T) (dbus.BusObject, *Player, *gupnp.MediaControl, *mocktype.Renderer) {
+	address := startBus(t)
+
+	media, _, tv := gupnptest.NewMedia(t)
+
+	player := New(media)
+	if e := player.Export(connect(t, address), BusName); e != nil {
+		t.Fatal(e)
+	}
+	t.Cleanup(func() { player.Close() })
+
+	return connect(t, address).Object(BusName, ObjectPath), player, media, tv
+}
+
+func getProperty(t *testing.T, obj dbus.BusObject, name string) interface{} {
+	value, e := obj.GetProperty(ifacePlayer + "." + name)
+	if e != nil {
+		t.Fatal(name, e)
+	}
+	return value.Value()
+}
+
+func call(t *testing.T, obj dbus.BusObject, method string, args ...interface{}) {
+	if e := obj.Call(ifacePlayer+"."+method, 0, args...).Err; e != nil {
+		t.Fatal(method, e)
+	}
+}
+
+func TestPlayer(t *testing.T) {
+	obj, player, media, tv := newTestPlayer(t)
+
+	if e := obj.Call(ifacePlayer+".Play", 0).Err; e == nil {
+		t.Error("play without renderer: no error")
+	}
+	if can := getProperty(t, obj, "CanPlay"); can != false {
+		t.Error("CanPlay without renderer:", can)
+	}
+
+	player.Call(func() { media.SetRenderer("uuid:tv") })
+	if can := getProperty(t, obj, "CanPlay"); can != true {
+		t.Error("CanPlay with renderer:", can)
+	}
+	if vol := getProperty(t, obj, "Volume"); vol != 0.5 {
+		t.Error("volume:", vol)
+	}
+
+	call(t, obj, "Play")
+	if status := getProperty(t, obj, "PlaybackStatus"); status != "Playing" {
+		t.Error("status after play:", status)
+	}
+	call(t, obj, "PlayPause")
+	if st := tv.State(); st.Transport != upnptype.PlaybackStatePaused {
+		t.Error("renderer after play pause:", st.Transport)
+	}
+	if status := getProperty(t, obj, "PlaybackStatus"); status != "Paused" {
+		t.Error("status after play pause:", status)
+	}
+
+	player.Call(func() {
+		tv.Events().OnCurrentTrackDuration(tv, 300)
+		tv.Events().OnCurrentTrackMetaData(tv, &upnptype.Item{
+			Object: upnptype.Object{Title: "Moment's Notice", Artist: "John Coltrane", Album: "Blue Train"},
+			Res:    []upnptype.Resource{{URL: "http://nas/1.mp3"}},
+		})
+	})
+	meta := getProperty(t, obj, "Metadata").(map[string]dbus.Variant)
+	if meta["xesam:title"].Value() != "Moment's Notice" ||
+		meta["xesam:album"].Value() != "Blue Train" ||
+		meta["xesam:url"].Value() != "http://nas/1.mp3" ||
+		meta["mpris:length"].Value() != int64(300e6) {
+		t.Errorf("bad metadata: %v", meta)
+	}
+	if artists, _ := meta["xesam:artist"].Value().([]string); len(artists) != 1 || artists[0] != "John Coltrane" {
+		t.Errorf("bad artists: %v", meta["xesam:artist"])
+	}
+	trackID := meta["mpris:trackid"].Value().(dbus.ObjectPath)
+
+	call(t, obj, "SetPosition", trackID, int64(90e6))
+	if st := tv.State(); st.Position != 90 {
+		t.Error("position after set position:", st.Position)
+	}
+	call(t, obj, "Seek", int64(30e6))
+	if st := tv.State(); st.Position != 120 {
+		t.Error("position after seek:", st.Position)
+	}
+	call(t, obj, "SetPosition", dbus.ObjectPath("/old/track"), int64(10e6))
+	if st := tv.State(); st.Position != 120 {
+		t.Error("set position of an old track:", st.Position)
+	}
+
+	call(t, obj, "Stop")
+	if status := getProperty(t, obj, "PlaybackStatus"); status != "Stopped" {
+		t.Error("status after stop:", status)
+	}
+
+	if e := obj.SetProperty(ifacePlayer+".Volume", dbus.MakeVariant(0.8)); e != nil {
+		t.Fatal(e)
+	}
+	deadline := time.Now().Add(2 * time.Second) // set asynchronously.
+	for getProperty(t, obj, "Volume") != 0.8 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := tv.State(); st.Volume != 80 {
+		t.Error("renderer volume:", st.Volume)
+	}
+	if vol := getProperty(t, obj, "Volume"); vol != 0.8 {
+		t.Error("volume after set:", vol)
+	}
+}
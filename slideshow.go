@@ -0,0 +1,474 @@
+package gupnp
+
+import (
+	"github.com/sqp/gupnp/upnptype"
+
+	"errors"
+	"math/rand"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSlideInterval is the default display time of an image in a slideshow.
+//
+const DefaultSlideInterval = 5 * time.Second
+
+// ErrNoImage is returned when a slideshow has no image to display.
+//
+var ErrNoImage = errors.New("no image to display")
+
+//
+//---------------------------------------------------------------[ SLIDESHOW ]--
+
+// Slideshow displays images on a renderer at a regular interval.
+//
+// Images come from a ContentDirectory container or from a list of items. For
+// each image, the resource with the largest resolution fitting the limits set
+// with SetMaxResolution is sent to the renderer.
+//
+// Progress is forwarded to the OnSlideshowState and OnSlideshowImage hooks.
+//
+type Slideshow struct {
+	// Call runs the timer ticks displaying the next image, in the backend
+	// event loop as the image is sent to the renderer and forwarded to the
+	// hooks. SetControlPoint sets it to the Call of backends implementing
+	// upnptype.EventLoop. Defaults to a direct call for the other ones.
+	//
+	Call func(fn func())
+
+	cp *MediaControl
+
+	mu        sync.Mutex
+	rend      *upnptype.CapableRenderer // renderer selected when started.
+	slides    []slide
+	order     []int // display order, as indexes in slides.
+	pos       int   // current position in order.
+	state     upnptype.PlaybackState
+	timer     *time.Timer
+	timerGen  int // invalidates pending timers.
+	interval  time.Duration
+	shuffle   bool
+	repeat    bool
+	maxWidth  int
+	maxHeight int
+}
+
+// slide defines an image of the slideshow.
+type slide struct {
+	item upnptype.Item
+	srv  upnptype.Server // server to get the resources from, if not provided with the item.
+}
+
+func newSlideshow(cp *MediaControl) *Slideshow {
+	return &Slideshow{
+		Call:     func(fn func()) { fn() },
+		cp:       cp,
+		state:    upnptype.PlaybackStateStopped,
+		interval: DefaultSlideInterval,
+	}
+}
+
+// Slideshow returns the slideshow controller.
+//
+func (cp *MediaControl) Slideshow() *Slideshow {
+	return cp.slideshow
+}
+
+// SetInterval sets the display time of each image. Applied from the next image.
+//
+func (show *Slideshow) SetInterval(interval time.Duration) {
+	show.mu.Lock()
+	show.interval = interval
+	show.mu.Unlock()
+}
+
+// SetShuffle sets whether images are displayed in random order.
+//
+func (show *Slideshow) SetShuffle(shuffle bool) {
+	show.mu.Lock()
+	defer show.mu.Unlock()
+	if show.shuffle != shuffle {
+		show.shuffle = shuffle
+		show.reorder()
+	}
+}
+
+// SetRepeat sets whether the slideshow starts again after the last image.
+//
+func (show *Slideshow) SetRepeat(repeat bool) {
+	show.mu.Lock()
+	show.repeat = repeat
+	show.mu.Unlock()
+}
+
+// SetMaxResolution sets the largest image resolution to send to the renderer,
+// usually its screen size. A zero value means no limit.
+//
+func (show *Slideshow) SetMaxResolution(width, height int) {
+	show.mu.Lock()
+	show.maxWidth, show.maxHeight = width, height
+	show.mu.Unlock()
+}
+
+// State returns the slideshow state: playing, paused or stopped.
+//
+func (show *Slideshow) State() upnptype.PlaybackState {
+	show.mu.Lock()
+	defer show.mu.Unlock()
+	return show.state
+}
+
+// Position returns the position of the displayed image in the slideshow and
+// the number of images.
+//
+func (show *Slideshow) Position() (index, count int) {
+	show.mu.Lock()
+	defer show.mu.Unlock()
+	return show.pos, len(show.slides)
+}
+
+//
+//----------------------------------------------------------------[ PLAYBACK ]--
+
+// PlayContainer starts a slideshow of the images in a container of the
+// selected server, on the selected renderer.
+//
+func (show *Slideshow) PlayContainer(containerID string) error {
+	srv := show.cp.curSrv
+	if srv == nil {
+		return ErrNoServer
+	}
+	var slides []slide
+	for start := uint32(0); ; {
+		res, e := srv.Browse(&upnptype.BrowseRequest{
+			ObjectID:      containerID,
+			BrowseFlag:    upnptype.BrowseFlagBrowseDirectChildren,
+			Filter:        upnptype.BrowseFilterAll,
+			StartingIndex: start,
+			RequestCount:  uint32(upnptype.MaxBrowse),
+		})
+		if e != nil {
+			return e
+		}
+		for _, obj := range res.Item {
+			if isImageClass(obj.Class) {
+				slides = append(slides, slide{item: upnptype.Item{Object: obj}, srv: srv})
+			}
+		}
+		count := len(res.Container) + len(res.Item)
+		start += uint32(count)
+		if count == 0 || int32(start) >= res.TotalMatches {
+			break
+		}
+	}
+	return show.start(slides)
+}
+
+// PlayItems starts a slideshow of image items on the selected renderer.
+// Items without resources are resolved on the selected server.
+//
+func (show *Slideshow) PlayItems(items []upnptype.Item) error {
+	var slides []slide
+	for _, item := range items {
+		switch {
+		case len(item.Res) == 0 && show.cp.curSrv != nil:
+			slides = append(slides, slide{item: item, srv: show.cp.curSrv})
+
+		case isImageClass(item.Class) || imageResource(item.Res, 0, 0) != nil:
+			slides = append(slides, slide{item: item})
+		}
+	}
+	return show.start(slides)
+}
+
+// Pause stops the timer, the current image stays displayed.
+//
+func (show *Slideshow) Pause() {
+	show.mu.Lock()
+	if show.state != upnptype.PlaybackStatePlaying {
+		show.mu.Unlock()
+		return
+	}
+	show.state = upnptype.PlaybackStatePaused
+	show.stopTimer()
+	show.mu.Unlock()
+	show.emitState(upnptype.PlaybackStatePaused)
+}
+
+// Resume restarts a paused slideshow.
+//
+func (show *Slideshow) Resume() {
+	show.mu.Lock()
+	if show.state != upnptype.PlaybackStatePaused {
+		show.mu.Unlock()
+		return
+	}
+	show.state = upnptype.PlaybackStatePlaying
+	show.stopTimer()
+	show.startTimer()
+	show.mu.Unlock()
+	show.emitState(upnptype.PlaybackStatePlaying)
+}
+
+// Next displays the next image, or the first after the last one.
+//
+func (show *Slideshow) Next() error {
+	show.mu.Lock()
+	pos, count := show.pos+1, len(show.order)
+	show.mu.Unlock()
+	if count == 0 {
+		return ErrNoImage
+	}
+	return show.display(pos % count)
+}
+
+// Previous displays the previous image, or the last before the first one.
+//
+func (show *Slideshow) Previous() error {
+	show.mu.Lock()
+	pos, count := show.pos-1, len(show.order)
+	show.mu.Unlock()
+	if count == 0 {
+		return ErrNoImage
+	}
+	return show.display((pos + count) % count)
+}
+
+// Stop ends the slideshow and stops the renderer.
+//
+func (show *Slideshow) Stop() error {
+	show.mu.Lock()
+	rend := show.rend
+	show.mu.Unlock()
+	if !show.halt() || rend == nil {
+		return nil
+	}
+	return rend.Stop(0)
+}
+
+// halt ends the slideshow, leaving the renderer as is.
+// Returns false if the slideshow wasn't running.
+func (show *Slideshow) halt() bool {
+	show.mu.Lock()
+	if show.state == upnptype.PlaybackStateStopped {
+		show.mu.Unlock()
+		return false
+	}
+	show.state = upnptype.PlaybackStateStopped
+	show.stopTimer()
+	show.mu.Unlock()
+	show.emitState(upnptype.PlaybackStateStopped)
+	return true
+}
+
+func (show *Slideshow) start(slides []slide) error {
+	rend := show.cp.curCaps
+	if rend == nil {
+		return ErrNoRenderer
+	}
+	if len(slides) == 0 {
+		return ErrNoImage
+	}
+	show.halt()
+
+	show.mu.Lock()
+	show.rend = rend
+	show.slides = slides
+	show.order, show.pos = nil, 0
+	show.reorder()
+	show.state = upnptype.PlaybackStatePlaying
+	show.mu.Unlock()
+
+	show.emitState(upnptype.PlaybackStatePlaying)
+	return show.display(0)
+}
+
+// display sends the image at the position in the display order, and schedules
+// the next one when playing.
+func (show *Slideshow) display(pos int) error {
+	show.mu.Lock()
+	if pos < 0 || pos >= len(show.order) {
+		show.mu.Unlock()
+		return ErrNoImage
+	}
+	show.pos = pos
+	show.stopTimer()
+	gen := show.timerGen
+	rend, sl, count := show.rend, show.slides[show.order[pos]], len(show.order)
+	maxWidth, maxHeight := show.maxWidth, show.maxHeight
+	show.mu.Unlock()
+
+	item, e := sl.send(rend, maxWidth, maxHeight)
+
+	show.mu.Lock()
+	if gen == show.timerGen && show.state == upnptype.PlaybackStatePlaying {
+		show.startTimer() // also after a failure, to skip the image.
+	}
+	show.mu.Unlock()
+
+	if e != nil {
+		show.cp.log.Warn("slideshow image failed", upnptype.FieldError, e)
+		return e
+	}
+	for _, instance := range show.cp.hookTest(testSlideshowImage) {
+		instance.OnSlideshowImage(pos, count, item)
+	}
+	return nil
+}
+
+// advance displays the next image when the timer expires.
+func (show *Slideshow) advance(gen int) {
+	show.mu.Lock()
+	if gen != show.timerGen || show.state != upnptype.PlaybackStatePlaying {
+		show.mu.Unlock()
+		return
+	}
+	next := show.pos + 1
+	if next >= len(show.order) {
+		if !show.repeat {
+			show.mu.Unlock()
+			show.halt()
+			return
+		}
+		next = 0
+		if show.shuffle { // new random order for the next round.
+			show.order, show.pos = nil, 0
+			show.reorder()
+		}
+	}
+	show.mu.Unlock()
+	show.display(next)
+}
+
+// startTimer schedules the next image with Call. Must be called with the lock held.
+func (show *Slideshow) startTimer() {
+	gen, call := show.timerGen, show.Call
+	show.timer = time.AfterFunc(show.interval, func() {
+		call(func() { show.advance(gen) })
+	})
+}
+
+// stopTimer cancels the pending timer. Must be called with the lock held.
+func (show *Slideshow) stopTimer() {
+	show.timerGen++
+	if show.timer != nil {
+		show.timer.Stop()
+		show.timer = nil
+	}
+}
+
+// reorder builds the display order, keeping the current image at the current
+// position. Must be called with the lock held.
+func (show *Slideshow) reorder() {
+	current := -1
+	if show.pos < len(show.order) {
+		current = show.order[show.pos]
+	}
+	show.order = make([]int, len(show.slides))
+	for i := range show.order {
+		show.order[i] = i
+	}
+	if !show.shuffle {
+		if current >= 0 {
+			show.pos = current
+		}
+		return
+	}
+	rand.Shuffle(len(show.order), func(i, j int) { show.order[i], show.order[j] = show.order[j], show.order[i] })
+	for i, index := range show.order {
+		if index == current {
+			show.order[0], show.order[i] = show.order[i], show.order[0]
+			show.pos = 0
+		}
+	}
+}
+
+func (show *Slideshow) emitState(state upnptype.PlaybackState) {
+	for _, instance := range show.cp.hookTest(testSlideshowState) {
+		instance.OnSlideshowState(state)
+	}
+}
+
+//
+//------------------------------------------------------------------[ IMAGES ]--
+
+// send displays the image on the renderer. Returns the item with its resources.
+func (sl *slide) send(rend *upnptype.CapableRenderer, maxWidth, maxHeight int) (*upnptype.Item, error) {
+	item, didl := &sl.item, ""
+	if sl.srv != nil {
+		_, items, didlxml := sl.srv.BrowseMetadata(sl.item.ID, 0, 1)
+		if len(items) == 0 {
+			return nil, errors.New("image not found: " + sl.item.ID)
+		}
+		item, didl = &items[0], didlxml
+	}
+	res := imageResource(item.Res, maxWidth, maxHeight)
+	if res == nil {
+		return nil, errors.New("no image resource: " + item.Title)
+	}
+	if didl == "" {
+		mimeType := resourceType(res)
+		if parsed, e := neturl.Parse(res.URL); mimeType == "" && e == nil {
+			mimeType = upnptype.MimeType(parsed.Path)
+		}
+		if mimeType == "" {
+			mimeType = "*"
+		}
+		didl = upnptype.Metadata(res.URL, item.Title, mimeType, int64(res.Size))
+	}
+	return item, rend.SetAVTransportURI(0, res.URL, didl)
+}
+
+// imageResource returns the image resource with the largest resolution within
+// the limits, or the smallest one if none fits. A zero limit is unbounded.
+// Resources without resolution are only used if none has one.
+func imageResource(list []upnptype.Resource, maxWidth, maxHeight int) *upnptype.Resource {
+	var first, best, smallest *upnptype.Resource
+	var bestSize, smallestSize int
+	for i := range list {
+		res := &list[i]
+		if mimeType := resourceType(res); mimeType != "" && !strings.HasPrefix(mimeType, "image/") {
+			continue
+		}
+		if first == nil {
+			first = res
+		}
+		width, height, ok := parseResolution(res.Resolution)
+		if !ok {
+			continue
+		}
+		size := width * height
+		if smallest == nil || size < smallestSize {
+			smallest, smallestSize = res, size
+		}
+		fits := (maxWidth <= 0 || width <= maxWidth) && (maxHeight <= 0 || height <= maxHeight)
+		if fits && (best == nil || size > bestSize) {
+			best, bestSize = res, size
+		}
+	}
+	switch {
+	case best != nil:
+		return best
+	case smallest != nil:
+		return smallest
+	}
+	return first
+}
+
+// parseResolution parses a resource resolution attribute: 1920x1080.
+func parseResolution(str string) (width, height int, ok bool) {
+	fields := strings.Split(str, "x")
+	if len(fields) != 2 {
+		return 0, 0, false
+	}
+	width, e1 := strconv.Atoi(fields[0])
+	height, e2 := strconv.Atoi(fields[1])
+	return width, height, e1 == nil && e2 == nil && width > 0 && height > 0
+}
+
+func isImageClass(class string) bool {
+	return strings.HasPrefix(class, "object.item.imageItem")
+}
@@ -0,0 +1,598 @@
+package upnpctl
+
+import (
+	"github.com/sqp/gupnp/upnptype"
+
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// command defines a controller command.
+type command struct {
+	name    string
+	args    string
+	help    string
+	minArgs int
+	maxArgs int
+	call    func(ctl *Ctl, args []string) error
+}
+
+var commands = []*command{
+	{"list", "", "list renderers and servers", 0, 0, (*Ctl).list},
+	{"info", "", "show the description of the renderer, or the server with -s", 0, 0, (*Ctl).info},
+	{"browse", "[id]", "list a container of the server", 0, 1, (*Ctl).browse},
+	{"search", "text [id]", "search titles in a container of the server and its children", 1, 2, (*Ctl).search},
+	{"play", "[url|file|id]", "play a media URL, a local file or a server item, or resume", 0, 1, (*Ctl).play},
+	{"pause", "", "pause the playback", 0, 0, (*Ctl).pause},
+	{"stop", "", "stop the playback", 0, 0, (*Ctl).stop},
+	{"seek", "position", "seek to a time (1:30), a percent (50%) or relative seconds (+10, -10)", 1, 1, (*Ctl).seek},
+	{"next", "", "play the next track", 0, 0, (*Ctl).next},
+	{"prev", "", "play the previous track", 0, 0, (*Ctl).prev},
+	{"volume", "[value|+n|-n]", "show or set the volume", 0, 1, (*Ctl).volume},
+	{"mute", "[on|off|toggle]", "show or set the mute state", 0, 1, (*Ctl).mute},
+	{"status", "", "show the renderer state", 0, 0, (*Ctl).status},
+	{"watch", "[duration]", "print the renderer events, until interrupted or for the duration", 0, 1, (*Ctl).watch},
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// hookName is the media hook registered by commands waiting for events.
+const hookName = "upnpctl"
+
+// searchDepth is the maximum depth of containers searched.
+const searchDepth = 8
+
+//
+//-----------------------------------------------------------------[ DEVICES ]--
+
+type deviceJSON struct {
+	Index        int      `json:"index"`
+	Name         string   `json:"name"`
+	UDN          string   `json:"udn"`
+	DeviceType   string   `json:"deviceType,omitempty"`
+	Manufacturer string   `json:"manufacturer,omitempty"`
+	ModelName    string   `json:"modelName,omitempty"`
+	ModelNumber  string   `json:"modelNumber,omitempty"`
+	Location     string   `json:"location,omitempty"`
+	Services     []string `json:"services,omitempty"`
+}
+
+func newDeviceJSON(index int, dev device) deviceJSON {
+	out := deviceJSON{Index: index, Name: dev.Name(), UDN: dev.UDN()}
+	if info := dev.DeviceInfo(); info != nil {
+		out.DeviceType = info.DeviceType
+		out.Manufacturer = info.Manufacturer
+		out.ModelName = info.ModelName
+		out.ModelNumber = info.ModelNumber
+		out.Location = info.Location
+		for _, srv := range info.Services {
+			out.Services = append(out.Services, srv.ServiceType)
+		}
+	}
+	return out
+}
+
+func newDeviceList(list []device) []deviceJSON {
+	out := []deviceJSON{}
+	for i, dev := range list {
+		dj := newDeviceJSON(i, dev)
+		dj.Services = nil
+		out = append(out, dj)
+	}
+	return out
+}
+
+func (ctl *Ctl) list(args []string) error {
+	ctl.waitFor(func() bool { return false })
+	out := struct {
+		Renderers []deviceJSON `json:"renderers"`
+		Servers   []deviceJSON `json:"servers"`
+	}{newDeviceList(ctl.renderers()), newDeviceList(ctl.servers())}
+
+	return ctl.print(out, func(w io.Writer) {
+		fmt.Fprintln(w, "KIND\tINDEX\tNAME\tUDN\tMODEL")
+		for _, dev := range out.Renderers {
+			fmt.Fprintf(w, "renderer\t%d\t%s\t%s\t%s\n", dev.Index, dev.Name, dev.UDN, dev.ModelName)
+		}
+		for _, dev := range out.Servers {
+			fmt.Fprintf(w, "server\t%d\t%s\t%s\t%s\n", dev.Index, dev.Name, dev.UDN, dev.ModelName)
+		}
+	})
+}
+
+func (ctl *Ctl) info(args []string) error {
+	kind, list, sel := "renderer", ctl.renderers, ctl.renderer
+	if ctl.server != "" && ctl.renderer == "" {
+		kind, list, sel = "server", ctl.servers, ctl.server
+	}
+	dev, e := ctl.findDevice(kind, list, sel)
+	if e != nil {
+		return e
+	}
+	index := 0
+	for i, other := range list() {
+		if other.UDN() == dev.UDN() {
+			index = i
+		}
+	}
+	out := newDeviceJSON(index, dev)
+	return ctl.print(out, func(w io.Writer) {
+		fmt.Fprintf(w, "name:\t%s\n", out.Name)
+		fmt.Fprintf(w, "udn:\t%s\n", out.UDN)
+		fmt.Fprintf(w, "type:\t%s\n", out.DeviceType)
+		fmt.Fprintf(w, "manufacturer:\t%s\n", out.Manufacturer)
+		fmt.Fprintf(w, "model:\t%s %s\n", out.ModelName, out.ModelNumber)
+		fmt.Fprintf(w, "location:\t%s\n", out.Location)
+		for i, srv := range out.Services {
+			label := ""
+			if i == 0 {
+				label = "services:"
+			}
+			fmt.Fprintf(w, "%s\t%s\n", label, srv)
+		}
+	})
+}
+
+//
+//------------------------------------------------------------------[ SERVER ]--
+
+type objectJSON struct {
+	ID         string `json:"id"`
+	ParentID   string `json:"parentID"`
+	Title      string `json:"title"`
+	Class      string `json:"class"`
+	Container  bool   `json:"container"`
+	ChildCount int    `json:"childCount,omitempty"`
+	Artist     string `json:"artist,omitempty"`
+	Album      string `json:"album,omitempty"`
+}
+
+func newObjectJSON(obj upnptype.Object) objectJSON {
+	return objectJSON{
+		ID:       obj.ID,
+		ParentID: obj.ParentID,
+		Title:    obj.Title,
+		Class:    obj.Class,
+		Artist:   obj.Artist,
+		Album:    obj.Album,
+	}
+}
+
+// browseAll returns all the children of a container, containers first.
+func browseAll(srv upnptype.Server, id string) ([]objectJSON, error) {
+	var containers, items []objectJSON
+	for start := uint32(0); ; {
+		res, e := srv.Browse(&upnptype.BrowseRequest{
+			ObjectID:      id,
+			BrowseFlag:    upnptype.BrowseFlagBrowseDirectChildren,
+			Filter:        upnptype.BrowseFilterAll,
+			StartingIndex: start,
+			RequestCount:  uint32(upnptype.MaxBrowse),
+		})
+		if e != nil {
+			return nil, e
+		}
+		for _, cont := range res.Container {
+			obj := newObjectJSON(cont.Object)
+			obj.Container = true
+			obj.ChildCount = cont.ChildCount
+			containers = append(containers, obj)
+		}
+		for _, item := range res.Item {
+			items = append(items, newObjectJSON(item))
+		}
+		count := len(res.Container) + len(res.Item)
+		start += uint32(count)
+		if count == 0 || int32(start) >= res.TotalMatches {
+			break
+		}
+	}
+	return append(append([]objectJSON{}, containers...), items...), nil
+}
+
+func printObjects(list []objectJSON) func(w io.Writer) {
+	return func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tTITLE\tCLASS")
+		for _, obj := range list {
+			title := obj.Title
+			if obj.Container {
+				title += "/"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", obj.ID, title, obj.Class)
+		}
+	}
+}
+
+func (ctl *Ctl) browse(args []string) error {
+	srv, e := ctl.selectServer()
+	if e != nil {
+		return e
+	}
+	id := upnptype.BrowseObjectIDRoot
+	if len(args) > 0 {
+		id = args[0]
+	}
+	list, e := browseAll(srv, id)
+	if e != nil {
+		return e
+	}
+	return ctl.print(list, printObjects(list))
+}
+
+func (ctl *Ctl) search(args []string) error {
+	srv, e := ctl.selectServer()
+	if e != nil {
+		return e
+	}
+	text := strings.ToLower(args[0])
+	id := upnptype.BrowseObjectIDRoot
+	if len(args) > 1 {
+		id = args[1]
+	}
+
+	found := []objectJSON{}
+	containers := []string{id}
+	for depth := 0; depth < searchDepth && len(containers) > 0; depth++ {
+		var next []string
+		for _, cont := range containers {
+			list, e := browseAll(srv, cont)
+			if e != nil {
+				return e
+			}
+			for _, obj := range list {
+				if obj.Container {
+					next = append(next, obj.ID)
+				}
+				for _, field := range []string{obj.Title, obj.Artist, obj.Album} {
+					if field != "" && strings.Contains(strings.ToLower(field), text) {
+						found = append(found, obj)
+						break
+					}
+				}
+			}
+		}
+		containers = next
+	}
+	return ctl.print(found, printObjects(found))
+}
+
+//
+//---------------------------------------------------------------[ TRANSPORT ]--
+
+func (ctl *Ctl) play(args []string) error {
+	rend, e := ctl.selectRenderer()
+	if e != nil {
+		return e
+	}
+	if len(args) == 0 {
+		return rend.Play(0, upnptype.PlaySpeedNormal)
+	}
+
+	target := args[0]
+	if strings.Contains(target, "://") {
+		return ctl.media.PlayURL(target, upnptype.ProbeURL(target))
+	}
+	if info, e := os.Stat(target); e == nil && !info.IsDir() {
+		return ctl.playFile(target)
+	}
+
+	srv, e := ctl.selectServer()
+	if e != nil {
+		return e
+	}
+	_, items, _ := srv.BrowseMetadata(target, 0, 1)
+	if len(items) == 0 || len(items[0].Res) == 0 {
+		return errors.New("not a media item: " + target)
+	}
+	return ctl.media.BrowseMetadata(target, 0)
+}
+
+// playFile plays a local file and serves it until the renderer stops.
+func (ctl *Ctl) playFile(filename string) error {
+	stopped := make(chan struct{})
+	playing := false
+	hook := ctl.media.SubscribeHook(hookName)
+	defer ctl.media.UnsubscribeHook(hookName)
+	hook.OnTransportState = func(_ upnptype.Renderer, state upnptype.PlaybackState) {
+		switch {
+		case state == upnptype.PlaybackStatePlaying:
+			playing = true
+
+		case state == upnptype.PlaybackStateStopped && playing:
+			playing = false
+			close(stopped)
+		}
+	}
+
+	if e := ctl.media.PlayFile(filename); e != nil || !ctl.serveFiles {
+		return e // the shell keeps serving files until it quits.
+	}
+	if !ctl.json {
+		ctl.print(nil, func(w io.Writer) { fmt.Fprintln(w, "serving", filename, "until the playback stops") })
+	}
+	for {
+		select {
+		case <-stopped:
+			return nil
+		default:
+			ctl.Loop(200 * time.Millisecond)
+		}
+	}
+}
+
+func (ctl *Ctl) pause(args []string) error {
+	if _, e := ctl.selectRenderer(); e != nil {
+		return e
+	}
+	return ctl.media.Capabilities().Pause(0)
+}
+
+func (ctl *Ctl) stop(args []string) error {
+	rend, e := ctl.selectRenderer()
+	if e != nil {
+		return e
+	}
+	return rend.Stop(0)
+}
+
+func (ctl *Ctl) next(args []string) error {
+	if _, e := ctl.selectRenderer(); e != nil {
+		return e
+	}
+	return ctl.media.Capabilities().Next(0)
+}
+
+func (ctl *Ctl) prev(args []string) error {
+	if _, e := ctl.selectRenderer(); e != nil {
+		return e
+	}
+	return ctl.media.Capabilities().Previous(0)
+}
+
+func (ctl *Ctl) seek(args []string) error {
+	if _, e := ctl.selectRenderer(); e != nil {
+		return e
+	}
+	pos := args[0]
+	switch {
+	case strings.HasSuffix(pos, "%"):
+		percent, e := strconv.ParseFloat(strings.TrimSuffix(pos, "%"), 64)
+		if e != nil || percent < 0 || percent > 100 {
+			return errors.New("invalid percent: " + pos)
+		}
+		return ctl.media.SeekPercent(percent)
+
+	case strings.HasPrefix(pos, "+"), strings.HasPrefix(pos, "-"):
+		delta, e := strconv.Atoi(pos)
+		if e != nil {
+			return errors.New("invalid seconds: " + pos)
+		}
+		target := ctl.media.GetCurrentTime() + delta
+		if target < 0 {
+			target = 0
+		}
+		return ctl.media.Capabilities().SeekTime(0, target)
+	}
+
+	secs, e := parseTime(pos)
+	if e != nil {
+		return e
+	}
+	return ctl.media.Capabilities().SeekTime(0, secs)
+}
+
+// parseTime parses a position in seconds: 90, 1:30 or 0:01:30.
+func parseTime(str string) (int, error) {
+	fields := strings.Split(str, ":")
+	if len(fields) > 3 {
+		return 0, errors.New("invalid time: " + str)
+	}
+	secs := 0
+	for _, field := range fields {
+		value, e := strconv.Atoi(field)
+		if e != nil || value < 0 {
+			return 0, errors.New("invalid time: " + str)
+		}
+		secs = secs*60 + value
+	}
+	return secs, nil
+}
+
+//
+//---------------------------------------------------------------[ RENDERING ]--
+
+func (ctl *Ctl) volume(args []string) error {
+	rend, e := ctl.selectRenderer()
+	if e != nil {
+		return e
+	}
+	vol, e := rend.GetVolume(0, upnptype.ChannelMaster)
+	if len(args) == 0 {
+		if e != nil {
+			return e
+		}
+		return ctl.print(map[string]uint16{"volume": vol}, func(w io.Writer) { fmt.Fprintln(w, vol) })
+	}
+
+	value, err := strconv.Atoi(args[0])
+	if err != nil {
+		return errors.New("invalid volume: " + args[0])
+	}
+	if strings.HasPrefix(args[0], "+") || strings.HasPrefix(args[0], "-") {
+		if e != nil {
+			return e
+		}
+		value += int(vol)
+	}
+	if value < 0 {
+		value = 0
+	}
+	return ctl.media.Capabilities().SetVolume(0, upnptype.ChannelMaster, uint16(value)) // Max set by quirks.
+}
+
+func (ctl *Ctl) mute(args []string) error {
+	rend, e := ctl.selectRenderer()
+	if e != nil {
+		return e
+	}
+	muted, e := rend.GetMute(0, upnptype.ChannelMaster)
+	if len(args) == 0 {
+		if e != nil {
+			return e
+		}
+		return ctl.print(map[string]bool{"mute": muted}, func(w io.Writer) { fmt.Fprintln(w, muted) })
+	}
+
+	switch args[0] {
+	case "on", "true", "1":
+		muted = true
+
+	case "off", "false", "0":
+		muted = false
+
+	case "toggle":
+		if e != nil {
+			return e
+		}
+		muted = !muted
+
+	default:
+		return errors.New("invalid mute state: " + args[0])
+	}
+	return ctl.media.Capabilities().SetMute(0, upnptype.ChannelMaster, muted)
+}
+
+//
+//------------------------------------------------------------------[ STATUS ]--
+
+type statusJSON struct {
+	Name     string `json:"name"`
+	UDN      string `json:"udn"`
+	State    string `json:"state"`
+	Title    string `json:"title,omitempty"`
+	Artist   string `json:"artist,omitempty"`
+	URI      string `json:"uri,omitempty"`
+	Position int    `json:"position"` // seconds.
+	Duration int    `json:"duration"` // seconds.
+	Volume   uint16 `json:"volume"`
+	Mute     bool   `json:"mute"`
+}
+
+func (ctl *Ctl) status(args []string) error {
+	rend, e := ctl.selectRenderer()
+	if e != nil {
+		return e
+	}
+	transport, e := rend.GetTransportInfo(0)
+	if e != nil {
+		return e
+	}
+	out := statusJSON{
+		Name:  rend.Name(),
+		UDN:   rend.UDN(),
+		State: transport.CurrentTransportState,
+	}
+	if pos, e := rend.GetPositionInfo(0); e == nil {
+		out.URI = pos.TrackURI
+		out.Position = upnptype.TimeToSecond(pos.RelTime)
+		out.Duration = upnptype.TimeToSecond(pos.TrackDuration)
+		if _, items, _ := upnptype.ParseDIDL(pos.TrackMetaData); len(items) > 0 {
+			out.Title = items[0].Title
+			out.Artist = items[0].Artist
+		}
+	}
+	out.Volume, _ = rend.GetVolume(0, upnptype.ChannelMaster)
+	out.Mute, _ = rend.GetMute(0, upnptype.ChannelMaster)
+
+	return ctl.print(out, func(w io.Writer) {
+		fmt.Fprintf(w, "renderer:\t%s (%s)\n", out.Name, out.UDN)
+		fmt.Fprintf(w, "state:\t%s\n", out.State)
+		if out.Title != "" {
+			fmt.Fprintf(w, "title:\t%s\n", strings.TrimSpace(out.Title+" - "+out.Artist))
+		}
+		if out.URI != "" {
+			fmt.Fprintf(w, "uri:\t%s\n", out.URI)
+		}
+		fmt.Fprintf(w, "position:\t%s / %s\n", upnptype.TimeToString(out.Position), upnptype.TimeToString(out.Duration))
+		fmt.Fprintf(w, "volume:\t%d\n", out.Volume)
+		fmt.Fprintf(w, "mute:\t%t\n", out.Mute)
+	})
+}
+
+//
+//-------------------------------------------------------------------[ WATCH ]--
+
+type eventJSON struct {
+	Time   time.Time   `json:"time"`
+	Event  string      `json:"event"`
+	UDN    string      `json:"udn"`
+	Device string      `json:"device"`
+	Value  interface{} `json:"value,omitempty"`
+}
+
+func (ctl *Ctl) event(dev device, name string, value interface{}) {
+	ev := eventJSON{Time: time.Now(), Event: name, UDN: dev.UDN(), Device: dev.Name(), Value: value}
+	ctl.print(ev, func(w io.Writer) {
+		fmt.Fprintf(w, "%s  %s  %s", ev.Time.Format("15:04:05"), ev.Device, ev.Event)
+		if value != nil {
+			fmt.Fprintf(w, "  %v", value)
+		}
+		fmt.Fprintln(w)
+	})
+}
+
+func (ctl *Ctl) watch(args []string) error {
+	var duration time.Duration
+	if len(args) > 0 {
+		var e error
+		if duration, e = time.ParseDuration(args[0]); e != nil {
+			return e
+		}
+	}
+	if _, e := ctl.selectRenderer(); e != nil {
+		return e
+	}
+
+	hook := ctl.media.SubscribeHook(hookName)
+	defer ctl.media.UnsubscribeHook(hookName)
+	hook.OnRendererFound = func(r upnptype.Renderer) { ctl.event(r, "found", nil) }
+	hook.OnRendererLost = func(r upnptype.Renderer) { ctl.event(r, "lost", nil) }
+	hook.OnServerFound = func(s upnptype.Server) { ctl.event(s, "found", nil) }
+	hook.OnServerLost = func(s upnptype.Server) { ctl.event(s, "lost", nil) }
+	hook.OnTransportState = func(r upnptype.Renderer, state upnptype.PlaybackState) {
+		ctl.event(r, "transport", state.String())
+	}
+	hook.OnCurrentTrackDuration = func(r upnptype.Renderer, secs int) { ctl.event(r, "duration", secs) }
+	hook.OnCurrentTrackMetaData = func(r upnptype.Renderer, item *upnptype.Item) {
+		title := ""
+		if item != nil {
+			title = item.Title
+		}
+		ctl.event(r, "track", title)
+	}
+	hook.OnCurrentTime = func(r upnptype.Renderer, secs int, percent float64) { ctl.event(r, "position", secs) }
+	hook.OnVolume = func(r upnptype.Renderer, vol uint) { ctl.event(r, "volume", vol) }
+	hook.OnMute = func(r upnptype.Renderer, mute bool) { ctl.event(r, "mute", mute) }
+
+	const step = time.Second
+	for start := time.Now(); duration <= 0 || time.Since(start) < duration; {
+		left := step
+		if duration > 0 && duration-time.Since(start) < left {
+			left = duration - time.Since(start)
+		}
+		ctl.Loop(left)
+	}
+	return nil
+}
@@ -0,0 +1,733 @@
+package upnpctl
+
+import (
+	"github.com/sqp/gupnp/upnptype"
+
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LineReader reads the lines typed in the interactive shell.
+//
+type LineReader interface {
+	// Prompt displays the prompt and returns the line typed, or io.EOF when
+	// the input is closed.
+	//
+	Prompt(prompt string) (string, error)
+
+	// AppendHistory adds a line to the history.
+	//
+	AppendHistory(line string)
+}
+
+// shellHook is the media hook registered by the shell.
+const shellHook = "upnpctl-shell"
+
+// Complete returns the completions of a line typed in the shell: commands,
+// device names and server paths.
+//
+// Safe to call from the line reader while the shell waits for events, as only
+// the devices and containers already known are used.
+//
+func (ctl *Ctl) Complete(line string) []string {
+	if ctl.sh == nil {
+		return nil
+	}
+	return ctl.sh.complete(line)
+}
+
+func (ctl *Ctl) shell(args []string) error {
+	input := ctl.Input
+	if input == nil {
+		input = &plainReader{in: bufio.NewScanner(os.Stdin), out: ctl.out}
+	}
+	sh := &shell{
+		ctl:   ctl,
+		input: input,
+		cache: make(map[string][]objectJSON),
+	}
+	ctl.sh = sh
+	defer func() { ctl.sh = nil }()
+	return sh.run()
+}
+
+// plainReader reads lines without edition.
+type plainReader struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+func (r *plainReader) Prompt(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	if !r.in.Scan() {
+		if e := r.in.Err(); e != nil {
+			return "", e
+		}
+		return "", io.EOF
+	}
+	return r.in.Text(), nil
+}
+
+func (r *plainReader) AppendHistory(string) {}
+
+//
+//-------------------------------------------------------------------[ SHELL ]--
+
+// shell runs controller commands typed by the user, with a navigation in the
+// server containers and a status of the renderer in the prompt.
+type shell struct {
+	ctl   *Ctl
+	input LineReader
+
+	mu        sync.Mutex              // fields used by completion and hooks.
+	path      []objectJSON            // current container and its parents, root excluded.
+	cache     map[string][]objectJSON // container children indexed by ID.
+	renderers []string                // device names for completion.
+	servers   []string                //
+	status    rendererStatus          // updated by the renderer events.
+}
+
+// rendererStatus defines the renderer state displayed in the prompt.
+type rendererStatus struct {
+	state    upnptype.PlaybackState
+	title    string
+	secs     int
+	duration int
+	volume   uint
+	mute     bool
+}
+
+// shellCommand defines a command handled by the shell itself.
+type shellCommand struct {
+	name string
+	args string
+	help string
+	call func(sh *shell, args []string) error
+}
+
+// shellCommands is set in init, as commands refer to it.
+var shellCommands []*shellCommand
+
+func init() {
+	commands = append(commands, &command{"shell", "", "start an interactive shell", 0, 0, (*Ctl).shell})
+
+	shellCommands = []*shellCommand{
+		{"help", "", "show this help", (*shell).help},
+		{"renderer", "[selector]", "show or select the renderer", (*shell).renderer},
+		{"server", "[selector]", "show or select the server", (*shell).server},
+		{"ls", "[path]", "list a container of the server", (*shell).ls},
+		{"cd", "[path]", "change the current container, / is the root", (*shell).cd},
+		{"pwd", "", "show the current container", (*shell).pwd},
+		{"play", "[path|url|file]", "play a server item, a media URL or a local file, or resume", (*shell).play},
+		{"exit", "", "quit the shell", nil},
+	}
+}
+
+func findShellCommand(name string) *shellCommand {
+	for _, cmd := range shellCommands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// run reads and executes the lines until exit or the end of the input.
+//
+// Lines are read in a goroutine, so the backend events are handled while the
+// user types.
+func (sh *shell) run() error {
+	sh.connectHook()
+	defer sh.ctl.media.UnsubscribeHook(shellHook)
+	sh.ctl.serveFiles = false
+	defer func() { sh.ctl.serveFiles = true }()
+
+	// Initial selection with the -r and -s options, if found.
+	if sh.ctl.renderer != "" {
+		sh.renderer([]string{sh.ctl.renderer})
+	}
+	if sh.ctl.server != "" {
+		sh.server([]string{sh.ctl.server})
+	}
+
+	type input struct {
+		line string
+		e    error
+	}
+	prompts := make(chan string)
+	inputs := make(chan input)
+	defer close(prompts)
+	go func() {
+		for prompt := range prompts {
+			line, e := sh.input.Prompt(prompt)
+			inputs <- input{line, e}
+		}
+	}()
+
+	for {
+		sh.updateNames()
+		prompts <- sh.prompt()
+		var in input
+		for waiting := true; waiting; {
+			select {
+			case in = <-inputs:
+				waiting = false
+			default:
+				sh.ctl.Loop(50 * time.Millisecond)
+			}
+		}
+		if in.e == io.EOF {
+			fmt.Fprintln(sh.ctl.out)
+			return nil
+		}
+		if in.e != nil {
+			return in.e
+		}
+
+		args, e := splitLine(in.line)
+		switch {
+		case e != nil:
+			fmt.Fprintln(sh.ctl.out, "error:", e)
+			continue
+		case len(args) == 0:
+			continue
+		}
+		sh.input.AppendHistory(in.line)
+		if args[0] == "exit" || args[0] == "quit" {
+			return nil
+		}
+		if e := sh.exec(args[0], args[1:]); e != nil {
+			fmt.Fprintln(sh.ctl.out, "error:", e)
+		}
+	}
+}
+
+// exec runs a shell command, or a controller command on the selected devices.
+func (sh *shell) exec(name string, args []string) error {
+	if cmd := findShellCommand(name); cmd != nil {
+		return cmd.call(sh, args)
+	}
+	if name == "shell" {
+		return errors.New("already in the shell")
+	}
+	return sh.execDevices(name, args)
+}
+
+// execDevices runs a controller command on the selected devices.
+func (sh *shell) execDevices(name string, args []string) error {
+	sh.ctl.renderer, sh.ctl.server = "", ""
+	if rend := sh.ctl.media.Renderer(); rend != nil {
+		sh.ctl.renderer = rend.UDN()
+	}
+	if srv := sh.ctl.media.Server(); srv != nil {
+		sh.ctl.server = srv.UDN()
+	}
+	wait := sh.ctl.wait
+	sh.ctl.wait = 0 // devices are discovered while the shell runs.
+	defer func() { sh.ctl.wait = wait }()
+	return sh.ctl.Exec(name, args)
+}
+
+// prompt returns the prompt with the renderer status and the current path.
+func (sh *shell) prompt() string {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	prompt := "no renderer"
+	if rend := sh.ctl.media.Renderer(); rend != nil {
+		st := sh.status
+		prompt = fmt.Sprintf("%s [%s", rend.Name(), strings.ToLower(st.state.String()))
+		if st.state == upnptype.PlaybackStatePlaying || st.state == upnptype.PlaybackStatePaused {
+			prompt += " " + upnptype.TimeToString(st.secs)
+			if st.duration > 0 {
+				prompt += "/" + upnptype.TimeToString(st.duration)
+			}
+		}
+		prompt += fmt.Sprintf(" vol %d", st.volume)
+		if st.mute {
+			prompt += " muted"
+		}
+		prompt += "]"
+		if st.title != "" {
+			prompt += " " + st.title
+		}
+	}
+	if srv := sh.ctl.media.Server(); srv != nil {
+		prompt += " | " + srv.Name() + ":" + pathString(sh.path)
+	}
+	return prompt + "> "
+}
+
+// connectHook updates the status and the cache with the media events.
+func (sh *shell) connectHook() {
+	hook := sh.ctl.media.SubscribeHook(shellHook)
+	update := func(call func(st *rendererStatus)) {
+		sh.mu.Lock()
+		call(&sh.status)
+		sh.mu.Unlock()
+	}
+	hook.OnRendererSelected = func(upnptype.Renderer) {
+		update(func(st *rendererStatus) { *st = rendererStatus{} })
+	}
+	hook.OnTransportState = func(_ upnptype.Renderer, state upnptype.PlaybackState) {
+		update(func(st *rendererStatus) { st.state = state })
+	}
+	hook.OnCurrentTrackMetaData = func(_ upnptype.Renderer, item *upnptype.Item) {
+		update(func(st *rendererStatus) {
+			st.title = ""
+			if item != nil {
+				st.title = item.Title
+			}
+		})
+	}
+	hook.OnCurrentTrackDuration = func(_ upnptype.Renderer, secs int) {
+		update(func(st *rendererStatus) { st.duration = secs })
+	}
+	hook.OnCurrentTime = func(_ upnptype.Renderer, secs int, _ float64) {
+		update(func(st *rendererStatus) { st.secs = secs })
+	}
+	hook.OnVolume = func(_ upnptype.Renderer, vol uint) {
+		update(func(st *rendererStatus) { st.volume = vol })
+	}
+	hook.OnMute = func(_ upnptype.Renderer, mute bool) {
+		update(func(st *rendererStatus) { st.mute = mute })
+	}
+
+	hook.OnServerSelected = func(upnptype.Server) {
+		sh.mu.Lock()
+		sh.path = nil
+		sh.cache = make(map[string][]objectJSON)
+		sh.mu.Unlock()
+	}
+	hook.OnSystemUpdateID = func(upnptype.Server, uint) {
+		sh.mu.Lock()
+		sh.cache = make(map[string][]objectJSON)
+		sh.mu.Unlock()
+	}
+	hook.OnContainerUpdateIDs = func(_ upnptype.Server, ids map[string]uint) {
+		sh.mu.Lock()
+		for id := range ids {
+			delete(sh.cache, id)
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// updateNames refreshes the device names used by the completion.
+func (sh *shell) updateNames() {
+	var rends, srvs []string
+	for _, dev := range sh.ctl.renderers() {
+		rends = append(rends, dev.Name())
+	}
+	for _, dev := range sh.ctl.servers() {
+		srvs = append(srvs, dev.Name())
+	}
+	sh.mu.Lock()
+	sh.renderers, sh.servers = rends, srvs
+	sh.mu.Unlock()
+}
+
+//
+//----------------------------------------------------------------[ COMMANDS ]--
+
+func (sh *shell) help(args []string) error {
+	return sh.ctl.print(nil, func(w io.Writer) {
+		fmt.Fprintln(w, "shell commands:")
+		for _, cmd := range shellCommands {
+			fmt.Fprintf(w, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.help)
+		}
+		fmt.Fprintln(w, "\ncommands on the selected devices:")
+		for _, cmd := range commands {
+			if findShellCommand(cmd.name) == nil && cmd.name != "shell" {
+				fmt.Fprintf(w, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.help)
+			}
+		}
+	})
+}
+
+func (sh *shell) renderer(args []string) error {
+	if len(args) == 0 {
+		rend := sh.ctl.media.Renderer()
+		if rend == nil {
+			return errors.New("no renderer selected")
+		}
+		return sh.ctl.print(nil, func(w io.Writer) { fmt.Fprintf(w, "%s\t%s\n", rend.Name(), rend.UDN()) })
+	}
+	dev, e := matchDevice("renderer", sh.ctl.renderers(), args[0])
+	if e != nil {
+		return e
+	}
+	sh.ctl.media.SetRenderer(dev.UDN())
+	return nil
+}
+
+func (sh *shell) server(args []string) error {
+	if len(args) == 0 {
+		srv := sh.ctl.media.Server()
+		if srv == nil {
+			return errors.New("no server selected")
+		}
+		return sh.ctl.print(nil, func(w io.Writer) { fmt.Fprintf(w, "%s\t%s\n", srv.Name(), srv.UDN()) })
+	}
+	dev, e := matchDevice("server", sh.ctl.servers(), args[0])
+	if e != nil {
+		return e
+	}
+	sh.ctl.media.SetServer(dev.UDN())
+	return nil
+}
+
+func (sh *shell) ls(args []string) error {
+	stack, e := sh.walk(strings.Join(args, " "))
+	if e != nil {
+		return e
+	}
+	list := []objectJSON{}
+	if target := last(stack); target != nil && !target.Container {
+		list = append(list, *target)
+	} else if list, e = sh.children(stackID(stack)); e != nil {
+		return e
+	}
+	if sh.ctl.json {
+		return sh.ctl.print(list, nil)
+	}
+	return sh.ctl.print(nil, func(w io.Writer) {
+		for _, obj := range list {
+			if obj.Container {
+				fmt.Fprintf(w, "%s/\t%d\n", obj.Title, obj.ChildCount)
+			} else {
+				fmt.Fprintf(w, "%s\t%s\n", obj.Title, strings.TrimSpace(obj.Artist+" "+obj.Album))
+			}
+		}
+	})
+}
+
+func (sh *shell) cd(args []string) error {
+	stack, e := sh.walk(strings.Join(args, " "))
+	if len(args) == 0 {
+		stack, e = nil, nil
+	}
+	if e != nil {
+		return e
+	}
+	if target := last(stack); target != nil && !target.Container {
+		return errors.New("not a container: " + target.Title)
+	}
+	if _, e := sh.children(stackID(stack)); e != nil { // fill the cache for the completion.
+		return e
+	}
+	sh.mu.Lock()
+	sh.path = stack
+	sh.mu.Unlock()
+	return nil
+}
+
+func (sh *shell) pwd(args []string) error {
+	sh.mu.Lock()
+	path := pathString(sh.path)
+	sh.mu.Unlock()
+	return sh.ctl.print(map[string]string{"path": path}, func(w io.Writer) { fmt.Fprintln(w, path) })
+}
+
+func (sh *shell) play(args []string) error {
+	if len(args) == 0 || strings.Contains(args[0], "://") {
+		return sh.execDevices("play", args)
+	}
+	if info, e := os.Stat(args[0]); e == nil && !info.IsDir() {
+		return sh.execDevices("play", args)
+	}
+	stack, e := sh.walk(strings.Join(args, " "))
+	if e != nil {
+		return e
+	}
+	target := last(stack)
+	if target == nil || target.Container {
+		return errors.New("not a media item: " + strings.Join(args, " "))
+	}
+	return sh.execDevices("play", []string{target.ID})
+}
+
+//
+//--------------------------------------------------------------[ NAVIGATION ]--
+
+// walk returns the containers stack of a path relative to the current
+// container, or absolute when starting with a slash. The last element is the
+// target, which can be an item. Names are titles or object IDs.
+func (sh *shell) walk(path string) ([]objectJSON, error) {
+	sh.mu.Lock()
+	stack := append([]objectJSON{}, sh.path...)
+	sh.mu.Unlock()
+	if strings.HasPrefix(path, "/") {
+		stack = nil
+	}
+	for _, name := range strings.Split(path, "/") {
+		switch name {
+		case "", ".":
+			continue
+
+		case "..":
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+		if target := last(stack); target != nil && !target.Container {
+			return nil, errors.New("not a container: " + target.Title)
+		}
+		list, e := sh.children(stackID(stack))
+		if e != nil {
+			return nil, e
+		}
+		obj := findChild(list, name)
+		if obj == nil {
+			return nil, errors.New("not found: " + name)
+		}
+		stack = append(stack, *obj)
+	}
+	return stack, nil
+}
+
+// children returns the children of a container, from the cache if possible.
+func (sh *shell) children(id string) ([]objectJSON, error) {
+	sh.mu.Lock()
+	list, ok := sh.cache[id]
+	sh.mu.Unlock()
+	if ok {
+		return list, nil
+	}
+	srv := sh.ctl.media.Server()
+	if srv == nil {
+		return nil, errors.New("no server selected, use: server name")
+	}
+	list, e := browseAll(srv, id)
+	if e != nil {
+		return nil, e
+	}
+	sh.mu.Lock()
+	sh.cache[id] = list
+	sh.mu.Unlock()
+	return list, nil
+}
+
+// findChild returns the object matching the name: title, ID, or title with a
+// different case.
+func findChild(list []objectJSON, name string) *objectJSON {
+	for _, test := range []func(obj *objectJSON) bool{
+		func(obj *objectJSON) bool { return obj.Title == name },
+		func(obj *objectJSON) bool { return obj.ID == name },
+		func(obj *objectJSON) bool { return strings.EqualFold(obj.Title, name) },
+	} {
+		for i := range list {
+			if test(&list[i]) {
+				return &list[i]
+			}
+		}
+	}
+	return nil
+}
+
+func last(stack []objectJSON) *objectJSON {
+	if len(stack) == 0 {
+		return nil
+	}
+	return &stack[len(stack)-1]
+}
+
+func stackID(stack []objectJSON) string {
+	if target := last(stack); target != nil {
+		return target.ID
+	}
+	return upnptype.BrowseObjectIDRoot
+}
+
+func pathString(stack []objectJSON) string {
+	path := ""
+	for _, obj := range stack {
+		path += "/" + obj.Title
+	}
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+//
+//--------------------------------------------------------------[ COMPLETION ]--
+
+// complete returns the full lines completing the line, using the cache only.
+func (sh *shell) complete(line string) []string {
+	args, e := splitLine(line)
+	if e != nil {
+		args, e = splitLine(line + `"`) // completing a quoted word.
+		if e != nil {
+			return nil
+		}
+	}
+	if len(args) == 0 || strings.HasSuffix(line, " ") {
+		args = append(args, "")
+	}
+	word := args[len(args)-1]
+	prefix := line[:lastWordStart(line)]
+
+	var names []string
+	switch {
+	case len(args) == 1:
+		for _, cmd := range shellCommands {
+			names = append(names, cmd.name+" ")
+		}
+		for _, cmd := range commands {
+			if findShellCommand(cmd.name) == nil && cmd.name != "shell" {
+				names = append(names, cmd.name+" ")
+			}
+		}
+
+	case len(args) == 2 && args[0] == "renderer":
+		sh.mu.Lock()
+		names = append(names, sh.renderers...)
+		sh.mu.Unlock()
+
+	case len(args) == 2 && args[0] == "server":
+		sh.mu.Lock()
+		names = append(names, sh.servers...)
+		sh.mu.Unlock()
+
+	case args[0] == "ls" || args[0] == "cd" || args[0] == "play":
+		// Complete the last path element, the path is a single argument.
+		word = strings.Join(args[1:], " ")
+		prefix = args[0] + " "
+		names = sh.completePath(word, args[0] == "cd")
+	}
+
+	sort.Strings(names)
+	var lines []string
+	for _, name := range names {
+		if strings.HasPrefix(strings.ToLower(name), strings.ToLower(word)) {
+			lines = append(lines, prefix+quoteWord(name))
+		}
+	}
+	return lines
+}
+
+// completePath returns the cached paths in the container of the word.
+func (sh *shell) completePath(word string, containersOnly bool) []string {
+	dir := ""
+	if i := strings.LastIndex(word, "/"); i >= 0 {
+		dir = word[:i+1]
+	}
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	stack := append([]objectJSON{}, sh.path...)
+	if strings.HasPrefix(dir, "/") {
+		stack = nil
+	}
+	for _, name := range strings.Split(dir, "/") {
+		switch name {
+		case "", ".":
+			continue
+
+		case "..":
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+		obj := findChild(sh.cache[stackID(stack)], name)
+		if obj == nil {
+			return nil
+		}
+		stack = append(stack, *obj)
+	}
+
+	var names []string
+	for _, obj := range sh.cache[stackID(stack)] {
+		switch {
+		case obj.Container:
+			names = append(names, dir+obj.Title+"/")
+
+		case !containersOnly:
+			names = append(names, dir+obj.Title)
+		}
+	}
+	return names
+}
+
+//
+//-----------------------------------------------------------------[ PARSING ]--
+
+// splitLine splits a line in words separated by spaces. Words can be quoted
+// with double quotes, and characters escaped with a backslash.
+func splitLine(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, quoted, escaped := false, false, false
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+
+		case r == '\\':
+			escaped, inWord = true, true
+
+		case r == '"':
+			quoted, inWord = !quoted, true
+
+		case r == ' ' && !quoted:
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// lastWordStart returns the position of the last word in the line, or the end
+// of the line if it ends with a space.
+func lastWordStart(line string) int {
+	start, quoted, escaped := 0, false, false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+
+		case r == '\\':
+			escaped = true
+
+		case r == '"':
+			quoted = !quoted
+
+		case r == ' ' && !quoted:
+			start = i + 1
+		}
+	}
+	return start
+}
+
+// quoteWord quotes a word containing spaces or special characters.
+func quoteWord(word string) string {
+	trimmed := strings.TrimSuffix(word, " ") // trailing space added to commands.
+	if !strings.ContainsAny(trimmed, " \"\\") {
+		return word
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + replacer.Replace(trimmed) + `"` + word[len(trimmed):]
+}
@@ -0,0 +1,277 @@
+// Package upnpctl implements a command line controller for UPnP media
+// renderers and servers, on top of gupnp.MediaControl.
+//
+// Devices are selected by index (as shown by list), name or UDN prefix:
+//
+//   upnpctl list
+//   upnpctl -s NAS browse 0
+//   upnpctl -r TV play http://host/movie.mp4
+//   upnpctl -r 0 volume +5
+//   upnpctl -json -r uuid:4d69 status
+//
+// The shell command starts an interactive shell, with the renderer status in
+// the prompt and a navigation in the server containers with cd and ls.
+//
+package upnpctl
+
+import (
+	"github.com/sqp/gupnp"
+	"github.com/sqp/gupnp/upnptype"
+
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// DefaultWait is the default time to wait for devices to be discovered.
+//
+const DefaultWait = 3 * time.Second
+
+// Ctl runs controller commands on a media control.
+//
+type Ctl struct {
+	// Loop runs the backend events for the given duration. Defaults to
+	// time.Sleep, for backends running their own event loop.
+	//
+	Loop func(time.Duration)
+
+	// Input reads the lines of the interactive shell. Defaults to reading
+	// stdin without line edition.
+	//
+	Input LineReader
+
+	media *gupnp.MediaControl
+	out   io.Writer
+	outMu sync.Mutex // events may be printed from the backend.
+	sh    *shell     // interactive shell, when running.
+
+	json       bool
+	wait       time.Duration
+	renderer   string // renderer selector.
+	server     string // server selector.
+	serveFiles bool   // wait for the end of the playback of local files.
+}
+
+// New creates a controller writing its results to out.
+//
+func New(media *gupnp.MediaControl, out io.Writer) *Ctl {
+	return &Ctl{
+		Loop:       time.Sleep,
+		media:      media,
+		out:        out,
+		wait:       DefaultWait,
+		serveFiles: true,
+	}
+}
+
+// Run parses the global options and runs the command.
+//
+func (ctl *Ctl) Run(args []string) error {
+	flags := flag.NewFlagSet("upnpctl", flag.ContinueOnError)
+	flags.SetOutput(ctl.out)
+	flags.StringVar(&ctl.renderer, "r", "", "renderer `selector`: index, name or UDN prefix")
+	flags.StringVar(&ctl.server, "s", "", "server `selector`: index, name or UDN prefix")
+	flags.DurationVar(&ctl.wait, "wait", DefaultWait, "time to wait for devices")
+	flags.BoolVar(&ctl.json, "json", false, "JSON output")
+	flags.Usage = func() {
+		fmt.Fprintln(ctl.out, "usage: upnpctl [options] command [args]")
+		fmt.Fprintln(ctl.out, "\noptions:")
+		flags.PrintDefaults()
+		fmt.Fprintln(ctl.out, "\ncommands:")
+		ctl.printCommands()
+	}
+	if e := flags.Parse(args); e != nil {
+		if e == flag.ErrHelp {
+			return nil
+		}
+		return e
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("missing command")
+	}
+	return ctl.Exec(flags.Arg(0), flags.Args()[1:])
+}
+
+// Exec runs a command with its arguments.
+//
+func (ctl *Ctl) Exec(name string, args []string) error {
+	cmd := findCommand(name)
+	if cmd == nil {
+		return errors.New("unknown command: " + name)
+	}
+	if len(args) < cmd.minArgs || (cmd.maxArgs >= 0 && len(args) > cmd.maxArgs) {
+		return fmt.Errorf("usage: %s %s", cmd.name, cmd.args)
+	}
+	return cmd.call(ctl, args)
+}
+
+func (ctl *Ctl) printCommands() {
+	w := tabwriter.NewWriter(ctl.out, 0, 8, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.help)
+	}
+	w.Flush()
+}
+
+//
+//------------------------------------------------------------------[ OUTPUT ]--
+
+// print writes the value as JSON in JSON mode, or calls text with a table
+// writer.
+func (ctl *Ctl) print(value interface{}, text func(w io.Writer)) error {
+	ctl.outMu.Lock()
+	defer ctl.outMu.Unlock()
+	if ctl.json {
+		return json.NewEncoder(ctl.out).Encode(value)
+	}
+	w := tabwriter.NewWriter(ctl.out, 0, 8, 2, ' ', 0)
+	text(w)
+	return w.Flush()
+}
+
+//
+//-----------------------------------------------------------------[ DEVICES ]--
+
+// device defines the common part of renderers and servers.
+type device interface {
+	upnptype.UDNer
+	Name() string
+	DeviceInfo() *upnptype.DeviceInfo
+}
+
+// sortDevices sorts devices by name then UDN, to get stable indexes.
+func sortDevices(list []device) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name() != list[j].Name() {
+			return strings.ToLower(list[i].Name()) < strings.ToLower(list[j].Name())
+		}
+		return list[i].UDN() < list[j].UDN()
+	})
+}
+
+func (ctl *Ctl) renderers() []device {
+	var list []device
+	for _, rend := range ctl.media.Renderers() {
+		list = append(list, rend)
+	}
+	sortDevices(list)
+	return list
+}
+
+func (ctl *Ctl) servers() []device {
+	var list []device
+	for _, srv := range ctl.media.Servers() {
+		list = append(list, srv)
+	}
+	sortDevices(list)
+	return list
+}
+
+// matchDevice returns the device designated by the selector: its index in the
+// list, its name or a prefix of its UDN. An empty selector matches the only
+// device of the list.
+func matchDevice(kind string, list []device, sel string) (device, error) {
+	if sel == "" {
+		switch len(list) {
+		case 0:
+			return nil, errors.New("no " + kind + " found")
+		case 1:
+			return list[0], nil
+		}
+		return nil, fmt.Errorf("%d %ss found, select one", len(list), kind)
+	}
+	if index, e := strconv.Atoi(sel); e == nil {
+		if index < 0 || index >= len(list) {
+			return nil, fmt.Errorf("%s index out of range: %d", kind, index)
+		}
+		return list[index], nil
+	}
+
+	var byName, byUDN []device
+	for _, dev := range list {
+		if strings.EqualFold(dev.Name(), sel) {
+			byName = append(byName, dev)
+		}
+		if strings.HasPrefix(trimUUID(dev.UDN()), trimUUID(sel)) {
+			byUDN = append(byUDN, dev)
+		}
+	}
+	for _, matches := range [][]device{byName, byUDN} {
+		switch len(matches) {
+		case 0:
+			continue
+		case 1:
+			return matches[0], nil
+		}
+		return nil, fmt.Errorf("%s %q is ambiguous: %d matches", kind, sel, len(matches))
+	}
+	return nil, fmt.Errorf("%s not found: %s", kind, sel)
+}
+
+func trimUUID(udn string) string {
+	return strings.TrimPrefix(strings.ToLower(udn), "uuid:")
+}
+
+// waitFor runs the backend events until the test succeeds or the discovery
+// delay expires. Returns the result of the last test.
+func (ctl *Ctl) waitFor(test func() bool) bool {
+	const step = 50 * time.Millisecond
+	deadline := time.Now().Add(ctl.wait)
+	for !test() {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return false
+		}
+		if left > step {
+			left = step
+		}
+		ctl.Loop(left)
+	}
+	return true
+}
+
+// findDevice waits for the device designated by the selector. Numeric and
+// empty selectors wait the full discovery delay, as the list may change.
+func (ctl *Ctl) findDevice(kind string, list func() []device, sel string) (device, error) {
+	_, e := strconv.Atoi(sel)
+	exact := sel != "" && e != nil // name or UDN, other devices don't matter.
+	var dev device
+	ctl.waitFor(func() bool {
+		dev, e = matchDevice(kind, list(), sel)
+		return e == nil && exact
+	})
+	return dev, e
+}
+
+// selectRenderer selects the renderer designated by the -r option.
+func (ctl *Ctl) selectRenderer() (upnptype.Renderer, error) {
+	dev, e := ctl.findDevice("renderer", ctl.renderers, ctl.renderer)
+	if e != nil {
+		return nil, e
+	}
+	if !ctl.media.RendererIsActive(dev.(upnptype.Renderer)) {
+		ctl.media.SetRenderer(dev.UDN())
+	}
+	return ctl.media.Renderer(), nil
+}
+
+// selectServer selects the server designated by the -s option.
+func (ctl *Ctl) selectServer() (upnptype.Server, error) {
+	dev, e := ctl.findDevice("server", ctl.servers, ctl.server)
+	if e != nil {
+		return nil, e
+	}
+	if !ctl.media.ServerIsActive(dev.(upnptype.Server)) {
+		ctl.media.SetServer(dev.UDN())
+	}
+	return ctl.media.Server(), nil
+}
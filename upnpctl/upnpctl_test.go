@@ -0,0 +1,278 @@
+package upnpctl
+
+import (
+	"github.com/sqp/gupnp/gupnptest"
+	"github.com/sqp/gupnp/mocktype"
+	"github.com/sqp/gupnp/upnptype"
+
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestCtl creates a controller with two renderers (Kitchen and TV) and a
+// server with an album.
+func newTestCtl(t *testing.T) (*Ctl, *bytes.Buffer, *mocktype.Renderer) {
+	media, cp, tv := gupnptest.NewMedia(t)
+	cp.AddRenderer(mocktype.NewRenderer("uuid:0a1b-kitchen", "Kitchen"))
+
+	out := &bytes.Buffer{}
+	return New(media, out), out, tv
+}
+
+func run(t *testing.T, ctl *Ctl, out *bytes.Buffer, args ...string) string {
+	out.Reset()
+	if e := ctl.Run(append([]string{"-wait", "0"}, args...)); e != nil {
+		t.Fatalf("%v: %v", args, e)
+	}
+	return out.String()
+}
+
+func TestSelectDevice(t *testing.T) {
+	ctl, _, _ := newTestCtl(t)
+	list := ctl.renderers()
+	for sel, want := range map[string]string{
+		"0":      "Kitchen",
+		"1":      "TV",
+		"tv":     "TV",
+		"uuid:t": "TV",
+		"0A1B":   "Kitchen",
+		"0a1b-k": "Kitchen",
+	} {
+		dev, e := matchDevice("renderer", list, sel)
+		if e != nil || dev.Name() != want {
+			t.Errorf("select %q: want %s, got %v %v", sel, want, dev, e)
+		}
+	}
+	for _, sel := range []string{"", "2", "radio"} {
+		if _, e := matchDevice("renderer", list, sel); e == nil {
+			t.Errorf("select %q: no error", sel)
+		}
+	}
+	if _, e := matchDevice("renderer", list, "uuid:"); e == nil || !strings.Contains(e.Error(), "ambiguous") {
+		t.Errorf("ambiguous UDN prefix: %v", e)
+	}
+}
+
+func TestList(t *testing.T) {
+	ctl, out, _ := newTestCtl(t)
+	var result struct {
+		Renderers []deviceJSON
+		Servers   []deviceJSON
+	}
+	if e := json.Unmarshal([]byte(run(t, ctl, out, "-json", "list")), &result); e != nil {
+		t.Fatal(e)
+	}
+	if len(result.Renderers) != 2 || result.Renderers[1].Name != "TV" || result.Renderers[1].Index != 1 || len(result.Servers) != 1 {
+		t.Errorf("bad list: %+v", result)
+	}
+
+	text := run(t, ctl, out, "list")
+	if !strings.Contains(text, "renderer  1      TV") {
+		t.Errorf("bad text list:\n%s", text)
+	}
+}
+
+func TestBrowseSearch(t *testing.T) {
+	ctl, out, _ := newTestCtl(t)
+	var list []objectJSON
+	json.Unmarshal([]byte(run(t, ctl, out, "-json", "browse", "album/1")), &list)
+	if len(list) != 2 || list[0].ID != "track1" || list[0].Container {
+		t.Errorf("bad browse: %+v", list)
+	}
+
+	list = nil
+	json.Unmarshal([]byte(run(t, ctl, out, "-json", "-s", "nas", "search", "loco")), &list)
+	if len(list) != 1 || list[0].ID != "track2" {
+		t.Errorf("bad search: %+v", list)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	ctl, out, tv := newTestCtl(t)
+	run(t, ctl, out, "-r", "tv", "play", "track2")
+	if st := tv.State(); st.URI != "http://nas/2.mp3" || st.Transport != upnptype.PlaybackStatePlaying {
+		t.Fatalf("item not played: %+v", st)
+	}
+	if e := ctl.Run([]string{"-wait", "0", "-r", "tv", "play", "missing"}); e == nil {
+		t.Error("missing item played")
+	}
+
+	run(t, ctl, out, "-r", "tv", "pause")
+	run(t, ctl, out, "-r", "tv", "seek", "1:30")
+	if st := tv.State(); st.Transport != upnptype.PlaybackStatePaused || st.Position != 90 {
+		t.Errorf("bad pause or seek: %+v", st)
+	}
+	run(t, ctl, out, "-r", "tv", "seek", "-30")
+	if st := tv.State(); st.Position != 60 {
+		t.Errorf("bad relative seek: %d", st.Position)
+	}
+
+	run(t, ctl, out, "-r", "tv", "volume", "+5")
+	run(t, ctl, out, "-r", "tv", "mute", "toggle")
+	if st := tv.State(); st.Volume != 55 || !st.Mute {
+		t.Errorf("bad volume or mute: %+v", st)
+	}
+
+	var status statusJSON
+	json.Unmarshal([]byte(run(t, ctl, out, "-json", "-r", "tv", "status")), &status)
+	if status.State != upnptype.StatePausedPlayback || status.Title != "Locomotion" || status.Position != 60 || status.Volume != 55 || !status.Mute {
+		t.Errorf("bad status: %+v", status)
+	}
+
+	for _, args := range [][]string{{"seek", "x"}, {"volume", "loud"}, {"mute", "maybe"}, {"volume", "1", "2"}, {"dance"}} {
+		if e := ctl.Run(append([]string{"-wait", "0", "-r", "tv"}, args...)); e == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctl, out, tv := newTestCtl(t)
+	ctl.Loop = func(time.Duration) { // events sent by the renderer while watching.
+		st := tv.State()
+		st.Volume = 20
+		tv.SetState(st)
+	}
+	text := run(t, ctl, out, "-json", "-r", "tv", "watch", "1ms")
+
+	var ev eventJSON
+	if e := json.Unmarshal([]byte(text), &ev); e != nil {
+		t.Fatal(e, text)
+	}
+	if ev.Event != "volume" || ev.UDN != tv.UDN() || ev.Value != float64(20) {
+		t.Errorf("bad event: %+v", ev)
+	}
+}
+
+//
+//-------------------------------------------------------------------[ SHELL ]--
+
+// testReader returns the lines of the test and records the prompts.
+type testReader struct {
+	mu      sync.Mutex
+	lines   []string
+	prompts []string
+	history []string
+}
+
+func (r *testReader) Prompt(prompt string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prompts = append(r.prompts, prompt)
+	if len(r.lines) == 0 {
+		return "", io.EOF
+	}
+	line := r.lines[0]
+	r.lines = r.lines[1:]
+	return line, nil
+}
+
+func (r *testReader) AppendHistory(line string) {
+	r.mu.Lock()
+	r.history = append(r.history, line)
+	r.mu.Unlock()
+}
+
+func TestShell(t *testing.T) {
+	ctl, out, tv := newTestCtl(t)
+	ctl.Loop = func(time.Duration) { time.Sleep(time.Millisecond) }
+	input := &testReader{lines: []string{
+		"server nas",
+		"renderer tv",
+		"cd Music",
+		"ls",
+		`cd "Blue Train"`,
+		"pwd",
+		"play Locomotion",
+		"cd ..",
+		"ls Blue Train/moment's notice",
+		"dance",
+		"exit",
+		"pwd",
+	}}
+	ctl.Input = input
+	text := run(t, ctl, out, "shell")
+
+	if st := tv.State(); st.URI != "http://nas/2.mp3" || st.Transport != upnptype.PlaybackStatePlaying {
+		t.Errorf("item not played: %+v", st)
+	}
+	for _, want := range []string{"Blue Train/  2", "/Music/Blue Train\n", "Moment's Notice", "error: unknown command: dance"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("output without %q:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "Locomotion") {
+		t.Errorf("container listed instead of item:\n%s", text)
+	}
+	if len(input.history) != 11 || len(input.lines) != 1 {
+		t.Errorf("bad history or exit: %v", input.history)
+	}
+
+	prompts := input.prompts
+	if prompts[0] != "no renderer> " || prompts[3] != "TV [stopped vol 50] | NAS:/Music> " {
+		t.Errorf("bad prompts: %q", prompts)
+	}
+	if want := "TV [playing 00:00:00 vol 50] | NAS:/Music> "; prompts[len(prompts)-1] != want {
+		t.Errorf("bad prompt: want %q, got %q", want, prompts[len(prompts)-1])
+	}
+}
+
+func TestShellComplete(t *testing.T) {
+	ctl, _, _ := newTestCtl(t)
+	ctl.wait = 0
+	sh := &shell{ctl: ctl, cache: make(map[string][]objectJSON)}
+	sh.updateNames()
+	if e := sh.server([]string{"nas"}); e != nil {
+		t.Fatal(e)
+	}
+	if e := sh.cd([]string{"Music"}); e != nil {
+		t.Fatal(e)
+	}
+	sh.walk("Blue Train/Locomotion") // fill the cache.
+
+	for line, want := range map[string][]string{
+		"pl":                       {"play "},
+		"se":                       {"search ", "seek ", "server "},
+		"renderer k":               {"renderer Kitchen"},
+		"server ":                  {"server NAS"},
+		"cd b":                     {`cd "Blue Train/"`},
+		`cd "Blue Tr`:              {`cd "Blue Train/"`},
+		"ls Blue Train/":           {`ls "Blue Train/Locomotion"`, `ls "Blue Train/Moment's Notice"`},
+		"play /Music/Blue Train/L": {`play "/Music/Blue Train/Locomotion"`},
+		"cd Blue Train/":           nil,
+		"volume ":                  nil,
+	} {
+		got := sh.complete(line)
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("complete %q: want %q, got %q", line, want, got)
+		}
+	}
+}
+
+func TestSplitLine(t *testing.T) {
+	for line, want := range map[string][]string{
+		"":                      nil,
+		"  ls  ":                {"ls"},
+		`cd "Blue Train"`:       {"cd", "Blue Train"},
+		`play Blue\ Train/a\"b`: {"play", `Blue Train/a"b`},
+		`search "" x`:           {"search", "", "x"},
+	} {
+		got, e := splitLine(line)
+		if e != nil || strings.Join(got, "|") != strings.Join(want, "|") || len(got) != len(want) {
+			t.Errorf("split %q: want %q, got %q %v", line, want, got, e)
+		}
+		if len(got) > 0 {
+			if back, _ := splitLine(quoteWord(got[len(got)-1])); len(back) != 1 || back[0] != got[len(got)-1] {
+				t.Errorf("quote %q: got %q", got[len(got)-1], back)
+			}
+		}
+	}
+	if _, e := splitLine(`cd "Blue`); e == nil {
+		t.Error("unterminated quote accepted")
+	}
+}
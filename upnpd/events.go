@@ -0,0 +1,205 @@
+package upnpd
+
+import (
+	"github.com/sqp/gupnp/upnptype"
+
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// eventsHook is the media hook registered by the server.
+const eventsHook = "upnpd"
+
+// eventsBuffer is the number of events queued for a client. Clients too slow
+// to read them are disconnected.
+const eventsBuffer = 64
+
+// eventsPing is the delay between keepalive comments sent to idle clients.
+const eventsPing = 15 * time.Second
+
+// EventJSON describes a media event sent on the event stream.
+//
+// Event is the name of the MediaHook callback without the On prefix, in
+// lower camel case: transportState, volume, rendererFound, serverSelected...
+//
+type EventJSON struct {
+	Time   time.Time   `json:"time"`
+	Event  string      `json:"event"`
+	UDN    string      `json:"udn,omitempty"`    // device of the event, if any.
+	Device string      `json:"device,omitempty"` // device name.
+	Value  interface{} `json:"value,omitempty"`
+}
+
+// eventClient is a client of the event stream, with its filters.
+type eventClient struct {
+	events chan EventJSON
+	types  map[string]bool // accepted event names, all if empty.
+	udns   map[string]bool // accepted devices, all if empty.
+}
+
+func (c *eventClient) accept(ev EventJSON) bool {
+	return (len(c.types) == 0 || c.types[ev.Event]) &&
+		(len(c.udns) == 0 || c.udns[ev.UDN])
+}
+
+// queryList returns the values of a query parameter, repeated or separated
+// by commas.
+func queryList(r *http.Request, key string) map[string]bool {
+	list := make(map[string]bool)
+	for _, value := range r.URL.Query()[key] {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				list[field] = true
+			}
+		}
+	}
+	return list
+}
+
+//
+//--------------------------------------------------------------------[ HOOK ]--
+
+// emit sends the event to the clients accepting it.
+func (s *Server) emit(dev device, name string, value interface{}) {
+	ev := EventJSON{Time: time.Now(), Event: name, Value: value}
+	if dev != nil {
+		ev.UDN, ev.Device = dev.UDN(), dev.Name()
+	}
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	for client := range s.clients {
+		if !client.accept(ev) {
+			continue
+		}
+		select {
+		case client.events <- ev:
+		default: // too slow, disconnected.
+			delete(s.clients, client)
+			close(client.events)
+		}
+	}
+}
+
+// connectEvents forwards all the media events to the event stream.
+func (s *Server) connectEvents() {
+	hook := s.media.SubscribeHook(eventsHook)
+
+	hook.OnRendererFound = func(r upnptype.Renderer) { s.emit(r, "rendererFound", nil) }
+	hook.OnRendererLost = func(r upnptype.Renderer) { s.emit(r, "rendererLost", nil) }
+	hook.OnServerFound = func(srv upnptype.Server) { s.emit(srv, "serverFound", nil) }
+	hook.OnServerLost = func(srv upnptype.Server) { s.emit(srv, "serverLost", nil) }
+
+	hook.OnTransportState = func(r upnptype.Renderer, state upnptype.PlaybackState) {
+		s.emit(r, "transportState", state.String())
+	}
+	hook.OnCurrentTrackDuration = func(r upnptype.Renderer, secs int) {
+		s.emit(r, "currentTrackDuration", secs)
+	}
+	hook.OnCurrentTrackMetaData = func(r upnptype.Renderer, item *upnptype.Item) {
+		var value *ObjectJSON
+		if item != nil {
+			obj := newObjectJSON(item.Object)
+			value = &obj
+		}
+		s.emit(r, "currentTrackMetaData", value)
+	}
+	hook.OnMute = func(r upnptype.Renderer, mute bool) { s.emit(r, "mute", mute) }
+	hook.OnVolume = func(r upnptype.Renderer, vol uint) { s.emit(r, "volume", vol) }
+	hook.OnCurrentTime = func(r upnptype.Renderer, secs int, percent float64) {
+		s.emit(r, "currentTime", map[string]interface{}{"position": secs, "percent": percent})
+	}
+	hook.OnCurrentConnectionIDs = func(r upnptype.Renderer, ids []string) {
+		s.emit(r, "currentConnectionIDs", ids)
+	}
+
+	hook.OnSystemUpdateID = func(srv upnptype.Server, id uint) { s.emit(srv, "systemUpdateID", id) }
+	hook.OnContainerUpdateIDs = func(srv upnptype.Server, ids map[string]uint) {
+		s.emit(srv, "containerUpdateIDs", ids)
+	}
+
+	hook.OnSetVolumeDelta = func(delta int) { s.emit(s.media.Renderer(), "setVolumeDelta", delta) }
+	hook.OnSetSeekDelta = func(delta int) { s.emit(s.media.Renderer(), "setSeekDelta", delta) }
+	hook.OnRendererSelected = func(r upnptype.Renderer) { s.emit(r, "rendererSelected", nil) } // nil when unselected.
+	hook.OnServerSelected = func(srv upnptype.Server) { s.emit(srv, "serverSelected", nil) }
+
+	hook.OnSlideshowState = func(state upnptype.PlaybackState) {
+		s.emit(s.media.Renderer(), "slideshowState", state.String())
+	}
+	hook.OnSlideshowImage = func(index, count int, item *upnptype.Item) {
+		value := map[string]interface{}{"index": index, "count": count}
+		if item != nil {
+			value["item"] = newObjectJSON(item.Object)
+		}
+		s.emit(s.media.Renderer(), "slideshowImage", value)
+	}
+}
+
+//
+//------------------------------------------------------------------[ STREAM ]--
+
+// serveEvents streams the media events as server-sent events, one JSON
+// EventJSON per message.
+//
+// Filters are set with the type and udn query parameters, repeated or
+// separated by commas: /events?type=volume,mute&udn=uuid:4d69...
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodGet) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "streaming unsupported"})
+		return
+	}
+	client := &eventClient{
+		events: make(chan EventJSON, eventsBuffer),
+		types:  queryList(r, "type"),
+		udns:   queryList(r, "udn"),
+	}
+	s.clientsMu.Lock()
+	s.clients[client] = struct{}{}
+	s.clientsMu.Unlock()
+	defer func() {
+		s.clientsMu.Lock()
+		if _, ok := s.clients[client]; ok {
+			delete(s.clients, client)
+			close(client.events)
+		}
+		s.clientsMu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ping := time.NewTicker(eventsPing)
+	defer ping.Stop()
+	for {
+		select {
+		case ev, ok := <-client.events:
+			if !ok {
+				return
+			}
+			data, e := json.Marshal(ev)
+			if e != nil {
+				continue
+			}
+			if _, e := fmt.Fprintf(w, "data: %s\n\n", data); e != nil {
+				return
+			}
+
+		case <-ping.C:
+			if _, e := fmt.Fprint(w, ": ping\n\n"); e != nil {
+				return
+			}
+
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,306 @@
+package upnpd
+
+import "net/http"
+
+func serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(OpenAPI))
+}
+
+// OpenAPI is the OpenAPI 3 description of the REST API, served on
+// /openapi.json.
+//
+const OpenAPI = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "upnpd",
+    "description": "Control UPnP media renderers and browse media servers.",
+    "version": "1.0.0"
+  },
+  "paths": {
+    "/renderers": {
+      "get": {
+        "summary": "List the renderers found.",
+        "operationId": "listRenderers",
+        "responses": {
+          "200": {"description": "Renderers sorted by name.", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Device"}}}}}
+        }
+      }
+    },
+    "/servers": {
+      "get": {
+        "summary": "List the media servers found.",
+        "operationId": "listServers",
+        "responses": {
+          "200": {"description": "Servers sorted by name.", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Device"}}}}}
+        }
+      }
+    },
+    "/renderers/{udn}/state": {
+      "parameters": [{"$ref": "#/components/parameters/udn"}],
+      "get": {
+        "summary": "Get the transport state, track, position and volume of a renderer.",
+        "operationId": "getState",
+        "responses": {
+          "200": {"description": "Renderer state.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/State"}}}},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "502": {"$ref": "#/components/responses/DeviceError"}
+        }
+      }
+    },
+    "/renderers/{udn}/play": {
+      "parameters": [{"$ref": "#/components/parameters/udn"}],
+      "post": {
+        "summary": "Play a media URL or a server item, or resume the playback without body. Playing media selects the renderer.",
+        "operationId": "play",
+        "requestBody": {
+          "required": false,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "properties": {
+              "uri": {"type": "string", "description": "Media URL."},
+              "mimeType": {"type": "string", "description": "Content type of the media URL, guessed from its extension if empty."},
+              "server": {"type": "string", "description": "Server UDN of the item, the selected server if empty."},
+              "id": {"type": "string", "description": "Item ID on the server."}
+            }
+          }}}
+        },
+        "responses": {
+          "204": {"description": "Playback started."},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "502": {"$ref": "#/components/responses/DeviceError"}
+        }
+      }
+    },
+    "/renderers/{udn}/pause": {
+      "parameters": [{"$ref": "#/components/parameters/udn"}],
+      "post": {
+        "summary": "Pause the playback.",
+        "operationId": "pause",
+        "responses": {
+          "204": {"description": "Action sent."},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "502": {"$ref": "#/components/responses/DeviceError"}
+        }
+      }
+    },
+    "/renderers/{udn}/stop": {
+      "parameters": [{"$ref": "#/components/parameters/udn"}],
+      "post": {
+        "summary": "Stop the playback.",
+        "operationId": "stop",
+        "responses": {
+          "204": {"description": "Action sent."},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "502": {"$ref": "#/components/responses/DeviceError"}
+        }
+      }
+    },
+    "/renderers/{udn}/next": {
+      "parameters": [{"$ref": "#/components/parameters/udn"}],
+      "post": {
+        "summary": "Play the next track.",
+        "operationId": "next",
+        "responses": {
+          "204": {"description": "Action sent."},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "502": {"$ref": "#/components/responses/DeviceError"}
+        }
+      }
+    },
+    "/renderers/{udn}/previous": {
+      "parameters": [{"$ref": "#/components/parameters/udn"}],
+      "post": {
+        "summary": "Play the previous track.",
+        "operationId": "previous",
+        "responses": {
+          "204": {"description": "Action sent."},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "502": {"$ref": "#/components/responses/DeviceError"}
+        }
+      }
+    },
+    "/renderers/{udn}/seek": {
+      "parameters": [{"$ref": "#/components/parameters/udn"}],
+      "post": {
+        "summary": "Seek to a position, a percent of the track or relative seconds.",
+        "operationId": "seek",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "properties": {
+              "position": {"type": "integer", "minimum": 0, "description": "Position in seconds."},
+              "percent": {"type": "number", "minimum": 0, "maximum": 100},
+              "delta": {"type": "integer", "description": "Seconds from the current position."}
+            }
+          }}}
+        },
+        "responses": {
+          "204": {"description": "Position changed."},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "502": {"$ref": "#/components/responses/DeviceError"}
+        }
+      }
+    },
+    "/renderers/{udn}/volume": {
+      "parameters": [{"$ref": "#/components/parameters/udn"}],
+      "post": {
+        "summary": "Set the volume, absolute or relative, and the mute state.",
+        "operationId": "volume",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {
+            "type": "object",
+            "properties": {
+              "volume": {"type": "integer", "minimum": 0, "maximum": 100},
+              "delta": {"type": "integer"},
+              "mute": {"type": "boolean"}
+            }
+          }}}
+        },
+        "responses": {
+          "204": {"description": "Volume changed."},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "502": {"$ref": "#/components/responses/DeviceError"}
+        }
+      }
+    },
+    "/renderers/{udn}/select": {
+      "parameters": [{"$ref": "#/components/parameters/udn"}],
+      "post": {
+        "summary": "Select the renderer of the control point, whose events are streamed.",
+        "operationId": "selectRenderer",
+        "responses": {
+          "204": {"description": "Renderer selected."},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      }
+    },
+    "/servers/{udn}/select": {
+      "parameters": [{"$ref": "#/components/parameters/udn"}],
+      "post": {
+        "summary": "Select the server of the control point.",
+        "operationId": "selectServer",
+        "responses": {
+          "204": {"description": "Server selected."},
+          "404": {"$ref": "#/components/responses/NotFound"}
+        }
+      }
+    },
+    "/events": {
+      "get": {
+        "summary": "Stream the media events as server-sent events, one Event per message. Renderer events are sent for the selected renderer.",
+        "operationId": "events",
+        "parameters": [
+          {"name": "type", "in": "query", "description": "Accepted event names, all if empty.", "schema": {"type": "array", "items": {"type": "string"}}, "style": "form", "explode": false, "example": ["transportState", "volume"]},
+          {"name": "udn", "in": "query", "description": "Accepted device UDNs, all if empty.", "schema": {"type": "array", "items": {"type": "string"}}, "style": "form", "explode": false}
+        ],
+        "responses": {
+          "200": {"description": "Event stream.", "content": {"text/event-stream": {"schema": {"$ref": "#/components/schemas/Event"}}}}
+        }
+      }
+    },
+    "/servers/{udn}/browse/{id}": {
+      "parameters": [
+        {"$ref": "#/components/parameters/udn"},
+        {"name": "id", "in": "path", "required": true, "description": "Container ID, 0 is the root.", "schema": {"type": "string"}},
+        {"name": "start", "in": "query", "schema": {"type": "integer", "minimum": 0, "default": 0}},
+        {"name": "count", "in": "query", "schema": {"type": "integer", "minimum": 0, "default": 64}, "description": "Objects requested, 0 for all."}
+      ],
+      "get": {
+        "summary": "List the children of a container.",
+        "operationId": "browse",
+        "responses": {
+          "200": {"description": "Page of children, containers first.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Browse"}}}},
+          "400": {"$ref": "#/components/responses/BadRequest"},
+          "404": {"$ref": "#/components/responses/NotFound"},
+          "502": {"$ref": "#/components/responses/DeviceError"}
+        }
+      }
+    }
+  },
+  "components": {
+    "parameters": {
+      "udn": {"name": "udn", "in": "path", "required": true, "description": "Device UDN.", "schema": {"type": "string"}, "example": "uuid:4d696e69-444c-164e-9d41-b827eb54e5ee"}
+    },
+    "responses": {
+      "BadRequest": {"description": "Invalid request.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "NotFound": {"description": "Device not found.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+      "DeviceError": {"description": "The device failed to answer.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+    },
+    "schemas": {
+      "Error": {"type": "object", "properties": {"error": {"type": "string"}}},
+      "Device": {
+        "type": "object",
+        "properties": {
+          "udn": {"type": "string"},
+          "name": {"type": "string"},
+          "manufacturer": {"type": "string"},
+          "modelName": {"type": "string"},
+          "selected": {"type": "boolean", "description": "Active device of the control point."}
+        }
+      },
+      "State": {
+        "type": "object",
+        "properties": {
+          "udn": {"type": "string"},
+          "name": {"type": "string"},
+          "state": {"type": "string", "enum": ["STOPPED", "PLAYING", "TRANSITIONING", "PAUSED_PLAYBACK", "PAUSED_RECORDING", "RECORDING", "NO_MEDIA_PRESENT"]},
+          "title": {"type": "string"},
+          "artist": {"type": "string"},
+          "album": {"type": "string"},
+          "albumArt": {"type": "string"},
+          "uri": {"type": "string"},
+          "position": {"type": "integer", "description": "Seconds."},
+          "duration": {"type": "integer", "description": "Seconds."},
+          "volume": {"type": "integer"},
+          "mute": {"type": "boolean"}
+        }
+      },
+      "Object": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "parentID": {"type": "string"},
+          "title": {"type": "string"},
+          "class": {"type": "string", "example": "object.item.audioItem.musicTrack"},
+          "container": {"type": "boolean"},
+          "childCount": {"type": "integer"},
+          "artist": {"type": "string"},
+          "album": {"type": "string"},
+          "genre": {"type": "string"},
+          "albumArt": {"type": "string"}
+        }
+      },
+      "Event": {
+        "type": "object",
+        "properties": {
+          "time": {"type": "string", "format": "date-time"},
+          "event": {"type": "string", "enum": [
+            "rendererFound", "rendererLost", "serverFound", "serverLost",
+            "transportState", "currentTrackDuration", "currentTrackMetaData", "mute", "volume", "currentTime", "currentConnectionIDs",
+            "systemUpdateID", "containerUpdateIDs",
+            "setVolumeDelta", "setSeekDelta", "rendererSelected", "serverSelected",
+            "slideshowState", "slideshowImage"
+          ]},
+          "udn": {"type": "string", "description": "Device of the event, if any."},
+          "device": {"type": "string", "description": "Device name."},
+          "value": {"description": "Event value: transport state name, seconds, volume, Object for currentTrackMetaData, {position, percent} for currentTime, {index, count, item} for slideshowImage."}
+        }
+      },
+      "Browse": {
+        "type": "object",
+        "properties": {
+          "objects": {"type": "array", "items": {"$ref": "#/components/schemas/Object"}},
+          "start": {"type": "integer"},
+          "total": {"type": "integer"}
+        }
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,606 @@
+// Package upnpd exposes a gupnp.MediaControl with a HTTP/JSON REST API, for
+// clients that can't link the cgo backend.
+//
+// Devices are designated by their UDN:
+//
+//   GET  /renderers                     renderers found.
+//   GET  /servers                       servers found.
+//   GET  /renderers/{udn}/state         transport state, track, volume.
+//   POST /renderers/{udn}/play          {"uri": url} or {"server": udn, "id": id}, or resume.
+//   POST /renderers/{udn}/pause
+//   POST /renderers/{udn}/stop
+//   POST /renderers/{udn}/next
+//   POST /renderers/{udn}/previous
+//   POST /renderers/{udn}/seek          {"position": secs}, {"percent": 50} or {"delta": -10}.
+//   POST /renderers/{udn}/volume        {"volume": 20} or {"delta": 5}, and {"mute": true}.
+//   POST /renderers/{udn}/select        selects the renderer of the media control.
+//   GET  /servers/{udn}/browse/{id}     children of a container, ?start=0&count=50.
+//   POST /servers/{udn}/select          selects the server of the media control.
+//   GET  /events                        event stream, ?type=volume,mute&udn=uuid:...
+//   GET  /openapi.json                  OpenAPI description of the API.
+//   GET  /                              web remote.
+//
+// Errors are returned as {"error": message}.
+//
+// The events of the MediaHook are streamed as server-sent events, each message
+// being an EventJSON. Renderer events are only sent by the media control for
+// the selected renderer.
+//
+package upnpd
+
+import (
+	"github.com/sqp/gupnp"
+	"github.com/sqp/gupnp/upnptype"
+
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultAddress is the default listen address of the daemon.
+//
+const DefaultAddress = ":8058"
+
+// Errors returned by the API.
+//
+var (
+	ErrNotFound   = errors.New("device not found")
+	ErrBadRequest = errors.New("bad request")
+)
+
+// Server serves the REST API of a media control.
+//
+type Server struct {
+	// Call runs fn with exclusive access to the backend. Backends iterating
+	// their own event loop must run fn in it. Defaults to a direct call
+	// guarded by a mutex.
+	//
+	Call func(fn func())
+
+	media *gupnp.MediaControl
+	mux   *http.ServeMux
+	mu    sync.Mutex
+
+	clients   map[*eventClient]struct{} // event stream clients.
+	clientsMu sync.Mutex
+}
+
+// New creates a REST API server for the media control.
+//
+func New(media *gupnp.MediaControl) *Server {
+	s := &Server{
+		media:   media,
+		mux:     http.NewServeMux(),
+		clients: make(map[*eventClient]struct{}),
+	}
+	s.Call = s.lockedCall
+	s.connectEvents()
+
+	s.mux.HandleFunc("/renderers", s.get(s.renderers))
+	s.mux.HandleFunc("/servers", s.get(s.servers))
+	s.mux.HandleFunc("/renderers/", s.serveRenderer)
+	s.mux.HandleFunc("/servers/", s.serveServer)
+	s.mux.HandleFunc("/events", s.serveEvents)
+	s.mux.HandleFunc("/openapi.json", serveOpenAPI)
+	s.mux.Handle("/", webui())
+	return s
+}
+
+// Handle registers an additional handler on the server.
+//
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// ServeHTTP dispatches the request to the API handlers.
+//
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) lockedCall(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn()
+}
+
+//
+//----------------------------------------------------------------[ HANDLERS ]--
+
+// handlerFunc handles an API request, returning the value to send as JSON.
+type handlerFunc func(r *http.Request) (interface{}, error)
+
+// rendererRoute defines an API request on a renderer: /renderers/{udn}/name.
+type rendererRoute struct {
+	method string
+	call   func(s *Server, rend upnptype.Renderer, r *http.Request) (interface{}, error)
+}
+
+var rendererRoutes = map[string]rendererRoute{
+	"state":    {http.MethodGet, (*Server).state},
+	"play":     {http.MethodPost, (*Server).play},
+	"pause":    {http.MethodPost, (*Server).pause},
+	"stop":     {http.MethodPost, (*Server).stop},
+	"next":     {http.MethodPost, (*Server).next},
+	"previous": {http.MethodPost, (*Server).previous},
+	"seek":     {http.MethodPost, (*Server).seek},
+	"volume":   {http.MethodPost, (*Server).volume},
+	"select":   {http.MethodPost, (*Server).selectRenderer},
+}
+
+// serve runs the handler in the backend with Call and sends its result.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, call handlerFunc) {
+	var value interface{}
+	var e error
+	s.Call(func() { value, e = call(r) })
+	if e != nil {
+		writeError(w, e)
+		return
+	}
+	if value == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, value)
+}
+
+// get returns a handler accepting only the GET method.
+func (s *Server) get(call handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkMethod(w, r, http.MethodGet) {
+			s.serve(w, r, call)
+		}
+	}
+}
+
+// serveRenderer dispatches the /renderers/{udn}/name requests.
+func (s *Server) serveRenderer(w http.ResponseWriter, r *http.Request) {
+	udn, name := splitPath(r.URL.Path, "/renderers/")
+	route, ok := rendererRoutes[name]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown request: " + name})
+		return
+	}
+	if !checkMethod(w, r, route.method) {
+		return
+	}
+	s.serve(w, r, func(r *http.Request) (interface{}, error) {
+		rend := s.media.GetRenderer(udn)
+		if rend == nil {
+			return nil, ErrNotFound
+		}
+		return route.call(s, rend, r)
+	})
+}
+
+// serveServer dispatches the /servers/{udn}/browse/{id} and select requests.
+func (s *Server) serveServer(w http.ResponseWriter, r *http.Request) {
+	udn, rest := splitPath(r.URL.Path, "/servers/")
+	name, id := rest, ""
+	if i := strings.Index(rest, "/"); i >= 0 {
+		name, id = rest[:i], rest[i+1:] // IDs can contain slashes.
+	}
+	method := http.MethodGet
+	switch name {
+	case "browse":
+	case "select":
+		method = http.MethodPost
+
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown request: " + name})
+		return
+	}
+	if !checkMethod(w, r, method) {
+		return
+	}
+	s.serve(w, r, func(r *http.Request) (interface{}, error) {
+		srv := s.media.GetServer(udn)
+		if srv == nil {
+			return nil, ErrNotFound
+		}
+		if name == "select" {
+			return s.selectServer(srv)
+		}
+		return s.browse(srv, id, r)
+	})
+}
+
+// splitPath returns the device UDN and the rest of the path after the prefix.
+func splitPath(path, prefix string) (udn, rest string) {
+	path = strings.TrimPrefix(path, prefix)
+	if i := strings.Index(path, "/"); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
+
+func checkMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed: " + r.Method})
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError sends the error with a status matching its origin: the request,
+// or the device when it failed to answer.
+func writeError(w http.ResponseWriter, e error) {
+	status := http.StatusBadGateway
+	switch {
+	case e == ErrNotFound:
+		status = http.StatusNotFound
+
+	case errors.Is(e, ErrBadRequest):
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, map[string]string{"error": e.Error()})
+}
+
+// badRequest returns an error sent with a bad request status.
+func badRequest(msg string) error {
+	return errorString{msg}
+}
+
+type errorString struct{ msg string }
+
+func (e errorString) Error() string        { return e.msg }
+func (e errorString) Is(target error) bool { return target == ErrBadRequest }
+
+// decodeBody decodes the JSON body of the request, if any.
+func decodeBody(r *http.Request, value interface{}) error {
+	if r.Body == nil || r.ContentLength == 0 {
+		return nil
+	}
+	if e := json.NewDecoder(r.Body).Decode(value); e != nil {
+		return badRequest("invalid body: " + e.Error())
+	}
+	return nil
+}
+
+//
+//-----------------------------------------------------------------[ DEVICES ]--
+
+// DeviceJSON describes a renderer or a server.
+//
+type DeviceJSON struct {
+	UDN          string `json:"udn"`
+	Name         string `json:"name"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	ModelName    string `json:"modelName,omitempty"`
+	Selected     bool   `json:"selected"` // active device of the media control.
+}
+
+type device interface {
+	upnptype.UDNer
+	Name() string
+	DeviceInfo() *upnptype.DeviceInfo
+}
+
+func newDeviceJSON(dev device, selected bool) DeviceJSON {
+	out := DeviceJSON{UDN: dev.UDN(), Name: dev.Name(), Selected: selected}
+	if info := dev.DeviceInfo(); info != nil {
+		out.Manufacturer = info.Manufacturer
+		out.ModelName = info.ModelName
+	}
+	return out
+}
+
+func sortDevices(list []DeviceJSON) []DeviceJSON {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name)
+		}
+		return list[i].UDN < list[j].UDN
+	})
+	return list
+}
+
+func (s *Server) renderers(r *http.Request) (interface{}, error) {
+	list := []DeviceJSON{}
+	for _, rend := range s.media.Renderers() {
+		list = append(list, newDeviceJSON(rend, s.media.RendererIsActive(rend)))
+	}
+	return sortDevices(list), nil
+}
+
+func (s *Server) servers(r *http.Request) (interface{}, error) {
+	list := []DeviceJSON{}
+	for _, srv := range s.media.Servers() {
+		list = append(list, newDeviceJSON(srv, s.media.ServerIsActive(srv)))
+	}
+	return sortDevices(list), nil
+}
+
+//
+//---------------------------------------------------------------[ RENDERERS ]--
+
+// StateJSON describes the state of a renderer.
+//
+type StateJSON struct {
+	UDN      string `json:"udn"`
+	Name     string `json:"name"`
+	State    string `json:"state"` // UPnP transport state: PLAYING, STOPPED...
+	Title    string `json:"title,omitempty"`
+	Artist   string `json:"artist,omitempty"`
+	Album    string `json:"album,omitempty"`
+	AlbumArt string `json:"albumArt,omitempty"`
+	URI      string `json:"uri,omitempty"`
+	Position int    `json:"position"` // seconds.
+	Duration int    `json:"duration"` // seconds.
+	Volume   uint16 `json:"volume"`
+	Mute     bool   `json:"mute"`
+}
+
+func (s *Server) state(rend upnptype.Renderer, r *http.Request) (interface{}, error) {
+	transport, e := rend.GetTransportInfo(0)
+	if e != nil {
+		return nil, e
+	}
+	out := StateJSON{
+		UDN:   rend.UDN(),
+		Name:  rend.Name(),
+		State: transport.CurrentTransportState,
+	}
+	if pos, e := rend.GetPositionInfo(0); e == nil {
+		out.URI = pos.TrackURI
+		out.Position = upnptype.TimeToSecond(pos.RelTime)
+		out.Duration = upnptype.TimeToSecond(pos.TrackDuration)
+		if _, items, _ := upnptype.ParseDIDL(pos.TrackMetaData); len(items) > 0 {
+			out.Title = items[0].Title
+			out.Artist = items[0].Artist
+			out.Album = items[0].Album
+			out.AlbumArt = items[0].AlbumArt
+		}
+	}
+	out.Volume, _ = rend.GetVolume(0, upnptype.ChannelMaster)
+	out.Mute, _ = rend.GetMute(0, upnptype.ChannelMaster)
+	return out, nil
+}
+
+// play plays a media URL or a server item, or resumes the playback.
+//
+// Media are sent with the media control, which selects the renderer.
+func (s *Server) play(rend upnptype.Renderer, r *http.Request) (interface{}, error) {
+	var req struct {
+		URI      string `json:"uri"`
+		MimeType string `json:"mimeType"`
+		Server   string `json:"server"`
+		ID       string `json:"id"`
+	}
+	if e := decodeBody(r, &req); e != nil {
+		return nil, e
+	}
+	switch {
+	case req.URI != "":
+		s.selectRenderer(rend, r)
+		return nil, s.media.PlayURL(req.URI, req.MimeType)
+
+	case req.ID != "":
+		srv := s.media.Server()
+		if req.Server != "" {
+			srv = s.media.GetServer(req.Server)
+		}
+		if srv == nil {
+			return nil, ErrNotFound
+		}
+		if _, items, _ := srv.BrowseMetadata(req.ID, 0, 1); len(items) == 0 || len(items[0].Res) == 0 {
+			return nil, badRequest("not a media item: " + req.ID)
+		}
+		s.selectRenderer(rend, r)
+		if !s.media.ServerIsActive(srv) {
+			s.media.SetServer(srv.UDN())
+		}
+		return nil, s.media.BrowseMetadata(req.ID, 0)
+	}
+	return nil, rend.Play(0, upnptype.PlaySpeedNormal)
+}
+
+// selectRenderer makes the renderer the active renderer of the media control,
+// sending its events on the event stream.
+func (s *Server) selectRenderer(rend upnptype.Renderer, r *http.Request) (interface{}, error) {
+	if !s.media.RendererIsActive(rend) {
+		s.media.SetRenderer(rend.UDN())
+	}
+	return nil, nil
+}
+
+func (s *Server) pause(rend upnptype.Renderer, r *http.Request) (interface{}, error) {
+	return nil, upnptype.NewCapableRenderer(rend).Pause(0)
+}
+
+func (s *Server) stop(rend upnptype.Renderer, r *http.Request) (interface{}, error) {
+	return nil, upnptype.NewCapableRenderer(rend).Stop(0)
+}
+
+func (s *Server) next(rend upnptype.Renderer, r *http.Request) (interface{}, error) {
+	return nil, upnptype.NewCapableRenderer(rend).Next(0)
+}
+
+func (s *Server) previous(rend upnptype.Renderer, r *http.Request) (interface{}, error) {
+	return nil, upnptype.NewCapableRenderer(rend).Previous(0)
+}
+
+func (s *Server) seek(rend upnptype.Renderer, r *http.Request) (interface{}, error) {
+	var req struct {
+		Position *int     `json:"position"`
+		Percent  *float64 `json:"percent"`
+		Delta    *int     `json:"delta"`
+	}
+	if e := decodeBody(r, &req); e != nil {
+		return nil, e
+	}
+	var target int
+	switch {
+	case req.Position != nil:
+		target = *req.Position
+
+	case req.Percent != nil, req.Delta != nil:
+		pos, e := rend.GetPositionInfo(0)
+		if e != nil {
+			return nil, e
+		}
+		if req.Percent != nil {
+			if *req.Percent < 0 || *req.Percent > 100 {
+				return nil, badRequest("invalid percent")
+			}
+			target = int(*req.Percent * float64(upnptype.TimeToSecond(pos.TrackDuration)) / 100)
+		} else {
+			current := pos.RelTime
+			if !strings.Contains(current, ":") { // NOT_IMPLEMENTED on some renderers.
+				current = pos.AbsTime
+			}
+			target = upnptype.TimeToSecond(current) + *req.Delta
+		}
+
+	default:
+		return nil, badRequest("missing position, percent or delta")
+	}
+	if target < 0 {
+		target = 0
+	}
+	return nil, upnptype.NewCapableRenderer(rend).SeekTime(0, target)
+}
+
+func (s *Server) volume(rend upnptype.Renderer, r *http.Request) (interface{}, error) {
+	var req struct {
+		Volume *int  `json:"volume"`
+		Delta  *int  `json:"delta"`
+		Mute   *bool `json:"mute"`
+	}
+	if e := decodeBody(r, &req); e != nil {
+		return nil, e
+	}
+	if req.Volume == nil && req.Delta == nil && req.Mute == nil {
+		return nil, badRequest("missing volume, delta or mute")
+	}
+	cr := upnptype.NewCapableRenderer(rend)
+	if req.Volume != nil || req.Delta != nil {
+		var value int
+		if req.Volume != nil {
+			value = *req.Volume
+		} else {
+			vol, e := rend.GetVolume(0, upnptype.ChannelMaster)
+			if e != nil {
+				return nil, e
+			}
+			value = int(vol) + *req.Delta
+		}
+		if value < 0 {
+			value = 0
+		}
+		if e := cr.SetVolume(0, upnptype.ChannelMaster, uint16(value)); e != nil { // Max set by quirks.
+			return nil, e
+		}
+	}
+	if req.Mute != nil {
+		return nil, cr.SetMute(0, upnptype.ChannelMaster, *req.Mute)
+	}
+	return nil, nil
+}
+
+//
+//-----------------------------------------------------------------[ SERVERS ]--
+
+// ObjectJSON describes a container or an item of a server.
+//
+type ObjectJSON struct {
+	ID         string `json:"id"`
+	ParentID   string `json:"parentID"`
+	Title      string `json:"title"`
+	Class      string `json:"class"`
+	Container  bool   `json:"container"`
+	ChildCount int    `json:"childCount,omitempty"`
+	Artist     string `json:"artist,omitempty"`
+	Album      string `json:"album,omitempty"`
+	Genre      string `json:"genre,omitempty"`
+	AlbumArt   string `json:"albumArt,omitempty"`
+}
+
+func newObjectJSON(obj upnptype.Object) ObjectJSON {
+	return ObjectJSON{
+		ID:       obj.ID,
+		ParentID: obj.ParentID,
+		Title:    obj.Title,
+		Class:    obj.Class,
+		Artist:   obj.Artist,
+		Album:    obj.Album,
+		Genre:    obj.Genre,
+		AlbumArt: obj.AlbumArt,
+	}
+}
+
+// BrowseJSON is a page of the children of a container.
+//
+type BrowseJSON struct {
+	Objects []ObjectJSON `json:"objects"`
+	Start   int          `json:"start"`
+	Total   int          `json:"total"` // children of the container.
+}
+
+func (s *Server) browse(srv upnptype.Server, id string, r *http.Request) (interface{}, error) {
+	if id == "" {
+		id = upnptype.BrowseObjectIDRoot
+	}
+	start, e := queryInt(r, "start", 0)
+	if e != nil {
+		return nil, e
+	}
+	count, e := queryInt(r, "count", upnptype.MaxBrowse)
+	if e != nil {
+		return nil, e
+	}
+
+	res, e := srv.Browse(&upnptype.BrowseRequest{
+		ObjectID:      id,
+		BrowseFlag:    upnptype.BrowseFlagBrowseDirectChildren,
+		Filter:        upnptype.BrowseFilterAll,
+		StartingIndex: uint32(start),
+		RequestCount:  uint32(count),
+	})
+	if e != nil {
+		return nil, e
+	}
+	out := BrowseJSON{Objects: []ObjectJSON{}, Start: start, Total: int(res.TotalMatches)}
+	for _, cont := range res.Container {
+		obj := newObjectJSON(cont.Object)
+		obj.Container = true
+		obj.ChildCount = cont.ChildCount
+		out.Objects = append(out.Objects, obj)
+	}
+	for _, item := range res.Item {
+		out.Objects = append(out.Objects, newObjectJSON(item))
+	}
+	return out, nil
+}
+
+// selectServer makes the server the active server of the media control.
+func (s *Server) selectServer(srv upnptype.Server) (interface{}, error) {
+	if !s.media.ServerIsActive(srv) {
+		s.media.SetServer(srv.UDN())
+	}
+	return nil, nil
+}
+
+// queryInt returns the positive integer value of a query parameter.
+func queryInt(r *http.Request, key string, def int) (int, error) {
+	str := r.URL.Query().Get(key)
+	if str == "" {
+		return def, nil
+	}
+	value, e := strconv.Atoi(str)
+	if e != nil || value < 0 {
+		return 0, badRequest("invalid " + key + ": " + str)
+	}
+	return value, nil
+}
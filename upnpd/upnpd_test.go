@@ -0,0 +1,226 @@
+package upnpd
+
+import (
+	"github.com/sqp/gupnp"
+	"github.com/sqp/gupnp/gupnptest"
+	"github.com/sqp/gupnp/mocktype"
+	"github.com/sqp/gupnp/upnptype"
+
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestServer creates an API server with two renderers (Kitchen and TV) and
+// a server with an album.
+func newTestServer(t *testing.T) (*httptest.Server, *Server, *gupnp.MediaControl, *mocktype.Renderer) {
+	media, cp, tv := gupnptest.NewMedia(t)
+	cp.AddRenderer(mocktype.NewRenderer("uuid:kitchen", "Kitchen"))
+
+	api := New(media)
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	return srv, api, media, tv
+}
+
+// request sends a request and decodes the JSON answer in out.
+func request(t *testing.T, srv *httptest.Server, method, path, body string, out interface{}) int {
+	req, e := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if e != nil {
+		t.Fatal(e)
+	}
+	resp, e := http.DefaultClient.Do(req)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if e := json.NewDecoder(resp.Body).Decode(out); e != nil {
+			t.Errorf("%s %s: %v", method, path, e)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestDevices(t *testing.T) {
+	srv, _, media, _ := newTestServer(t)
+	media.SetRenderer("uuid:tv")
+
+	var list []DeviceJSON
+	if code := request(t, srv, "GET", "/renderers", "", &list); code != http.StatusOK {
+		t.Fatal("renderers status", code)
+	}
+	if len(list) != 2 || list[0].Name != "Kitchen" || list[0].Selected || list[1].UDN != "uuid:tv" || !list[1].Selected {
+		t.Errorf("bad renderers: %+v", list)
+	}
+	list = nil
+	request(t, srv, "GET", "/servers", "", &list)
+	if len(list) != 1 || list[0].Name != "NAS" {
+		t.Errorf("bad servers: %+v", list)
+	}
+
+	var browse BrowseJSON
+	request(t, srv, "GET", "/servers/uuid:nas/browse/album/1?count=1", "", &browse)
+	if browse.Total != 2 || len(browse.Objects) != 1 || browse.Objects[0].ID != "track1" || browse.Objects[0].Container {
+		t.Errorf("bad browse: %+v", browse)
+	}
+	browse = BrowseJSON{}
+	request(t, srv, "GET", "/servers/uuid:nas/browse/", "", &browse)
+	if len(browse.Objects) != 1 || !browse.Objects[0].Container || browse.Objects[0].ChildCount != 1 {
+		t.Errorf("bad root browse: %+v", browse)
+	}
+
+	var apiErr struct{ Error string }
+	for path, want := range map[string]int{
+		"/servers/uuid:nas/browse/missing":   http.StatusBadGateway,
+		"/servers/uuid:nas/browse/0?start=x": http.StatusBadRequest,
+		"/servers/uuid:none/browse/0":        http.StatusNotFound,
+		"/renderers/uuid:none/state":         http.StatusNotFound,
+	} {
+		if code := request(t, srv, "GET", path, "", &apiErr); code != want || apiErr.Error == "" {
+			t.Errorf("%s: want %d, got %d %q", path, want, code, apiErr.Error)
+		}
+	}
+
+	var doc map[string]interface{}
+	if code := request(t, srv, "GET", "/openapi.json", "", &doc); code != http.StatusOK || doc["openapi"] == nil {
+		t.Errorf("bad OpenAPI description: %d", code)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	srv, _, media, tv := newTestServer(t)
+	post := func(path, body string) {
+		var apiErr struct{ Error string }
+		if code := request(t, srv, "POST", "/renderers/uuid:tv"+path, body, nil); code != http.StatusNoContent {
+			request(t, srv, "POST", "/renderers/uuid:tv"+path, body, &apiErr)
+			t.Fatalf("%s %s: status %d %s", path, body, code, apiErr.Error)
+		}
+	}
+
+	post("/play", `{"server": "uuid:nas", "id": "track2"}`)
+	if st := tv.State(); st.URI != "http://nas/2.mp3" || st.Transport != upnptype.PlaybackStatePlaying {
+		t.Fatalf("item not played: %+v", st)
+	}
+	if rend := media.Renderer(); rend == nil || rend.UDN() != "uuid:tv" {
+		t.Error("renderer not selected")
+	}
+
+	post("/pause", "")
+	post("/seek", `{"position": 90}`)
+	post("/seek", `{"delta": -30}`)
+	post("/volume", `{"delta": 5, "mute": true}`)
+	if st := tv.State(); st.Transport != upnptype.PlaybackStatePaused || st.Position != 60 || st.Volume != 55 || !st.Mute {
+		t.Errorf("bad pause, seek or volume: %+v", st)
+	}
+
+	var state StateJSON
+	request(t, srv, "GET", "/renderers/uuid:tv/state", "", &state)
+	if state.State != upnptype.StatePausedPlayback || state.Title != "Locomotion" || state.Position != 60 || state.Volume != 55 || !state.Mute {
+		t.Errorf("bad state: %+v", state)
+	}
+
+	post("/play", "")
+	post("/stop", "")
+	if st := tv.State(); st.Transport != upnptype.PlaybackStateStopped {
+		t.Errorf("not stopped: %+v", st)
+	}
+
+	for path, body := range map[string]string{
+		"/seek":   `{}`,
+		"/volume": `{"volume": "loud"}`,
+		"/play":   `{"server": "uuid:nas", "id": "album/1"}`,
+	} {
+		if code := request(t, srv, "POST", "/renderers/uuid:tv"+path, body, nil); code != http.StatusBadRequest {
+			t.Errorf("%s %s: status %d", path, body, code)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	srv, api, media, tv := newTestServer(t)
+	api.Call(func() { media.SetRenderer("uuid:tv") })
+
+	resp, e := http.Get(srv.URL + "/events?type=volume,rendererSelected&type=transportState&udn=uuid:tv")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatal("bad content type:", ct)
+	}
+
+	api.Call(func() {
+		st := tv.State()
+		st.Volume = 20
+		tv.SetState(st)
+		st.Mute = true // filtered by type.
+		tv.SetState(st)
+		media.SetRenderer("uuid:kitchen") // filtered by device.
+		media.SetRenderer("uuid:tv")
+		st.Transport = upnptype.PlaybackStatePlaying
+		tv.SetState(st)
+	})
+
+	// SetRenderer sends the volume and the transport state of the renderer.
+	want := []string{"volume 20", "rendererSelected <nil>", "volume 20", "transportState STOPPED", "transportState PLAYING"}
+	lines := bufio.NewScanner(resp.Body)
+	i := 0
+	for i < len(want) && lines.Scan() {
+		line := lines.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var ev EventJSON
+		if e := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); e != nil {
+			t.Fatal(e, line)
+		}
+		if got := ev.Event + " " + fmt.Sprint(ev.Value); got != want[i] || ev.UDN != "uuid:tv" || ev.Device != "TV" || ev.Time.IsZero() {
+			t.Errorf("event %d: want %q, got %+v", i, want[i], ev)
+		}
+		i++
+	}
+	if i != len(want) {
+		t.Errorf("missing events: %d/%d", i, len(want))
+	}
+}
+
+func TestWebUI(t *testing.T) {
+	srv, api, media, _ := newTestServer(t)
+	for path, want := range map[string]string{
+		"/":          "<title>UPnP remote</title>",
+		"/app.js":    "new EventSource('events')",
+		"/style.css": "#seekbar",
+	} {
+		resp, e := http.Get(srv.URL + path)
+		if e != nil {
+			t.Fatal(e)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(data), want) {
+			t.Errorf("%s: status %d, without %q", path, resp.StatusCode, want)
+		}
+	}
+
+	// Devices selected by the device pickers.
+	if code := request(t, srv, "POST", "/renderers/uuid:kitchen/select", "", nil); code != http.StatusNoContent {
+		t.Error("select renderer status", code)
+	}
+	if code := request(t, srv, "POST", "/servers/uuid:nas/select", "", nil); code != http.StatusNoContent {
+		t.Error("select server status", code)
+	}
+	if code := request(t, srv, "GET", "/servers/uuid:nas/select", "", nil); code != http.StatusMethodNotAllowed {
+		t.Error("select server with GET status", code)
+	}
+	api.Call(func() {
+		if rend, srv := media.Renderer(), media.Server(); rend == nil || rend.UDN() != "uuid:kitchen" || srv == nil || srv.UDN() != "uuid:nas" {
+			t.Errorf("devices not selected: %v %v", rend, srv)
+		}
+	})
+}
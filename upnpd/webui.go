@@ -0,0 +1,19 @@
+package upnpd
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed webui
+var webuiFiles embed.FS
+
+// webui returns the handler of the web remote, served on the root path.
+//
+// The page uses the REST API and the event stream with relative paths, so the
+// server can be mounted under a prefix with http.StripPrefix.
+func webui() http.Handler {
+	files, _ := fs.Sub(webuiFiles, "webui")
+	return http.FileServer(http.FS(files))
+}
@@ -0,0 +1,409 @@
+// UPnP remote: controls the renderers of upnpd with its REST API, and follows
+// their state with the event stream.
+'use strict';
+
+const $ = (id) => document.getElementById(id);
+
+const state = {
+	renderer: '', // selected renderer UDN.
+	server: '',   // selected server UDN.
+	transport: '',
+	duration: 0,
+	volumeStep: 5,
+	seekStep: 10,
+	mute: false,
+	path: [],     // browsed containers: {id, title}.
+	start: 0,     // next browse page.
+	queue: JSON.parse(localStorage.getItem('upnpd.queue') || '[]'),
+	queuePos: -1, // queue item played, -1 when not playing the queue.
+};
+
+//
+//---------------------------------------------------------------------[ API ]--
+
+async function api(method, path, body) {
+	const opts = {method: method};
+	if (body !== undefined) {
+		opts.headers = {'Content-Type': 'application/json'};
+		opts.body = JSON.stringify(body);
+	}
+	const resp = await fetch(path, opts);
+	if (resp.status === 204) {
+		return null;
+	}
+	const data = await resp.json();
+	if (!resp.ok) {
+		showError(data.error || resp.statusText);
+		throw new Error(data.error);
+	}
+	return data;
+}
+
+function rendererCall(action, body) {
+	if (!state.renderer) {
+		showError('no renderer selected');
+		return Promise.resolve(null);
+	}
+	return api('POST', 'renderers/' + encodeURIComponent(state.renderer) + '/' + action, body).catch(() => {});
+}
+
+function showError(msg) {
+	$('state').textContent = 'error: ' + msg;
+}
+
+//
+//-----------------------------------------------------------------[ DEVICES ]--
+
+async function loadDevices() {
+	const [renderers, servers] = await Promise.all([api('GET', 'renderers'), api('GET', 'servers')]);
+	fillDevices($('renderer'), renderers);
+	fillDevices($('server'), servers);
+
+	const rend = renderers.find((dev) => dev.selected);
+	if ((rend ? rend.udn : '') !== state.renderer) {
+		setRenderer(rend ? rend.udn : '');
+	}
+	const srv = servers.find((dev) => dev.selected);
+	if ((srv ? srv.udn : '') !== state.server) {
+		setServer(srv ? srv.udn : '');
+	}
+}
+
+function fillDevices(select, list) {
+	select.replaceChildren(new Option('none', ''));
+	for (const dev of list) {
+		select.add(new Option(dev.name, dev.udn, false, dev.selected));
+	}
+}
+
+function setRenderer(udn) {
+	state.renderer = udn;
+	$('renderer').value = udn;
+	if (!udn) {
+		setTrack(null);
+		$('state').textContent = 'no renderer';
+		return;
+	}
+	refreshState();
+}
+
+function setServer(udn) {
+	state.server = udn;
+	$('server').value = udn;
+	state.path = [];
+	browse();
+}
+
+async function refreshState() {
+	const st = await api('GET', 'renderers/' + encodeURIComponent(state.renderer) + '/state');
+	setTransport(st.state);
+	setTrack(st);
+	setDuration(st.duration);
+	setPosition(st.position);
+	setVolume(st.volume);
+	setMute(st.mute);
+}
+
+//
+//--------------------------------------------------------------[ TRANSPORT ]--
+
+function formatTime(secs) {
+	const pad = (n) => String(n).padStart(2, '0');
+	return Math.floor(secs / 3600) + ':' + pad(Math.floor(secs / 60) % 60) + ':' + pad(secs % 60);
+}
+
+function setTransport(name) {
+	const previous = state.transport;
+	state.transport = name;
+	$('state').textContent = name.toLowerCase().replace('_', ' ');
+	$('play').innerHTML = name === 'PLAYING' ? '&#x23F8;' : '&#x25B6;';
+	$('play').title = name === 'PLAYING' ? 'Pause' : 'Play';
+	$('seek').disabled = name !== 'PLAYING' && name !== 'PAUSED_PLAYBACK';
+
+	// Play the next queue item at the end of the track.
+	if (previous === 'PLAYING' && name === 'STOPPED' && state.queuePos >= 0) {
+		playQueue(state.queuePos + 1);
+	}
+}
+
+function setTrack(obj) {
+	$('title').textContent = obj && obj.title ? obj.title : '-';
+	$('artist').textContent = obj && obj.artist ? [obj.artist, obj.album].filter(Boolean).join(' - ') : '';
+	if (obj && obj.albumArt) {
+		$('art').src = obj.albumArt;
+	} else {
+		$('art').removeAttribute('src');
+	}
+}
+
+function setDuration(secs) {
+	state.duration = secs;
+	$('duration').textContent = formatTime(secs);
+	$('seek').max = secs;
+}
+
+function setPosition(secs) {
+	$('position').textContent = formatTime(secs);
+	if (!state.seeking) {
+		$('seek').value = secs;
+	}
+}
+
+function setVolume(vol) {
+	$('volume').value = vol;
+}
+
+function setMute(mute) {
+	state.mute = mute;
+	$('mute').innerHTML = mute ? '&#x1F507;' : '&#x1F50A;';
+}
+
+function connectControls() {
+	$('renderer').onchange = (ev) => {
+		if (ev.target.value) {
+			api('POST', 'renderers/' + encodeURIComponent(ev.target.value) + '/select');
+		}
+	};
+	$('server').onchange = (ev) => {
+		if (ev.target.value) {
+			api('POST', 'servers/' + encodeURIComponent(ev.target.value) + '/select');
+		}
+	};
+
+	$('play').onclick = () => rendererCall(state.transport === 'PLAYING' ? 'pause' : 'play');
+	$('stop').onclick = () => {
+		state.queuePos = -1;
+		rendererCall('stop');
+		renderQueue();
+	};
+	$('next').onclick = () => state.queuePos >= 0 ? playQueue(state.queuePos + 1) : rendererCall('next');
+	$('previous').onclick = () => state.queuePos > 0 ? playQueue(state.queuePos - 1) : rendererCall('previous');
+
+	$('seek').oninput = () => {
+		state.seeking = true;
+	};
+	$('seek').onchange = (ev) => {
+		state.seeking = false;
+		rendererCall('seek', {position: Number(ev.target.value)});
+	};
+	$('volume').onchange = (ev) => rendererCall('volume', {volume: Number(ev.target.value)});
+	$('mute').onclick = () => rendererCall('volume', {mute: !state.mute});
+
+	document.onkeydown = (ev) => {
+		if (ev.target.tagName === 'INPUT' || ev.target.tagName === 'SELECT') {
+			return;
+		}
+		switch (ev.key) {
+		case ' ':
+			$('play').click();
+			break;
+		case 'ArrowUp':
+			rendererCall('volume', {delta: state.volumeStep});
+			break;
+		case 'ArrowDown':
+			rendererCall('volume', {delta: -state.volumeStep});
+			break;
+		case 'ArrowRight':
+			rendererCall('seek', {delta: state.seekStep});
+			break;
+		case 'ArrowLeft':
+			rendererCall('seek', {delta: -state.seekStep});
+			break;
+		default:
+			return;
+		}
+		ev.preventDefault();
+	};
+
+	for (const button of document.querySelectorAll('#tabs button')) {
+		button.onclick = () => {
+			for (const other of document.querySelectorAll('#tabs button')) {
+				other.classList.toggle('active', other === button);
+				$(other.dataset.tab).hidden = other !== button;
+			}
+		};
+	}
+	$('more').onclick = () => browse(true);
+	$('clear').onclick = () => {
+		state.queue = [];
+		state.queuePos = -1;
+		saveQueue();
+	};
+}
+
+//
+//-----------------------------------------------------------------[ BROWSER ]--
+
+function containerID() {
+	return state.path.length ? state.path[state.path.length - 1].id : '0';
+}
+
+// browse lists the current container, or its next page.
+async function browse(more) {
+	const list = $('objects');
+	if (!more) {
+		state.start = 0;
+		list.replaceChildren();
+	}
+	renderPath();
+	if (!state.server) {
+		$('more').hidden = true;
+		return;
+	}
+	const page = await api('GET', 'servers/' + encodeURIComponent(state.server) + '/browse/' +
+		encodeURIComponent(containerID()) + '?start=' + state.start);
+	for (const obj of page.objects) {
+		list.append(objectRow(obj));
+	}
+	state.start += page.objects.length;
+	$('more').hidden = page.objects.length === 0 || state.start >= page.total;
+}
+
+function objectRow(obj) {
+	const li = document.createElement('li');
+	const img = document.createElement('img');
+	img.alt = '';
+	if (obj.albumArt) {
+		img.src = obj.albumArt;
+	}
+	const name = document.createElement('span');
+	name.className = 'name';
+	name.textContent = obj.container ? obj.title + '/' : obj.title;
+	li.append(img, name);
+
+	if (obj.container) {
+		name.onclick = () => {
+			state.path.push({id: obj.id, title: obj.title});
+			browse();
+		};
+		return li;
+	}
+	const item = {server: state.server, id: obj.id, title: obj.title, artist: obj.artist, albumArt: obj.albumArt};
+	name.onclick = () => {
+		state.queuePos = -1;
+		renderQueue();
+		playItem(item);
+	};
+	const add = document.createElement('button');
+	add.textContent = '+';
+	add.title = 'Add to the queue';
+	add.onclick = () => {
+		state.queue.push(item);
+		saveQueue();
+	};
+	li.append(add);
+	return li;
+}
+
+function renderPath() {
+	const path = $('path');
+	path.replaceChildren();
+	const crumbs = [{title: 'root'}].concat(state.path);
+	crumbs.forEach((crumb, i) => {
+		const a = document.createElement('a');
+		a.textContent = crumb.title;
+		a.onclick = () => {
+			state.path = state.path.slice(0, i);
+			browse();
+		};
+		path.append(i ? ' / ' : '', a);
+	});
+}
+
+function playItem(item) {
+	return rendererCall('play', {server: item.server, id: item.id});
+}
+
+//
+//-------------------------------------------------------------------[ QUEUE ]--
+
+function saveQueue() {
+	localStorage.setItem('upnpd.queue', JSON.stringify(state.queue));
+	renderQueue();
+}
+
+function playQueue(pos) {
+	if (pos < 0 || pos >= state.queue.length) {
+		state.queuePos = -1;
+		renderQueue();
+		return;
+	}
+	state.queuePos = pos;
+	renderQueue();
+	playItem(state.queue[pos]);
+}
+
+function renderQueue() {
+	const list = $('queued');
+	list.replaceChildren();
+	state.queue.forEach((item, i) => {
+		const li = objectRow({title: item.title, albumArt: item.albumArt});
+		li.classList.toggle('current', i === state.queuePos);
+		li.querySelector('.name').onclick = () => playQueue(i);
+		const remove = li.querySelector('button');
+		remove.textContent = '×';
+		remove.title = 'Remove from the queue';
+		remove.onclick = () => {
+			state.queue.splice(i, 1);
+			if (i < state.queuePos) {
+				state.queuePos--;
+			} else if (i === state.queuePos) {
+				state.queuePos = -1;
+			}
+			saveQueue();
+		};
+		list.append(li);
+	});
+	$('queue-count').textContent = state.queue.length ? '(' + state.queue.length + ')' : '';
+}
+
+//
+//------------------------------------------------------------------[ EVENTS ]--
+
+const rendererEvents = {
+	transportState: (value) => setTransport(value),
+	currentTrackDuration: (value) => setDuration(value),
+	currentTrackMetaData: (value) => setTrack(value),
+	currentTime: (value) => setPosition(value.position),
+	volume: (value) => setVolume(value),
+	mute: (value) => setMute(Boolean(value)),
+	setVolumeDelta: (value) => {
+		state.volumeStep = value;
+	},
+	setSeekDelta: (value) => {
+		state.seekStep = value;
+	},
+};
+
+function handleEvent(ev) {
+	switch (ev.event) {
+	case 'rendererFound':
+	case 'rendererLost':
+	case 'serverFound':
+	case 'serverLost':
+		loadDevices();
+		return;
+
+	case 'rendererSelected':
+		setRenderer(ev.udn || '');
+		return;
+
+	case 'serverSelected':
+		setServer(ev.udn || '');
+		return;
+	}
+	const call = rendererEvents[ev.event];
+	if (call && ev.udn === state.renderer) {
+		call(ev.value === undefined ? 0 : ev.value);
+	}
+}
+
+function connectEvents() {
+	const source = new EventSource('events');
+	source.onmessage = (msg) => handleEvent(JSON.parse(msg.data));
+	source.onopen = () => loadDevices(); // also resync after a reconnection.
+}
+
+connectControls();
+renderQueue();
+connectEvents();
@@ -0,0 +1,70 @@
+package upnpdevice
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var soapClient = &http.Client{Timeout: 10 * time.Second}
+
+// SendAction calls an action on a service, with arguments given as name/value
+// pairs in the order defined by the service description. It returns the
+// output arguments, or an *Error when the device answered with an UPnP error.
+//
+//   out, e := upnpdevice.SendAction(srv.ControlURL, srv.ServiceType, "GetVolume",
+//   	"InstanceID", "0", "Channel", "Master")
+//
+func SendAction(controlURL, serviceType, action string, args ...string) (map[string]string, error) {
+	var body bytes.Buffer
+	body.WriteString(xml.Header +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + serviceType + `">`)
+	for i := 0; i+1 < len(args); i += 2 {
+		body.WriteString("<" + args[i] + ">")
+		xml.EscapeText(&body, []byte(args[i+1]))
+		body.WriteString("</" + args[i] + ">")
+	}
+	body.WriteString(`</u:` + action + `></s:Body></s:Envelope>`)
+
+	req, e := http.NewRequest("POST", controlURL, &body)
+	if e != nil {
+		return nil, e
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPACTION", `"`+serviceType+"#"+action+`"`)
+	resp, e := soapClient.Do(req)
+	if e != nil {
+		return nil, e
+	}
+	defer resp.Body.Close()
+
+	var env struct {
+		Body struct {
+			Response soapAction `xml:",any"`
+			Fault    struct {
+				Code        string `xml:"detail>UPnPError>errorCode"`
+				Description string `xml:"detail>UPnPError>errorDescription"`
+			} `xml:"Fault"`
+		}
+	}
+	if e := xml.NewDecoder(resp.Body).Decode(&env); e != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.New(action + ": " + resp.Status)
+		}
+		return nil, e
+	}
+	if resp.StatusCode != http.StatusOK {
+		code, _ := strconv.Atoi(env.Body.Fault.Code)
+		return nil, &Error{Code: code, Description: env.Body.Fault.Description}
+	}
+
+	out := make(map[string]string)
+	for _, arg := range env.Body.Response.Args {
+		out[arg.XMLName.Local] = arg.Value
+	}
+	return out, nil
+}
@@ -0,0 +1,299 @@
+// Package upnpdevice hosts UPnP devices in pure Go, without the C libraries.
+//
+// A device serves its description, services descriptions, SOAP actions and
+// GENA events over HTTP, and is advertised with SSDP.
+//
+//   dev := upnpdevice.NewDevice(upnptype.DeviceInfo{
+//   	DeviceType:   "urn:schemas-upnp-org:device:MediaRenderer:1",
+//   	FriendlyName: "Virtual renderer",
+//   })
+//   srv := upnpdevice.NewService(upnptype.ServiceTypeAVTransport, "urn:upnp-org:serviceId:AVTransport", scpd)
+//   srv.Handle("Play", func(args map[string]string) (map[string]string, error) { ... })
+//   dev.AddService(srv)
+//   e := dev.Start(upnpdevice.Options{})
+//
+package upnpdevice
+
+import (
+	"github.com/sqp/gupnp/upnptype"
+
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server is the SERVER header sent in HTTP and SSDP responses.
+//
+const Server = "Linux/1.0 UPnP/1.0 gupnp-go/1.0"
+
+// DefaultMaxAge is the default SSDP advertisement lifetime in seconds.
+//
+const DefaultMaxAge = 1800
+
+// Options defines the network settings of a device.
+//
+type Options struct {
+	Interface   string // Network interface to serve and advertise on. Empty for loopback.
+	Address     string // IP address to serve on. Empty for the first IPv4 address of the interface.
+	Port        int    // HTTP port. 0 for a random port.
+	MaxAge      int    // SSDP advertisement lifetime in seconds. 0 for DefaultMaxAge.
+	DisableSSDP bool   // Don't advertise. The device is only reachable by its location.
+
+	Log upnptype.Logger // Optional logger for network errors.
+}
+
+// Device defines an UPnP device hosted in Go.
+//
+type Device struct {
+	info     upnptype.DeviceInfo
+	services []*Service
+	mux      *http.ServeMux
+	log      upnptype.Logger
+
+	mu       sync.Mutex
+	listener net.Listener
+	http     *http.Server
+	ssdp     *ssdp
+	location string
+}
+
+// NewDevice creates a device. A random UDN is set if missing.
+//
+func NewDevice(info upnptype.DeviceInfo) *Device {
+	if info.UDN == "" {
+		info.UDN = NewUDN()
+	}
+	dev := &Device{
+		info: info,
+		mux:  http.NewServeMux(),
+		log:  upnptype.NopLogger{},
+	}
+	dev.mux.HandleFunc("/description.xml", dev.serveDescription)
+	return dev
+}
+
+// UDN returns the unique device name.
+//
+func (dev *Device) UDN() string { return dev.info.UDN }
+
+// Info returns the device description, with services. URLs are absolute when
+// the device is started.
+//
+func (dev *Device) Info() upnptype.DeviceInfo {
+	info := dev.info
+	info.Location = dev.Location()
+	base := strings.TrimSuffix(dev.BaseURL(), "/")
+	for _, srv := range dev.services {
+		srvInfo := srv.info()
+		if base != "" {
+			srvInfo.SCPDURL = base + srvInfo.SCPDURL
+			srvInfo.ControlURL = base + srvInfo.ControlURL
+			srvInfo.EventSubURL = base + srvInfo.EventSubURL
+		}
+		info.Services = append(info.Services, srvInfo)
+	}
+	return info
+}
+
+// AddService adds a service to the device. Must be called before Start.
+//
+func (dev *Device) AddService(srv *Service) {
+	srv.path = "/" + srv.name() + "/"
+	dev.services = append(dev.services, srv)
+	dev.mux.HandleFunc(srv.path+"scpd.xml", srv.serveSCPD)
+	dev.mux.HandleFunc(srv.path+"control", srv.serveControl)
+	dev.mux.HandleFunc(srv.path+"event", srv.serveEvent)
+}
+
+// Handle registers an HTTP handler on the device server, to serve resources.
+// Must be called before Start.
+//
+func (dev *Device) Handle(pattern string, handler http.Handler) {
+	dev.mux.Handle(pattern, handler)
+}
+
+// Location returns the URL of the device description. Empty if not started.
+//
+func (dev *Device) Location() string {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return dev.location
+}
+
+// BaseURL returns the URL of the device server, with a trailing slash.
+// Empty if not started.
+//
+func (dev *Device) BaseURL() string {
+	return strings.TrimSuffix(dev.Location(), "description.xml")
+}
+
+// Start serves the device and advertises it.
+//
+func (dev *Device) Start(opts Options) error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if dev.listener != nil {
+		return errors.New("device already started")
+	}
+
+	if opts.Log != nil {
+		dev.log = opts.Log
+	}
+	for _, srv := range dev.services {
+		srv.log = dev.log
+	}
+
+	iface, addr, e := resolveAddress(opts)
+	if e != nil {
+		return e
+	}
+	listener, e := net.Listen("tcp", net.JoinHostPort(addr, strconv.Itoa(opts.Port)))
+	if e != nil {
+		return e
+	}
+	dev.listener = listener
+	dev.location = "http://" + listener.Addr().String() + "/description.xml"
+	dev.http = &http.Server{Handler: dev.mux}
+	go func() {
+		if e := dev.http.Serve(listener); e != http.ErrServerClosed {
+			dev.log.Warn("device http server", upnptype.FieldError, e)
+		}
+	}()
+
+	if !opts.DisableSSDP {
+		maxAge := opts.MaxAge
+		if maxAge <= 0 {
+			maxAge = DefaultMaxAge
+		}
+		dev.ssdp, e = startSSDP(iface, maxAge, dev.location, dev.targets(), dev.log)
+		if e != nil { // The device stays reachable by its location.
+			dev.log.Warn("ssdp: device not advertised", "location", dev.location, upnptype.FieldError, e)
+		}
+	}
+	return nil
+}
+
+// Stop sends the byebye advertisement and stops serving the device.
+//
+func (dev *Device) Stop() error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if dev.listener == nil {
+		return nil
+	}
+	if dev.ssdp != nil {
+		dev.ssdp.stop()
+		dev.ssdp = nil
+	}
+	for _, srv := range dev.services {
+		srv.closeSubscriptions()
+	}
+	e := dev.http.Close()
+	dev.listener = nil
+	dev.location = ""
+	return e
+}
+
+// targets returns the SSDP notification types and unique service names.
+func (dev *Device) targets() map[string]string {
+	udn := dev.info.UDN
+	targets := map[string]string{
+		"upnp:rootdevice":   udn + "::upnp:rootdevice",
+		udn:                 udn,
+		dev.info.DeviceType: udn + "::" + dev.info.DeviceType,
+	}
+	for _, srv := range dev.services {
+		targets[srv.Type] = udn + "::" + srv.Type
+	}
+	return targets
+}
+
+//
+//-------------------------------------------------------------[ DESCRIPTION ]--
+
+func (dev *Device) serveDescription(w http.ResponseWriter, r *http.Request) {
+	info := dev.info
+	for _, srv := range dev.services {
+		info.Services = append(info.Services, srv.info())
+	}
+	doc := struct {
+		XMLName     xml.Name            `xml:"urn:schemas-upnp-org:device-1-0 root"`
+		SpecVersion specVersion         `xml:"specVersion"`
+		Device      upnptype.DeviceInfo `xml:"device"`
+	}{SpecVersion: specVersion{1, 0}, Device: info}
+
+	writeXML(w, doc)
+}
+
+type specVersion struct {
+	Major int `xml:"major"`
+	Minor int `xml:"minor"`
+}
+
+func writeXML(w http.ResponseWriter, doc interface{}) {
+	data, e := xml.Marshal(doc)
+	if e != nil {
+		http.Error(w, e.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("Server", Server)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+//
+//-----------------------------------------------------------------[ HELPERS ]--
+
+// NewUDN returns a random unique device name (uuid:...).
+//
+func NewUDN() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40 // Version 4.
+	b[8] = b[8]&0x3f | 0x80 // Variant RFC 4122.
+	return fmt.Sprintf("uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// resolveAddress returns the interface and IP address to use.
+func resolveAddress(opts Options) (*net.Interface, string, error) {
+	name := opts.Interface
+	if name == "" {
+		name = loopbackName()
+	}
+	iface, e := net.InterfaceByName(name)
+	if e != nil {
+		return nil, "", e
+	}
+	if opts.Address != "" {
+		return iface, opts.Address, nil
+	}
+
+	addrs, e := iface.Addrs()
+	if e != nil {
+		return nil, "", e
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return iface, ipnet.IP.String(), nil
+		}
+	}
+	return nil, "", errors.New("no IPv4 address on interface " + name)
+}
+
+// loopbackName returns the name of the loopback interface.
+func loopbackName() string {
+	ifaces, _ := net.Interfaces()
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			return iface.Name
+		}
+	}
+	return "lo"
+}
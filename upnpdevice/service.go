@@ -0,0 +1,454 @@
+package upnpdevice
+
+import (
+	"github.com/sqp/gupnp/upnptype"
+
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ActionFunc handles an action call. It receives the input arguments and
+// returns the output arguments.
+//
+// Return an *Error to send a specific UPnP error code.
+//
+type ActionFunc func(args map[string]string) (map[string]string, error)
+
+// Error defines an UPnP error returned by an action, the fault type shared
+// with the backends.
+//
+type Error = upnptype.Fault
+
+// UPnP errors codes.
+//
+const (
+	ErrInvalidAction   = 401
+	ErrInvalidArgs     = 402
+	ErrActionFailed    = 501
+	ErrArgumentInvalid = 600
+)
+
+// Service defines a service hosted by a device.
+//
+type Service struct {
+	Type string // Service type (urn:schemas-upnp-org:service:AVTransport:1).
+	ID   string // Service ID (urn:upnp-org:serviceId:AVTransport).
+
+	scpd     *upnptype.SCPD
+	path     string // URL path prefix on the device server, set by the device.
+	handlers map[string]ActionFunc
+	log      upnptype.Logger
+
+	mu        sync.Mutex
+	variables map[string]string        // evented variables values.
+	subs      map[string]*subscription // indexed by SID.
+}
+
+// NewService creates a service with its description.
+//
+func NewService(serviceType, serviceID string, scpd *upnptype.SCPD) *Service {
+	return &Service{
+		Type:      serviceType,
+		ID:        serviceID,
+		scpd:      scpd,
+		handlers:  make(map[string]ActionFunc),
+		log:       upnptype.NopLogger{},
+		variables: make(map[string]string),
+		subs:      make(map[string]*subscription),
+	}
+}
+
+// Handle sets the handler of an action.
+//
+func (srv *Service) Handle(action string, call ActionFunc) {
+	srv.handlers[action] = call
+}
+
+// SCPD returns the service description.
+//
+func (srv *Service) SCPD() *upnptype.SCPD { return srv.scpd }
+
+// name returns the short name of the service (AVTransport).
+func (srv *Service) name() string {
+	fields := strings.Split(srv.Type, ":")
+	if len(fields) >= 2 {
+		return fields[len(fields)-2]
+	}
+	return srv.Type
+}
+
+func (srv *Service) info() upnptype.ServiceInfo {
+	return upnptype.ServiceInfo{
+		ServiceType: srv.Type,
+		ServiceID:   srv.ID,
+		SCPDURL:     srv.path + "scpd.xml",
+		ControlURL:  srv.path + "control",
+		EventSubURL: srv.path + "event",
+	}
+}
+
+func (srv *Service) serveSCPD(w http.ResponseWriter, r *http.Request) {
+	doc := struct {
+		XMLName     xml.Name    `xml:"urn:schemas-upnp-org:service-1-0 scpd"`
+		SpecVersion specVersion `xml:"specVersion"`
+		*upnptype.SCPD
+	}{SpecVersion: specVersion{1, 0}, SCPD: srv.scpd}
+
+	writeXML(w, doc)
+}
+
+//
+//-----------------------------------------------------------------[ CONTROL ]--
+
+type soapEnvelope struct {
+	Body struct {
+		Action soapAction `xml:",any"`
+	} `xml:"Body"`
+}
+
+type soapAction struct {
+	XMLName xml.Name
+	Args    []soapArg `xml:",any"`
+}
+
+type soapArg struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+func (srv *Service) serveControl(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var env soapEnvelope
+	if e := xml.NewDecoder(r.Body).Decode(&env); e != nil {
+		writeSOAPError(w, &Error{Code: ErrInvalidAction, Description: "Invalid Action"})
+		return
+	}
+	action := env.Body.Action.XMLName.Local
+	args := make(map[string]string)
+	for _, arg := range env.Body.Action.Args {
+		args[arg.XMLName.Local] = arg.Value
+	}
+
+	call, ok := srv.handlers[action]
+	if !ok {
+		writeSOAPError(w, &Error{Code: ErrInvalidAction, Description: "Invalid Action"})
+		return
+	}
+	out, e := call(args)
+	if e != nil {
+		upnpErr, ok := e.(*Error)
+		if !ok {
+			upnpErr = &Error{Code: ErrActionFailed, Description: e.Error()}
+		}
+		writeSOAPError(w, upnpErr)
+		return
+	}
+
+	var body bytes.Buffer
+	body.WriteString(`<u:` + action + `Response xmlns:u="` + srv.Type + `">`)
+	for _, name := range srv.outArgs(action, out) {
+		body.WriteString("<" + name + ">")
+		xml.EscapeText(&body, []byte(out[name]))
+		body.WriteString("</" + name + ">")
+	}
+	body.WriteString(`</u:` + action + `Response>`)
+	writeSOAP(w, http.StatusOK, body.String())
+}
+
+// outArgs returns the names of output arguments, in the description order.
+func (srv *Service) outArgs(action string, out map[string]string) []string {
+	if info := srv.scpd.Action(action); info != nil {
+		var names []string
+		for _, arg := range info.ArgumentsOut() {
+			names = append(names, arg.Name)
+		}
+		return names
+	}
+	var names []string
+	for name := range out {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeSOAPError(w http.ResponseWriter, e *Error) {
+	var desc bytes.Buffer
+	xml.EscapeText(&desc, []byte(e.Description))
+	writeSOAP(w, http.StatusInternalServerError, `<s:Fault>`+
+		`<faultcode>s:Client</faultcode>`+
+		`<faultstring>UPnPError</faultstring>`+
+		`<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0">`+
+		`<errorCode>`+strconv.Itoa(e.Code)+`</errorCode>`+
+		`<errorDescription>`+desc.String()+`</errorDescription>`+
+		`</UPnPError></detail></s:Fault>`)
+}
+
+func writeSOAP(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("Ext", "")
+	w.Header().Set("Server", Server)
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body>` + body + `</s:Body></s:Envelope>`))
+}
+
+//
+//------------------------------------------------------------------[ EVENTS ]--
+
+// SubscriptionTimeout is the duration of event subscriptions.
+//
+var SubscriptionTimeout = 1800 * time.Second
+
+type subscription struct {
+	sid       string
+	callbacks []string
+	expire    time.Time
+	queue     chan []byte
+}
+
+// SetVariable sets the value of an evented state variable, and sends it to
+// subscribers.
+//
+func (srv *Service) SetVariable(name, value string) {
+	srv.SetVariables(map[string]string{name: value})
+}
+
+// SetVariables sets the values of evented state variables, and sends them to
+// subscribers in a single event.
+//
+func (srv *Service) SetVariables(values map[string]string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for name, value := range values {
+		srv.variables[name] = value
+	}
+	body := propertySet(values)
+	now := time.Now()
+	for sid, sub := range srv.subs {
+		if now.After(sub.expire) {
+			srv.dropSubscription(sid)
+			continue
+		}
+		select {
+		case sub.queue <- body:
+		default:
+			srv.log.Warn("event dropped, subscriber too slow", "sid", sid)
+		}
+	}
+}
+
+// Variable returns the value of an evented state variable.
+//
+func (srv *Service) Variable(name string) string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.variables[name]
+}
+
+var reCallback = regexp.MustCompile(`<([^>]+)>`)
+
+func (srv *Service) serveEvent(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "SUBSCRIBE":
+		srv.subscribe(w, r)
+
+	case "UNSUBSCRIBE":
+		srv.mu.Lock()
+		_, ok := srv.subs[r.Header.Get("SID")]
+		if ok {
+			srv.dropSubscription(r.Header.Get("SID"))
+		}
+		srv.mu.Unlock()
+		if !ok {
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		}
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (srv *Service) subscribe(w http.ResponseWriter, r *http.Request) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	timeout := SubscriptionTimeout
+	if sid := r.Header.Get("SID"); sid != "" { // Renewal.
+		sub, ok := srv.subs[sid]
+		if !ok {
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		sub.expire = time.Now().Add(timeout)
+		writeSubscribed(w, sid, timeout)
+		return
+	}
+
+	var callbacks []string
+	for _, match := range reCallback.FindAllStringSubmatch(r.Header.Get("CALLBACK"), -1) {
+		callbacks = append(callbacks, match[1])
+	}
+	if len(callbacks) == 0 || r.Header.Get("NT") != "upnp:event" {
+		http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+
+	sub := &subscription{
+		sid:       NewUDN(),
+		callbacks: callbacks,
+		expire:    time.Now().Add(timeout),
+		queue:     make(chan []byte, 32),
+	}
+	srv.subs[sub.sid] = sub
+	go sub.send()
+
+	writeSubscribed(w, sub.sid, timeout)
+	if flusher, ok := w.(http.Flusher); ok { // Client must know the SID before the first event.
+		flusher.Flush()
+	}
+	sub.queue <- propertySet(srv.variables) // Initial event with all values.
+}
+
+func writeSubscribed(w http.ResponseWriter, sid string, timeout time.Duration) {
+	w.Header().Set("SID", sid)
+	w.Header().Set("TIMEOUT", "Second-"+strconv.Itoa(int(timeout.Seconds())))
+	w.Header().Set("Server", Server)
+	w.WriteHeader(http.StatusOK)
+}
+
+// dropSubscription removes a subscription. The lock must be held.
+func (srv *Service) dropSubscription(sid string) {
+	close(srv.subs[sid].queue)
+	delete(srv.subs, sid)
+}
+
+func (srv *Service) closeSubscriptions() {
+	srv.mu.Lock()
+	for sid := range srv.subs {
+		srv.dropSubscription(sid)
+	}
+	srv.mu.Unlock()
+}
+
+var eventClient = &http.Client{Timeout: 5 * time.Second}
+
+// send delivers events in order, until the queue is closed.
+func (sub *subscription) send() {
+	seq := 0
+	for body := range sub.queue {
+		for _, callback := range sub.callbacks { // First working URL.
+			req, e := http.NewRequest("NOTIFY", callback, bytes.NewReader(body))
+			if e != nil {
+				continue
+			}
+			req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+			req.Header.Set("NT", "upnp:event")
+			req.Header.Set("NTS", "upnp:propchange")
+			req.Header.Set("SID", sub.sid)
+			req.Header.Set("SEQ", strconv.Itoa(seq))
+			resp, e := eventClient.Do(req)
+			if e == nil {
+				resp.Body.Close()
+				break
+			}
+		}
+		seq++
+	}
+}
+
+func propertySet(values map[string]string) []byte {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header + `<e:propertyset xmlns:e="urn:schemas-upnp-org:event-1-0">`)
+	for _, name := range names {
+		b.WriteString("<e:property><" + name + ">")
+		xml.EscapeText(&b, []byte(values[name]))
+		b.WriteString("</" + name + "></e:property>")
+	}
+	b.WriteString("</e:propertyset>")
+	return b.Bytes()
+}
+
+//
+//--------------------------------------------------------------[ LASTCHANGE ]--
+
+// LastChange namespaces.
+//
+const (
+	LastChangeAVT = "urn:schemas-upnp-org:metadata-1-0/AVT/"
+	LastChangeRCS = "urn:schemas-upnp-org:metadata-1-0/RCS/"
+)
+
+// LastChange returns a LastChange event document for instance 0.
+//
+// Volume and Mute values are sent on the Master channel.
+//
+func LastChange(namespace string, values map[string]string) string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	b.WriteString(`<Event xmlns="` + namespace + `"><InstanceID val="0">`)
+	for _, name := range names {
+		b.WriteString("<" + name)
+		if name == "Volume" || name == "Mute" {
+			b.WriteString(` channel="Master"`)
+		}
+		b.WriteString(` val="`)
+		xml.EscapeText(&b, []byte(values[name]))
+		b.WriteString(`"/>`)
+	}
+	b.WriteString(`</InstanceID></Event>`)
+	return b.String()
+}
+
+//
+//-------------------------------------------------------------[ DESCRIPTION ]--
+
+// Action returns an action description.
+//
+func Action(name string, args ...upnptype.ArgumentInfo) upnptype.ActionInfo {
+	return upnptype.ActionInfo{Name: name, Arguments: args}
+}
+
+// ArgIn returns an input argument description.
+//
+func ArgIn(name, variable string) upnptype.ArgumentInfo {
+	return upnptype.ArgumentInfo{Name: name, Direction: "in", RelatedStateVariable: variable}
+}
+
+// ArgOut returns an output argument description.
+//
+func ArgOut(name, variable string) upnptype.ArgumentInfo {
+	return upnptype.ArgumentInfo{Name: name, Direction: "out", RelatedStateVariable: variable}
+}
+
+// Variable returns a state variable description.
+//
+func Variable(name, dataType string, evented bool, allowed ...string) upnptype.StateVariable {
+	sendEvents := "no"
+	if evented {
+		sendEvents = "yes"
+	}
+	return upnptype.StateVariable{Name: name, DataType: dataType, SendEvents: sendEvents, AllowedValues: allowed}
+}
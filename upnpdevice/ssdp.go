@@ -0,0 +1,151 @@
+package upnpdevice
+
+import (
+	"github.com/sqp/gupnp/upnptype"
+
+	"bufio"
+	"bytes"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SSDP multicast address.
+const ssdpAddr = "239.255.255.250:1900"
+
+// ssdp advertises a device and answers searches.
+type ssdp struct {
+	conn     *net.UDPConn
+	group    *net.UDPAddr
+	maxAge   int
+	location string
+	targets  map[string]string // NT => USN.
+	log      upnptype.Logger
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func startSSDP(iface *net.Interface, maxAge int, location string, targets map[string]string, log upnptype.Logger) (*ssdp, error) {
+	group, e := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if e != nil {
+		return nil, e
+	}
+	conn, e := net.ListenMulticastUDP("udp4", iface, group)
+	if e != nil {
+		return nil, e
+	}
+	s := &ssdp{
+		conn:     conn,
+		group:    group,
+		maxAge:   maxAge,
+		location: location,
+		targets:  targets,
+		log:      log,
+		quit:     make(chan struct{}),
+	}
+	s.notify("ssdp:alive")
+
+	s.wg.Add(2)
+	go s.listen()
+	go s.announce()
+	return s, nil
+}
+
+func (s *ssdp) stop() {
+	close(s.quit)
+	s.notify("ssdp:byebye")
+	s.conn.Close()
+	s.wg.Wait()
+}
+
+// announce repeats the alive notifications before they expire.
+func (s *ssdp) announce() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(time.Duration(s.maxAge) * time.Second / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.notify("ssdp:alive")
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+func (s *ssdp) notify(nts string) {
+	for nt, usn := range s.targets {
+		msg := "NOTIFY * HTTP/1.1\r\n" +
+			"HOST: " + ssdpAddr + "\r\n" +
+			"NT: " + nt + "\r\n" +
+			"NTS: " + nts + "\r\n" +
+			"USN: " + usn + "\r\n"
+		if nts == "ssdp:alive" {
+			msg += "CACHE-CONTROL: max-age=" + strconv.Itoa(s.maxAge) + "\r\n" +
+				"LOCATION: " + s.location + "\r\n" +
+				"SERVER: " + Server + "\r\n"
+		}
+		if _, e := s.conn.WriteToUDP([]byte(msg+"\r\n"), s.group); e != nil {
+			s.log.Warn("ssdp notify", upnptype.FieldError, e)
+		}
+	}
+}
+
+// listen answers M-SEARCH requests until the connection is closed.
+func (s *ssdp) listen() {
+	defer s.wg.Done()
+	buf := make([]byte, 2048)
+	for {
+		n, from, e := s.conn.ReadFromUDP(buf)
+		if e != nil {
+			select {
+			case <-s.quit:
+			default:
+				s.log.Warn("ssdp read", upnptype.FieldError, e)
+			}
+			return
+		}
+		req, e := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if e != nil || req.Method != "M-SEARCH" || req.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+		go s.reply(req.Header.Get("ST"), req.Header.Get("MX"), from)
+	}
+}
+
+// reply sends the search responses after a random delay up to MX seconds.
+func (s *ssdp) reply(st, mx string, to *net.UDPAddr) {
+	delay, _ := strconv.Atoi(mx)
+	if delay > 5 {
+		delay = 5
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(delay) * int64(time.Second)))):
+		case <-s.quit:
+			return
+		}
+	}
+
+	for nt, usn := range s.targets {
+		if st != "ssdp:all" && st != nt {
+			continue
+		}
+		msg := "HTTP/1.1 200 OK\r\n" +
+			"CACHE-CONTROL: max-age=" + strconv.Itoa(s.maxAge) + "\r\n" +
+			"DATE: " + time.Now().UTC().Format(http.TimeFormat) + "\r\n" +
+			"EXT:\r\n" +
+			"LOCATION: " + s.location + "\r\n" +
+			"SERVER: " + Server + "\r\n" +
+			"ST: " + nt + "\r\n" +
+			"USN: " + usn + "\r\n\r\n"
+		if _, e := s.conn.WriteToUDP([]byte(msg), to); e != nil && !strings.Contains(e.Error(), "closed") {
+			s.log.Warn("ssdp reply", upnptype.FieldError, e)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Package upnplog provides loggers for the API, based on log/slog.
+//
+// A *slog.Logger is an upnptype.Logger, so applications using slog can share
+// their logger:
+//
+//   media, e := gupnp.New(slog.Default())
+//
+// The package also provides a text logger for commands, and a logger adding
+// the fields of a device to its messages.
+//
+package upnplog
+
+import (
+	"github.com/sqp/gupnp/upnptype"
+
+	"io"
+	"log/slog"
+	"testing"
+)
+
+var _ upnptype.Logger = (*slog.Logger)(nil)
+
+// New returns a logger sending the messages to the slog handler.
+//
+func New(handler slog.Handler) upnptype.Logger {
+	return slog.New(handler)
+}
+
+// Text returns a logger writing messages of the level and above in the
+// key=value format of slog.TextHandler.
+//
+func Text(w io.Writer, level slog.Level) upnptype.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// Test returns a logger sending the messages to the test log, with their
+// level and fields.
+//
+func Test(t testing.TB) upnptype.Logger {
+	return testLogger{t: t}
+}
+
+// Device returns a logger adding the device UDN and name fields to the
+// messages.
+//
+func Device(log upnptype.Logger, dev interface {
+	UDN() string
+	Name() string
+}) upnptype.Logger {
+	return With(log, upnptype.FieldUDN, dev.UDN(), upnptype.FieldDevice, dev.Name())
+}
+
+// With returns a logger adding the fields to the messages.
+//
+func With(log upnptype.Logger, fields ...interface{}) upnptype.Logger {
+	if sl, ok := log.(*slog.Logger); ok {
+		return sl.With(fields...)
+	}
+	return withLogger{log: log, fields: fields}
+}
+
+// withLogger adds fields to the messages of any logger.
+type withLogger struct {
+	log    upnptype.Logger
+	fields []interface{}
+}
+
+func (l withLogger) add(fields []interface{}) []interface{} {
+	return append(l.fields[:len(l.fields):len(l.fields)], fields...)
+}
+
+func (l withLogger) Debug(msg string, fields ...interface{}) { l.log.Debug(msg, l.add(fields)...) }
+func (l withLogger) Info(msg string, fields ...interface{})  { l.log.Info(msg, l.add(fields)...) }
+func (l withLogger) Warn(msg string, fields ...interface{})  { l.log.Warn(msg, l.add(fields)...) }
+func (l withLogger) Error(msg string, fields ...interface{}) { l.log.Error(msg, l.add(fields)...) }
+
+// testLogger forwards the messages to a test.
+type testLogger struct{ t testing.TB }
+
+func (l testLogger) Debug(msg string, fields ...interface{}) {
+	l.t.Helper()
+	l.log("DEBUG", msg, fields)
+}
+
+func (l testLogger) Info(msg string, fields ...interface{}) {
+	l.t.Helper()
+	l.log("INFO", msg, fields)
+}
+
+func (l testLogger) Warn(msg string, fields ...interface{}) {
+	l.t.Helper()
+	l.log("WARN", msg, fields)
+}
+
+func (l testLogger) Error(msg string, fields ...interface{}) {
+	l.t.Helper()
+	l.log("ERROR", msg, fields)
+}
+
+func (l testLogger) log(level, msg string, fields []interface{}) {
+	l.t.Helper()
+	l.t.Log(append([]interface{}{level, msg}, fields...)...)
+}
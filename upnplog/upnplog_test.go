@@ -0,0 +1,85 @@
+package upnplog
+
+import (
+	"github.com/sqp/gupnp/mocktype"
+	"github.com/sqp/gupnp/upnptype"
+
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// recorder keeps the messages with their fields.
+type recorder struct{ lines []string }
+
+func (r *recorder) add(level, msg string, fields []interface{}) {
+	line := level + " " + msg
+	for _, field := range fields {
+		line += " " + toString(field)
+	}
+	r.lines = append(r.lines, line)
+}
+
+func (r *recorder) Debug(msg string, fields ...interface{}) { r.add("debug", msg, fields) }
+func (r *recorder) Info(msg string, fields ...interface{})  { r.add("info", msg, fields) }
+func (r *recorder) Warn(msg string, fields ...interface{})  { r.add("warn", msg, fields) }
+func (r *recorder) Error(msg string, fields ...interface{}) { r.add("error", msg, fields) }
+
+func toString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return "?"
+}
+
+func TestText(t *testing.T) {
+	var buf bytes.Buffer
+	log := Text(&buf, slog.LevelInfo)
+	log.Debug("hidden")
+	log = Device(log, mocktype.NewRenderer("uuid:tv", "TV"))
+	log.Warn("action failed", upnptype.FieldAction, "Play")
+
+	got := buf.String()
+	if strings.Contains(got, "hidden") {
+		t.Error("debug message below the level:", got)
+	}
+	for _, want := range []string{"level=WARN", `msg="action failed"`, "udn=uuid:tv", "device=TV", "action=Play"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %s in %q", want, got)
+		}
+	}
+}
+
+func TestWith(t *testing.T) {
+	rec := &recorder{}
+	log := With(rec, upnptype.FieldUDN, "uuid:tv")
+	log.Info("first", "a", "1")
+	log.Error("second", "b", "2")
+
+	want := []string{"info first udn uuid:tv a 1", "error second udn uuid:tv b 2"}
+	if strings.Join(rec.lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("bad messages: %q", rec.lines)
+	}
+}
+
+// testTB keeps the lines logged to a test.
+type testTB struct {
+	testing.TB
+	lines []string
+}
+
+func (tb *testTB) Helper() {}
+
+func (tb *testTB) Log(args ...interface{}) {
+	tb.lines = append(tb.lines, strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
+}
+
+func TestTest(t *testing.T) {
+	tb := &testTB{}
+	Test(tb).Warn("action failed", upnptype.FieldUDN, "uuid:tv")
+	if len(tb.lines) != 1 || tb.lines[0] != "WARN action failed udn uuid:tv" {
+		t.Errorf("bad test log: %q", tb.lines)
+	}
+}
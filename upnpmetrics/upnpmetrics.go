@@ -0,0 +1,305 @@
+// Package upnpmetrics collects metrics of the control point, served in the
+// Prometheus text format.
+//
+// The media control and the gupnp backend record their metrics in the Default
+// collector, unless set otherwise:
+//
+//   upnp_devices{type}                          devices known.
+//   upnp_discovery_events_total{type,event}     devices found and lost.
+//   upnp_actions_total{udn,device,action}       SOAP actions sent.
+//   upnp_action_errors_total{udn,device,action} SOAP actions failed.
+//   upnp_action_duration_seconds{udn,device,action}
+//   upnp_subscription_renewals_total{udn,device,service}
+//   upnp_subscription_failures_total{udn,device,service}
+//   upnp_renderer_state{udn,device,state}       1 for the current transport state.
+//
+// The metrics are served with the Handler:
+//
+//   http.Handle("/metrics", upnpmetrics.Default.Handler())
+//
+package upnpmetrics
+
+import (
+	"github.com/sqp/gupnp/upnptype"
+
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Device types used in labels.
+//
+const (
+	TypeRenderer = "renderer"
+	TypeServer   = "server"
+)
+
+// Discovery events used in labels.
+//
+const (
+	EventFound = "found"
+	EventLost  = "lost"
+)
+
+// DurationBuckets are the upper bounds of the action duration histogram, in
+// seconds. SOAP calls time out after 30 seconds.
+//
+var DurationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Default is the collector used by the media control and the backend.
+//
+var Default = New()
+
+// playbackStates are the states of the renderer_state metric.
+var playbackStates = []upnptype.PlaybackState{
+	upnptype.PlaybackStateStopped,
+	upnptype.PlaybackStatePlaying,
+	upnptype.PlaybackStatePaused,
+	upnptype.PlaybackStateTransitioning,
+	upnptype.PlaybackStateUnknown,
+}
+
+// Metrics collects the control point metrics.
+//
+type Metrics struct {
+	mu       sync.Mutex
+	families []*family
+
+	devices        *family
+	discovery      *family
+	actions        *family
+	actionErrors   *family
+	actionDuration *family
+	renewals       *family
+	renewFailures  *family
+	rendererState  *family
+}
+
+// New creates an empty metrics collector.
+//
+func New() *Metrics {
+	m := &Metrics{}
+	m.devices = m.add("upnp_devices", "Devices known by the control point.", "gauge", "type")
+	m.discovery = m.add("upnp_discovery_events_total", "Devices found and lost.", "counter", "type", "event")
+	m.actions = m.add("upnp_actions_total", "SOAP actions sent to devices.", "counter", "udn", "device", "action")
+	m.actionErrors = m.add("upnp_action_errors_total", "SOAP actions failed.", "counter", "udn", "device", "action")
+	m.actionDuration = m.add("upnp_action_duration_seconds", "Duration of SOAP actions.", "histogram", "udn", "device", "action")
+	m.renewals = m.add("upnp_subscription_renewals_total", "Event subscriptions started or renewed.", "counter", "udn", "device", "service")
+	m.renewFailures = m.add("upnp_subscription_failures_total", "Event subscriptions lost.", "counter", "udn", "device", "service")
+	m.rendererState = m.add("upnp_renderer_state", "Transport state of renderers, 1 for the current state.", "gauge", "udn", "device", "state")
+	return m
+}
+
+func (m *Metrics) add(name, help, typ string, labels ...string) *family {
+	f := &family{name: name, help: help, typ: typ, labels: labels, series: make(map[string]*series)}
+	m.families = append(m.families, f)
+	return f
+}
+
+//
+//----------------------------------------------------------------[ RECORDS ]--
+
+// SetDevices sets the number of devices of a type (TypeRenderer, TypeServer).
+//
+func (m *Metrics) SetDevices(typ string, count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.devices.get(typ).value = float64(count)
+}
+
+// Discovery counts a discovery event (EventFound, EventLost) of a device type.
+//
+func (m *Metrics) Discovery(typ, event string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.discovery.get(typ, event).value++
+}
+
+// Action records a SOAP action sent to a device, with its duration and error.
+//
+func (m *Metrics) Action(udn, device, action string, duration time.Duration, e error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.actions.get(udn, device, action).value++
+	if e != nil {
+		m.actionErrors.get(udn, device, action).value++
+	}
+	m.actionDuration.get(udn, device, action).observe(duration.Seconds())
+}
+
+// SubscriptionRenewed counts an event subscription started or renewed.
+//
+func (m *Metrics) SubscriptionRenewed(udn, device, service string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.renewals.get(udn, device, service).value++
+}
+
+// SubscriptionFailed counts an event subscription lost.
+//
+func (m *Metrics) SubscriptionFailed(udn, device, service string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.renewFailures.get(udn, device, service).value++
+}
+
+// RendererState sets the transport state of a renderer.
+//
+func (m *Metrics) RendererState(udn, device string, state upnptype.PlaybackState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, st := range playbackStates {
+		value := 0.
+		if st == state {
+			value = 1
+		}
+		m.rendererState.get(udn, device, st.String()).value = value
+	}
+}
+
+// RemoveRenderer drops the state of a lost renderer.
+//
+func (m *Metrics) RemoveRenderer(udn string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, s := range m.rendererState.series {
+		if s.labels[0] == udn {
+			delete(m.rendererState.series, key)
+		}
+	}
+}
+
+//
+//-----------------------------------------------------------------[ EXPORT ]--
+
+// WriteTo writes the metrics in the Prometheus text format.
+//
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	var b strings.Builder
+	for _, f := range m.families {
+		f.write(&b)
+	}
+	m.mu.Unlock()
+	n, e := io.WriteString(w, b.String())
+	return int64(n), e
+}
+
+// Handler returns a HTTP handler serving the metrics.
+//
+func (m *Metrics) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.WriteTo(w)
+	})
+}
+
+//
+//-----------------------------------------------------------------[ FAMILY ]--
+
+// family is a metric with its series indexed by label values.
+type family struct {
+	name   string
+	help   string
+	typ    string // counter, gauge or histogram.
+	labels []string
+	series map[string]*series
+}
+
+// series holds the value of a metric for a set of label values.
+type series struct {
+	labels []string
+	value  float64
+
+	// Histogram only.
+	buckets []uint64 // counts by DurationBuckets, not cumulated.
+	sum     float64
+	count   uint64
+}
+
+func (s *series) observe(value float64) {
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(DurationBuckets))
+	}
+	for i, bound := range DurationBuckets {
+		if value <= bound {
+			s.buckets[i]++
+			break
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (f *family) get(labels ...string) *series {
+	key := strings.Join(labels, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: labels}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *family) write(b *strings.Builder) {
+	if len(f.series) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		labels := f.formatLabels(s.labels)
+		if f.typ != "histogram" {
+			fmt.Fprintf(b, "%s%s %s\n", f.name, braces(labels), formatFloat(s.value))
+			continue
+		}
+		var cumul uint64
+		for i, bound := range DurationBuckets {
+			if i < len(s.buckets) {
+				cumul += s.buckets[i]
+			}
+			fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, braces(labels, `le="`+formatFloat(bound)+`"`), cumul)
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, braces(labels, `le="+Inf"`), s.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", f.name, braces(labels), formatFloat(s.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", f.name, braces(labels), s.count)
+	}
+}
+
+func (f *family) formatLabels(values []string) []string {
+	list := make([]string, len(values))
+	for i, value := range values {
+		list[i] = f.labels[i] + `="` + labelEscaper.Replace(value) + `"`
+	}
+	return list
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func braces(labels []string, more ...string) string {
+	labels = append(labels[:len(labels):len(labels)], more...)
+	if len(labels) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,59 @@
+package upnpmetrics
+
+import (
+	"github.com/sqp/gupnp/upnptype"
+
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	m := New()
+	m.SetDevices(TypeRenderer, 2)
+	m.Action("uuid:tv", `TV "salon"`, "Play", 30*time.Millisecond, nil)
+	m.Action("uuid:tv", `TV "salon"`, "Play", 3*time.Second, errors.New("timeout"))
+	m.SubscriptionFailed("uuid:tv", "TV", "urn:schemas-upnp-org:service:AVTransport:1")
+	m.RendererState("uuid:tv", "TV", upnptype.PlaybackStatePlaying)
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Error("content type:", ct)
+	}
+	data, _ := ioutil.ReadAll(w.Body)
+	out := string(data)
+
+	labels := `udn="uuid:tv",device="TV \"salon\"",action="Play"`
+	for _, want := range []string{
+		"# TYPE upnp_devices gauge",
+		`upnp_devices{type="renderer"} 2`,
+		"# TYPE upnp_actions_total counter",
+		`upnp_actions_total{` + labels + `} 2`,
+		`upnp_action_errors_total{` + labels + `} 1`,
+		"# TYPE upnp_action_duration_seconds histogram",
+		`upnp_action_duration_seconds_bucket{` + labels + `,le="0.01"} 0`,
+		`upnp_action_duration_seconds_bucket{` + labels + `,le="0.05"} 1`,
+		`upnp_action_duration_seconds_bucket{` + labels + `,le="2.5"} 1`,
+		`upnp_action_duration_seconds_bucket{` + labels + `,le="5"} 2`,
+		`upnp_action_duration_seconds_bucket{` + labels + `,le="+Inf"} 2`,
+		`upnp_action_duration_seconds_sum{` + labels + `} 3.03`,
+		`upnp_action_duration_seconds_count{` + labels + `} 2`,
+		`upnp_subscription_failures_total{udn="uuid:tv",device="TV",service="urn:schemas-upnp-org:service:AVTransport:1"} 1`,
+		`upnp_renderer_state{udn="uuid:tv",device="TV",state="PLAYING"} 1`,
+		`upnp_renderer_state{udn="uuid:tv",device="TV",state="STOPPED"} 0`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %s", want)
+		}
+	}
+	if strings.Contains(out, "upnp_subscription_renewals_total") {
+		t.Error("empty metric exported")
+	}
+	if t.Failed() {
+		t.Log(out)
+	}
+}
@@ -0,0 +1,394 @@
+// Package upnpmqtt bridges the renderers of a gupnp.MediaControl to a MQTT
+// broker, for home automation.
+//
+// The state of each renderer is published on retained topics, under the
+// prefix and the renderer UDN:
+//
+//   gupnp/{udn}/available     online or offline.
+//   gupnp/{udn}/transport     PLAYING, STOPPED, PAUSED_PLAYBACK...
+//   gupnp/{udn}/volume        0 to 100.
+//   gupnp/{udn}/mute          true or false.
+//   gupnp/{udn}/title         title of the current track.
+//   gupnp/{udn}/position      track position in seconds.
+//
+// Commands are received on the set topics:
+//
+//   gupnp/{udn}/set/action    play_pause, stop, volume_up, volume_down,
+//                             toggle_mute, seek_forward or seek_backward.
+//   gupnp/{udn}/set/volume    0 to 100.
+//   gupnp/{udn}/set/mute      true or false.
+//   gupnp/{udn}/set/seek      position in seconds, or H:MM:SS.
+//
+// Actions are sent by the media control to its selected renderer, so an action
+// on another renderer selects it first.
+//
+// Renderer events are only sent by the media control for the selected
+// renderer. The state of the others is updated by Refresh, to call regularly.
+//
+// Home Assistant discovery payloads are published for each renderer, unless
+// DiscoveryPrefix is empty.
+//
+package upnpmqtt
+
+import (
+	"github.com/sqp/gupnp"
+	"github.com/sqp/gupnp/upnptype"
+
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default topic prefixes.
+//
+const (
+	DefaultPrefix          = "gupnp"
+	DefaultDiscoveryPrefix = "homeassistant"
+)
+
+// hookName is the media hook registered by the bridge.
+const hookName = "upnpmqtt"
+
+// Availability payloads.
+const (
+	online  = "online"
+	offline = "offline"
+)
+
+// ErrUnknownCommand is returned for commands not handled by the bridge.
+//
+var ErrUnknownCommand = errors.New("unknown command")
+
+// Client defines the MQTT client used by the bridge.
+//
+type Client interface {
+	// Publish sends the payload on the topic.
+	//
+	Publish(topic string, retained bool, payload []byte) error
+
+	// Subscribe calls the handler with the messages received on topics
+	// matching the filter, which can use the + and # wildcards.
+	//
+	Subscribe(filter string, handler func(topic string, payload []byte)) error
+}
+
+// actions are the action commands of the renderers.
+var actions = map[string]upnptype.Action{
+	"play_pause":    upnptype.ActionPlayPause,
+	"stop":          upnptype.ActionStop,
+	"volume_up":     upnptype.ActionVolumeUp,
+	"volume_down":   upnptype.ActionVolumeDown,
+	"toggle_mute":   upnptype.ActionToggleMute,
+	"seek_forward":  upnptype.ActionSeekForward,
+	"seek_backward": upnptype.ActionSeekBackward,
+}
+
+// Bridge publishes the renderers state and runs the commands received.
+//
+type Bridge struct {
+	// Call runs fn with exclusive access to the backend. Backends iterating
+	// their own event loop must run fn in it. Defaults to a direct call
+	// guarded by a mutex. Must be set before Start.
+	//
+	Call func(fn func())
+
+	// Prefix is the root of the renderer topics.
+	//
+	Prefix string
+
+	// DiscoveryPrefix is the root of the Home Assistant discovery topics.
+	// Discovery is disabled when empty.
+	//
+	DiscoveryPrefix string
+
+	media  *gupnp.MediaControl
+	client Client
+	log    upnptype.Logger
+	callMu sync.Mutex
+
+	mu        sync.Mutex
+	published map[string]string // last payload sent by topic.
+}
+
+// New creates a MQTT bridge for the media control.
+//
+func New(media *gupnp.MediaControl, client Client, log upnptype.Logger) *Bridge {
+	b := &Bridge{
+		Prefix:          DefaultPrefix,
+		DiscoveryPrefix: DefaultDiscoveryPrefix,
+		media:           media,
+		client:          client,
+		log:             log,
+		published:       make(map[string]string),
+	}
+	b.Call = b.lockedCall
+	return b
+}
+
+func (b *Bridge) lockedCall(fn func()) {
+	b.callMu.Lock()
+	defer b.callMu.Unlock()
+	fn()
+}
+
+// Start subscribes to the command topics, and publishes the renderers found.
+//
+func (b *Bridge) Start() error {
+	if e := b.client.Subscribe(b.Prefix+"/+/set/+", b.onCommand); e != nil {
+		return e
+	}
+	b.Call(func() {
+		b.connectHook()
+		for _, rend := range b.media.Renderers() {
+			b.addRenderer(rend)
+		}
+	})
+	return nil
+}
+
+// Close stops following the media events, and marks the renderers offline.
+//
+func (b *Bridge) Close() {
+	b.Call(func() {
+		b.media.UnsubscribeHook(hookName)
+		for _, rend := range b.media.Renderers() {
+			b.publish(rend, "available", offline)
+		}
+	})
+}
+
+// Refresh polls the state of all renderers, as the media control only
+// forwards the events of the selected one.
+//
+func (b *Bridge) Refresh() {
+	b.Call(func() {
+		for _, rend := range b.media.Renderers() {
+			b.refresh(rend)
+		}
+	})
+}
+
+//
+//-----------------------------------------------------------------[ PUBLISH ]--
+
+// TopicID returns the topic level of a device, without the MQTT wildcards
+// and separator.
+//
+func TopicID(udn string) string {
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(udn)
+}
+
+func (b *Bridge) topic(rend upnptype.Renderer, name string) string {
+	return b.Prefix + "/" + TopicID(rend.UDN()) + "/" + name
+}
+
+// publish sends a retained state of the renderer, if changed.
+func (b *Bridge) publish(rend upnptype.Renderer, name, value string) {
+	b.publishTopic(b.topic(rend, name), value)
+}
+
+func (b *Bridge) publishTopic(topic, value string) {
+	b.mu.Lock()
+	last, ok := b.published[topic]
+	b.published[topic] = value
+	b.mu.Unlock()
+	if ok && last == value {
+		return
+	}
+	if e := b.client.Publish(topic, true, []byte(value)); e != nil {
+		b.log.Warn("mqtt publish", "topic", topic, upnptype.FieldError, e)
+	}
+}
+
+// addRenderer publishes the discovery and state of a new renderer.
+func (b *Bridge) addRenderer(rend upnptype.Renderer) {
+	if b.DiscoveryPrefix != "" {
+		for topic, config := range b.discovery(rend) {
+			data, _ := json.Marshal(config)
+			b.publishTopic(topic, string(data))
+		}
+	}
+	b.publish(rend, "available", online)
+	b.refresh(rend)
+}
+
+// refresh polls the renderer state and publishes it.
+func (b *Bridge) refresh(rend upnptype.Renderer) {
+	if transport, e := rend.GetTransportInfo(0); e == nil {
+		b.publish(rend, "transport", transport.CurrentTransportState)
+	}
+	if vol, e := rend.GetVolume(0, upnptype.ChannelMaster); e == nil {
+		b.publish(rend, "volume", strconv.Itoa(int(vol)))
+	}
+	if mute, e := rend.GetMute(0, upnptype.ChannelMaster); e == nil {
+		b.publish(rend, "mute", strconv.FormatBool(mute))
+	}
+	if pos, e := rend.GetPositionInfo(0); e == nil {
+		b.publish(rend, "position", strconv.Itoa(upnptype.TimeToSecond(pos.RelTime)))
+		_, items, _ := upnptype.ParseDIDL(pos.TrackMetaData)
+		title := ""
+		if len(items) > 0 {
+			title = items[0].Title
+		}
+		b.publish(rend, "title", title)
+	}
+}
+
+// connectHook publishes the events of the selected renderer.
+func (b *Bridge) connectHook() {
+	hook := b.media.SubscribeHook(hookName)
+	hook.OnRendererFound = b.addRenderer
+	hook.OnRendererLost = func(rend upnptype.Renderer) { b.publish(rend, "available", offline) }
+
+	hook.OnTransportState = func(rend upnptype.Renderer, state upnptype.PlaybackState) {
+		b.publish(rend, "transport", state.String())
+	}
+	hook.OnVolume = func(rend upnptype.Renderer, vol uint) {
+		b.publish(rend, "volume", strconv.Itoa(int(vol)))
+	}
+	hook.OnMute = func(rend upnptype.Renderer, mute bool) {
+		b.publish(rend, "mute", strconv.FormatBool(mute))
+	}
+	hook.OnCurrentTrackMetaData = func(rend upnptype.Renderer, item *upnptype.Item) {
+		title := ""
+		if item != nil {
+			title = item.Title
+		}
+		b.publish(rend, "title", title)
+	}
+	hook.OnCurrentTime = func(rend upnptype.Renderer, secs int, _ float64) {
+		b.publish(rend, "position", strconv.Itoa(secs))
+	}
+}
+
+//
+//----------------------------------------------------------------[ COMMANDS ]--
+
+// onCommand runs a command received on {prefix}/{udn}/set/{command}.
+func (b *Bridge) onCommand(topic string, payload []byte) {
+	fields := strings.Split(strings.TrimPrefix(topic, b.Prefix+"/"), "/")
+	if len(fields) != 3 || fields[1] != "set" {
+		return
+	}
+	var e error
+	b.Call(func() {
+		for _, rend := range b.media.Renderers() {
+			if TopicID(rend.UDN()) == fields[0] {
+				e = b.command(rend, fields[2], strings.TrimSpace(string(payload)))
+				return
+			}
+		}
+	})
+	if e != nil {
+		b.log.Warn("mqtt command", "topic", topic, upnptype.FieldError, e)
+	}
+}
+
+func (b *Bridge) command(rend upnptype.Renderer, name, value string) error {
+	caps := upnptype.NewCapableRenderer(rend)
+	var e error
+	switch name {
+	case "action":
+		action, ok := actions[value]
+		if !ok {
+			return ErrUnknownCommand
+		}
+		if !b.media.RendererIsActive(rend) {
+			b.media.SetRenderer(rend.UDN())
+		}
+		e = b.media.Action(action)
+
+	case "volume":
+		var vol int
+		vol, e = strconv.Atoi(value)
+		if e != nil {
+			return e
+		}
+		if vol < 0 {
+			vol = 0
+		}
+		e = caps.SetVolume(0, upnptype.ChannelMaster, uint16(vol)) // Max set by quirks.
+
+	case "mute":
+		var mute bool
+		mute, e = strconv.ParseBool(value)
+		if e != nil {
+			return e
+		}
+		e = caps.SetMute(0, upnptype.ChannelMaster, mute)
+
+	case "seek":
+		secs := upnptype.TimeToSecond(value)
+		if !strings.Contains(value, ":") {
+			secs, e = strconv.Atoi(value)
+			if e != nil {
+				return e
+			}
+		}
+		e = caps.SeekTime(0, secs)
+
+	default:
+		return ErrUnknownCommand
+	}
+	b.refresh(rend) // events of other renderers are not forwarded.
+	return e
+}
+
+//
+//---------------------------------------------------------------[ DISCOVERY ]--
+
+// discovery returns the Home Assistant discovery payloads of the renderer,
+// indexed by topic.
+func (b *Bridge) discovery(rend upnptype.Renderer) map[string]map[string]interface{} {
+	id := strings.NewReplacer(":", "_", "-", "_").Replace(TopicID(rend.UDN()))
+	device := map[string]interface{}{
+		"identifiers": []string{rend.UDN()},
+		"name":        rend.Name(),
+	}
+	if info := rend.DeviceInfo(); info != nil {
+		device["manufacturer"] = info.Manufacturer
+		device["model"] = info.ModelName
+	}
+
+	configs := make(map[string]map[string]interface{})
+	add := func(component, object, name string, config map[string]interface{}) {
+		config["name"] = name
+		config["unique_id"] = id + "_" + object
+		config["device"] = device
+		config["availability_topic"] = b.topic(rend, "available")
+		configs[b.DiscoveryPrefix+"/"+component+"/"+id+"/"+object+"/config"] = config
+	}
+
+	add("sensor", "transport", "Transport", map[string]interface{}{
+		"state_topic": b.topic(rend, "transport"),
+	})
+	add("sensor", "title", "Title", map[string]interface{}{
+		"state_topic": b.topic(rend, "title"),
+	})
+	add("sensor", "position", "Position", map[string]interface{}{
+		"state_topic":         b.topic(rend, "position"),
+		"unit_of_measurement": "s",
+	})
+	add("number", "volume", "Volume", map[string]interface{}{
+		"state_topic":   b.topic(rend, "volume"),
+		"command_topic": b.topic(rend, "set/volume"),
+		"min":           0,
+		"max":           100,
+	})
+	add("switch", "mute", "Mute", map[string]interface{}{
+		"state_topic":   b.topic(rend, "mute"),
+		"command_topic": b.topic(rend, "set/mute"),
+		"payload_on":    "true",
+		"payload_off":   "false",
+	})
+	for object := range actions {
+		name := strings.ToUpper(object[:1]) + strings.Replace(object[1:], "_", " ", -1) // Play pause.
+		add("button", object, name, map[string]interface{}{
+			"command_topic": b.topic(rend, "set/action"),
+			"payload_press": object,
+		})
+	}
+	return configs
+}
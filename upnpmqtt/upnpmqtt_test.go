@@ -0,0 +1,200 @@
+package upnpmqtt
+
+import (
+	"github.com/sqp/gupnp"
+	"github.com/sqp/gupnp/gupnptest"
+	"github.com/sqp/gupnp/mocktype"
+	"github.com/sqp/gupnp/upnplog"
+	"github.com/sqp/gupnp/upnptype"
+
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testBroker is a broker stand-in, keeping the retained messages and
+// delivering the messages to the subscribers.
+type testBroker struct {
+	mu       sync.Mutex
+	retained map[string]string
+	subs     map[string]func(topic string, payload []byte)
+}
+
+func newTestBroker() *testBroker {
+	return &testBroker{
+		retained: make(map[string]string),
+		subs:     make(map[string]func(string, []byte)),
+	}
+}
+
+func (b *testBroker) Publish(topic string, retained bool, payload []byte) error {
+	b.mu.Lock()
+	if retained {
+		b.retained[topic] = string(payload)
+	}
+	var handlers []func(string, []byte)
+	for filter, handler := range b.subs {
+		if matchTopic(filter, topic) {
+			handlers = append(handlers, handler)
+		}
+	}
+	b.mu.Unlock()
+	for _, handler := range handlers {
+		handler(topic, payload)
+	}
+	return nil
+}
+
+func (b *testBroker) Subscribe(filter string, handler func(topic string, payload []byte)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[filter] = handler
+	return nil
+}
+
+func (b *testBroker) get(topic string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.retained[topic]
+}
+
+// matchTopic returns whether the topic matches a filter with wildcards.
+func matchTopic(filter, topic string) bool {
+	fields, levels := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, field := range fields {
+		switch {
+		case field == "#":
+			return true
+
+		case i >= len(levels), field != "+" && field != levels[i]:
+			return false
+		}
+	}
+	return len(fields) == len(levels)
+}
+
+func newTestBridge(t *testing.T) (*testBroker, *gupnp.MediaControl, *mocktype.ControlPoint, *mocktype.Renderer) {
+	media, cp, tv := gupnptest.NewMedia(t)
+
+	broker := newTestBroker()
+	bridge := New(media, broker, upnplog.Test(t))
+	if e := bridge.Start(); e != nil {
+		t.Fatal(e)
+	}
+	return broker, media, cp, tv
+}
+
+func TestState(t *testing.T) {
+	broker, media, cp, tv := newTestBridge(t)
+
+	for topic, want := range map[string]string{
+		"gupnp/uuid:tv/available": "online",
+		"gupnp/uuid:tv/transport": "STOPPED",
+		"gupnp/uuid:tv/volume":    "50",
+		"gupnp/uuid:tv/mute":      "false",
+		"gupnp/uuid:tv/position":  "0",
+	} {
+		if got := broker.get(topic); got != want {
+			t.Errorf("%s: %q, want %q", topic, got, want)
+		}
+	}
+
+	// Events of the selected renderer.
+	media.SetRenderer("uuid:tv")
+	tv.SetState(mocktype.RendererState{Transport: upnptype.PlaybackStatePlaying, Volume: 30, Mute: true, Position: 42})
+	tv.Events().OnCurrentTrackMetaData(tv, &upnptype.Item{Object: upnptype.Object{Title: "Locomotion"}})
+	for topic, want := range map[string]string{
+		"gupnp/uuid:tv/transport": "PLAYING",
+		"gupnp/uuid:tv/volume":    "30",
+		"gupnp/uuid:tv/mute":      "true",
+		"gupnp/uuid:tv/title":     "Locomotion",
+		"gupnp/uuid:tv/position":  "42",
+	} {
+		if got := broker.get(topic); got != want {
+			t.Errorf("%s: %q, want %q", topic, got, want)
+		}
+	}
+
+	// Renderer found and lost.
+	kitchen := mocktype.NewRenderer("uuid:kitchen", "Kitchen")
+	cp.AddRenderer(kitchen)
+	if got := broker.get("gupnp/uuid:kitchen/available"); got != "online" {
+		t.Error("kitchen found:", got)
+	}
+	cp.RemoveRenderer(kitchen)
+	if got := broker.get("gupnp/uuid:kitchen/available"); got != "offline" {
+		t.Error("kitchen lost:", got)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	broker, media, _, tv := newTestBridge(t)
+
+	broker.Publish("gupnp/uuid:tv/set/volume", false, []byte("35"))
+	if st := tv.State(); st.Volume != 35 {
+		t.Error("volume command:", st.Volume)
+	}
+	if got := broker.get("gupnp/uuid:tv/volume"); got != "35" {
+		t.Error("volume state:", got)
+	}
+	broker.Publish("gupnp/uuid:tv/set/mute", false, []byte("true"))
+	if st := tv.State(); !st.Mute {
+		t.Error("mute command: not muted")
+	}
+
+	// Actions select the renderer.
+	broker.Publish("gupnp/uuid:tv/set/action", false, []byte("play_pause"))
+	if media.Renderer() != upnptype.Renderer(tv) {
+		t.Error("action: renderer not selected")
+	}
+	if got := broker.get("gupnp/uuid:tv/transport"); got != "PLAYING" {
+		t.Error("play pause state:", got)
+	}
+
+	broker.Publish("gupnp/uuid:tv/set/seek", false, []byte("0:01:30"))
+	if got := broker.get("gupnp/uuid:tv/position"); got != "90" {
+		t.Error("seek state:", got)
+	}
+	broker.Publish("gupnp/uuid:tv/set/seek", false, []byte("12"))
+	if st := tv.State(); st.Position != 12 {
+		t.Error("seek seconds:", st.Position)
+	}
+
+	tv.ResetCalls()
+	broker.Publish("gupnp/uuid:tv/set/action", false, []byte("rewind"))
+	broker.Publish("gupnp/uuid:other/set/volume", false, []byte("10"))
+	for _, call := range tv.Calls() {
+		if strings.HasPrefix(call, "Set") {
+			t.Error("bad commands called", call)
+		}
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	broker, _, _, _ := newTestBridge(t)
+
+	var config map[string]interface{}
+	data := broker.get("homeassistant/number/uuid_tv/volume/config")
+	if e := json.Unmarshal([]byte(data), &config); e != nil {
+		t.Fatal("volume config:", e, data)
+	}
+	if config["command_topic"] != "gupnp/uuid:tv/set/volume" ||
+		config["state_topic"] != "gupnp/uuid:tv/volume" ||
+		config["availability_topic"] != "gupnp/uuid:tv/available" ||
+		config["unique_id"] != "uuid_tv_volume" {
+		t.Errorf("bad volume config: %v", config)
+	}
+	device, _ := config["device"].(map[string]interface{})
+	if device["name"] != "TV" || device["manufacturer"] != "mocktype" {
+		t.Errorf("bad device: %v", device)
+	}
+
+	data = broker.get("homeassistant/button/uuid_tv/play_pause/config")
+	if e := json.Unmarshal([]byte(data), &config); e != nil {
+		t.Fatal("button config:", e, data)
+	}
+	if config["payload_press"] != "play_pause" || config["name"] != "Play pause" {
+		t.Errorf("bad button config: %v", config)
+	}
+}
@@ -0,0 +1,236 @@
+// Package upnprecord captures the SOAP and event traffic of UPnP devices, and
+// replays it as devices for the media control.
+//
+// A recording is a JSON lines file, one entry per device description, action
+// with its response or fault, and event received:
+//
+//   {"time":"...","kind":"device","udn":"uuid:0a1b...","info":{...}}
+//   {"time":"...","kind":"action","udn":"uuid:0a1b...","service":"urn:...:AVTransport:1","action":"GetTransportInfo","in":{"InstanceID":"0"},"out":{"CurrentTransportState":"PLAYING",...},"duration":0.012}
+//   {"time":"...","kind":"fault","udn":"uuid:0a1b...","service":"urn:...:AVTransport:1","action":"Seek","in":{...},"code":710,"error":"Seek mode not supported"}
+//   {"time":"...","kind":"event","udn":"uuid:0a1b...","service":"urn:...:AVTransport:1","variable":"LastChange","value":"<Event>...</Event>"}
+//
+// The gupnp backend writes its traffic to the recorder set in its options:
+//
+//   rec, e := upnprecord.Create("tv.jsonl")
+//   cp := backendgupnp.NewControlPointWithOptions(backendgupnp.Options{Recorder: rec})
+//
+// A recording attached to a bug report can then be replayed in a test:
+//
+//   recording, e := upnprecord.Open("testdata/tv.jsonl")
+//   cp := upnprecord.NewControlPoint(recording)
+//   media.SetControlPoint(cp)
+//   cp.Play() // forwards the devices and their events.
+//
+package upnprecord
+
+import (
+	"github.com/sqp/gupnp/upnptype"
+
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Kinds of entries.
+//
+const (
+	KindDevice = "device" // device found, with its description.
+	KindLost   = "lost"   // device lost.
+	KindAction = "action" // action sent, with its response.
+	KindFault  = "fault"  // action failed, with the UPnP error code if any.
+	KindEvent  = "event"  // state variable received.
+)
+
+// Entry defines a recorded message of a device.
+//
+type Entry struct {
+	Time time.Time `json:"time"`
+	Kind string    `json:"kind"`
+	UDN  string    `json:"udn"`
+
+	Info *upnptype.DeviceInfo `json:"info,omitempty"` // device description.
+
+	Service  string            `json:"service,omitempty"` // service type.
+	Action   string            `json:"action,omitempty"`
+	In       map[string]string `json:"in,omitempty"`
+	Out      map[string]string `json:"out,omitempty"`
+	Duration float64           `json:"duration,omitempty"` // action duration in seconds.
+	Code     int               `json:"code,omitempty"`     // UPnP error code of a fault.
+	Error    string            `json:"error,omitempty"`
+
+	Variable string `json:"variable,omitempty"` // evented state variable.
+	Value    string `json:"value,omitempty"`
+}
+
+//
+//----------------------------------------------------------------[ RECORDER ]--
+
+// Recorder writes the traffic of devices as JSON lines. It's safe for
+// concurrent use, and a nil Recorder records nothing.
+//
+type Recorder struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+	err    error
+}
+
+// NewRecorder creates a recorder writing to w.
+//
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Create creates a recorder writing to a new file.
+//
+func Create(filename string) (*Recorder, error) {
+	file, e := os.Create(filename)
+	if e != nil {
+		return nil, e
+	}
+	rec := NewRecorder(file)
+	rec.closer = file
+	return rec, nil
+}
+
+// Device records a device found with its description.
+//
+func (rec *Recorder) Device(info *upnptype.DeviceInfo) {
+	if rec == nil {
+		return
+	}
+	rec.write(&Entry{Kind: KindDevice, UDN: info.UDN, Info: info})
+}
+
+// Lost records a device lost.
+//
+func (rec *Recorder) Lost(udn string) {
+	if rec == nil {
+		return
+	}
+	rec.write(&Entry{Kind: KindLost, UDN: udn})
+}
+
+// Action records an action sent to a device service, with its response or
+// error. An *upnptype.Fault is recorded as a fault with its code.
+//
+func (rec *Recorder) Action(udn, service, action string, in, out map[string]string, duration time.Duration, e error) {
+	if rec == nil {
+		return
+	}
+	entry := &Entry{
+		Kind:     KindAction,
+		UDN:      udn,
+		Service:  service,
+		Action:   action,
+		In:       in,
+		Out:      out,
+		Duration: duration.Seconds(),
+	}
+	if e != nil {
+		entry.Kind = KindFault
+		entry.Out = nil
+		entry.Error = e.Error()
+		var fault *upnptype.Fault
+		if errors.As(e, &fault) {
+			entry.Code = fault.Code
+			entry.Error = fault.Description
+		}
+	}
+	rec.write(entry)
+}
+
+// Event records a state variable received from a device service.
+//
+func (rec *Recorder) Event(udn, service, variable, value string) {
+	if rec == nil {
+		return
+	}
+	rec.write(&Entry{Kind: KindEvent, UDN: udn, Service: service, Variable: variable, Value: value})
+}
+
+// Err returns the first write error.
+//
+func (rec *Recorder) Err() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.err
+}
+
+// Close closes the file of a recorder created with Create.
+//
+func (rec *Recorder) Close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.closer == nil {
+		return rec.err
+	}
+	e := rec.closer.Close()
+	rec.closer = nil
+	if rec.err == nil {
+		rec.err = e
+	}
+	return rec.err
+}
+
+func (rec *Recorder) write(entry *Entry) {
+	entry.Time = time.Now()
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if e := rec.enc.Encode(entry); e != nil && rec.err == nil {
+		rec.err = e
+	}
+}
+
+//
+//---------------------------------------------------------------[ RECORDING ]--
+
+// Recording defines the entries of a recorded session.
+//
+type Recording struct {
+	Entries []Entry
+}
+
+// Load reads a recording from JSON lines.
+//
+func Load(r io.Reader) (*Recording, error) {
+	rec := &Recording{}
+	dec := json.NewDecoder(r)
+	for {
+		var entry Entry
+		e := dec.Decode(&entry)
+		if e == io.EOF {
+			return rec, nil
+		}
+		if e != nil {
+			return nil, e
+		}
+		rec.Entries = append(rec.Entries, entry)
+	}
+}
+
+// Open reads a recording file.
+//
+func Open(filename string) (*Recording, error) {
+	file, e := os.Open(filename)
+	if e != nil {
+		return nil, e
+	}
+	defer file.Close()
+	return Load(file)
+}
+
+// Device returns the entries of a device.
+//
+func (rec *Recording) Device(udn string) *Recording {
+	dev := &Recording{}
+	for _, entry := range rec.Entries {
+		if entry.UDN == udn {
+			dev.Entries = append(dev.Entries, entry)
+		}
+	}
+	return dev
+}
@@ -0,0 +1,667 @@
+package upnprecord
+
+import (
+	"github.com/sqp/gupnp/upnptype"
+
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Interfaces implemented.
+var (
+	_ upnptype.ControlPoint = (*ControlPoint)(nil)
+	_ upnptype.Renderer     = (*Renderer)(nil)
+	_ upnptype.Server       = (*Server)(nil)
+)
+
+// ErrNotRecorded is returned by replayed actions without recorded response left.
+//
+var ErrNotRecorded = errors.New("action not recorded")
+
+//
+//-----------------------------------------------------------[ CONTROL POINT ]--
+
+// ControlPoint replays a recording as a control point backend.
+//
+// Devices and their events are forwarded in the recorded order with Next or
+// Play. Actions sent to the devices get the recorded responses of the same
+// action, in order, regardless of their arguments. Devices send the same
+// actions as the gupnp backend, so a session can be replayed as recorded.
+//
+// Service descriptions aren't recorded: all actions are considered supported.
+//
+type ControlPoint struct {
+	events    upnptype.ControlPointEvents
+	timeline  []Entry // device, lost and event entries.
+	next      int
+	renderers map[string]*Renderer
+	servers   map[string]*Server
+	found     map[string]bool
+
+	mu        sync.Mutex
+	responses map[string][]Entry // action and fault entries indexed by UDN and action.
+}
+
+// NewControlPoint creates a control point replaying the recording.
+//
+func NewControlPoint(rec *Recording) *ControlPoint {
+	cp := &ControlPoint{
+		renderers: make(map[string]*Renderer),
+		servers:   make(map[string]*Server),
+		found:     make(map[string]bool),
+		responses: make(map[string][]Entry),
+	}
+	for _, entry := range rec.Entries {
+		switch entry.Kind {
+		case KindAction, KindFault:
+			key := responseKey(entry.UDN, entry.Action)
+			cp.responses[key] = append(cp.responses[key], entry)
+
+		case KindDevice:
+			if entry.Info != nil {
+				cp.addDevice(entry.Info)
+			}
+			cp.timeline = append(cp.timeline, entry)
+
+		case KindLost, KindEvent:
+			cp.timeline = append(cp.timeline, entry)
+		}
+	}
+	return cp
+}
+
+// SetEvents sets the discovery callbacks.
+//
+func (cp *ControlPoint) SetEvents(events upnptype.ControlPointEvents) { cp.events = events }
+
+// Rescan forwards found events for the devices found by the replay.
+//
+func (cp *ControlPoint) Rescan() {
+	for udn := range cp.found {
+		cp.foundDevice(udn)
+	}
+}
+
+// AddDevice isn't supported by the replay.
+//
+func (cp *ControlPoint) AddDevice(location string) error {
+	return errors.New("replay: can't add device " + location)
+}
+
+// RemoveDevice does nothing.
+//
+func (cp *ControlPoint) RemoveDevice(location string) {}
+
+// StaticDevices returns no devices.
+//
+func (cp *ControlPoint) StaticDevices() []string { return nil }
+
+// Renderer returns the renderer recorded with the UDN, or nil.
+//
+func (cp *ControlPoint) Renderer(udn string) *Renderer { return cp.renderers[udn] }
+
+// Server returns the server recorded with the UDN, or nil.
+//
+func (cp *ControlPoint) Server(udn string) *Server { return cp.servers[udn] }
+
+// Next forwards the next device found, lost or event of the recording.
+// Returns false when the recording is over.
+//
+func (cp *ControlPoint) Next() bool {
+	if cp.next >= len(cp.timeline) {
+		return false
+	}
+	entry := cp.timeline[cp.next]
+	cp.next++
+
+	switch entry.Kind {
+	case KindDevice:
+		if !cp.found[entry.UDN] {
+			cp.found[entry.UDN] = true
+			cp.foundDevice(entry.UDN)
+		}
+
+	case KindLost:
+		if cp.found[entry.UDN] {
+			delete(cp.found, entry.UDN)
+			cp.lostDevice(entry.UDN)
+		}
+
+	case KindEvent:
+		if r, ok := cp.renderers[entry.UDN]; ok {
+			r.onEvent(entry.Service, entry.Variable, entry.Value)
+		}
+		if s, ok := cp.servers[entry.UDN]; ok {
+			s.onEvent(entry.Variable, entry.Value)
+		}
+	}
+	return true
+}
+
+// Play forwards all the remaining devices and events of the recording.
+//
+func (cp *ControlPoint) Play() {
+	for cp.Next() {
+	}
+}
+
+// Pending returns the recorded responses not replayed yet.
+//
+func (cp *ControlPoint) Pending() []Entry {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	var list []Entry
+	for _, entries := range cp.responses {
+		list = append(list, entries...)
+	}
+	return list
+}
+
+func (cp *ControlPoint) addDevice(info *upnptype.DeviceInfo) {
+	if cp.renderers[info.UDN] != nil || cp.servers[info.UDN] != nil {
+		return
+	}
+	info = withoutSCPD(info)
+	switch {
+	case strings.Contains(info.DeviceType, ":MediaRenderer:"):
+		r := &Renderer{cp: cp}
+		r.SetUDN(info.UDN)
+		r.SetName(info.FriendlyName)
+		r.SetDeviceInfo(info)
+		cp.renderers[info.UDN] = r
+
+	case strings.Contains(info.DeviceType, ":MediaServer:"):
+		s := &Server{cp: cp}
+		s.SetUDN(info.UDN)
+		s.SetName(info.FriendlyName)
+		s.SetDeviceInfo(info)
+		cp.servers[info.UDN] = s
+	}
+}
+
+func (cp *ControlPoint) foundDevice(udn string) {
+	if r, ok := cp.renderers[udn]; ok && cp.events.OnRendererFound != nil {
+		cp.events.OnRendererFound(r)
+	}
+	if s, ok := cp.servers[udn]; ok && cp.events.OnServerFound != nil {
+		cp.events.OnServerFound(s)
+	}
+}
+
+func (cp *ControlPoint) lostDevice(udn string) {
+	if r, ok := cp.renderers[udn]; ok && cp.events.OnRendererLost != nil {
+		cp.events.OnRendererLost(r)
+	}
+	if s, ok := cp.servers[udn]; ok && cp.events.OnServerLost != nil {
+		cp.events.OnServerLost(s)
+	}
+}
+
+// call returns the next recorded response of a device action.
+func (cp *ControlPoint) call(udn, action string) (map[string]string, error) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	key := responseKey(udn, action)
+	list := cp.responses[key]
+	if len(list) == 0 {
+		return nil, fmt.Errorf("%s %s: %w", udn, action, ErrNotRecorded)
+	}
+	entry := list[0]
+	if len(list) == 1 {
+		delete(cp.responses, key)
+	} else {
+		cp.responses[key] = list[1:]
+	}
+
+	switch {
+	case entry.Kind == KindAction && entry.Out == nil:
+		return map[string]string{}, nil
+	case entry.Kind == KindAction:
+		return entry.Out, nil
+	case entry.Code > 0:
+		return nil, &upnptype.Fault{Code: entry.Code, Description: entry.Error}
+	}
+	return nil, errors.New(entry.Error)
+}
+
+func responseKey(udn, action string) string { return udn + "#" + action }
+
+// withoutSCPD returns a copy of the description without service description
+// URLs, to prevent downloads from the recorded device.
+func withoutSCPD(info *upnptype.DeviceInfo) *upnptype.DeviceInfo {
+	dev := *info
+	dev.Services = append([]upnptype.ServiceInfo(nil), info.Services...)
+	for i := range dev.Services {
+		dev.Services[i].SCPDURL = ""
+	}
+	dev.Devices = nil
+	for _, child := range info.Devices {
+		dev.Devices = append(dev.Devices, *withoutSCPD(&child))
+	}
+	return &dev
+}
+
+//
+//----------------------------------------------------------------[ RENDERER ]--
+
+// Renderer defines a renderer replaying recorded responses and events.
+//
+type Renderer struct {
+	upnptype.RendererBase
+	cp *ControlPoint
+
+	state    upnptype.PlaybackState
+	current  int // track position in seconds.
+	duration int // track duration in seconds.
+}
+
+//-------------------------------------------------------[ RENDERING CONTROL ]--
+
+// GetMute returns the recorded mute state.
+//
+func (r *Renderer) GetMute(instanceID uint32, channel string) (bool, error) {
+	out, e := r.cp.call(r.UDN(), "GetMute")
+	if e != nil {
+		return false, e
+	}
+	return parseBool(out["CurrentMute"]), nil
+}
+
+// SetMute replays the SetMute action.
+//
+func (r *Renderer) SetMute(instanceID uint32, channel string, desiredMute bool) error {
+	_, e := r.cp.call(r.UDN(), "SetMute")
+	return e
+}
+
+// GetVolume returns the recorded volume.
+//
+func (r *Renderer) GetVolume(instanceID uint32, channel string) (uint16, error) {
+	out, e := r.cp.call(r.UDN(), "GetVolume")
+	if e != nil {
+		return 0, e
+	}
+	vol, _ := strconv.Atoi(out["CurrentVolume"])
+	return uint16(vol), nil
+}
+
+// SetVolume replays the SetVolume action.
+//
+func (r *Renderer) SetVolume(instanceID uint32, channel string, volume uint16) error {
+	_, e := r.cp.call(r.UDN(), "SetVolume")
+	return e
+}
+
+// SetRelativeVolume replays the GetVolume and SetVolume actions, and returns
+// the new volume, kept in the range of the renderer quirks.
+//
+func (r *Renderer) SetRelativeVolume(instanceID uint32, channel string, adjustment int32) (uint16, error) {
+	vol, e := r.GetVolume(instanceID, channel)
+	if e != nil {
+		return 0, e
+	}
+	quirks := r.Quirks()
+	newvol := int32(vol) + adjustment
+	switch {
+	case newvol < int32(quirks.VolumeMin):
+		newvol = int32(quirks.VolumeMin)
+	case quirks.VolumeMax > 0 && newvol > int32(quirks.VolumeMax):
+		newvol = int32(quirks.VolumeMax)
+	}
+	if e := r.SetVolume(instanceID, channel, uint16(newvol)); e != nil {
+		return 0, e
+	}
+	return uint16(newvol), nil
+}
+
+//-------------------------------------------------------------[ AVTRANSPORT ]--
+
+// SetAVTransportURI replays the SetAVTransportURI and Play actions.
+//
+func (r *Renderer) SetAVTransportURI(instanceID uint32, currentURI, currentURIMetaData string) error {
+	if r.Quirks().StopBeforeSetURI {
+		r.Stop(instanceID)
+	}
+	if _, e := r.cp.call(r.UDN(), "SetAVTransportURI"); e != nil {
+		return e
+	}
+	return r.Play(instanceID, upnptype.PlaySpeedNormal)
+}
+
+// SetNextAVTransportURI replays the SetNextAVTransportURI action.
+//
+func (r *Renderer) SetNextAVTransportURI(instanceID uint32, nextURI, nextURIMetaData string) error {
+	_, e := r.cp.call(r.UDN(), "SetNextAVTransportURI")
+	return e
+}
+
+// AddURIToQueue returns the recorded queue position.
+//
+func (r *Renderer) AddURIToQueue(instanceID uint32, req *upnptype.AddURIToQueueIn) (*upnptype.AddURIToQueueOut, error) {
+	out, e := r.cp.call(r.UDN(), "AddURIToQueue")
+	if e != nil {
+		return nil, e
+	}
+	return &upnptype.AddURIToQueueOut{
+		FirstTrackNumberEnqueued: parseUint32(out["FirstTrackNumberEnqueued"]),
+		NumTracksAdded:           parseUint32(out["NumTracksAdded"]),
+		NewQueueLength:           parseUint32(out["NewQueueLength"]),
+	}, nil
+}
+
+// AddMultipleURIsToQueue returns the recorded queue position.
+//
+func (r *Renderer) AddMultipleURIsToQueue(instanceID uint32, req *upnptype.AddMultipleURIsToQueueIn) (*upnptype.AddMultipleURIsToQueueOut, error) {
+	out, e := r.cp.call(r.UDN(), "AddMultipleURIsToQueue")
+	if e != nil {
+		return nil, e
+	}
+	return &upnptype.AddMultipleURIsToQueueOut{
+		FirstTrackNumberEnqueued: parseUint32(out["FirstTrackNumberEnqueued"]),
+		NumTracksAdded:           parseUint32(out["NumTracksAdded"]),
+		NewQueueLength:           parseUint32(out["NewQueueLength"]),
+		NewUpdateID:              parseUint32(out["NewUpdateID"]),
+	}, nil
+}
+
+// GetMediaInfo returns the recorded media.
+//
+func (r *Renderer) GetMediaInfo(instanceID uint32) (*upnptype.MediaInfo, error) {
+	out, e := r.cp.call(r.UDN(), "GetMediaInfo")
+	if e != nil {
+		return nil, e
+	}
+	return &upnptype.MediaInfo{
+		NrTracks:           parseUint32(out["NrTracks"]),
+		MediaDuration:      out["MediaDuration"],
+		CurrentURI:         out["CurrentURI"],
+		CurrentURIMetaData: out["CurrentURIMetaData"],
+		NextURI:            out["NextURI"],
+		NextURIMetaData:    out["NextURIMetaData"],
+		PlayMedium:         out["PlayMedium"],
+		RecordMedium:       out["RecordMedium"],
+		WriteStatus:        out["WriteStatus"],
+	}, nil
+}
+
+// GetTransportInfo returns the recorded transport state.
+//
+func (r *Renderer) GetTransportInfo(instanceID uint32) (*upnptype.TransportInfo, error) {
+	out, e := r.cp.call(r.UDN(), "GetTransportInfo")
+	if e != nil {
+		return nil, e
+	}
+	return &upnptype.TransportInfo{
+		CurrentTransportState:  out["CurrentTransportState"],
+		CurrentTransportStatus: out["CurrentTransportStatus"],
+		CurrentSpeed:           out["CurrentSpeed"],
+	}, nil
+}
+
+// GetPositionInfo returns the recorded track position.
+//
+func (r *Renderer) GetPositionInfo(instanceID uint32) (*upnptype.PositionInfo, error) {
+	out, e := r.cp.call(r.UDN(), "GetPositionInfo")
+	if e != nil {
+		return nil, e
+	}
+	return &upnptype.PositionInfo{
+		Track:         parseUint32(out["Track"]),
+		TrackDuration: out["TrackDuration"],
+		TrackMetaData: out["TrackMetaData"],
+		TrackURI:      out["TrackURI"],
+		RelTime:       out["RelTime"],
+		AbsTime:       out["AbsTime"],
+		RelCount:      parseUint32(out["RelCount"]),
+		AbsCount:      parseUint32(out["AbsCount"]),
+	}, nil
+}
+
+// Stop replays the Stop action.
+//
+func (r *Renderer) Stop(instanceID uint32) error {
+	_, e := r.cp.call(r.UDN(), "Stop")
+	return e
+}
+
+// Play replays the Play action.
+//
+func (r *Renderer) Play(instanceID uint32, speed string) error {
+	_, e := r.cp.call(r.UDN(), "Play")
+	return e
+}
+
+// Pause replays the Pause action.
+//
+func (r *Renderer) Pause(instanceID uint32) error {
+	_, e := r.cp.call(r.UDN(), "Pause")
+	return e
+}
+
+// PlayPause replays the Play or Pause action, depending on the transport
+// state received.
+//
+func (r *Renderer) PlayPause(instanceID uint32, speed string) error {
+	switch r.state {
+	case upnptype.PlaybackStatePaused, upnptype.PlaybackStateStopped:
+		return r.Play(instanceID, speed)
+
+	case upnptype.PlaybackStatePlaying:
+		return r.Pause(instanceID)
+	}
+	return nil
+}
+
+// Seek replays the Seek action, and the position request following it.
+//
+func (r *Renderer) Seek(instanceID uint32, unit, target string) error {
+	if _, e := r.cp.call(r.UDN(), "Seek"); e != nil {
+		return e
+	}
+	r.GetCurrentTime()
+	r.DisplayCurrentTime()
+	return nil
+}
+
+// Next replays the Next action.
+//
+func (r *Renderer) Next(instanceID uint32) error {
+	_, e := r.cp.call(r.UDN(), "Next")
+	return e
+}
+
+// Previous replays the Previous action.
+//
+func (r *Renderer) Previous(instanceID uint32) error {
+	_, e := r.cp.call(r.UDN(), "Previous")
+	return e
+}
+
+// GetCurrentTransportActions returns the recorded actions.
+//
+func (r *Renderer) GetCurrentTransportActions(instanceID uint32) ([]string, error) {
+	out, e := r.cp.call(r.UDN(), "GetCurrentTransportActions")
+	if e != nil {
+		return nil, e
+	}
+	var list []string
+	for _, action := range strings.Split(out["Actions"], ",") {
+		if action = strings.TrimSpace(action); action != "" {
+			list = append(list, action)
+		}
+	}
+	return list, nil
+}
+
+// GetCurrentTime replays a position request, and returns the position in
+// seconds.
+//
+func (r *Renderer) GetCurrentTime() int {
+	if pos, e := r.GetPositionInfo(0); e == nil {
+		r.current = upnptype.TimeToSecond(pos.AbsTime)
+	}
+	return r.current
+}
+
+// DisplayCurrentTime forwards the current position with the OnCurrentTime event.
+//
+func (r *Renderer) DisplayCurrentTime() {
+	if r.Events().OnCurrentTime == nil {
+		return
+	}
+	percent := 0.
+	if r.duration > 0 {
+		percent = float64(r.current) * 100 / float64(r.duration)
+	}
+	r.Events().OnCurrentTime(r, r.current, percent)
+}
+
+// onEvent forwards a recorded state variable.
+func (r *Renderer) onEvent(service, variable, value string) {
+	events := r.Events()
+	switch {
+	case variable == "CurrentConnectionIDs":
+		if events.OnCurrentConnectionIDs != nil {
+			events.OnCurrentConnectionIDs(r, upnptype.ParseConnectionIDs(value))
+		}
+
+	case variable != "LastChange":
+
+	case strings.Contains(service, ":AVTransport:"):
+		values := parseLastChange(value)
+		if v, ok := values["TransportState"]; ok {
+			r.state = upnptype.PlaybackStateFromName(v)
+			if events.OnTransportState != nil {
+				events.OnTransportState(r, r.state)
+			}
+		}
+		if v, ok := values["CurrentTrackDuration"]; ok {
+			r.duration = upnptype.TimeToSecond(v)
+			if events.OnCurrentTrackDuration != nil {
+				events.OnCurrentTrackDuration(r, r.duration)
+			}
+		}
+		if v, ok := values["CurrentTrackMetaData"]; ok && events.OnCurrentTrackMetaData != nil {
+			item := &upnptype.Item{}
+			if _, items, _ := upnptype.ParseDIDL(v); len(items) > 0 {
+				item = &items[0]
+			}
+			events.OnCurrentTrackMetaData(r, item)
+		}
+
+	case strings.Contains(service, ":RenderingControl:"):
+		values := parseLastChange(value)
+		if v, ok := values["Mute"]; ok && events.OnMute != nil {
+			events.OnMute(r, parseBool(v))
+		}
+		if v, ok := values["Volume"]; ok && events.OnVolume != nil {
+			if vol, e := strconv.Atoi(v); e == nil {
+				events.OnVolume(r, uint(vol))
+			}
+		}
+	}
+}
+
+//
+//------------------------------------------------------------------[ SERVER ]--
+
+// Server defines a server replaying recorded responses and events.
+//
+type Server struct {
+	upnptype.ServerBase
+	cp *ControlPoint
+}
+
+// Browse returns the recorded children of a container.
+//
+func (s *Server) Browse(req *upnptype.BrowseRequest) (*upnptype.BrowseResult, error) {
+	out, e := s.cp.call(s.UDN(), "Browse")
+	if e != nil {
+		return nil, e
+	}
+	containers, items, e := upnptype.ParseDIDL(out["Result"])
+	if e != nil {
+		return nil, e
+	}
+	res := &upnptype.BrowseResult{
+		NumberReturned: int32(parseUint32(out["NumberReturned"])),
+		TotalMatches:   int32(parseUint32(out["TotalMatches"])),
+		UpdateID:       int32(parseUint32(out["UpdateID"])),
+		Container:      containers,
+	}
+	for _, item := range items {
+		res.Item = append(res.Item, item.Object)
+	}
+	return res, nil
+}
+
+// BrowseMetadata returns the recorded object with its DIDL-Lite description.
+//
+func (s *Server) BrowseMetadata(id string, startingIndex, requestedCount uint) ([]upnptype.Container, []upnptype.Item, string) {
+	out, e := s.cp.call(s.UDN(), "Browse")
+	if e != nil {
+		return nil, nil, ""
+	}
+	containers, items, _ := upnptype.ParseDIDL(out["Result"])
+	return containers, items, out["Result"]
+}
+
+// onEvent forwards a recorded state variable.
+func (s *Server) onEvent(variable, value string) {
+	events := s.Events()
+	switch variable {
+	case "SystemUpdateID":
+		if id, e := strconv.ParseUint(value, 10, 32); e == nil && events.OnSystemUpdateID != nil {
+			events.OnSystemUpdateID(s, uint(id))
+		}
+
+	case "ContainerUpdateIDs":
+		if events.OnContainerUpdateIDs != nil {
+			events.OnContainerUpdateIDs(s, upnptype.ParseContainerUpdateIDs(value))
+		}
+	}
+}
+
+//
+//-----------------------------------------------------------------[ HELPERS ]--
+
+// parseLastChange returns the values of the first instance of a LastChange
+// event. Variables with channels only keep the Master channel.
+func parseLastChange(str string) map[string]string {
+	var doc struct {
+		Instances []struct {
+			Vars []struct {
+				XMLName xml.Name
+				Val     string `xml:"val,attr"`
+				Channel string `xml:"channel,attr"`
+			} `xml:",any"`
+		} `xml:"InstanceID"`
+	}
+	values := make(map[string]string)
+	if xml.Unmarshal([]byte(str), &doc) != nil || len(doc.Instances) == 0 {
+		return values
+	}
+	for _, v := range doc.Instances[0].Vars {
+		if v.Channel == "" || v.Channel == upnptype.ChannelMaster {
+			values[v.XMLName.Local] = v.Val
+		}
+	}
+	return values
+}
+
+func parseBool(str string) bool {
+	switch strings.ToLower(str) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+func parseUint32(str string) uint32 {
+	i, _ := strconv.ParseUint(str, 10, 32)
+	return uint32(i)
+}
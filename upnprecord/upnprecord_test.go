@@ -0,0 +1,154 @@
+package upnprecord
+
+import (
+	"github.com/sqp/gupnp"
+	"github.com/sqp/gupnp/upnptype"
+
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+const (
+	lastChangeAVT = `<Event xmlns="urn:schemas-upnp-org:metadata-1-0/AVT/"><InstanceID val="0">` +
+		`<TransportState val="PLAYING"/><CurrentTrackDuration val="0:03:00"/>` +
+		`<CurrentTrackMetaData val="&lt;DIDL-Lite&gt;&lt;item id=&quot;1&quot;&gt;&lt;title&gt;Locomotion&lt;/title&gt;&lt;/item&gt;&lt;/DIDL-Lite&gt;"/>` +
+		`</InstanceID></Event>`
+
+	lastChangeRCS = `<Event xmlns="urn:schemas-upnp-org:metadata-1-0/RCS/"><InstanceID val="0">` +
+		`<Volume channel="LF" val="10"/><Volume channel="Master" val="25"/>` +
+		`</InstanceID></Event>`
+)
+
+// recordSession records the traffic of a TV session, as sent by the gupnp backend.
+func recordSession(t *testing.T) *Recording {
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	rec.Device(&upnptype.DeviceInfo{
+		DeviceType:   "urn:schemas-upnp-org:device:MediaRenderer:1",
+		FriendlyName: "TV",
+		UDN:          "uuid:tv",
+		Services: []upnptype.ServiceInfo{
+			{ServiceType: upnptype.ServiceTypeAVTransport, SCPDURL: "http://192.0.2.1/avt.xml"},
+			{ServiceType: upnptype.ServiceTypeRenderingControl, SCPDURL: "http://192.0.2.1/rcs.xml"},
+		},
+	})
+	avt, rcs := upnptype.ServiceTypeAVTransport, upnptype.ServiceTypeRenderingControl
+	in := map[string]string{"InstanceID": "0", "Channel": "Master"}
+	rec.Action("uuid:tv", rcs, "GetVolume", in, map[string]string{"CurrentVolume": "20"}, time.Millisecond, nil)
+	rec.Action("uuid:tv", rcs, "GetMute", in, map[string]string{"CurrentMute": "0"}, time.Millisecond, nil)
+	rec.Action("uuid:tv", avt, "GetPositionInfo", nil, map[string]string{"TrackDuration": "0:03:00"}, time.Millisecond, nil)
+	rec.Action("uuid:tv", avt, "Play", map[string]string{"InstanceID": "0", "Speed": "1"}, nil, time.Millisecond, nil)
+	rec.Event("uuid:tv", avt, "LastChange", lastChangeAVT)
+	rec.Event("uuid:tv", rcs, "LastChange", lastChangeRCS)
+	rec.Action("uuid:tv", avt, "Seek", nil, nil, time.Millisecond, &upnptype.Fault{Code: 710, Description: "Seek mode not supported"})
+	rec.Action("uuid:tv", avt, "Stop", nil, nil, time.Second, errors.New("timeout"))
+	rec.Lost("uuid:tv")
+	if e := rec.Err(); e != nil {
+		t.Fatal(e)
+	}
+
+	recording, e := Load(&buf)
+	if e != nil {
+		t.Fatal("load:", e)
+	}
+	return recording
+}
+
+func TestRecord(t *testing.T) {
+	recording := recordSession(t)
+	if len(recording.Entries) != 10 {
+		t.Fatal("entries:", len(recording.Entries))
+	}
+	dev := recording.Entries[0]
+	if dev.Kind != KindDevice || dev.Info == nil || dev.Info.FriendlyName != "TV" {
+		t.Errorf("bad device entry: %+v", dev)
+	}
+	vol := recording.Entries[1]
+	if vol.Kind != KindAction || vol.Action != "GetVolume" || vol.In["Channel"] != "Master" ||
+		vol.Out["CurrentVolume"] != "20" || vol.Duration != 0.001 || vol.Time.IsZero() {
+		t.Errorf("bad action entry: %+v", vol)
+	}
+	seek := recording.Entries[7]
+	if seek.Kind != KindFault || seek.Code != 710 || seek.Error != "Seek mode not supported" {
+		t.Errorf("bad fault entry: %+v", seek)
+	}
+	stop := recording.Entries[8]
+	if stop.Kind != KindFault || stop.Code != 0 || stop.Error != "timeout" {
+		t.Errorf("bad error entry: %+v", stop)
+	}
+	if n := len(recording.Device("uuid:other").Entries); n != 0 {
+		t.Error("entries of another device:", n)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	media, e := gupnp.New(nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer media.Close()
+
+	var states []upnptype.PlaybackState
+	var volumes []uint
+	var title string
+	lost := false
+	hook := media.SubscribeHook("test")
+	hook.OnTransportState = func(_ upnptype.Renderer, state upnptype.PlaybackState) { states = append(states, state) }
+	hook.OnVolume = func(_ upnptype.Renderer, vol uint) { volumes = append(volumes, vol) }
+	hook.OnCurrentTrackMetaData = func(_ upnptype.Renderer, item *upnptype.Item) { title = item.Title }
+	hook.OnRendererLost = func(upnptype.Renderer) { lost = true }
+
+	cp := NewControlPoint(recordSession(t))
+	media.SetControlPoint(cp)
+	if !cp.Next() || media.GetRenderer("uuid:tv") == nil {
+		t.Fatal("renderer not found")
+	}
+	tv := cp.Renderer("uuid:tv")
+	if url := tv.DeviceInfo().Service(upnptype.ServiceTypeAVTransport).SCPDURL; url != "" {
+		t.Error("SCPD URL kept:", url)
+	}
+
+	media.SetRenderer("uuid:tv") // GetVolume, GetMute, GetTransportInfo (not recorded) and GetPositionInfo.
+	if len(volumes) != 1 || volumes[0] != 20 {
+		t.Error("volume on select:", volumes)
+	}
+	if e := tv.Play(0, upnptype.PlaySpeedNormal); e != nil {
+		t.Error("play:", e)
+	}
+
+	cp.Next()
+	cp.Next()
+	if len(states) == 0 || states[len(states)-1] != upnptype.PlaybackStatePlaying {
+		t.Error("transport events:", states)
+	}
+	if title != "Locomotion" {
+		t.Errorf("track title: %q", title)
+	}
+	if volumes[len(volumes)-1] != 25 {
+		t.Error("volume events:", volumes)
+	}
+
+	var fault *upnptype.Fault
+	if e := tv.Seek(0, upnptype.SeekModeRelTime, "0:01:00"); !errors.As(e, &fault) || fault.Code != 710 {
+		t.Error("seek fault:", e)
+	}
+	if e := tv.Stop(0); e == nil || e.Error() != "timeout" {
+		t.Error("stop error:", e)
+	}
+	if _, e := tv.GetVolume(0, upnptype.ChannelMaster); !errors.Is(e, ErrNotRecorded) {
+		t.Error("volume not recorded:", e)
+	}
+	if pending := cp.Pending(); len(pending) != 0 {
+		t.Errorf("responses not replayed: %+v", pending)
+	}
+
+	cp.Play()
+	if !lost || media.GetRenderer("uuid:tv") != nil {
+		t.Error("renderer not lost")
+	}
+	if cp.Next() {
+		t.Error("next after the end")
+	}
+}
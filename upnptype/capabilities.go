@@ -0,0 +1,276 @@
+package upnptype
+
+import "errors"
+
+// Service types of media devices, to use with SCPD and HasAction.
+//
+const (
+	ServiceTypeAVTransport       = "urn:schemas-upnp-org:service:AVTransport:1"
+	ServiceTypeRenderingControl  = "urn:schemas-upnp-org:service:RenderingControl:1"
+	ServiceTypeConnectionManager = "urn:schemas-upnp-org:service:ConnectionManager:1"
+	ServiceTypeContentDirectory  = "urn:schemas-upnp-org:service:ContentDirectory:1"
+)
+
+// ErrNotSupported is returned when an action or argument value isn't
+// supported by a device, before any network call.
+//
+type ErrNotSupported struct {
+	Action string // Action name.
+	Value  string // Rejected argument value, if any.
+}
+
+func (e *ErrNotSupported) Error() string {
+	if e.Value != "" {
+		return "not supported: " + e.Action + " " + e.Value
+	}
+	return "not supported: " + e.Action
+}
+
+// IsNotSupported returns whether the error is, or wraps, an ErrNotSupported.
+//
+func IsNotSupported(e error) bool {
+	var notSupported *ErrNotSupported
+	return errors.As(e, &notSupported)
+}
+
+//
+//------------------------------------------------------------[ CAPABILITIES ]--
+
+// CapableRenderer wraps a renderer to check actions against its services
+// description, and rejects unsupported actions with ErrNotSupported.
+//
+// When a service description can't be read, actions are allowed and sent
+// as usual.
+//
+// Known quirks of the renderer are applied to the calls.
+//
+type CapableRenderer struct {
+	Renderer
+	quirks Quirks
+}
+
+// NewCapableRenderer wraps the renderer with capability checks.
+//
+func NewCapableRenderer(rend Renderer) *CapableRenderer {
+	return &CapableRenderer{
+		Renderer: rend,
+		quirks:   rend.Quirks(),
+	}
+}
+
+// Supports returns whether the service provides the action.
+//
+func (cr *CapableRenderer) Supports(serviceType, action string) bool {
+	scpd, e := cr.SCPD(serviceType)
+	return e != nil || scpd.HasAction(action)
+}
+
+// SeekModes returns the seek modes supported by the renderer.
+// Returns nil if unknown.
+//
+func (cr *CapableRenderer) SeekModes() []string {
+	return cr.allowedValues(ServiceTypeAVTransport, "Seek", "Unit")
+}
+
+// PlayModes returns the play modes supported by the renderer.
+// Returns nil if unknown.
+//
+func (cr *CapableRenderer) PlayModes() []string {
+	return cr.allowedValues(ServiceTypeAVTransport, "SetPlayMode", "NewPlayMode")
+}
+
+// SupportsSeekMode returns whether the renderer can seek with the given unit.
+//
+func (cr *CapableRenderer) SupportsSeekMode(unit string) bool {
+	return cr.allows(ServiceTypeAVTransport, "Seek", "Unit", unit)
+}
+
+// SupportsPlayMode returns whether the renderer can use the given play mode.
+//
+func (cr *CapableRenderer) SupportsPlayMode(mode string) bool {
+	return cr.allows(ServiceTypeAVTransport, "SetPlayMode", "NewPlayMode", mode)
+}
+
+// TimeSeekMode returns the seek mode to use to move in the current track:
+// the one set by quirks, ABS_TIME if supported, or REL_TIME.
+// Returns an empty string if none works.
+//
+func (cr *CapableRenderer) TimeSeekMode() string {
+	if cr.quirks.SeekMode != "" {
+		return cr.quirks.SeekMode
+	}
+	for _, unit := range []string{SeekModeAbsTime, SeekModeRelTime} {
+		if cr.SupportsSeekMode(unit) {
+			return unit
+		}
+	}
+	return ""
+}
+
+// Quirks returns the behaviour adjustments applied to the renderer.
+//
+func (cr *CapableRenderer) Quirks() Quirks { return cr.quirks }
+
+//
+//-----------------------------------------------------------[ CHECKED CALLS ]--
+
+// Seek checks the seek mode before seeking.
+//
+func (cr *CapableRenderer) Seek(instanceID uint32, unit, target string) error {
+	if !cr.SupportsSeekMode(unit) {
+		return &ErrNotSupported{Action: "Seek", Value: unit}
+	}
+	return cr.Renderer.Seek(instanceID, unit, target)
+}
+
+// SeekTime seeks to the given position in seconds in the current track,
+// with the first time seek mode supported.
+//
+func (cr *CapableRenderer) SeekTime(instanceID uint32, secs int) error {
+	unit := cr.TimeSeekMode()
+	if unit == "" {
+		return &ErrNotSupported{Action: "Seek", Value: SeekModeAbsTime}
+	}
+	return cr.Renderer.Seek(instanceID, unit, TimeToString(secs))
+}
+
+// Next checks the action before skipping to the next track.
+//
+func (cr *CapableRenderer) Next(instanceID uint32) error {
+	if !cr.Supports(ServiceTypeAVTransport, "Next") {
+		return &ErrNotSupported{Action: "Next"}
+	}
+	return cr.Renderer.Next(instanceID)
+}
+
+// Previous checks the action before moving to the previous track.
+//
+func (cr *CapableRenderer) Previous(instanceID uint32) error {
+	if !cr.Supports(ServiceTypeAVTransport, "Previous") {
+		return &ErrNotSupported{Action: "Previous"}
+	}
+	return cr.Renderer.Previous(instanceID)
+}
+
+// Pause checks the action before pausing.
+//
+func (cr *CapableRenderer) Pause(instanceID uint32) error {
+	if !cr.Supports(ServiceTypeAVTransport, "Pause") {
+		return &ErrNotSupported{Action: "Pause"}
+	}
+	return cr.Renderer.Pause(instanceID)
+}
+
+// PlayPause checks the Play action, and the Pause action when the renderer is
+// playing, before toggling play / pause.
+//
+func (cr *CapableRenderer) PlayPause(instanceID uint32, speed string) error {
+	if !cr.Supports(ServiceTypeAVTransport, "Play") {
+		return &ErrNotSupported{Action: "Play"}
+	}
+	if !cr.Supports(ServiceTypeAVTransport, "Pause") {
+		info, e := cr.Renderer.GetTransportInfo(instanceID)
+		if e == nil && PlaybackStateFromName(info.CurrentTransportState) == PlaybackStatePlaying {
+			return &ErrNotSupported{Action: "Pause"}
+		}
+	}
+	return cr.Renderer.PlayPause(instanceID, speed)
+}
+
+// Stop checks the action before stopping.
+//
+func (cr *CapableRenderer) Stop(instanceID uint32) error {
+	if !cr.Supports(ServiceTypeAVTransport, "Stop") {
+		return &ErrNotSupported{Action: "Stop"}
+	}
+	return cr.Renderer.Stop(instanceID)
+}
+
+// SetAVTransportURI sets the playback URI, with a minimal metadata if the
+// renderer requires one.
+//
+func (cr *CapableRenderer) SetAVTransportURI(instanceID uint32, currentURI, currentURIMetaData string) error {
+	if currentURIMetaData == "" && cr.quirks.RequireMetadata {
+		currentURIMetaData = MinimalMetadata(currentURI, "")
+	}
+	return cr.Renderer.SetAVTransportURI(instanceID, currentURI, currentURIMetaData)
+}
+
+// SetNextAVTransportURI checks the action before setting the next URI.
+//
+func (cr *CapableRenderer) SetNextAVTransportURI(instanceID uint32, nextURI, nextURIMetaData string) error {
+	if !cr.Supports(ServiceTypeAVTransport, "SetNextAVTransportURI") {
+		return &ErrNotSupported{Action: "SetNextAVTransportURI"}
+	}
+	if nextURIMetaData == "" && cr.quirks.RequireMetadata {
+		nextURIMetaData = MinimalMetadata(nextURI, "")
+	}
+	return cr.Renderer.SetNextAVTransportURI(instanceID, nextURI, nextURIMetaData)
+}
+
+// SetMute checks the action before setting the mute state.
+//
+func (cr *CapableRenderer) SetMute(instanceID uint32, channel string, desiredMute bool) error {
+	if !cr.Supports(ServiceTypeRenderingControl, "SetMute") {
+		return &ErrNotSupported{Action: "SetMute"}
+	}
+	return cr.Renderer.SetMute(instanceID, channel, desiredMute)
+}
+
+// SetVolume checks the action before setting the volume.
+// The volume is kept in the range accepted by the renderer.
+//
+func (cr *CapableRenderer) SetVolume(instanceID uint32, channel string, desiredVolume uint16) error {
+	if !cr.Supports(ServiceTypeRenderingControl, "SetVolume") {
+		return &ErrNotSupported{Action: "SetVolume"}
+	}
+	switch {
+	case desiredVolume < cr.quirks.VolumeMin:
+		desiredVolume = cr.quirks.VolumeMin
+	case cr.quirks.VolumeMax > 0 && desiredVolume > cr.quirks.VolumeMax:
+		desiredVolume = cr.quirks.VolumeMax
+	}
+	return cr.Renderer.SetVolume(instanceID, channel, desiredVolume)
+}
+
+//
+//-----------------------------------------------------------------[ HELPERS ]--
+
+// argVariable returns the state variable related to an action argument.
+func (cr *CapableRenderer) argVariable(serviceType, action, argument string) *StateVariable {
+	scpd, e := cr.SCPD(serviceType)
+	if e != nil {
+		return nil
+	}
+	act := scpd.Action(action)
+	if act == nil {
+		return nil
+	}
+	for _, arg := range act.Arguments {
+		if arg.Name == argument {
+			return scpd.StateVariable(arg.RelatedStateVariable)
+		}
+	}
+	return nil
+}
+
+func (cr *CapableRenderer) allowedValues(serviceType, action, argument string) []string {
+	if sv := cr.argVariable(serviceType, action, argument); sv != nil {
+		return sv.AllowedValues
+	}
+	return nil
+}
+
+// allows returns whether the action accepts the argument value.
+// The action must be provided, and the value allowed if a list is defined.
+func (cr *CapableRenderer) allows(serviceType, action, argument, value string) bool {
+	scpd, e := cr.SCPD(serviceType)
+	if e != nil {
+		return true
+	}
+	if !scpd.HasAction(action) {
+		return false
+	}
+	sv := cr.argVariable(serviceType, action, argument)
+	return sv == nil || sv.Allows(value)
+}
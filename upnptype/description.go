@@ -0,0 +1,384 @@
+package upnptype
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+//-------------------------------------------------------[ DEVICE DESCRIPTION ]--
+
+// DeviceInfo defines the description of an UPnP device.
+//
+type DeviceInfo struct {
+	DeviceType       string `xml:"deviceType"`
+	FriendlyName     string `xml:"friendlyName"`
+	Manufacturer     string `xml:"manufacturer"`
+	ManufacturerURL  string `xml:"manufacturerURL"`
+	ModelDescription string `xml:"modelDescription"`
+	ModelName        string `xml:"modelName"`
+	ModelNumber      string `xml:"modelNumber"`
+	ModelURL         string `xml:"modelURL"`
+	SerialNumber     string `xml:"serialNumber"`
+	UDN              string `xml:"UDN"`
+	PresentationURL  string `xml:"presentationURL"`
+
+	Location  string        `xml:"-"` // URL of the description document.
+	ParentUDN string        `xml:"-"` // UDN of the parent device when embedded.
+	Services  []ServiceInfo `xml:"serviceList>service"`
+	Devices   []DeviceInfo  `xml:"deviceList>device"` // Embedded devices.
+}
+
+// IsEmbedded returns whether the device is embedded in another device.
+//
+func (info *DeviceInfo) IsEmbedded() bool { return info.ParentUDN != "" }
+
+// ServiceInfo defines a service provided by an UPnP device.
+//
+// URLs are absolute when provided by a backend, and may be relative to the
+// device location when parsed from a description document.
+//
+type ServiceInfo struct {
+	ServiceType string `xml:"serviceType"`
+	ServiceID   string `xml:"serviceId"`
+	SCPDURL     string `xml:"SCPDURL"`
+	ControlURL  string `xml:"controlURL"`
+	EventSubURL string `xml:"eventSubURL"`
+}
+
+// Service returns the service of the given type (version ignored) provided by
+// the device or one of its embedded devices. Returns nil if not found.
+//
+func (info *DeviceInfo) Service(serviceType string) *ServiceInfo {
+	for i := range info.Services {
+		if sameType(info.Services[i].ServiceType, serviceType) {
+			return &info.Services[i]
+		}
+	}
+	for i := range info.Devices {
+		if srv := info.Devices[i].Service(serviceType); srv != nil {
+			return srv
+		}
+	}
+	return nil
+}
+
+// ParseDeviceDescription parses a device description document.
+//
+// Relative service URLs are resolved against the URLBase if any, or the
+// location the document was downloaded from.
+//
+func ParseDeviceDescription(data []byte, location string) (*DeviceInfo, error) {
+	doc := struct {
+		URLBase string     `xml:"URLBase"`
+		Device  DeviceInfo `xml:"device"`
+	}{}
+	if e := xml.Unmarshal(data, &doc); e != nil {
+		return nil, e
+	}
+	if doc.Device.UDN == "" {
+		return nil, errors.New("device description: missing UDN")
+	}
+
+	base := location
+	if doc.URLBase != "" {
+		base = doc.URLBase
+	}
+	doc.Device.resolve(base, location)
+	return &doc.Device, nil
+}
+
+func (info *DeviceInfo) resolve(base, location string) {
+	info.Location = location
+	info.PresentationURL = resolveURL(base, info.PresentationURL)
+	for i := range info.Services {
+		srv := &info.Services[i]
+		srv.SCPDURL = resolveURL(base, srv.SCPDURL)
+		srv.ControlURL = resolveURL(base, srv.ControlURL)
+		srv.EventSubURL = resolveURL(base, srv.EventSubURL)
+	}
+	for i := range info.Devices {
+		info.Devices[i].ParentUDN = info.UDN
+		info.Devices[i].resolve(base, location)
+	}
+}
+
+//
+//---------------------------------------------------------------------[ SCPD ]--
+
+// SCPD defines the description of an UPnP service (Service Control Protocol Description).
+//
+type SCPD struct {
+	Actions        []ActionInfo    `xml:"actionList>action"`
+	StateVariables []StateVariable `xml:"serviceStateTable>stateVariable"`
+}
+
+// ActionInfo defines an action provided by a service.
+//
+type ActionInfo struct {
+	Name      string         `xml:"name"`
+	Arguments []ArgumentInfo `xml:"argumentList>argument"`
+}
+
+// ArgumentInfo defines an action argument.
+//
+type ArgumentInfo struct {
+	Name                 string `xml:"name"`
+	Direction            string `xml:"direction"` // "in" or "out".
+	RelatedStateVariable string `xml:"relatedStateVariable"`
+}
+
+// StateVariable defines a state variable of a service.
+//
+type StateVariable struct {
+	Name          string      `xml:"name"`
+	DataType      string      `xml:"dataType"`
+	DefaultValue  string      `xml:"defaultValue,omitempty"`
+	SendEvents    string      `xml:"sendEvents,attr"` // "yes" or "no".
+	AllowedValues []string    `xml:"allowedValueList>allowedValue"`
+	AllowedRange  *ValueRange `xml:"allowedValueRange"`
+}
+
+// ValueRange defines the allowed range of a numeric state variable.
+//
+type ValueRange struct {
+	Minimum string `xml:"minimum"`
+	Maximum string `xml:"maximum"`
+	Step    string `xml:"step,omitempty"`
+}
+
+// Action returns the action with the given name, or nil if not provided.
+//
+func (scpd *SCPD) Action(name string) *ActionInfo {
+	for i := range scpd.Actions {
+		if scpd.Actions[i].Name == name {
+			return &scpd.Actions[i]
+		}
+	}
+	return nil
+}
+
+// HasAction returns whether the service provides the action.
+//
+func (scpd *SCPD) HasAction(name string) bool {
+	return scpd.Action(name) != nil
+}
+
+// StateVariable returns the state variable with the given name, or nil if not found.
+//
+func (scpd *SCPD) StateVariable(name string) *StateVariable {
+	for i := range scpd.StateVariables {
+		if scpd.StateVariables[i].Name == name {
+			return &scpd.StateVariables[i]
+		}
+	}
+	return nil
+}
+
+// ArgumentsIn returns the input arguments of the action.
+//
+func (action *ActionInfo) ArgumentsIn() []ArgumentInfo { return action.arguments("in") }
+
+// ArgumentsOut returns the output arguments of the action.
+//
+func (action *ActionInfo) ArgumentsOut() []ArgumentInfo { return action.arguments("out") }
+
+func (action *ActionInfo) arguments(direction string) (list []ArgumentInfo) {
+	for _, arg := range action.Arguments {
+		if strings.EqualFold(arg.Direction, direction) {
+			list = append(list, arg)
+		}
+	}
+	return list
+}
+
+// IsEvented returns whether changes of the variable are sent as events.
+//
+func (sv *StateVariable) IsEvented() bool {
+	return strings.EqualFold(sv.SendEvents, "yes")
+}
+
+// Allows returns whether the value is in the allowed value list of the
+// variable. Variables without a list allow any value.
+//
+func (sv *StateVariable) Allows(value string) bool {
+	if len(sv.AllowedValues) == 0 {
+		return true
+	}
+	for _, allowed := range sv.AllowedValues {
+		if allowed == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSCPD parses a service description document.
+//
+func ParseSCPD(data []byte) (*SCPD, error) {
+	scpd := &SCPD{}
+	if e := xml.Unmarshal(data, scpd); e != nil {
+		return nil, e
+	}
+	return scpd, nil
+}
+
+// FetchSCPD downloads and parses a service description document.
+//
+func FetchSCPD(url string) (*SCPD, error) {
+	data, e := fetchURL(url)
+	if e != nil {
+		return nil, e
+	}
+	return ParseSCPD(data)
+}
+
+//
+//--------------------------------------------------------------[ DESCRIPTION ]--
+
+// Description provides the device description and services introspection
+// to devices. SCPD documents are downloaded on first use and cached.
+//
+type Description struct {
+	info *DeviceInfo
+
+	mu   sync.Mutex
+	scpd map[string]*SCPD // indexed by SCPD URL.
+}
+
+// DeviceInfo returns the device description.
+//
+func (desc *Description) DeviceInfo() *DeviceInfo {
+	if desc.info == nil {
+		return &DeviceInfo{}
+	}
+	return desc.info
+}
+
+// SetDeviceInfo sets the device description.
+//
+func (desc *Description) SetDeviceInfo(info *DeviceInfo) { desc.info = info }
+
+// Quirks returns the behaviour adjustments for the device from KnownQuirks.
+//
+func (desc *Description) Quirks() Quirks {
+	return KnownQuirks.Lookup(desc.DeviceInfo())
+}
+
+// SCPD returns the description of the service of the given type (version
+// ignored), provided by the device or one of its embedded devices.
+//
+// The document is downloaded on the first call, so this may block.
+// A failed download isn't retried.
+//
+func (desc *Description) SCPD(serviceType string) (*SCPD, error) {
+	srv := desc.DeviceInfo().Service(serviceType)
+	if srv == nil {
+		return nil, errors.New("service not found: " + serviceType)
+	}
+	if srv.SCPDURL == "" {
+		return nil, errors.New("no SCPD URL for service: " + serviceType)
+	}
+
+	desc.mu.Lock()
+	scpd, ok := desc.scpd[srv.SCPDURL]
+	desc.mu.Unlock()
+	switch {
+	case ok && scpd == nil:
+		return nil, errors.New("SCPD unavailable: " + srv.SCPDURL)
+	case ok:
+		return scpd, nil
+	}
+
+	scpd, e := FetchSCPD(srv.SCPDURL)
+
+	desc.mu.Lock()
+	if desc.scpd == nil {
+		desc.scpd = make(map[string]*SCPD)
+	}
+	desc.scpd[srv.SCPDURL] = scpd // Failures are also cached (nil) to only try once.
+	desc.mu.Unlock()
+	return scpd, e
+}
+
+// SetSCPD sets the description of a service provided by the device, to use
+// instead of downloading it. The service must be in the device description.
+//
+func (desc *Description) SetSCPD(serviceType string, scpd *SCPD) error {
+	srv := desc.DeviceInfo().Service(serviceType)
+	if srv == nil {
+		return errors.New("service not found: " + serviceType)
+	}
+	if srv.SCPDURL == "" {
+		srv.SCPDURL = "scpd:" + srv.ServiceType
+	}
+
+	desc.mu.Lock()
+	if desc.scpd == nil {
+		desc.scpd = make(map[string]*SCPD)
+	}
+	desc.scpd[srv.SCPDURL] = scpd
+	desc.mu.Unlock()
+	return nil
+}
+
+// HasAction returns whether the service of the given type provides the action.
+// Returns false if the service description can't be found.
+//
+func (desc *Description) HasAction(serviceType, action string) bool {
+	scpd, e := desc.SCPD(serviceType)
+	return e == nil && scpd.HasAction(action)
+}
+
+//
+//-----------------------------------------------------------------[ HELPERS ]--
+
+var descriptionClient = &http.Client{Timeout: 10 * time.Second}
+
+func fetchURL(url string) ([]byte, error) {
+	resp, e := descriptionClient.Get(url)
+	if e != nil {
+		return nil, e
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("get " + url + ": " + resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// resolveURL returns the reference resolved against the base URL.
+func resolveURL(base, ref string) string {
+	if ref == "" || base == "" {
+		return ref
+	}
+	b, e := neturl.Parse(base)
+	if e != nil {
+		return ref
+	}
+	r, e := neturl.Parse(ref)
+	if e != nil {
+		return ref
+	}
+	return b.ResolveReference(r).String()
+}
+
+// sameType compares device or service types, ignoring the version.
+//
+// input as "urn:schemas-upnp-org:service:AVTransport:1".
+//
+func sameType(typ, other string) bool {
+	trim := func(s string) string {
+		if i := strings.LastIndex(s, ":"); i > 0 {
+			return s[:i]
+		}
+		return s
+	}
+	return trim(typ) == trim(other)
+}
@@ -0,0 +1,45 @@
+package upnptype
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// didlTest defines a DIDL-Lite document with the objects expected from
+// ParseDIDL and the gupnp backend parser.
+type didlTest struct {
+	Name       string
+	DIDL       string
+	Containers []Container
+	Items      []Item
+	Error      bool
+}
+
+func TestParseDIDL(t *testing.T) {
+	data, e := ioutil.ReadFile("testdata/didl.json")
+	if e != nil {
+		t.Fatal(e)
+	}
+	var tests []didlTest
+	if e := json.Unmarshal(data, &tests); e != nil {
+		t.Fatal("parse fixtures:", e)
+	}
+
+	for _, test := range tests {
+		containers, items, e := ParseDIDL(test.DIDL)
+		if (e != nil) != test.Error {
+			t.Errorf("%s: error %v", test.Name, e)
+		}
+		if test.Error {
+			continue
+		}
+		if !reflect.DeepEqual(containers, test.Containers) {
+			t.Errorf("%s: containers\n%+v\nwant\n%+v", test.Name, containers, test.Containers)
+		}
+		if !reflect.DeepEqual(items, test.Items) {
+			t.Errorf("%s: items\n%+v\nwant\n%+v", test.Name, items, test.Items)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package upnptype
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+//-------------------------------------------------------------[ MEDIA FILES ]--
+
+// Objects classes.
+//
+const (
+	ClassFolder = "object.container.storageFolder"
+	ClassMusic  = "object.item.audioItem.musicTrack"
+	ClassVideo  = "object.item.videoItem"
+	ClassPhoto  = "object.item.imageItem.photo"
+)
+
+// mimeTypes completes the system MIME database for media formats.
+var mimeTypes = map[string]string{
+	".aac":  "audio/aac",
+	".avi":  "video/x-msvideo",
+	".flac": "audio/flac",
+	".jpeg": "image/jpeg",
+	".jpg":  "image/jpeg",
+	".m4a":  "audio/mp4",
+	".m4v":  "video/mp4",
+	".mkv":  "video/x-matroska",
+	".mov":  "video/quicktime",
+	".mp3":  "audio/mpeg",
+	".mp4":  "video/mp4",
+	".mpeg": "video/mpeg",
+	".mpg":  "video/mpeg",
+	".oga":  "audio/ogg",
+	".ogg":  "audio/ogg",
+	".ogv":  "video/ogg",
+	".opus": "audio/ogg",
+	".png":  "image/png",
+	".ts":   "video/mp2t",
+	".wav":  "audio/wav",
+	".webm": "video/webm",
+	".wma":  "audio/x-ms-wma",
+	".wmv":  "video/x-ms-wmv",
+}
+
+// MimeType returns the MIME type of a media file from its extension.
+// Returns an empty string if the file isn't an audio, video or image file.
+//
+func MimeType(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	typ, ok := mimeTypes[ext]
+	if !ok {
+		typ, _, _ = mime.ParseMediaType(mime.TypeByExtension(ext))
+	}
+	if ItemClass(typ) == "" {
+		return ""
+	}
+	return typ
+}
+
+// MimeTypes returns the media MIME types known without the system database,
+// sorted.
+//
+func MimeTypes() []string {
+	seen := make(map[string]bool)
+	var list []string
+	for _, typ := range mimeTypes {
+		if !seen[typ] {
+			seen[typ] = true
+			list = append(list, typ)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// ItemClass returns the UPnP class of an item with the given MIME type.
+// Returns an empty string if the type isn't a media type.
+//
+func ItemClass(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "audio/"):
+		return ClassMusic
+	case strings.HasPrefix(mimeType, "video/"):
+		return ClassVideo
+	case strings.HasPrefix(mimeType, "image/"):
+		return ClassPhoto
+	}
+	return ""
+}
+
+// ProtocolInfo returns the protocolInfo of a resource served over HTTP with
+// byte range seek support.
+//
+func ProtocolInfo(mimeType string) string {
+	return "http-get:*:" + mimeType + ":" + ContentFeatures()
+}
+
+// ContentFeatures returns the DLNA features of resources served over HTTP,
+// sent in the contentFeatures.dlna.org header: byte range seek support and
+// streaming transfer mode.
+//
+func ContentFeatures() string {
+	return "DLNA.ORG_OP=01;DLNA.ORG_CI=0;DLNA.ORG_FLAGS=01700000000000000000000000000000"
+}
+
+// ProbeURL returns the content type of a media URL, if provided by the remote
+// server. It blocks up to a few seconds: call it out of the backend main loop.
+//
+func ProbeURL(uri string) string {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, e := client.Head(uri)
+	if e == nil {
+		resp.Body.Close()
+		if resp.StatusCode/100 == 2 && resp.Header.Get("Content-Type") != "" {
+			return mediaType(resp.Header.Get("Content-Type"))
+		}
+	}
+
+	// Some servers refuse HEAD requests, ask for the first byte instead.
+	req, e := http.NewRequest("GET", uri, nil)
+	if e != nil {
+		return ""
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, e = client.Do(req)
+	if e != nil {
+		return ""
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return ""
+	}
+	return mediaType(resp.Header.Get("Content-Type"))
+}
+
+// mediaType returns the MIME type of a Content-Type header, without parameters.
+func mediaType(contentType string) string {
+	typ, _, _ := mime.ParseMediaType(contentType)
+	return typ
+}
+
+// Metadata returns a DIDL-Lite document describing a single media item
+// available at the given URL.
+//
+func Metadata(resURL, title, mimeType string, size int64) string {
+	esc := func(str string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(str))
+		return b.String()
+	}
+	class := ItemClass(mimeType)
+	if class == "" {
+		class = "object.item"
+	}
+	res := `<res protocolInfo="` + ProtocolInfo(mimeType) + `"`
+	if size > 0 {
+		res += ` size="` + strconv.FormatInt(size, 10) + `"`
+	}
+	return `<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"` +
+		` xmlns:dc="http://purl.org/dc/elements/1.1/"` +
+		` xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/"` +
+		` xmlns:dlna="urn:schemas-dlna-org:metadata-1-0/">` +
+		`<item id="0" parentID="-1" restricted="1">` +
+		`<dc:title>` + esc(title) + `</dc:title>` +
+		`<upnp:class>` + class + `</upnp:class>` +
+		res + `>` + esc(resURL) + `</res>` +
+		`</item></DIDL-Lite>`
+}
+
+//
+//---------------------------------------------------------------[ SUBTITLES ]--
+
+// Namespaces of the DIDL-Lite extensions for subtitles.
+//
+const (
+	NamespaceSec = "http://www.sec.co.kr/"
+	NamespacePV  = "http://www.pv.com/pvns/"
+)
+
+// subtitleTypes lists the MIME types of subtitles files.
+var subtitleTypes = map[string]string{
+	".srt": "text/srt",
+	".vtt": "text/vtt",
+}
+
+// SubtitleMimeType returns the MIME type of a subtitles file from its
+// extension. Returns an empty string if the file isn't a subtitles file.
+//
+func SubtitleMimeType(name string) string {
+	return subtitleTypes[strings.ToLower(path.Ext(name))]
+}
+
+// IsSubtitleType returns whether the MIME type designates subtitles.
+//
+func IsSubtitleType(mimeType string) bool {
+	switch mimeType {
+	case "text/srt", "application/x-subrip", "smi/caption", "text/vtt":
+		return true
+	}
+	return false
+}
+
+// SubtitleKind returns the short subtitles type used by TVs (srt, vtt).
+//
+func SubtitleKind(mimeType string) string {
+	if mimeType == "text/vtt" {
+		return "vtt"
+	}
+	return "srt"
+}
+
+// FindSubtitles returns the subtitles files next to a video file: with the
+// same name and a .srt or .vtt extension, optionally with a language code
+// (movie.srt, movie.en.srt). The exact name comes first.
+//
+func FindSubtitles(videoFile string) []string {
+	infos, e := ioutil.ReadDir(filepath.Dir(videoFile))
+	if e != nil {
+		return nil
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	var list []string
+	for _, name := range MatchSubtitles(names, filepath.Base(videoFile)) {
+		list = append(list, filepath.Join(filepath.Dir(videoFile), name))
+	}
+	return list
+}
+
+// MatchSubtitles returns the subtitles names matching the video name, among
+// the names of the files of its directory. The exact name comes first.
+//
+func MatchSubtitles(names []string, video string) []string {
+	base := strings.TrimSuffix(video, filepath.Ext(video))
+	var list []string
+	for _, name := range names {
+		if SubtitleMimeType(name) == "" {
+			continue
+		}
+		subBase := strings.TrimSuffix(name, filepath.Ext(name))
+		if subBase == base || strings.HasPrefix(subBase, base+".") {
+			list = append(list, name)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return len(list[i]) < len(list[j]) })
+	return list
+}
+
+// AddSubtitle adds a subtitles URL to the first item of a DIDL-Lite document,
+// in the formats understood by common TVs: a res element with the subtitles
+// type, sec:CaptionInfoEx and sec:CaptionInfo elements (Samsung), and the
+// pv:subtitleFileUri attribute on the media resource (Panasonic, LG).
+//
+// Returns the document unchanged if it has no item.
+//
+func AddSubtitle(didl, subURL, mimeType string) string {
+	rootStart := strings.Index(didl, "<DIDL-Lite")
+	itemStart := strings.Index(didl, "<item")
+	itemEnd := strings.Index(didl, "</item>")
+	if rootStart < 0 || itemStart < 0 || itemEnd < itemStart {
+		return didl
+	}
+	kind := SubtitleKind(mimeType)
+	var esc strings.Builder
+	xml.EscapeText(&esc, []byte(subURL))
+	url := esc.String()
+
+	item := didl[itemStart:itemEnd]
+	if res := strings.Index(item, "<res"); res >= 0 {
+		res += len("<res")
+		item = item[:res] + ` pv:subtitleFileUri="` + url + `" pv:subtitleFileType="` + kind + `"` + item[res:]
+	}
+	item += `<res protocolInfo="http-get:*:` + mimeType + `:*">` + url + `</res>` +
+		`<sec:CaptionInfoEx sec:type="` + kind + `">` + url + `</sec:CaptionInfoEx>` +
+		`<sec:CaptionInfo sec:type="` + kind + `">` + url + `</sec:CaptionInfo>`
+
+	root := didl[rootStart:itemStart]
+	if !strings.Contains(root, "xmlns:sec=") {
+		root = strings.Replace(root, "<DIDL-Lite", `<DIDL-Lite xmlns:sec="`+NamespaceSec+`"`, 1)
+	}
+	if !strings.Contains(root, "xmlns:pv=") {
+		root = strings.Replace(root, "<DIDL-Lite", `<DIDL-Lite xmlns:pv="`+NamespacePV+`"`, 1)
+	}
+	return didl[:rootStart] + root + item + didl[itemEnd:]
+}
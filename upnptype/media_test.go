@@ -0,0 +1,75 @@
+package upnptype
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMimeType(t *testing.T) {
+	for name, want := range map[string]string{
+		"movie.MKV":  "video/x-matroska",
+		"song.flac":  "audio/flac",
+		"photo.jpeg": "image/jpeg",
+		"notes.txt":  "",
+		"movie.srt":  "",
+	} {
+		if got := MimeType(name); got != want {
+			t.Errorf("MimeType(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestProbeURL(t *testing.T) {
+	radio := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "audio/mpeg; charset=binary")
+	}))
+	defer radio.Close()
+
+	if got := ProbeURL(radio.URL + "/stream"); got != "audio/mpeg" {
+		t.Errorf("probe: %q, want audio/mpeg", got)
+	}
+	if got := ProbeURL("http://127.0.0.1:1/movie.mkv"); got != "" {
+		t.Errorf("probe unreachable: %q", got)
+	}
+}
+
+func TestMatchSubtitles(t *testing.T) {
+	names := []string{"movie.mkv", "movie.fr.srt", "movie.srt", "movie2.srt", "other.vtt", "movie.txt"}
+	got := MatchSubtitles(names, "movie.mkv")
+	if want := []string{"movie.srt", "movie.fr.srt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("MatchSubtitles: want %v, got %v", want, got)
+	}
+}
+
+func TestAddSubtitle(t *testing.T) {
+	didl := Metadata("http://host/movie.mp4", "movie", "video/mp4", 0)
+	got := AddSubtitle(didl, "http://host/movie.vtt?a&b", "text/vtt")
+	for _, want := range []string{
+		`xmlns:sec="` + NamespaceSec + `"`,
+		`pv:subtitleFileUri="http://host/movie.vtt?a&amp;b" pv:subtitleFileType="vtt"`,
+		`<res protocolInfo="http-get:*:text/vtt:*">http://host/movie.vtt?a&amp;b</res>`,
+		`<sec:CaptionInfoEx sec:type="vtt">http://host/movie.vtt?a&amp;b</sec:CaptionInfoEx>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %s in %s", want, got)
+		}
+	}
+	if AddSubtitle("<DIDL-Lite></DIDL-Lite>", "http://host/a.srt", "text/srt") != "<DIDL-Lite></DIDL-Lite>" {
+		t.Error("document without item modified")
+	}
+
+	containers, items, e := ParseDIDL(got)
+	if e != nil || len(containers) != 0 || len(items) != 1 {
+		t.Fatalf("parse: %v %+v %+v", e, containers, items)
+	}
+	if item := items[0]; item.Title != "movie" || item.Class != ClassVideo || len(item.Res) != 2 {
+		t.Errorf("bad item: %+v", item)
+	}
+}
@@ -0,0 +1,172 @@
+package upnptype
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// Quirks defines the behaviour adjustments for a device deviating from the spec.
+//
+type Quirks struct {
+	SeekMode         string // Time seek mode to use (ABS_TIME or REL_TIME). Empty to detect.
+	StopBeforeSetURI bool   // Send Stop before SetAVTransportURI.
+	RequireMetadata  bool   // Send a minimal DIDL-Lite when the metadata is empty.
+	VolumeMin        uint16 // Lowest volume accepted by the renderer.
+	VolumeMax        uint16 // Highest volume accepted by the renderer.
+}
+
+// DefaultQuirks returns the settings used for devices without quirks.
+//
+func DefaultQuirks() Quirks {
+	return Quirks{
+		StopBeforeSetURI: true,
+		VolumeMax:        100,
+	}
+}
+
+// QuirkRule defines quirks to apply to matching devices.
+//
+// Match fields are case insensitive and empty fields match any device.
+// Manufacturer and model name match as substrings, UDN must be equal.
+// Only settings defined by the rule are changed.
+//
+//   {"manufacturer": "Samsung", "modelName": "UE40", "seekMode": "REL_TIME"}
+//
+type QuirkRule struct {
+	Name string `json:"name,omitempty"` // Description of the rule.
+
+	Manufacturer string `json:"manufacturer,omitempty"`
+	ModelName    string `json:"modelName,omitempty"`
+	ModelNumber  string `json:"modelNumber,omitempty"`
+	UDN          string `json:"udn,omitempty"`
+
+	SeekMode         *string `json:"seekMode,omitempty"`
+	StopBeforeSetURI *bool   `json:"stopBeforeSetURI,omitempty"`
+	RequireMetadata  *bool   `json:"requireMetadata,omitempty"`
+	VolumeMin        *uint16 `json:"volumeMin,omitempty"`
+	VolumeMax        *uint16 `json:"volumeMax,omitempty"`
+}
+
+// Matches returns whether the rule applies to the device.
+//
+func (rule *QuirkRule) Matches(info *DeviceInfo) bool {
+	contains := func(value, match string) bool {
+		return match == "" || strings.Contains(strings.ToLower(value), strings.ToLower(match))
+	}
+	return contains(info.Manufacturer, rule.Manufacturer) &&
+		contains(info.ModelName, rule.ModelName) &&
+		contains(info.ModelNumber, rule.ModelNumber) &&
+		(rule.UDN == "" || strings.EqualFold(info.UDN, rule.UDN))
+}
+
+func (rule *QuirkRule) apply(q *Quirks) {
+	if rule.SeekMode != nil {
+		q.SeekMode = *rule.SeekMode
+	}
+	if rule.StopBeforeSetURI != nil {
+		q.StopBeforeSetURI = *rule.StopBeforeSetURI
+	}
+	if rule.RequireMetadata != nil {
+		q.RequireMetadata = *rule.RequireMetadata
+	}
+	if rule.VolumeMin != nil {
+		q.VolumeMin = *rule.VolumeMin
+	}
+	if rule.VolumeMax != nil {
+		q.VolumeMax = *rule.VolumeMax
+	}
+}
+
+//
+//---------------------------------------------------------------[ QUIRKS DB ]--
+
+// QuirksDB defines a list of quirk rules, applied in order.
+//
+type QuirksDB struct {
+	mu    sync.RWMutex
+	rules []QuirkRule
+}
+
+// KnownQuirks is the quirks database used by devices. It starts empty: rules
+// are loaded from a user file, to add devices without recompiling.
+//
+var KnownQuirks = NewQuirksDB()
+
+// NewQuirksDB creates a quirks database with the given rules.
+//
+func NewQuirksDB(rules ...QuirkRule) *QuirksDB {
+	return &QuirksDB{rules: rules}
+}
+
+// Add appends rules to the database. They override previous rules.
+//
+func (db *QuirksDB) Add(rules ...QuirkRule) {
+	db.mu.Lock()
+	db.rules = append(db.rules, rules...)
+	db.mu.Unlock()
+}
+
+// Load appends rules from a JSON file (a list of rules).
+//
+func (db *QuirksDB) Load(filename string) error {
+	data, e := ioutil.ReadFile(filename)
+	if e != nil {
+		return e
+	}
+	var rules []QuirkRule
+	if e := json.Unmarshal(data, &rules); e != nil {
+		return e
+	}
+	db.Add(rules...)
+	return nil
+}
+
+// Rules returns a copy of the rules.
+//
+func (db *QuirksDB) Rules() []QuirkRule {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return append([]QuirkRule(nil), db.rules...)
+}
+
+// Lookup returns the quirks for the device, with all matching rules applied.
+//
+func (db *QuirksDB) Lookup(info *DeviceInfo) Quirks {
+	q := DefaultQuirks()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for i := range db.rules {
+		if db.rules[i].Matches(info) {
+			db.rules[i].apply(&q)
+		}
+	}
+	return q
+}
+
+//
+//-----------------------------------------------------------------[ HELPERS ]--
+
+// MinimalMetadata returns a DIDL-Lite document describing a single item, for
+// renderers rejecting an empty metadata.
+//
+func MinimalMetadata(uri, title string) string {
+	esc := func(str string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(str))
+		return b.String()
+	}
+	if title == "" {
+		title = uri
+	}
+	return `<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"` +
+		` xmlns:dc="http://purl.org/dc/elements/1.1/"` +
+		` xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/">` +
+		`<item id="0" parentID="-1" restricted="1">` +
+		`<dc:title>` + esc(title) + `</dc:title>` +
+		`<upnp:class>object.item</upnp:class>` +
+		`<res protocolInfo="http-get:*:*:*">` + esc(uri) + `</res>` +
+		`</item></DIDL-Lite>`
+}
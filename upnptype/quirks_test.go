@@ -0,0 +1,75 @@
+package upnptype
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestKnownQuirks(t *testing.T) {
+	info := DeviceInfo{Manufacturer: "Samsung Electronics", ModelName: "UE40ES6300"}
+	if got := KnownQuirks.Lookup(&info); got != DefaultQuirks() {
+		t.Errorf("quirks %+v, want defaults %+v", got, DefaultQuirks())
+	}
+}
+
+func TestQuirksLoad(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "quirks.json")
+	data := `[
+		{"name": "TV seeking with relative time", "manufacturer": "Samsung", "seekMode": "REL_TIME", "requireMetadata": true},
+		{"name": "smaller TV", "manufacturer": "samsung", "modelName": "ue40", "volumeMax": 40},
+		{"name": "player replacing media without stop", "manufacturer": "XBMC Foundation", "modelName": "Kodi", "stopBeforeSetURI": false}
+	]`
+	if e := ioutil.WriteFile(filename, []byte(data), 0644); e != nil {
+		t.Fatal(e)
+	}
+	db := NewQuirksDB()
+	if e := db.Load(filename); e != nil {
+		t.Fatal("load:", e)
+	}
+
+	for _, test := range []struct {
+		name string
+		info DeviceInfo
+		want Quirks
+	}{
+		{
+			name: "unknown device",
+			info: DeviceInfo{Manufacturer: "ACME", ModelName: "Radio 1"},
+			want: DefaultQuirks(),
+		},
+		{
+			name: "rules applied in order",
+			info: DeviceInfo{Manufacturer: "Samsung Electronics", ModelName: "UE40ES6300"},
+			want: Quirks{SeekMode: SeekModeRelTime, StopBeforeSetURI: true, RequireMetadata: true, VolumeMax: 40},
+		},
+		{
+			name: "other model",
+			info: DeviceInfo{Manufacturer: "Samsung Electronics", ModelName: "UE55"},
+			want: Quirks{SeekMode: SeekModeRelTime, StopBeforeSetURI: true, RequireMetadata: true, VolumeMax: 100},
+		},
+		{
+			name: "setting disabled",
+			info: DeviceInfo{Manufacturer: "XBMC Foundation", ModelName: "Kodi"},
+			want: Quirks{VolumeMax: 100},
+		},
+	} {
+		if got := db.Lookup(&test.info); got != test.want {
+			t.Errorf("%s: quirks %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestQuirksLoadInvalid(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "quirks.json")
+	if e := ioutil.WriteFile(filename, []byte(`{"manufacturer": "ACME"}`), 0644); e != nil {
+		t.Fatal(e)
+	}
+	db := NewQuirksDB()
+	if e := db.Load(filename); e == nil {
+		t.Error("load of an object instead of a list: no error")
+	}
+	if len(db.Rules()) != 0 {
+		t.Errorf("rules added: %+v", db.Rules())
+	}
+}
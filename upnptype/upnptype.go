@@ -4,11 +4,44 @@ package upnptype
 import (
 	"encoding/xml"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
-// type ControlPoint interface {
-// }
+// ControlPoint defines a backend discovering UPnP devices on the network.
+//
+type ControlPoint interface {
+	// SetEvents sets the discovery callbacks.
+	//
+	SetEvents(ControlPointEvents)
+
+	// Rescan network for servers and renderers.
+	//
+	Rescan()
+
+	// AddDevice registers a device by its description URL, without SSDP discovery.
+	//
+	AddDevice(location string) error
+
+	// RemoveDevice unregisters a device added by its description URL.
+	//
+	RemoveDevice(location string)
+
+	// StaticDevices returns the description URL of devices added manually.
+	//
+	StaticDevices() []string
+}
+
+// EventLoop is implemented by control points running their own event loop,
+// like the glib main loop of the gupnp backend. The media control runs its
+// timers in it.
+//
+type EventLoop interface {
+	// Call runs fn in the event loop. It can be called from any goroutine.
+	//
+	Call(fn func())
+}
 
 // Action defines an UPnP simple renderer action.
 //
@@ -27,19 +60,75 @@ const (
 	ActionSeekForward
 )
 
+var actionNames = []string{"none", "toggleMute", "volumeDown", "volumeUp", "playPause", "stop", "seekBackward", "seekForward"}
+
+// String returns the name of the action.
+//
+func (a Action) String() string {
+	if a < 0 || int(a) >= len(actionNames) {
+		return "Action(" + strconv.Itoa(int(a)) + ")"
+	}
+	return actionNames[a]
+}
+
 // Common settings.
 //
 const (
 	MaxBrowse = 64
 )
 
-// Logger defines logging forwarding for the API.
+// Logger defines levelled logging for the API.
+//
+// Messages are constant strings, with details given as fields: alternating
+// keys and values, like log/slog. A *slog.Logger is a Logger.
 //
 type Logger interface {
-	Infof(string, ...interface{})
-	Warningf(string, ...interface{})
+	Debug(msg string, fields ...interface{})
+	Info(msg string, fields ...interface{})
+	Warn(msg string, fields ...interface{})
+	Error(msg string, fields ...interface{})
+}
+
+// Common keys of log fields.
+//
+const (
+	FieldUDN      = "udn"      // device UDN.
+	FieldDevice   = "device"   // device name.
+	FieldAction   = "action"   // SOAP or media control action.
+	FieldDuration = "duration" // time.Duration of the action.
+	FieldError    = "error"
+)
+
+// NopLogger drops all messages. Used when no logger is set.
+//
+type NopLogger struct{}
+
+// Debug drops the message.
+//
+func (NopLogger) Debug(string, ...interface{}) {}
+
+// Info drops the message.
+//
+func (NopLogger) Info(string, ...interface{}) {}
+
+// Warn drops the message.
+//
+func (NopLogger) Warn(string, ...interface{}) {}
+
+// Error drops the message.
+//
+func (NopLogger) Error(string, ...interface{}) {}
+
+// Fault defines an UPnP error returned by a device for an action (SOAP fault).
+// Backends return it with the UPnP error code, to be checked with errors.As.
+//
+type Fault struct {
+	Code        int
+	Description string
 }
 
+func (e *Fault) Error() string { return strconv.Itoa(e.Code) + " " + e.Description }
+
 // MediaControl defines actions provided by the selected server and renderer.
 //
 type MediaControl interface {
@@ -48,6 +137,23 @@ type MediaControl interface {
 	//
 	DefineEvents() ControlPointEvents
 
+	// Capabilities returns the current renderer wrapped with capability checks,
+	// or nil if no renderer is selected.
+	//
+	Capabilities() *CapableRenderer
+
+	// SetControlPoint connects the discovery backend.
+	//
+	SetControlPoint(ControlPoint)
+
+	// AddDevice registers a device by its description URL, without SSDP discovery.
+	//
+	AddDevice(location string) error
+
+	// RemoveDevice unregisters a device added by its description URL.
+	//
+	RemoveDevice(location string)
+
 	// Action sends an action message to the selected renderer.
 	//
 	Action(Action) error
@@ -109,6 +215,14 @@ type MediaControl interface {
 	//
 	SetPreferredServer(name string)
 
+	// SetSubtitles enables or disables the delivery of subtitles to a renderer.
+	//
+	SetSubtitles(udn string, enable bool)
+
+	// Subtitles returns whether subtitles are delivered to the renderer.
+	//
+	Subtitles(udn string) bool
+
 	//
 	//--------------------------------------------------------------------[ TIME ]--
 
@@ -143,6 +257,18 @@ type MediaControl interface {
 	//
 	SetNextAVTransportURI(nextURI, nextURIMetaData string) error
 
+	//
+	//----------------------------------------------------------------[ CAST ]--
+
+	// PlayURL starts the playback of a media URL on the selected renderer.
+	// The MIME type is guessed from the URL when empty.
+	//
+	PlayURL(uri, mimeType string) error
+
+	// PlayFile starts the playback of a local file on the selected renderer.
+	//
+	PlayFile(filename string) error
+
 	//
 	//---------------------------------------------------------------[ HOOKS ]--
 
@@ -168,6 +294,26 @@ type serviceID interface {
 	Name() string
 	Icon() string
 	SetIcon(icon string)
+
+	// Interface returns the name of the network interface the device was found on.
+	//
+	Interface() string
+
+	// HostIP returns the local IP address used to reach the device.
+	//
+	HostIP() string
+
+	// DeviceInfo returns the device description.
+	//
+	DeviceInfo() *DeviceInfo
+
+	// SCPD returns the description of a service provided by the device.
+	//
+	SCPD(serviceType string) (*SCPD, error)
+
+	// HasAction returns whether the service of the given type provides the action.
+	//
+	HasAction(serviceType, action string) bool
 }
 
 // UDNer defines an object that returns its UPnP ID.
@@ -182,6 +328,10 @@ type Server interface {
 	serviceID
 	ServiceContentDirectory
 
+	// Events returns the server events callbacks.
+	//
+	Events() *ServerEvents
+
 	// CompareProxy compares two devices to see if they points to the same object.
 	//
 	CompareProxy(devtest UDNer) bool
@@ -269,6 +419,10 @@ type Renderer interface {
 	//
 	Events() *RendererEvents
 
+	// Quirks returns the behaviour adjustments for the renderer.
+	//
+	Quirks() Quirks
+
 	// Duration() int
 
 	// CompareProxy compares two devices to see if they points to the same object.
@@ -869,12 +1023,17 @@ type Renderers map[string]Renderer
 type MediaHook struct {
 	ControlPointEvents
 	RendererEvents
+	ServerEvents
 
 	// Local events.
 	OnSetVolumeDelta   func(int)
 	OnSetSeekDelta     func(int)
 	OnRendererSelected func(Renderer)
 	OnServerSelected   func(Server)
+
+	// Slideshow events.
+	OnSlideshowState func(PlaybackState)                // Playing, paused or stopped.
+	OnSlideshowImage func(index, count int, item *Item) // Image displayed, index in the slideshow.
 }
 
 // ControlPointEvents defines events of a control point.
@@ -897,6 +1056,15 @@ type RendererEvents struct {
 	OnVolume func(Renderer, uint)
 
 	OnCurrentTime func(r Renderer, secs int, percent float64)
+
+	OnCurrentConnectionIDs func(Renderer, []string)
+}
+
+// ServerEvents defines events of a server.
+//
+type ServerEvents struct {
+	OnSystemUpdateID     func(Server, uint)
+	OnContainerUpdateIDs func(Server, map[string]uint)
 }
 
 //
@@ -905,9 +1073,14 @@ type RendererEvents struct {
 // DeviceBase provides a common device base to extend for backends.
 //
 type DeviceBase struct {
+	Description
+
 	udn  string
 	name string
 	icon string // path to icon file on disk.
+
+	iface  string // network interface name.
+	hostIP string // local IP address on the interface.
 }
 
 // GetIconFile gets the device icon location.
@@ -942,6 +1115,21 @@ func (db *DeviceBase) Icon() string { return db.icon }
 //
 func (db *DeviceBase) SetIcon(icon string) { db.icon = icon }
 
+// Interface returns the name of the network interface the device was found on.
+//
+func (db *DeviceBase) Interface() string { return db.iface }
+
+// HostIP returns the local IP address used to reach the device.
+//
+func (db *DeviceBase) HostIP() string { return db.hostIP }
+
+// SetNetwork sets the network interface and local IP address of the device.
+//
+func (db *DeviceBase) SetNetwork(iface, hostIP string) {
+	db.iface = iface
+	db.hostIP = hostIP
+}
+
 // CompareProxy compares two devices to see if they points to the same object.
 //
 func (db *DeviceBase) CompareProxy(devtest UDNer) bool {
@@ -953,10 +1141,12 @@ func (db *DeviceBase) CompareProxy(devtest UDNer) bool {
 type ServerBase struct {
 	DeviceBase
 
-	// events ServerEvents
+	events ServerEvents
 }
 
-// func (srv *ServerBase) Events() *ServerEvents { return &srv.events }
+// Events returns the server events callbacks.
+//
+func (srv *ServerBase) Events() *ServerEvents { return &srv.events }
 
 // RendererBase provides a common renderer base to extend for backends.
 //
@@ -1015,6 +1205,58 @@ func PlaybackStateFromName(name string) PlaybackState {
 	return PlaybackStateUnknown
 }
 
+// String returns the UPnP name of the state, or UNKNOWN.
+//
+func (state PlaybackState) String() string {
+	switch state {
+	case PlaybackStateStopped:
+		return StateStopped
+
+	case PlaybackStatePlaying:
+		return StatePlaying
+
+	case PlaybackStatePaused:
+		return StatePausedPlayback
+
+	case PlaybackStateTransitioning:
+		return StateTransitioning
+	}
+
+	return "UNKNOWN"
+}
+
+//
+//---------------------------------------------------------[ STATE VARIABLES ]--
+
+// ParseContainerUpdateIDs parses the ContainerUpdateIDs state variable of a
+// ContentDirectory, a comma separated list of container ID and update ID pairs.
+//
+// input as "id1,12,id2,4".
+//
+func ParseContainerUpdateIDs(str string) map[string]uint {
+	ids := make(map[string]uint)
+	fields := strings.Split(str, ",")
+	for i := 0; i+1 < len(fields); i += 2 {
+		if updateID, e := strconv.ParseUint(fields[i+1], 10, 32); e == nil {
+			ids[fields[i]] = uint(updateID)
+		}
+	}
+	return ids
+}
+
+// ParseConnectionIDs parses the CurrentConnectionIDs state variable of a
+// ConnectionManager, a comma separated list of connection IDs.
+//
+func ParseConnectionIDs(str string) []string {
+	var ids []string
+	for _, id := range strings.Split(str, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
 //
 //--------------------------------------------------------------------[ TIME ]--
 
@@ -1096,11 +1338,121 @@ type Item struct {
 type Object struct {
 	ID         string `xml:"id,attr"`
 	ParentID   string `xml:"parentID,attr"`
-	Restricted int    `xml:"restricted,attr"`  // indicates whether the object is modifiable
-	Class      string `xml:"class"`            // upnp:
-	Icon       string `xml:"icon,omitempty"`   // upnp:
-	Title      string `xml:"title"`            // dc:
-	Artist     string `xml:"artist,omitempty"` // upnp:
-	Album      string `xml:"album,omitempty"`  // upnp:
-	Genre      string `xml:"genre,omitempty"`  // upnp:
+	Restricted int    `xml:"restricted,attr"`       // indicates whether the object is modifiable
+	Class      string `xml:"class"`                 // upnp:
+	Icon       string `xml:"icon,omitempty"`        // upnp:
+	Title      string `xml:"title"`                 // dc:
+	Artist     string `xml:"artist,omitempty"`      // upnp:
+	Album      string `xml:"album,omitempty"`       // upnp:
+	Genre      string `xml:"genre,omitempty"`       // upnp:
+	AlbumArt   string `xml:"albumArtURI,omitempty"` // upnp:
+}
+
+// ParseDIDL parses a DIDL-Lite document, like the result of a Browse action
+// or the CurrentTrackMetaData of a renderer.
+//
+// It gives the same values as the gupnp-av parser of the backend, for packages
+// built without cgo. Both are tested on the documents of testdata/didl.json.
+//
+func ParseDIDL(str string) ([]Container, []Item, error) {
+	var didl struct {
+		Objects []didlObject `xml:",any"`
+	}
+	if str == "" {
+		return nil, nil, nil
+	}
+	e := xml.Unmarshal([]byte(str), &didl)
+
+	var containers []Container
+	var items []Item
+	for _, obj := range didl.Objects {
+		switch obj.XMLName.Local {
+		case "container":
+			count, _ := strconv.Atoi(obj.ChildCount)
+			if count < 0 {
+				count = 0
+			}
+			containers = append(containers, Container{Object: obj.object(), ChildCount: count})
+
+		case "item":
+			items = append(items, Item{Object: obj.object(), Res: obj.resources()})
+		}
+	}
+	return containers, items, e
+}
+
+// didlObject defines the DIDL-Lite elements read by ParseDIDL.
+type didlObject struct {
+	XMLName    xml.Name
+	ID         string         `xml:"id,attr"`
+	ParentID   string         `xml:"parentID,attr"`
+	Restricted string         `xml:"restricted,attr"`
+	ChildCount string         `xml:"childCount,attr"`
+	Class      []string       `xml:"class"`
+	Title      []string       `xml:"title"`
+	Artist     []string       `xml:"artist"`
+	Album      []string       `xml:"album"`
+	Genre      []string       `xml:"genre"`
+	AlbumArt   []string       `xml:"albumArtURI"`
+	Res        []didlResource `xml:"res"`
+}
+
+type didlResource struct {
+	ProtocolInfo string `xml:"protocolInfo,attr"`
+	URL          string `xml:",chardata"`
+	Size         string `xml:"size,attr"`
+	Bitrate      string `xml:"bitrate,attr"`
+	Duration     string `xml:"duration,attr"`
+	Resolution   string `xml:"resolution,attr"`
+}
+
+func (obj *didlObject) object() Object {
+	first := func(list []string) string {
+		if len(list) == 0 {
+			return ""
+		}
+		return list[0]
+	}
+	restricted := 0
+	switch strings.ToLower(obj.Restricted) {
+	case "1", "true", "yes":
+		restricted = 1
+	}
+	return Object{
+		ID:         obj.ID,
+		ParentID:   obj.ParentID,
+		Restricted: restricted,
+		Class:      first(obj.Class),
+		Title:      first(obj.Title),
+		Artist:     first(obj.Artist),
+		Album:      first(obj.Album),
+		Genre:      first(obj.Genre),
+		AlbumArt:   first(obj.AlbumArt),
+	}
+}
+
+func (obj *didlObject) resources() []Resource {
+	var list []Resource
+	for _, res := range obj.Res {
+		ur := Resource{
+			ProtocolInfo: res.ProtocolInfo,
+			URL:          res.URL,
+		}
+		if size, e := strconv.ParseUint(res.Size, 10, 64); e == nil {
+			ur.Size = size
+		}
+		if bitrate, e := strconv.ParseUint(res.Bitrate, 10, 32); e == nil {
+			ur.Bitrate = uint(bitrate)
+		}
+		var h, m, sec int
+		if n, _ := fmt.Sscanf(res.Duration, "%d:%d:%d", &h, &m, &sec); n == 3 {
+			ur.Duration = TimeToString((h*60+m)*60 + sec)
+		}
+		var width, height int
+		if n, _ := fmt.Sscanf(res.Resolution, "%dx%d", &width, &height); n == 2 && width > 0 && height > 0 {
+			ur.Resolution = strconv.Itoa(width) + "x" + strconv.Itoa(height)
+		}
+		list = append(list, ur)
+	}
+	return list
 }
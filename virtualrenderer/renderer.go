@@ -0,0 +1,631 @@
+// Package virtualrenderer provides a MediaRenderer hosted in Go, without
+// audio or video output.
+//
+// It implements the AVTransport, RenderingControl and ConnectionManager
+// services, and plays media by simulating the time progression, which allows
+// running control points tests on machines without a real renderer.
+//
+//   rend := virtualrenderer.New("Test renderer")
+//   rend.TimeScale = 10 // Tracks play 10 times faster.
+//   e := rend.Start(upnpdevice.Options{}) // Advertised on loopback.
+//   defer rend.Stop()
+//
+package virtualrenderer
+
+import (
+	"github.com/sqp/gupnp/upnpdevice"
+	"github.com/sqp/gupnp/upnptype"
+
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeviceType is the UPnP type of the virtual renderer.
+//
+const DeviceType = "urn:schemas-upnp-org:device:MediaRenderer:1"
+
+// SinkProtocolInfo lists the formats accepted by the renderer.
+//
+const SinkProtocolInfo = "http-get:*:audio/mpeg:*,http-get:*:audio/flac:*,http-get:*:audio/ogg:*," +
+	"http-get:*:video/mp4:*,http-get:*:video/x-matroska:*,http-get:*:image/jpeg:*,http-get:*:*:*"
+
+// Transport states.
+//
+const (
+	StateNoMedia       = "NO_MEDIA_PRESENT"
+	StateStopped       = upnptype.StateStopped
+	StatePlaying       = upnptype.StatePlaying
+	StatePaused        = upnptype.StatePausedPlayback
+	StateTransitioning = upnptype.StateTransitioning
+)
+
+// UPnP AVTransport errors codes.
+const (
+	errTransitionNotAvailable = 701
+	errSeekModeNotSupported   = 710
+	errIllegalSeekTarget      = 711
+	errInvalidInstanceID      = 718
+)
+
+// Status defines the state of the virtual renderer.
+//
+type Status struct {
+	Transport    string
+	URI          string
+	Metadata     string
+	NextURI      string
+	NextMetadata string
+	Position     time.Duration
+	Duration     time.Duration
+	Volume       uint16
+	Mute         bool
+}
+
+// Renderer defines a virtual MediaRenderer.
+//
+type Renderer struct {
+	DefaultDuration time.Duration // Track duration when the metadata doesn't provide one.
+	TimeScale       float64       // Simulated playback speed. 1 for real time.
+	Transition      time.Duration // Time spent in the TRANSITIONING state when loading a track.
+
+	dev *upnpdevice.Device
+	avt *upnpdevice.Service
+	rcs *upnpdevice.Service
+	cm  *upnpdevice.Service
+
+	mu       sync.Mutex
+	status   Status
+	since    time.Time   // Clock start time, when playing.
+	timer    *time.Timer // End of track or end of transition.
+	timerGen int         // Ignores timers fired after being replaced.
+}
+
+// New creates a virtual renderer with the given friendly name.
+//
+func New(name string) *Renderer {
+	rend := &Renderer{
+		DefaultDuration: 3 * time.Minute,
+		TimeScale:       1,
+		status: Status{
+			Transport: StateNoMedia,
+			Volume:    50,
+		},
+	}
+
+	rend.dev = upnpdevice.NewDevice(upnptype.DeviceInfo{
+		DeviceType:       DeviceType,
+		FriendlyName:     name,
+		Manufacturer:     "gupnp-go",
+		ModelDescription: "Virtual media renderer",
+		ModelName:        "virtualrenderer",
+		ModelNumber:      "1",
+	})
+
+	rend.avt = upnpdevice.NewService(upnptype.ServiceTypeAVTransport, "urn:upnp-org:serviceId:AVTransport", scpdAVTransport())
+	rend.rcs = upnpdevice.NewService(upnptype.ServiceTypeRenderingControl, "urn:upnp-org:serviceId:RenderingControl", scpdRenderingControl())
+	rend.cm = upnpdevice.NewService(upnptype.ServiceTypeConnectionManager, "urn:upnp-org:serviceId:ConnectionManager", scpdConnectionManager())
+
+	for name, call := range map[string]upnpdevice.ActionFunc{
+		"SetAVTransportURI":          rend.setAVTransportURI,
+		"SetNextAVTransportURI":      rend.setNextAVTransportURI,
+		"GetMediaInfo":               rend.getMediaInfo,
+		"GetTransportInfo":           rend.getTransportInfo,
+		"GetPositionInfo":            rend.getPositionInfo,
+		"GetDeviceCapabilities":      rend.getDeviceCapabilities,
+		"GetTransportSettings":       rend.getTransportSettings,
+		"GetCurrentTransportActions": rend.getCurrentTransportActions,
+		"Stop":                       rend.stop,
+		"Play":                       rend.play,
+		"Pause":                      rend.pause,
+		"Seek":                       rend.seek,
+		"Next":                       rend.next,
+		"Previous":                   rend.previous,
+	} {
+		rend.avt.Handle(name, instance(call))
+	}
+
+	for name, call := range map[string]upnpdevice.ActionFunc{
+		"ListPresets":  rend.listPresets,
+		"SelectPreset": rend.selectPreset,
+		"GetMute":      rend.getMute,
+		"SetMute":      rend.setMute,
+		"GetVolume":    rend.getVolume,
+		"SetVolume":    rend.setVolume,
+	} {
+		rend.rcs.Handle(name, instance(call))
+	}
+
+	rend.cm.Handle("GetProtocolInfo", rend.getProtocolInfo)
+	rend.cm.Handle("GetCurrentConnectionIDs", rend.getCurrentConnectionIDs)
+	rend.cm.Handle("GetCurrentConnectionInfo", rend.getCurrentConnectionInfo)
+	rend.cm.SetVariables(map[string]string{
+		"SourceProtocolInfo":   "",
+		"SinkProtocolInfo":     SinkProtocolInfo,
+		"CurrentConnectionIDs": "0",
+	})
+
+	rend.dev.AddService(rend.avt)
+	rend.dev.AddService(rend.rcs)
+	rend.dev.AddService(rend.cm)
+
+	rend.sendAVT()
+	rend.sendRCS()
+	return rend
+}
+
+// Start serves and advertises the renderer.
+//
+func (rend *Renderer) Start(opts upnpdevice.Options) error { return rend.dev.Start(opts) }
+
+// Stop stops the playback and the device.
+//
+func (rend *Renderer) Stop() error {
+	rend.mu.Lock()
+	rend.stopTimer()
+	rend.mu.Unlock()
+	return rend.dev.Stop()
+}
+
+// Device returns the hosted device.
+//
+func (rend *Renderer) Device() *upnpdevice.Device { return rend.dev }
+
+// UDN returns the unique device name.
+//
+func (rend *Renderer) UDN() string { return rend.dev.UDN() }
+
+// Location returns the URL of the device description. Empty if not started.
+//
+func (rend *Renderer) Location() string { return rend.dev.Location() }
+
+// Status returns the current state of the renderer.
+//
+func (rend *Renderer) Status() Status {
+	rend.mu.Lock()
+	defer rend.mu.Unlock()
+	status := rend.status
+	status.Position = rend.position()
+	return status
+}
+
+//
+//-------------------------------------------------------------------[ CLOCK ]--
+
+// position returns the simulated track position. The lock must be held.
+func (rend *Renderer) position() time.Duration {
+	pos := rend.status.Position
+	if rend.status.Transport == StatePlaying {
+		pos += time.Duration(float64(time.Since(rend.since)) * rend.TimeScale)
+		if pos > rend.status.Duration {
+			pos = rend.status.Duration
+		}
+	}
+	return pos
+}
+
+// setTransport changes the transport state and restarts the clock if needed.
+// The lock must be held.
+func (rend *Renderer) setTransport(state string) {
+	rend.status.Position = rend.position()
+	rend.stopTimer()
+	rend.status.Transport = state
+
+	switch state {
+	case StatePlaying:
+		rend.since = time.Now()
+		left := float64(rend.status.Duration - rend.status.Position)
+		rend.startTimer(time.Duration(left/rend.TimeScale), rend.trackEnded)
+
+	case StateNoMedia, StateStopped:
+		rend.status.Position = 0
+	}
+	rend.sendAVT()
+}
+
+// load sets the current track and starts playing it when asked. The
+// transport goes through TRANSITIONING if a transition time is set.
+// The lock must be held.
+func (rend *Renderer) load(uri, metadata string, play bool) {
+	rend.stopTimer()
+	rend.status.URI = uri
+	rend.status.Metadata = metadata
+	rend.status.Duration = rend.duration(metadata)
+	rend.status.Position = 0
+	rend.since = time.Now()
+
+	switch {
+	case uri == "":
+		rend.setTransport(StateNoMedia)
+
+	case !play:
+		rend.setTransport(StateStopped)
+
+	case rend.Transition > 0:
+		rend.setTransport(StateTransitioning)
+		rend.startTimer(rend.Transition, func() { rend.setTransport(StatePlaying) })
+
+	default:
+		rend.setTransport(StatePlaying)
+	}
+}
+
+// trackEnded plays the next track if any, or stops. The lock is held.
+func (rend *Renderer) trackEnded() {
+	if rend.status.NextURI == "" {
+		rend.setTransport(StateStopped)
+		return
+	}
+	uri, metadata := rend.status.NextURI, rend.status.NextMetadata
+	rend.status.NextURI, rend.status.NextMetadata = "", ""
+	rend.load(uri, metadata, true)
+}
+
+// startTimer calls the function with the lock held after the delay.
+// The lock must be held.
+func (rend *Renderer) startTimer(delay time.Duration, call func()) {
+	rend.timerGen++
+	gen := rend.timerGen
+	rend.timer = time.AfterFunc(delay, func() {
+		rend.mu.Lock()
+		defer rend.mu.Unlock()
+		if gen == rend.timerGen { // Not replaced while waiting for the lock.
+			rend.timer = nil
+			call()
+		}
+	})
+}
+
+// stopTimer cancels the pending timer. The lock must be held.
+func (rend *Renderer) stopTimer() {
+	rend.timerGen++
+	if rend.timer != nil {
+		rend.timer.Stop()
+		rend.timer = nil
+	}
+}
+
+// duration returns the track duration from its DIDL-Lite metadata.
+func (rend *Renderer) duration(metadata string) time.Duration {
+	didl := struct {
+		Items []upnptype.Item `xml:"item"`
+	}{}
+	if xml.Unmarshal([]byte(metadata), &didl) == nil {
+		for _, item := range didl.Items {
+			for _, res := range item.Res {
+				if res.Duration != "" {
+					if secs := upnptype.TimeToSecond(res.Duration); secs > 0 {
+						return time.Duration(secs) * time.Second
+					}
+				}
+			}
+		}
+	}
+	return rend.DefaultDuration
+}
+
+//
+//-----------------------------------------------------------------[ EVENTS ]--
+
+// sendAVT sends the AVTransport state in a LastChange event.
+func (rend *Renderer) sendAVT() {
+	tracks := "0"
+	if rend.status.URI != "" {
+		tracks = "1"
+	}
+	rend.avt.SetVariable("LastChange", upnpdevice.LastChange(upnpdevice.LastChangeAVT, map[string]string{
+		"TransportState":             rend.status.Transport,
+		"TransportStatus":            "OK",
+		"TransportPlaySpeed":         "1",
+		"CurrentPlayMode":            "NORMAL",
+		"NumberOfTracks":             tracks,
+		"CurrentTrack":               tracks,
+		"CurrentTrackDuration":       upnptype.TimeToString(int(rend.status.Duration.Seconds())),
+		"CurrentMediaDuration":       upnptype.TimeToString(int(rend.status.Duration.Seconds())),
+		"CurrentTrackMetaData":       rend.status.Metadata,
+		"CurrentTrackURI":            rend.status.URI,
+		"AVTransportURI":             rend.status.URI,
+		"AVTransportURIMetaData":     rend.status.Metadata,
+		"NextAVTransportURI":         rend.status.NextURI,
+		"NextAVTransportURIMetaData": rend.status.NextMetadata,
+		"CurrentTransportActions":    rend.transportActions(),
+	}))
+}
+
+// sendRCS sends the RenderingControl state in a LastChange event.
+func (rend *Renderer) sendRCS() {
+	rend.rcs.SetVariable("LastChange", upnpdevice.LastChange(upnpdevice.LastChangeRCS, map[string]string{
+		"Volume": strconv.Itoa(int(rend.status.Volume)),
+		"Mute":   formatBool(rend.status.Mute),
+	}))
+}
+
+// transportActions returns the actions available in the current state.
+func (rend *Renderer) transportActions() string {
+	switch rend.status.Transport {
+	case StatePlaying:
+		return "Stop,Pause,Seek,Next,Previous"
+	case StatePaused:
+		return "Play,Stop,Seek,Next,Previous"
+	case StateStopped:
+		return "Play,Seek,Next,Previous"
+	}
+	return ""
+}
+
+//
+//-------------------------------------------------------------[ AVTRANSPORT ]--
+
+func (rend *Renderer) setAVTransportURI(args map[string]string) (map[string]string, error) {
+	rend.mu.Lock()
+	defer rend.mu.Unlock()
+	rend.load(args["CurrentURI"], args["CurrentURIMetaData"], rend.status.Transport == StatePlaying)
+	return nil, nil
+}
+
+func (rend *Renderer) setNextAVTransportURI(args map[string]string) (map[string]string, error) {
+	rend.mu.Lock()
+	defer rend.mu.Unlock()
+	rend.status.NextURI = args["NextURI"]
+	rend.status.NextMetadata = args["NextURIMetaData"]
+	rend.sendAVT()
+	return nil, nil
+}
+
+func (rend *Renderer) getMediaInfo(args map[string]string) (map[string]string, error) {
+	rend.mu.Lock()
+	defer rend.mu.Unlock()
+	tracks, medium := "0", "NONE"
+	if rend.status.URI != "" {
+		tracks, medium = "1", "NETWORK"
+	}
+	return map[string]string{
+		"NrTracks":           tracks,
+		"MediaDuration":      upnptype.TimeToString(int(rend.status.Duration.Seconds())),
+		"CurrentURI":         rend.status.URI,
+		"CurrentURIMetaData": rend.status.Metadata,
+		"NextURI":            rend.status.NextURI,
+		"NextURIMetaData":    rend.status.NextMetadata,
+		"PlayMedium":         medium,
+		"RecordMedium":       "NOT_IMPLEMENTED",
+		"WriteStatus":        "NOT_IMPLEMENTED",
+	}, nil
+}
+
+func (rend *Renderer) getTransportInfo(args map[string]string) (map[string]string, error) {
+	rend.mu.Lock()
+	defer rend.mu.Unlock()
+	return map[string]string{
+		"CurrentTransportState":  rend.status.Transport,
+		"CurrentTransportStatus": "OK",
+		"CurrentSpeed":           "1",
+	}, nil
+}
+
+func (rend *Renderer) getPositionInfo(args map[string]string) (map[string]string, error) {
+	rend.mu.Lock()
+	defer rend.mu.Unlock()
+	track := "0"
+	if rend.status.URI != "" {
+		track = "1"
+	}
+	pos := upnptype.TimeToString(int(rend.position().Seconds()))
+	return map[string]string{
+		"Track":         track,
+		"TrackDuration": upnptype.TimeToString(int(rend.status.Duration.Seconds())),
+		"TrackMetaData": rend.status.Metadata,
+		"TrackURI":      rend.status.URI,
+		"RelTime":       pos,
+		"AbsTime":       pos,
+		"RelCount":      "2147483647",
+		"AbsCount":      "2147483647",
+	}, nil
+}
+
+func (rend *Renderer) getDeviceCapabilities(args map[string]string) (map[string]string, error) {
+	return map[string]string{
+		"PlayMedia":       "NETWORK",
+		"RecMedia":        "NOT_IMPLEMENTED",
+		"RecQualityModes": "NOT_IMPLEMENTED",
+	}, nil
+}
+
+func (rend *Renderer) getTransportSettings(args map[string]string) (map[string]string, error) {
+	return map[string]string{
+		"PlayMode":       "NORMAL",
+		"RecQualityMode": "NOT_IMPLEMENTED",
+	}, nil
+}
+
+func (rend *Renderer) getCurrentTransportActions(args map[string]string) (map[string]string, error) {
+	rend.mu.Lock()
+	defer rend.mu.Unlock()
+	return map[string]string{"Actions": rend.transportActions()}, nil
+}
+
+func (rend *Renderer) stop(args map[string]string) (map[string]string, error) {
+	rend.mu.Lock()
+	defer rend.mu.Unlock()
+	if rend.status.Transport == StateNoMedia {
+		return nil, &upnpdevice.Error{Code: errTransitionNotAvailable, Description: "Transition not available"}
+	}
+	rend.setTransport(StateStopped)
+	return nil, nil
+}
+
+func (rend *Renderer) play(args map[string]string) (map[string]string, error) {
+	rend.mu.Lock()
+	defer rend.mu.Unlock()
+	switch rend.status.Transport {
+	case StateNoMedia:
+		return nil, &upnpdevice.Error{Code: errTransitionNotAvailable, Description: "Transition not available"}
+
+	case StateStopped, StatePaused:
+		rend.setTransport(StatePlaying)
+	}
+	return nil, nil
+}
+
+func (rend *Renderer) pause(args map[string]string) (map[string]string, error) {
+	rend.mu.Lock()
+	defer rend.mu.Unlock()
+	if rend.status.Transport != StatePlaying {
+		return nil, &upnpdevice.Error{Code: errTransitionNotAvailable, Description: "Transition not available"}
+	}
+	rend.setTransport(StatePaused)
+	return nil, nil
+}
+
+func (rend *Renderer) seek(args map[string]string) (map[string]string, error) {
+	unit := args["Unit"]
+	if unit != "REL_TIME" && unit != "ABS_TIME" {
+		return nil, &upnpdevice.Error{Code: errSeekModeNotSupported, Description: "Seek mode not supported"}
+	}
+	target := time.Duration(upnptype.TimeToSecond(args["Target"])) * time.Second
+
+	rend.mu.Lock()
+	defer rend.mu.Unlock()
+	if rend.status.Transport == StateNoMedia || target < 0 || target > rend.status.Duration {
+		return nil, &upnpdevice.Error{Code: errIllegalSeekTarget, Description: "Illegal seek target"}
+	}
+	state := rend.status.Transport
+	rend.stopTimer()
+	rend.status.Transport = StateStopped // Freeze the clock during the change.
+	rend.status.Position = target
+	if state == StatePlaying {
+		rend.setTransport(StatePlaying)
+	} else {
+		rend.status.Transport = state
+	}
+	return nil, nil
+}
+
+func (rend *Renderer) next(args map[string]string) (map[string]string, error) {
+	rend.mu.Lock()
+	defer rend.mu.Unlock()
+	if rend.status.NextURI == "" {
+		return nil, &upnpdevice.Error{Code: errIllegalSeekTarget, Description: "Illegal seek target"}
+	}
+	play := rend.status.Transport == StatePlaying
+	uri, metadata := rend.status.NextURI, rend.status.NextMetadata
+	rend.status.NextURI, rend.status.NextMetadata = "", ""
+	rend.load(uri, metadata, play)
+	return nil, nil
+}
+
+func (rend *Renderer) previous(args map[string]string) (map[string]string, error) {
+	return rend.seek(map[string]string{"Unit": "REL_TIME", "Target": "00:00:00"})
+}
+
+//
+//--------------------------------------------------------[ RENDERINGCONTROL ]--
+
+func (rend *Renderer) listPresets(args map[string]string) (map[string]string, error) {
+	return map[string]string{"CurrentPresetNameList": "FactoryDefaults"}, nil
+}
+
+func (rend *Renderer) selectPreset(args map[string]string) (map[string]string, error) {
+	if args["PresetName"] != "FactoryDefaults" {
+		return nil, &upnpdevice.Error{Code: upnpdevice.ErrInvalidArgs, Description: "Invalid Name"}
+	}
+	rend.mu.Lock()
+	defer rend.mu.Unlock()
+	rend.status.Volume = 50
+	rend.status.Mute = false
+	rend.sendRCS()
+	return nil, nil
+}
+
+func (rend *Renderer) getMute(args map[string]string) (map[string]string, error) {
+	rend.mu.Lock()
+	defer rend.mu.Unlock()
+	return map[string]string{"CurrentMute": formatBool(rend.status.Mute)}, nil
+}
+
+func (rend *Renderer) setMute(args map[string]string) (map[string]string, error) {
+	mute, ok := parseBool(args["DesiredMute"])
+	if !ok {
+		return nil, &upnpdevice.Error{Code: upnpdevice.ErrInvalidArgs, Description: "Invalid Args"}
+	}
+	rend.mu.Lock()
+	defer rend.mu.Unlock()
+	rend.status.Mute = mute
+	rend.sendRCS()
+	return nil, nil
+}
+
+func (rend *Renderer) getVolume(args map[string]string) (map[string]string, error) {
+	rend.mu.Lock()
+	defer rend.mu.Unlock()
+	return map[string]string{"CurrentVolume": strconv.Itoa(int(rend.status.Volume))}, nil
+}
+
+func (rend *Renderer) setVolume(args map[string]string) (map[string]string, error) {
+	volume, e := strconv.ParseUint(args["DesiredVolume"], 10, 16)
+	if e != nil || volume > 100 {
+		return nil, &upnpdevice.Error{Code: upnpdevice.ErrInvalidArgs, Description: "Invalid Args"}
+	}
+	rend.mu.Lock()
+	defer rend.mu.Unlock()
+	rend.status.Volume = uint16(volume)
+	rend.sendRCS()
+	return nil, nil
+}
+
+//
+//-------------------------------------------------------[ CONNECTIONMANAGER ]--
+
+func (rend *Renderer) getProtocolInfo(args map[string]string) (map[string]string, error) {
+	return map[string]string{"Source": "", "Sink": SinkProtocolInfo}, nil
+}
+
+func (rend *Renderer) getCurrentConnectionIDs(args map[string]string) (map[string]string, error) {
+	return map[string]string{"ConnectionIDs": "0"}, nil
+}
+
+func (rend *Renderer) getCurrentConnectionInfo(args map[string]string) (map[string]string, error) {
+	if args["ConnectionID"] != "0" {
+		return nil, &upnpdevice.Error{Code: 706, Description: "Invalid connection reference"}
+	}
+	return map[string]string{
+		"RcsID":                 "0",
+		"AVTransportID":         "0",
+		"ProtocolInfo":          "",
+		"PeerConnectionManager": "",
+		"PeerConnectionID":      "-1",
+		"Direction":             "Input",
+		"Status":                "OK",
+	}, nil
+}
+
+//
+//-----------------------------------------------------------------[ HELPERS ]--
+
+// instance rejects calls for other instances than 0.
+func instance(call upnpdevice.ActionFunc) upnpdevice.ActionFunc {
+	return func(args map[string]string) (map[string]string, error) {
+		if id := args["InstanceID"]; id != "" && id != "0" {
+			return nil, &upnpdevice.Error{Code: errInvalidInstanceID, Description: "Invalid InstanceID"}
+		}
+		return call(args)
+	}
+}
+
+func formatBool(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+func parseBool(value string) (bool, bool) {
+	switch strings.ToLower(value) {
+	case "1", "true", "yes":
+		return true, true
+	case "0", "false", "no":
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,253 @@
+package virtualrenderer
+
+import (
+	"github.com/sqp/gupnp/upnpdevice"
+	"github.com/sqp/gupnp/upnptype"
+
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startRenderer starts a renderer reachable only by its location.
+func startRenderer(t *testing.T) (*Renderer, *upnptype.DeviceInfo) {
+	rend := New("Test renderer")
+	if e := rend.Start(upnpdevice.Options{DisableSSDP: true}); e != nil {
+		t.Fatal("start:", e)
+	}
+	t.Cleanup(func() { rend.Stop() })
+
+	resp, e := http.Get(rend.Location())
+	if e != nil {
+		t.Fatal("get description:", e)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	info, e := upnptype.ParseDeviceDescription(data, rend.Location())
+	if e != nil {
+		t.Fatal("parse description:", e)
+	}
+	return rend, info
+}
+
+// call sends a SOAP action and returns the output arguments, or the UPnP
+// error code.
+func call(t *testing.T, info *upnptype.DeviceInfo, serviceType, action string, args ...string) (map[string]string, string) {
+	srv := info.Service(serviceType)
+	if srv == nil {
+		t.Fatal("missing service", serviceType)
+	}
+	out, e := upnpdevice.SendAction(srv.ControlURL, serviceType, action, args...)
+	if upnpErr, ok := e.(*upnpdevice.Error); ok {
+		return nil, strconv.Itoa(upnpErr.Code)
+	}
+	if e != nil {
+		t.Fatal(action, e)
+	}
+	return out, ""
+}
+
+func mustCall(t *testing.T, info *upnptype.DeviceInfo, serviceType, action string, args ...string) map[string]string {
+	out, code := call(t, info, serviceType, action, args...)
+	if code != "" {
+		t.Fatalf("%s: upnp error %s", action, code)
+	}
+	return out
+}
+
+func transportState(t *testing.T, info *upnptype.DeviceInfo) string {
+	out := mustCall(t, info, upnptype.ServiceTypeAVTransport, "GetTransportInfo", "InstanceID", "0")
+	return out["CurrentTransportState"]
+}
+
+func waitState(t *testing.T, rend *Renderer, state string) {
+	deadline := time.Now().Add(2 * time.Second)
+	for rend.Status().Transport != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("transport state: want %s, got %s", state, rend.Status().Transport)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func didl(duration string) string {
+	return `<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"><item id="1" parentID="0" restricted="1">` +
+		`<res protocolInfo="http-get:*:audio/mpeg:*" duration="` + duration + `">http://host/a.mp3</res></item></DIDL-Lite>`
+}
+
+func TestDescription(t *testing.T) {
+	rend, info := startRenderer(t)
+	if info.DeviceType != DeviceType || info.UDN != rend.UDN() || info.FriendlyName != "Test renderer" {
+		t.Errorf("bad description: %+v", info)
+	}
+	for _, typ := range []string{upnptype.ServiceTypeAVTransport, upnptype.ServiceTypeRenderingControl, upnptype.ServiceTypeConnectionManager} {
+		srv := info.Service(typ)
+		if srv == nil {
+			t.Fatal("missing service", typ)
+		}
+		scpd, e := upnptype.FetchSCPD(srv.SCPDURL)
+		if e != nil {
+			t.Fatal("fetch scpd:", e)
+		}
+		if len(scpd.Actions) == 0 {
+			t.Fatal("no actions for", typ)
+		}
+		for _, action := range scpd.Actions {
+			for _, arg := range action.Arguments {
+				if scpd.StateVariable(arg.RelatedStateVariable) == nil {
+					t.Errorf("%s %s: missing state variable %s", typ, action.Name, arg.RelatedStateVariable)
+				}
+			}
+		}
+	}
+}
+
+func TestTransportStates(t *testing.T) {
+	_, info := startRenderer(t)
+	avt := upnptype.ServiceTypeAVTransport
+
+	if state := transportState(t, info); state != StateNoMedia {
+		t.Errorf("initial state: %s", state)
+	}
+	if _, code := call(t, info, avt, "Play", "InstanceID", "0", "Speed", "1"); code != "701" {
+		t.Errorf("play without media: want error 701, got %q", code)
+	}
+
+	mustCall(t, info, avt, "SetAVTransportURI", "InstanceID", "0", "CurrentURI", "http://host/a.mp3", "CurrentURIMetaData", didl("0:04:10.000"))
+	if state := transportState(t, info); state != StateStopped {
+		t.Errorf("state after set uri: %s", state)
+	}
+	media := mustCall(t, info, avt, "GetMediaInfo", "InstanceID", "0")
+	if media["CurrentURI"] != "http://host/a.mp3" || media["MediaDuration"] != "00:04:10" {
+		t.Errorf("bad media info: %v", media)
+	}
+
+	mustCall(t, info, avt, "Play", "InstanceID", "0", "Speed", "1")
+	if state := transportState(t, info); state != StatePlaying {
+		t.Errorf("state after play: %s", state)
+	}
+	mustCall(t, info, avt, "Pause", "InstanceID", "0")
+	if state := transportState(t, info); state != StatePaused {
+		t.Errorf("state after pause: %s", state)
+	}
+	mustCall(t, info, avt, "Stop", "InstanceID", "0")
+	if state := transportState(t, info); state != StateStopped {
+		t.Errorf("state after stop: %s", state)
+	}
+
+	if _, code := call(t, info, avt, "Stop", "InstanceID", "1"); code != "718" {
+		t.Errorf("bad instance: want error 718, got %q", code)
+	}
+	if _, code := call(t, info, avt, "Unknown", "InstanceID", "0"); code != "401" {
+		t.Errorf("unknown action: want error 401, got %q", code)
+	}
+}
+
+func TestTimeProgression(t *testing.T) {
+	rend, info := startRenderer(t)
+	rend.TimeScale = 100
+	avt := upnptype.ServiceTypeAVTransport
+
+	mustCall(t, info, avt, "SetAVTransportURI", "InstanceID", "0", "CurrentURI", "http://host/a.mp3", "CurrentURIMetaData", didl("0:01:00"))
+	mustCall(t, info, avt, "Seek", "InstanceID", "0", "Unit", "REL_TIME", "Target", "00:00:30")
+	if pos := rend.Status().Position; pos != 30*time.Second {
+		t.Errorf("position after seek: %s", pos)
+	}
+	if _, code := call(t, info, avt, "Seek", "InstanceID", "0", "Unit", "TRACK_NR", "Target", "1"); code != "710" {
+		t.Errorf("seek track: want error 710, got %q", code)
+	}
+	if _, code := call(t, info, avt, "Seek", "InstanceID", "0", "Unit", "ABS_TIME", "Target", "00:05:00"); code != "711" {
+		t.Errorf("seek after end: want error 711, got %q", code)
+	}
+
+	mustCall(t, info, avt, "SetNextAVTransportURI", "InstanceID", "0", "NextURI", "http://host/b.mp3", "NextURIMetaData", didl("0:10:00"))
+	mustCall(t, info, avt, "Play", "InstanceID", "0", "Speed", "1")
+
+	time.Sleep(50 * time.Millisecond) // 5 seconds at scale 100.
+	pos := mustCall(t, info, avt, "GetPositionInfo", "InstanceID", "0")
+	if secs := upnptype.TimeToSecond(pos["RelTime"]); secs < 32 || secs > 59 {
+		t.Errorf("position not progressing: %s", pos["RelTime"])
+	}
+
+	// The track ends after 30 simulated seconds, and the next one is played.
+	deadline := time.Now().Add(2 * time.Second)
+	for rend.Status().URI != "http://host/b.mp3" {
+		if time.Now().After(deadline) {
+			t.Fatal("next track not played:", rend.Status().URI)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	status := rend.Status()
+	if status.Transport != StatePlaying || status.Duration != 10*time.Minute || status.NextURI != "" {
+		t.Errorf("bad state after track end: %+v", status)
+	}
+
+	// Without next track, the renderer stops at the end.
+	mustCall(t, info, avt, "Seek", "InstanceID", "0", "Unit", "REL_TIME", "Target", "00:09:59")
+	waitState(t, rend, StateStopped)
+}
+
+func TestRenderingControl(t *testing.T) {
+	rend, info := startRenderer(t)
+	rcs := upnptype.ServiceTypeRenderingControl
+
+	mustCall(t, info, rcs, "SetVolume", "InstanceID", "0", "Channel", "Master", "DesiredVolume", "42")
+	if out := mustCall(t, info, rcs, "GetVolume", "InstanceID", "0", "Channel", "Master"); out["CurrentVolume"] != "42" {
+		t.Errorf("volume: %v", out)
+	}
+	if _, code := call(t, info, rcs, "SetVolume", "InstanceID", "0", "Channel", "Master", "DesiredVolume", "101"); code != "402" {
+		t.Errorf("volume out of range: want error 402, got %q", code)
+	}
+	mustCall(t, info, rcs, "SetMute", "InstanceID", "0", "Channel", "Master", "DesiredMute", "true")
+	if out := mustCall(t, info, rcs, "GetMute", "InstanceID", "0", "Channel", "Master"); out["CurrentMute"] != "1" {
+		t.Errorf("mute: %v", out)
+	}
+	if status := rend.Status(); status.Volume != 42 || !status.Mute {
+		t.Errorf("bad status: %+v", status)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	_, info := startRenderer(t)
+
+	events := make(chan string, 10)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		events <- r.Header.Get("SEQ") + " " + string(data)
+	}))
+	defer callback.Close()
+
+	req, _ := http.NewRequest("SUBSCRIBE", info.Service(upnptype.ServiceTypeRenderingControl).EventSubURL, nil)
+	req.Header.Set("CALLBACK", "<"+callback.URL+">")
+	req.Header.Set("NT", "upnp:event")
+	resp, e := http.DefaultClient.Do(req)
+	if e != nil {
+		t.Fatal("subscribe:", e)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("SID") == "" {
+		t.Fatal("subscribe failed:", resp.Status)
+	}
+
+	next := func() string {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(2 * time.Second):
+			t.Fatal("event not received")
+		}
+		return ""
+	}
+	if event := next(); !strings.HasPrefix(event, "0 ") || !strings.Contains(event, "Volume channel=&#34;Master&#34; val=&#34;50&#34;") {
+		t.Errorf("bad initial event: %s", event)
+	}
+
+	mustCall(t, info, upnptype.ServiceTypeRenderingControl, "SetVolume", "InstanceID", "0", "Channel", "Master", "DesiredVolume", "7")
+	if event := next(); !strings.HasPrefix(event, "1 ") || !strings.Contains(event, "val=&#34;7&#34;") {
+		t.Errorf("bad volume event: %s", event)
+	}
+}
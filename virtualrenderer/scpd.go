@@ -0,0 +1,170 @@
+package virtualrenderer
+
+import (
+	"github.com/sqp/gupnp/upnpdevice"
+	"github.com/sqp/gupnp/upnptype"
+)
+
+// Services descriptions, limited to the actions implemented.
+
+var (
+	action   = upnpdevice.Action
+	in       = upnpdevice.ArgIn
+	out      = upnpdevice.ArgOut
+	variable = upnpdevice.Variable
+)
+
+func scpdAVTransport() *upnptype.SCPD {
+	return &upnptype.SCPD{
+		Actions: []upnptype.ActionInfo{
+			action("SetAVTransportURI", in("InstanceID", "A_ARG_TYPE_InstanceID"),
+				in("CurrentURI", "AVTransportURI"),
+				in("CurrentURIMetaData", "AVTransportURIMetaData")),
+			action("SetNextAVTransportURI", in("InstanceID", "A_ARG_TYPE_InstanceID"),
+				in("NextURI", "NextAVTransportURI"),
+				in("NextURIMetaData", "NextAVTransportURIMetaData")),
+			action("GetMediaInfo", in("InstanceID", "A_ARG_TYPE_InstanceID"),
+				out("NrTracks", "NumberOfTracks"),
+				out("MediaDuration", "CurrentMediaDuration"),
+				out("CurrentURI", "AVTransportURI"),
+				out("CurrentURIMetaData", "AVTransportURIMetaData"),
+				out("NextURI", "NextAVTransportURI"),
+				out("NextURIMetaData", "NextAVTransportURIMetaData"),
+				out("PlayMedium", "PlaybackStorageMedium"),
+				out("RecordMedium", "RecordStorageMedium"),
+				out("WriteStatus", "RecordMediumWriteStatus")),
+			action("GetTransportInfo", in("InstanceID", "A_ARG_TYPE_InstanceID"),
+				out("CurrentTransportState", "TransportState"),
+				out("CurrentTransportStatus", "TransportStatus"),
+				out("CurrentSpeed", "TransportPlaySpeed")),
+			action("GetPositionInfo", in("InstanceID", "A_ARG_TYPE_InstanceID"),
+				out("Track", "CurrentTrack"),
+				out("TrackDuration", "CurrentTrackDuration"),
+				out("TrackMetaData", "CurrentTrackMetaData"),
+				out("TrackURI", "CurrentTrackURI"),
+				out("RelTime", "RelativeTimePosition"),
+				out("AbsTime", "AbsoluteTimePosition"),
+				out("RelCount", "RelativeCounterPosition"),
+				out("AbsCount", "AbsoluteCounterPosition")),
+			action("GetDeviceCapabilities", in("InstanceID", "A_ARG_TYPE_InstanceID"),
+				out("PlayMedia", "PossiblePlaybackStorageMedia"),
+				out("RecMedia", "PossibleRecordStorageMedia"),
+				out("RecQualityModes", "PossibleRecordQualityModes")),
+			action("GetTransportSettings", in("InstanceID", "A_ARG_TYPE_InstanceID"),
+				out("PlayMode", "CurrentPlayMode"),
+				out("RecQualityMode", "CurrentRecordQualityMode")),
+			action("GetCurrentTransportActions", in("InstanceID", "A_ARG_TYPE_InstanceID"),
+				out("Actions", "CurrentTransportActions")),
+			action("Stop", in("InstanceID", "A_ARG_TYPE_InstanceID")),
+			action("Play", in("InstanceID", "A_ARG_TYPE_InstanceID"),
+				in("Speed", "TransportPlaySpeed")),
+			action("Pause", in("InstanceID", "A_ARG_TYPE_InstanceID")),
+			action("Seek", in("InstanceID", "A_ARG_TYPE_InstanceID"),
+				in("Unit", "A_ARG_TYPE_SeekMode"),
+				in("Target", "A_ARG_TYPE_SeekTarget")),
+			action("Next", in("InstanceID", "A_ARG_TYPE_InstanceID")),
+			action("Previous", in("InstanceID", "A_ARG_TYPE_InstanceID")),
+		},
+		StateVariables: []upnptype.StateVariable{
+			variable("LastChange", "string", true),
+			variable("TransportState", "string", false,
+				"STOPPED", "PLAYING", "PAUSED_PLAYBACK", "TRANSITIONING", "NO_MEDIA_PRESENT"),
+			variable("TransportStatus", "string", false, "OK", "ERROR_OCCURRED"),
+			variable("TransportPlaySpeed", "string", false, "1"),
+			variable("PlaybackStorageMedium", "string", false, "NONE", "NETWORK"),
+			variable("RecordStorageMedium", "string", false, "NOT_IMPLEMENTED"),
+			variable("PossiblePlaybackStorageMedia", "string", false),
+			variable("PossibleRecordStorageMedia", "string", false),
+			variable("PossibleRecordQualityModes", "string", false),
+			variable("RecordMediumWriteStatus", "string", false, "NOT_IMPLEMENTED"),
+			variable("CurrentPlayMode", "string", false, "NORMAL"),
+			variable("CurrentRecordQualityMode", "string", false, "NOT_IMPLEMENTED"),
+			variable("NumberOfTracks", "ui4", false),
+			variable("CurrentTrack", "ui4", false),
+			variable("CurrentTrackDuration", "string", false),
+			variable("CurrentMediaDuration", "string", false),
+			variable("CurrentTrackMetaData", "string", false),
+			variable("CurrentTrackURI", "string", false),
+			variable("AVTransportURI", "string", false),
+			variable("AVTransportURIMetaData", "string", false),
+			variable("NextAVTransportURI", "string", false),
+			variable("NextAVTransportURIMetaData", "string", false),
+			variable("RelativeTimePosition", "string", false),
+			variable("AbsoluteTimePosition", "string", false),
+			variable("RelativeCounterPosition", "i4", false),
+			variable("AbsoluteCounterPosition", "i4", false),
+			variable("CurrentTransportActions", "string", false),
+			variable("A_ARG_TYPE_SeekMode", "string", false, "ABS_TIME", "REL_TIME"),
+			variable("A_ARG_TYPE_SeekTarget", "string", false),
+			variable("A_ARG_TYPE_InstanceID", "ui4", false),
+		},
+	}
+}
+
+func scpdRenderingControl() *upnptype.SCPD {
+	volume := variable("Volume", "ui2", false)
+	volume.AllowedRange = &upnptype.ValueRange{Minimum: "0", Maximum: "100", Step: "1"}
+
+	return &upnptype.SCPD{
+		Actions: []upnptype.ActionInfo{
+			action("ListPresets", in("InstanceID", "A_ARG_TYPE_InstanceID"),
+				out("CurrentPresetNameList", "PresetNameList")),
+			action("SelectPreset", in("InstanceID", "A_ARG_TYPE_InstanceID"),
+				in("PresetName", "A_ARG_TYPE_PresetName")),
+			action("GetMute", in("InstanceID", "A_ARG_TYPE_InstanceID"),
+				in("Channel", "A_ARG_TYPE_Channel"),
+				out("CurrentMute", "Mute")),
+			action("SetMute", in("InstanceID", "A_ARG_TYPE_InstanceID"),
+				in("Channel", "A_ARG_TYPE_Channel"),
+				in("DesiredMute", "Mute")),
+			action("GetVolume", in("InstanceID", "A_ARG_TYPE_InstanceID"),
+				in("Channel", "A_ARG_TYPE_Channel"),
+				out("CurrentVolume", "Volume")),
+			action("SetVolume", in("InstanceID", "A_ARG_TYPE_InstanceID"),
+				in("Channel", "A_ARG_TYPE_Channel"),
+				in("DesiredVolume", "Volume")),
+		},
+		StateVariables: []upnptype.StateVariable{
+			variable("LastChange", "string", true),
+			variable("PresetNameList", "string", false),
+			variable("Mute", "boolean", false),
+			volume,
+			variable("A_ARG_TYPE_Channel", "string", false, "Master"),
+			variable("A_ARG_TYPE_InstanceID", "ui4", false),
+			variable("A_ARG_TYPE_PresetName", "string", false, "FactoryDefaults"),
+		},
+	}
+}
+
+func scpdConnectionManager() *upnptype.SCPD {
+	return &upnptype.SCPD{
+		Actions: []upnptype.ActionInfo{
+			action("GetProtocolInfo",
+				out("Source", "SourceProtocolInfo"),
+				out("Sink", "SinkProtocolInfo")),
+			action("GetCurrentConnectionIDs",
+				out("ConnectionIDs", "CurrentConnectionIDs")),
+			action("GetCurrentConnectionInfo", in("ConnectionID", "A_ARG_TYPE_ConnectionID"),
+				out("RcsID", "A_ARG_TYPE_RcsID"),
+				out("AVTransportID", "A_ARG_TYPE_AVTransportID"),
+				out("ProtocolInfo", "A_ARG_TYPE_ProtocolInfo"),
+				out("PeerConnectionManager", "A_ARG_TYPE_ConnectionManager"),
+				out("PeerConnectionID", "A_ARG_TYPE_ConnectionID"),
+				out("Direction", "A_ARG_TYPE_Direction"),
+				out("Status", "A_ARG_TYPE_ConnectionStatus")),
+		},
+		StateVariables: []upnptype.StateVariable{
+			variable("SourceProtocolInfo", "string", true),
+			variable("SinkProtocolInfo", "string", true),
+			variable("CurrentConnectionIDs", "string", true),
+			variable("A_ARG_TYPE_ConnectionStatus", "string", false,
+				"OK", "ContentFormatMismatch", "InsufficientBandwidth", "UnreliableChannel", "Unknown"),
+			variable("A_ARG_TYPE_ConnectionManager", "string", false),
+			variable("A_ARG_TYPE_Direction", "string", false, "Input", "Output"),
+			variable("A_ARG_TYPE_ProtocolInfo", "string", false),
+			variable("A_ARG_TYPE_ConnectionID", "i4", false),
+			variable("A_ARG_TYPE_AVTransportID", "i4", false),
+			variable("A_ARG_TYPE_RcsID", "i4", false),
+		},
+	}
+}